@@ -38,6 +38,14 @@ var timelineCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(timelineCmd)
+
+	timelineCmd.Flags().BoolP(
+		"normalize-whitespace", "w", false,
+		"Collapse runs of spaces and tabs in twts to a single space",
+	)
+
+	viper.BindPFlag("normalize-whitespace", timelineCmd.Flags().Lookup("normalize-whitespace"))
+	viper.SetDefault("normalize-whitespace", false)
 }
 
 func timeline(cli *client.Client, args []string) {
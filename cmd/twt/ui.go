@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/spf13/viper"
+
 	"github.com/prologic/twtxt/types"
 )
 
@@ -37,6 +39,9 @@ func PrintFolloweeRaw(nick, url string) {
 
 func PrintTwt(twt types.Twt, now time.Time) {
 	text := FormatTwt(twt.Text)
+	if viper.GetBool("normalize-whitespace") {
+		text = NormalizeWhitespace(text)
+	}
 
 	nick := green(twt.Twter.Nick)
 	// TODO: Show mentions
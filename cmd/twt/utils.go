@@ -25,6 +25,28 @@ func FormatTwt(text string) string {
 	})
 }
 
+var whitespaceRe = regexp.MustCompile(`[ \t]+`)
+
+// NormalizeWhitespace collapses runs of spaces and tabs into a single space
+// whilst preserving newlines (including the `LS: Line Separator, U+2028`
+// used by multi-line twts) as well as any whitespace at either end of a line.
+func NormalizeWhitespace(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		parts := strings.Split(line, "\u2028")
+		for j, part := range parts {
+			trimmed := strings.Trim(part, " \t")
+			if trimmed == "" {
+				continue
+			}
+			start := strings.Index(part, trimmed)
+			parts[j] = part[:start] + whitespaceRe.ReplaceAllString(trimmed, " ") + part[start+len(trimmed):]
+		}
+		lines[i] = strings.Join(parts, "\u2028")
+	}
+	return strings.Join(lines, "\n")
+}
+
 // NormalizeURL ...
 func NormalizeURL(url string) string {
 	if url == "" {
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeWhitespace(t *testing.T) {
+	testCases := []struct {
+		text     string
+		expected string
+	}{
+		{"", ""},
+		{"Hello World", "Hello World"},
+		{"Hello   \t World", "Hello World"},
+		{"  indented  twt  ", "  indented twt  "},
+		{"line  one\nline\t\ttwo", "line one\nline two"},
+		{"one  \u2028  two   three", "one  \u2028  two three"},
+		{" \t ", " \t "},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, NormalizeWhitespace(testCase.text), testCase.text)
+	}
+}
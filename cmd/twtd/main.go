@@ -38,17 +38,40 @@ var (
 	// Pod Settings
 	openProfiles      bool
 	openRegistrations bool
+	stripReplyTargets bool
+	enablePolls       bool
+	editRedirects     bool
+	renameRedirects   bool
+	updateMovedFeeds  bool
+	emptyEditDeletes  bool
+	markEdits         bool
+	lookupMentions    bool
+	slashCommands     bool
+	cleanLinks        bool
+	quarantineSpam    bool
+	strictSeparator   bool
+	stripImportedHTML bool
 
 	// Pod Limits
-	twtsPerPage   int
-	maxTwtLength  int
-	maxUploadSize int64
-	maxFetchLimit int64
-	maxCacheTTL   time.Duration
-	maxCacheItems int
+	twtsPerPage       int
+	maxTwtLength      int
+	maxMentions       int
+	postBurst         int
+	postRate          time.Duration
+	spamThreshold     float64
+	spamTimeout       time.Duration
+	maxUploadSize     int64
+	maxFetchLimit     int64
+	maxCacheTTL       time.Duration
+	maxCacheItems     int
+	feedTTL           time.Duration
+	lineEnding        string
+	mentionPrecedence string
+	tagNamespace      string
 
 	// Pod Secrets
 	apiSigningKey   string
+	pingSigningKey  string
 	cookieSecret    string
 	magiclinkSecret string
 
@@ -67,6 +90,9 @@ var (
 
 	// Whitelists, Sources
 	feedSources        []string
+	systemFeeds        []string
+	reactionMarkers    []string
+	trackingParams     []string
 	whitelistedDomains []string
 )
 
@@ -97,6 +123,58 @@ func init() {
 		&openProfiles, "open-profiles", "O", internal.DefaultOpenProfiles,
 		"whether or not to have open user profiles",
 	)
+	flag.BoolVar(
+		&stripReplyTargets, "strip-reply-targets", internal.DefaultStripReplyTargets,
+		"whether or not to strip leading reply mentions when displaying twts",
+	)
+	flag.BoolVar(
+		&enablePolls, "enable-polls", internal.DefaultEnablePolls,
+		"whether or not to parse and render inline polls in twts",
+	)
+	flag.BoolVar(
+		&editRedirects, "edit-redirects", internal.DefaultEditRedirects,
+		"whether or not to resolve references to edited twts to their edited version",
+	)
+	flag.BoolVar(
+		&renameRedirects, "rename-redirects", internal.DefaultRenameRedirects,
+		"whether or not to redirect the old urls of renamed feeds to the new ones",
+	)
+	flag.BoolVar(
+		&updateMovedFeeds, "update-moved-feeds", internal.DefaultUpdateMovedFeeds,
+		"whether or not to update the urls of followed feeds that have permanently moved",
+	)
+	flag.BoolVar(
+		&emptyEditDeletes, "empty-edit-deletes", internal.DefaultEmptyEditDeletes,
+		"whether or not editing a twt to empty text deletes it",
+	)
+	flag.BoolVar(
+		&markEdits, "mark-edits", internal.DefaultMarkEdits,
+		"whether or not to mark edited twts with the time they were edited",
+	)
+	flag.BoolVar(
+		&lookupMentions, "lookup-mentions", internal.DefaultLookupMentions,
+		"whether or not to look up @nick@domain mentions in the remote pod's directory",
+	)
+	flag.BoolVar(
+		&slashCommands, "slash-commands", internal.DefaultSlashCommands,
+		"whether or not to process slash commands (e.g: /me) in posts",
+	)
+	flag.BoolVar(
+		&cleanLinks, "clean-links", internal.DefaultCleanLinks,
+		"whether or not to strip tracking parameters from links in twts when displayed",
+	)
+	flag.BoolVar(
+		&quarantineSpam, "quarantine-spam", internal.DefaultQuarantineSpam,
+		"whether or not to save twts classified as spam as drafts instead of rejecting them",
+	)
+	flag.BoolVar(
+		&strictSeparator, "strict-separator", internal.DefaultStrictSeparator,
+		"whether or not to require a tab between the timestamp and text of twts in feeds",
+	)
+	flag.BoolVar(
+		&stripImportedHTML, "strip-imported-html", internal.DefaultStripImportedHTML,
+		"whether or not to strip html from the text of imported twts",
+	)
 
 	// Pod Limits
 	flag.IntVarP(
@@ -107,6 +185,26 @@ func init() {
 		&maxTwtLength, "max-twt-length", "L", internal.DefaultMaxTwtLength,
 		"maximum length of posts",
 	)
+	flag.IntVar(
+		&maxMentions, "max-mentions", internal.DefaultMaxMentions,
+		"maximum number of mentions expanded in a single post (0 for no limit)",
+	)
+	flag.IntVar(
+		&postBurst, "post-burst", internal.DefaultPostBurst,
+		"number of twts a user can post in a burst before being rate limited (0 for no limit)",
+	)
+	flag.DurationVar(
+		&postRate, "post-rate", internal.DefaultPostRate,
+		"sustained rate of posting once a burst is used up (one twt per duration)",
+	)
+	flag.Float64Var(
+		&spamThreshold, "spam-threshold", internal.DefaultSpamThreshold,
+		"spam score (between 0 and 1) above which twts are rejected",
+	)
+	flag.DurationVar(
+		&spamTimeout, "spam-timeout", internal.DefaultSpamTimeout,
+		"time allowed to classify a twt as spam before assuming it is not",
+	)
 	flag.Int64VarP(
 		&maxUploadSize, "max-upload-size", "U", internal.DefaultMaxUploadSize,
 		"maximum upload size of media",
@@ -123,12 +221,32 @@ func init() {
 		&maxCacheItems, "max-cache-items", "I", internal.DefaultMaxCacheItems,
 		"maximum cache items (per feed source) of cached twts in memory",
 	)
+	flag.DurationVar(
+		&feedTTL, "feed-ttl", internal.DefaultFeedTTL,
+		"age after which twts in local feeds are considered old (0 to disable)",
+	)
+	flag.StringVar(
+		&lineEnding, "line-ending", internal.DefaultLineEnding,
+		"line ending written to local feeds (lf or crlf)",
+	)
+	flag.StringVar(
+		&mentionPrecedence, "mention-precedence", internal.DefaultMentionPrecedence,
+		"precedence of mentions of followed nicks that are also local (following or local)",
+	)
+	flag.StringVar(
+		&tagNamespace, "tag-namespace", internal.DefaultTagNamespace,
+		"namespace of tags expanded in twts for community scoped tags (empty for global tags)",
+	)
 
 	// Pod Secrets
 	flag.StringVar(
 		&apiSigningKey, "api-signing-key", internal.DefaultAPISigningKey,
 		"secret to use for signing api tokens",
 	)
+	flag.StringVar(
+		&pingSigningKey, "ping-signing-key", internal.DefaultPingSigningKey,
+		"base64 encoded ed25519 seed to sign mention pings with (empty for unsigned pings)",
+	)
 	flag.StringVar(
 		&cookieSecret, "cookie-secret", internal.DefaultCookieSecret,
 		"cookie secret to use secure sessions",
@@ -168,6 +286,18 @@ func init() {
 		&feedSources, "feed-sources", internal.DefaultFeedSources,
 		"external feed sources for discovery of other feeds",
 	)
+	flag.StringSliceVar(
+		&systemFeeds, "system-feed", internal.DefaultSystemFeeds,
+		"additional local feeds to treat as system/bot feeds",
+	)
+	flag.StringSliceVar(
+		&reactionMarkers, "reaction-marker", internal.DefaultReactionMarkers,
+		"markers (besides single emoji) that make a reply a reaction (e.g: +1)",
+	)
+	flag.StringSliceVar(
+		&trackingParams, "tracking-param", internal.DefaultTrackingParams,
+		"tracking parameters stripped from links with --clean-links (e.g: utm_*)",
+	)
 	flag.StringSliceVar(
 		&whitelistedDomains, "whitelist-domain", internal.DefaultWhitelistedDomains,
 		"whitelist of external domains to permit for display of inline images",
@@ -242,17 +372,40 @@ func main() {
 		// Pod Settings
 		internal.WithOpenProfiles(openProfiles),
 		internal.WithOpenRegistrations(openRegistrations),
+		internal.WithStripReplyTargets(stripReplyTargets),
+		internal.WithEnablePolls(enablePolls),
+		internal.WithEditRedirects(editRedirects),
+		internal.WithRenameRedirects(renameRedirects),
+		internal.WithUpdateMovedFeeds(updateMovedFeeds),
+		internal.WithEmptyEditDeletes(emptyEditDeletes),
+		internal.WithMarkEdits(markEdits),
+		internal.WithLookupMentions(lookupMentions),
+		internal.WithSlashCommands(slashCommands),
+		internal.WithCleanLinks(cleanLinks),
+		internal.WithQuarantineSpam(quarantineSpam),
+		internal.WithStrictSeparator(strictSeparator),
+		internal.WithStripImportedHTML(stripImportedHTML),
 
 		// Pod Limits
 		internal.WithTwtsPerPage(twtsPerPage),
 		internal.WithMaxTwtLength(maxTwtLength),
+		internal.WithMaxMentions(maxMentions),
+		internal.WithPostBurst(postBurst),
+		internal.WithPostRate(postRate),
+		internal.WithSpamThreshold(spamThreshold),
+		internal.WithSpamTimeout(spamTimeout),
 		internal.WithMaxUploadSize(maxUploadSize),
 		internal.WithMaxFetchLimit(maxFetchLimit),
 		internal.WithMaxCacheTTL(maxCacheTTL),
 		internal.WithMaxCacheItems(maxCacheItems),
+		internal.WithFeedTTL(feedTTL),
+		internal.WithLineEnding(lineEnding),
+		internal.WithMentionPrecedence(mentionPrecedence),
+		internal.WithTagNamespace(tagNamespace),
 
 		// Pod Secrets
 		internal.WithAPISigningKey(apiSigningKey),
+		internal.WithPingSigningKey(pingSigningKey),
 		internal.WithCookieSecret(cookieSecret),
 		internal.WithMagicLinkSecret(magiclinkSecret),
 
@@ -271,6 +424,9 @@ func main() {
 
 		// Whitelists, Sources
 		internal.WithFeedSources(feedSources),
+		internal.WithSystemFeeds(systemFeeds),
+		internal.WithReactionMarkers(reactionMarkers),
+		internal.WithTrackingParams(trackingParams),
 		internal.WithWhitelistedDomains(whitelistedDomains),
 	)
 	if err != nil {
@@ -5,9 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"math"
 	"net/http"
-	"os"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -254,18 +253,12 @@ func (a *API) RegisterEndpoint() httprouter.Handle {
 			return
 		}
 
-		fn := filepath.Join(a.config.Data, feedsDir, username)
-		if _, err := os.Stat(fn); err == nil {
+		store := a.config.FeedStore()
+		if _, err := store.Stat(username); err == nil {
 			http.Error(w, "Feed Exists", http.StatusBadRequest)
 			return
 		}
 
-		if err := ioutil.WriteFile(fn, []byte{}, 0644); err != nil {
-			log.WithError(err).Error("error creating new user feed")
-			http.Error(w, "Feed Creation Failed", http.StatusInternalServerError)
-			return
-		}
-
 		hash, err := a.pm.CreatePassword(password)
 		if err != nil {
 			log.WithError(err).Error("error creating password hash")
@@ -283,6 +276,12 @@ func (a *API) RegisterEndpoint() httprouter.Handle {
 			CreatedAt: time.Now(),
 		}
 
+		if err := EnsureUserFeed(a.config, a.db, user); err != nil {
+			log.WithError(err).Error("error creating new user feed")
+			http.Error(w, "Feed Creation Failed", http.StatusInternalServerError)
+			return
+		}
+
 		if err := a.db.SetUser(username, user); err != nil {
 			log.WithError(err).Error("error saving user object for new user")
 			http.Error(w, "User Creation Failed", http.StatusInternalServerError)
@@ -413,8 +412,14 @@ func (a *API) PostEndpoint() httprouter.Handle {
 
 		if err != nil {
 			log.WithError(err).Error("error posting twt")
+			var rateLimited *ErrRateLimited
 			if err == ErrFeedImposter {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			} else if errors.As(err, &rateLimited) {
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(rateLimited.RetryAfter.Seconds()))))
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			} else if err == ErrSpamRejected || err == ErrSpamQuarantined {
+				http.Error(w, "Unprocessable Entity", http.StatusUnprocessableEntity)
 			} else {
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			}
@@ -422,7 +427,7 @@ func (a *API) PostEndpoint() httprouter.Handle {
 		}
 
 		// Update user's own timeline with their own new post.
-		a.cache.FetchTwts(a.config, a.archive, user.Source(), nil)
+		a.cache.FetchTwts(a.config, a.db, a.archive, user.Source(), nil)
 
 		// Re-populate/Warm cache with local twts for this pod
 		a.cache.GetByPrefix(a.config.BaseURL, true)
@@ -452,6 +457,39 @@ func (a *API) TimelineEndpoint() httprouter.Handle {
 			twts = append(twts, a.cache.GetByURL(feed.URL)...)
 		}
 
+		// Cursor based paging (stable as new twts arrive)
+		if req.Before != "" || req.ExcludeOwn {
+			var options []TimelineOption
+			if req.ExcludeOwn {
+				options = append(options, ExcludeOwnTwts(user))
+			}
+
+			pagedTwts, nextCursor, err := BuildTimeline(twts, req.Before, a.config.TwtsPerPage, options...)
+			if err != nil {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+
+			res := types.PagedResponse{
+				Twts: a.formatTwtText(FilterTwts(user, pagedTwts)),
+				Pager: types.PagerResponse{
+					TotalTwts:  len(twts),
+					NextCursor: nextCursor,
+				},
+			}
+
+			body, err := res.Bytes()
+			if err != nil {
+				log.WithError(err).Error("error serializing response")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+			return
+		}
+
 		sort.Sort(twts)
 
 		var pagedTwts types.Twts
@@ -465,12 +503,19 @@ func (a *API) TimelineEndpoint() httprouter.Handle {
 			return
 		}
 
+		// Allow clients to switch to cursor based paging from any page
+		var nextCursor string
+		if pager.HasNext() && len(pagedTwts) > 0 {
+			nextCursor = EncodePageCursor(pagedTwts[len(pagedTwts)-1])
+		}
+
 		res := types.PagedResponse{
 			Twts: a.formatTwtText(FilterTwts(user, pagedTwts)),
 			Pager: types.PagerResponse{
-				Current:   pager.Page(),
-				MaxPages:  pager.PageNums(),
-				TotalTwts: pager.Nums(),
+				Current:    pager.Page(),
+				MaxPages:   pager.PageNums(),
+				TotalTwts:  pager.Nums(),
+				NextCursor: nextCursor,
 			},
 		}
 
@@ -1116,7 +1161,7 @@ func (a *API) FetchTwtsEndpoint() httprouter.Handle {
 			if !a.cache.IsCached(req.URL) {
 				sources := make(types.Feeds)
 				sources[types.Feed{Nick: nick, URL: req.URL}] = true
-				a.cache.FetchTwts(a.config, a.archive, sources, nil)
+				a.cache.FetchTwts(a.config, a.db, a.archive, sources, nil)
 			}
 
 			twts = a.cache.GetByURL(req.URL)
@@ -0,0 +1,538 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	archiveFeedsDir = "archives"
+	archiveFeedExt  = ".txt.gz"
+)
+
+var (
+	ErrInvalidArchivePeriod = errors.New("error: invalid archive period")
+
+	validArchivePeriod = regexp.MustCompile(`^\d{4}(-\d{2})?$`)
+)
+
+// Granularity is the period of time covered by each archive feed of a feed
+type Granularity int
+
+const (
+	// MonthlyArchives keeps one archive feed per month, e.g: 2020-01
+	MonthlyArchives Granularity = iota
+	// YearlyArchives keeps one archive feed per year, e.g: 2020
+	YearlyArchives
+)
+
+// Period returns the period of the archive feed holding twts created at t
+func (g Granularity) Period(t time.Time) string {
+	if g == YearlyArchives {
+		return t.UTC().Format("2006")
+	}
+	return t.UTC().Format("2006-01")
+}
+
+// periodStart returns when an archive period starts
+func periodStart(period string) time.Time {
+	if t, err := time.Parse("2006-01", period); err == nil {
+		return t
+	}
+	t, _ := time.Parse("2006", period)
+	return t
+}
+
+// periodComplete returns true if no twt created from now on can belong to
+// the period of granularity g containing t
+func periodComplete(g Granularity, t time.Time) bool {
+	now := time.Now().UTC()
+	if g == YearlyArchives {
+		return t.UTC().Year() < now.Year()
+	}
+	return g.Period(t) < g.Period(now)
+}
+
+// URLForArchiveFeed returns the url of the named feed's archive feed for
+// period
+func URLForArchiveFeed(conf *Config, name, period string) string {
+	return fmt.Sprintf(
+		"%s/user/%s/archive/%s.txt",
+		strings.TrimSuffix(conf.BaseURL, "/"),
+		name, period,
+	)
+}
+
+func archiveFeedsPath(conf *Config, name string) (string, error) {
+	name, err := sanitizeFeedName(NormalizeUsername(name))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(conf.Data, archiveFeedsDir, name), nil
+}
+
+// ListArchiveFeeds returns the periods of the named feed's archive feeds,
+// oldest first.
+func ListArchiveFeeds(conf *Config, name string) ([]string, error) {
+	p, err := archiveFeedsPath(conf, name)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var periods []string
+	for _, info := range files {
+		period := strings.TrimSuffix(info.Name(), archiveFeedExt)
+		if info.IsDir() || !validArchivePeriod.MatchString(period) {
+			continue
+		}
+		periods = append(periods, period)
+	}
+
+	sort.SliceStable(periods, func(i, j int) bool {
+		return periodStart(periods[i]).Before(periodStart(periods[j]))
+	})
+
+	return periods, nil
+}
+
+// GetArchiveFeed returns the (uncompressed) archive feed of the named feed
+// for period.
+func GetArchiveFeed(conf *Config, name, period string) ([]byte, error) {
+	if !validArchivePeriod.MatchString(period) {
+		return nil, ErrInvalidArchivePeriod
+	}
+
+	p, err := archiveFeedsPath(conf, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return readArchiveFeed(filepath.Join(p, period+archiveFeedExt))
+}
+
+func readArchiveFeed(fn string) ([]byte, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	return ioutil.ReadAll(gr)
+}
+
+func writeArchiveFeed(fn string, data []byte) error {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(fn, buf.Bytes(), 0644)
+}
+
+// archiveFeeds is the archive feeds of a feed being changed, the twt lines
+// of each archive are kept sorted oldest first
+type archiveFeeds struct {
+	conf  *Config
+	name  string
+	path  string
+	twter types.Twter
+
+	periods []string
+	lines   map[string][]string
+	changed map[string]bool
+	removed map[string]bool
+}
+
+// loadArchiveFeeds reads all of the named feed's archive feeds, the caller
+// must hold the lock of the archive feeds directory (see lockFeed)
+func loadArchiveFeeds(conf *Config, name string) (*archiveFeeds, error) {
+	p, err := archiveFeedsPath(conf, name)
+	if err != nil {
+		return nil, err
+	}
+
+	periods, err := ListArchiveFeeds(conf, name)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &archiveFeeds{
+		conf:    conf,
+		name:    name,
+		path:    p,
+		twter:   types.Twter{Nick: name, URL: URLForUser(conf, name)},
+		periods: periods,
+		lines:   make(map[string][]string),
+		changed: make(map[string]bool),
+		removed: make(map[string]bool),
+	}
+
+	for _, period := range periods {
+		data, err := readArchiveFeed(filepath.Join(p, period+archiveFeedExt))
+		if err != nil {
+			log.WithError(err).Errorf("error reading archive feed %s of %s", period, name)
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSuffix(line, "\r")
+			if twt, err := parseLine(conf, line, a.twter); err == nil && !twt.IsZero() {
+				a.lines[period] = append(a.lines[period], line)
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// add adds twt lines to the archive of period keeping them sorted oldest
+// first and skipping lines already archived
+func (a *archiveFeeds) add(period string, lines ...string) {
+	if _, ok := a.lines[period]; !ok {
+		a.periods = append(a.periods, period)
+		sort.SliceStable(a.periods, func(i, j int) bool {
+			return periodStart(a.periods[i]).Before(periodStart(a.periods[j]))
+		})
+		delete(a.removed, period)
+	}
+
+	seen := make(map[string]bool)
+	for _, line := range a.lines[period] {
+		seen[line] = true
+	}
+	for _, line := range lines {
+		if !seen[line] {
+			seen[line] = true
+			a.lines[period] = append(a.lines[period], line)
+		}
+	}
+
+	twts := make(map[string]types.Twt)
+	for _, line := range a.lines[period] {
+		twts[line], _ = parseLine(a.conf, line, a.twter)
+	}
+	sort.SliceStable(a.lines[period], func(i, j int) bool {
+		return twts[a.lines[period][i]].Created.Before(twts[a.lines[period][j]].Created)
+	})
+
+	a.changed[period] = true
+}
+
+// remove removes the archive of period
+func (a *archiveFeeds) remove(period string) {
+	delete(a.lines, period)
+	delete(a.changed, period)
+	a.periods = RemoveString(a.periods, period)
+	a.removed[period] = true
+}
+
+// periodFor returns the period of the existing archive covering t, if any,
+// or the monthly archive t belongs to
+func (a *archiveFeeds) periodFor(t time.Time) string {
+	if year := YearlyArchives.Period(t); HasString(a.periods, year) {
+		return year
+	}
+	return MonthlyArchives.Period(t)
+}
+
+// prev returns the `# prev` value pointing at the archive before index i
+// (or the newest archive for i == len(a.periods))
+func (a *archiveFeeds) prev(i int) string {
+	if i == 0 {
+		return ""
+	}
+	period := a.periods[i-1]
+	lines := a.lines[period]
+	if len(lines) == 0 {
+		return ""
+	}
+	last, _ := parseLine(a.conf, lines[len(lines)-1], a.twter)
+	return fmt.Sprintf("%s %s", last.Hash(), URLForArchiveFeed(a.conf, a.name, period))
+}
+
+// render returns the archive feed of period with its `# prev` pointer
+func (a *archiveFeeds) render(i int) []byte {
+	eol := a.conf.EOL()
+
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("# nick = %s%s", a.name, eol))
+	buf.WriteString(fmt.Sprintf("# url = %s%s", URLForUser(a.conf, a.name), eol))
+	if prev := a.prev(i); prev != "" {
+		buf.WriteString(fmt.Sprintf("# prev = %s%s", prev, eol))
+	}
+	for _, line := range a.lines[a.periods[i]] {
+		buf.WriteString(line + eol)
+	}
+	return []byte(buf.String())
+}
+
+// save writes the archives that changed or whose `# prev` pointer is out of
+// date and removes the archives removed, returning the periods written and
+// removed (without touching any file if dryRun)
+func (a *archiveFeeds) save(dryRun bool) (written, removed []string, err error) {
+	if !dryRun && len(a.periods) > 0 {
+		if err := os.MkdirAll(a.path, 0755); err != nil {
+			log.WithError(err).Errorf("error creating archive feeds directory of %s", a.name)
+			return nil, nil, err
+		}
+	}
+
+	for i, period := range a.periods {
+		fn := filepath.Join(a.path, period+archiveFeedExt)
+		data := a.render(i)
+		if !a.changed[period] {
+			if current, err := readArchiveFeed(fn); err == nil && bytes.Equal(current, data) {
+				continue
+			}
+		}
+		written = append(written, period)
+		if dryRun {
+			continue
+		}
+		if err := writeArchiveFeed(fn, data); err != nil {
+			log.WithError(err).Errorf("error writing archive feed %s of %s", period, a.name)
+			return nil, nil, err
+		}
+	}
+
+	// Removed archives are only removed once the archives replacing them
+	// are written so no twt is lost
+	for period := range a.removed {
+		removed = append(removed, period)
+		if dryRun {
+			continue
+		}
+		fn := filepath.Join(a.path, period+archiveFeedExt)
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Errorf("error removing archive feed %s of %s", period, a.name)
+			return nil, nil, err
+		}
+	}
+	sort.Strings(removed)
+
+	return written, removed, nil
+}
+
+// setFeedPrev sets (or removes if prev is empty) the `# prev` pointer of the
+// feed data returning nil if it is unchanged
+func setFeedPrev(data []byte, prev, eol string) []byte {
+	lines := strings.Split(string(data), "\n")
+
+	idx, found := 0, false
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		idx = i + 1
+		if key, value, ok := ParseMetadataLine(line); ok && key == "prev" {
+			if value == prev {
+				return nil
+			}
+			idx, found = i, true
+			break
+		}
+	}
+
+	newLine := fmt.Sprintf("# prev = %s%s", prev, strings.TrimSuffix(eol, "\n"))
+	switch {
+	case found && prev == "":
+		lines = append(lines[:idx], lines[idx+1:]...)
+	case found:
+		lines[idx] = newLine
+	case prev == "":
+		return nil
+	default:
+		lines = append(lines[:idx], append([]string{newLine}, lines[idx:]...)...)
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// archiveFeedLines moves twt lines of the named feed into its archive feeds
+// (see TrimToArchiveFeeds) returning the `# prev` pointer of the feed
+func archiveFeedLines(conf *Config, name string, lines []string) (string, error) {
+	a, err := lockArchiveFeeds(conf, name)
+	if err != nil {
+		return "", err
+	}
+	defer a.unlock()
+
+	byPeriod := make(map[string][]string)
+	for _, line := range lines {
+		twt, err := parseLine(conf, line, a.twter)
+		if err != nil || twt.IsZero() {
+			continue
+		}
+		period := a.periodFor(twt.Created)
+		byPeriod[period] = append(byPeriod[period], line)
+	}
+	for period, lines := range byPeriod {
+		a.add(period, lines...)
+	}
+
+	if _, _, err := a.save(false); err != nil {
+		return "", err
+	}
+
+	return a.prev(len(a.periods)), nil
+}
+
+// lockedArchiveFeeds is the archive feeds of a feed locked for writing
+type lockedArchiveFeeds struct {
+	*archiveFeeds
+	unlock func()
+}
+
+func lockArchiveFeeds(conf *Config, name string) (*lockedArchiveFeeds, error) {
+	p, err := archiveFeedsPath(conf, name)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := lockFeed(p)
+	a, err := loadArchiveFeeds(conf, name)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	return &lockedArchiveFeeds{archiveFeeds: a, unlock: unlock}, nil
+}
+
+type compactOptions struct {
+	dryRun bool
+}
+
+// CompactOption configures how CompactArchives compacts archive feeds
+type CompactOption func(*compactOptions)
+
+// CompactDryRun makes CompactArchives only log the archive feeds it would
+// write and remove leaving them untouched.
+func CompactDryRun() CompactOption {
+	return func(opts *compactOptions) {
+		opts.dryRun = true
+	}
+}
+
+// CompactArchives merges the archive feeds of the named feed into archive
+// feeds of the given granularity, e.g: the monthly archives of each past
+// year into one yearly archive, re-linking the `# prev` pointers of the
+// archives and of the feed itself. Only archives of complete periods are
+// merged (the current year's monthly archives are kept until the year is
+// over). Archives and the feed are only rewritten if they change so
+// compacting is idempotent.
+func CompactArchives(conf *Config, name string, granularity Granularity, options ...CompactOption) error {
+	opts := &compactOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+
+	var prev string
+
+	compact := func() error {
+		a, err := lockArchiveFeeds(conf, name)
+		if err != nil {
+			return err
+		}
+		defer a.unlock()
+
+		for _, period := range append([]string{}, a.periods...) {
+			start := periodStart(period)
+			target := granularity.Period(start)
+			if target == period || !periodComplete(granularity, start) {
+				continue
+			}
+			lines := a.lines[period]
+			a.remove(period)
+			a.add(target, lines...)
+		}
+
+		written, removed, err := a.save(opts.dryRun)
+		if err != nil {
+			return err
+		}
+		if opts.dryRun {
+			for _, period := range written {
+				conf.feedLog(name).Infof("would write archive feed %s", period)
+			}
+			for _, period := range removed {
+				conf.feedLog(name).Infof("would remove archive feed %s", period)
+			}
+		}
+
+		prev = a.prev(len(a.periods))
+		return nil
+	}
+
+	// The feed is locked first (as when trimming to archive feeds) so its
+	// `# prev` pointer is updated along with the archives
+	err := conf.FeedStore().Update(name, func(data []byte) ([]byte, error) {
+		if err := compact(); err != nil {
+			return nil, err
+		}
+		if opts.dryRun {
+			return nil, nil
+		}
+		return setFeedPrev(data, prev, conf.EOL()), nil
+	})
+	if os.IsNotExist(err) {
+		// Archives of a feed that no longer exists
+		err = compact()
+	}
+	if err != nil {
+		conf.feedLog(name).WithError(err).Error("error compacting archive feeds")
+		return err
+	}
+
+	return nil
+}
+
+// ListArchivedFeeds returns the names of all feeds with archive feeds
+func ListArchivedFeeds(conf *Config) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(conf.Data, archiveFeedsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var names []string
+	for _, info := range files {
+		if info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+// archiveFeedPrev returns the `# prev` pointer of a feed
+func archiveFeedPrev(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		if key, value, ok := ParseMetadataLine(line); ok && key == "prev" {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestCompactArchives(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	twter := user.Twter()
+
+	var twts types.Twts
+	for _, created := range []time.Time{
+		time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+		time.Now().Add(-time.Minute),
+	} {
+		twt, err := AppendTwt(conf, nil, user, "Hello "+created.Format("2006-01"), created)
+		require.NoError(t, err)
+		twts = append(twts, twt)
+	}
+
+	n, err := TrimFeed(conf, "alice", 1, TrimToArchiveFeeds())
+	require.NoError(t, err)
+	assert.Equal(3, n)
+
+	periods, err := ListArchiveFeeds(conf, "alice")
+	require.NoError(t, err)
+	assert.Equal([]string{"2019-03", "2019-07", "2020-01"}, periods)
+
+	// The feed points at its newest archive and each archive at the next older
+	data, err := readFeed(conf.FeedStore(), "alice")
+	require.NoError(t, err)
+	assert.Equal(twts[2].Hash()+" "+URLForArchiveFeed(conf, "alice", "2020-01"), archiveFeedPrev(data))
+
+	data, err = GetArchiveFeed(conf, "alice", "2020-01")
+	require.NoError(t, err)
+	assert.Equal(twts[1].Hash()+" "+URLForArchiveFeed(conf, "alice", "2019-07"), archiveFeedPrev(data))
+	assert.Contains(string(data), "# url = "+URLForUser(conf, "alice"))
+
+	data, err = GetArchiveFeed(conf, "alice", "2019-03")
+	require.NoError(t, err)
+	assert.Empty(archiveFeedPrev(data))
+
+	// A dry run changes nothing
+	require.NoError(t, CompactArchives(conf, "alice", YearlyArchives, CompactDryRun()))
+	periods, err = ListArchiveFeeds(conf, "alice")
+	require.NoError(t, err)
+	assert.Equal([]string{"2019-03", "2019-07", "2020-01"}, periods)
+
+	require.NoError(t, CompactArchives(conf, "alice", YearlyArchives))
+
+	periods, err = ListArchiveFeeds(conf, "alice")
+	require.NoError(t, err)
+	assert.Equal([]string{"2019", "2020"}, periods)
+
+	data, err = GetArchiveFeed(conf, "alice", "2019")
+	require.NoError(t, err)
+	assert.Empty(archiveFeedPrev(data))
+
+	// Archived twts keep their hashes
+	var archived []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if twt, err := ParseLine(strings.TrimSuffix(line, "\r"), twter); err == nil && !twt.IsZero() {
+			archived = append(archived, twt.Hash())
+		}
+	}
+	assert.Equal([]string{twts[0].Hash(), twts[1].Hash()}, archived)
+
+	data, err = GetArchiveFeed(conf, "alice", "2020")
+	require.NoError(t, err)
+	assert.Equal(twts[1].Hash()+" "+URLForArchiveFeed(conf, "alice", "2019"), archiveFeedPrev(data))
+
+	feed, err := readFeed(conf.FeedStore(), "alice")
+	require.NoError(t, err)
+	assert.Equal(twts[2].Hash()+" "+URLForArchiveFeed(conf, "alice", "2020"), archiveFeedPrev(feed))
+	assert.Equal(1, strings.Count(string(feed), "# prev ="))
+
+	// Compacting again is a no-op
+	p := filepath.Join(conf.Data, archiveFeedsDir, "alice", "2019"+archiveFeedExt)
+	before, err := os.Stat(p)
+	require.NoError(t, err)
+
+	require.NoError(t, CompactArchives(conf, "alice", YearlyArchives))
+
+	after, err := os.Stat(p)
+	require.NoError(t, err)
+	assert.Equal(before.ModTime(), after.ModTime())
+
+	again, err := readFeed(conf.FeedStore(), "alice")
+	require.NoError(t, err)
+	assert.Equal(string(feed), string(again))
+
+	_, err = GetArchiveFeed(conf, "alice", "../../feeds/alice")
+	assert.Equal(ErrInvalidArchivePeriod, err)
+	_, err = GetArchiveFeed(conf, "alice", "2018")
+	assert.True(os.IsNotExist(err))
+
+	names, err := ListArchivedFeeds(conf)
+	require.NoError(t, err)
+	assert.Equal([]string{"alice"}, names)
+}
+
+func TestSetFeedPrev(t *testing.T) {
+	assert := assert.New(t)
+
+	feed := "# nick = alice\n2020-01-01T00:00:00Z\tHello\n"
+
+	data := setFeedPrev([]byte(feed), "abc http://foo", "\n")
+	assert.Equal("# nick = alice\n# prev = abc http://foo\n2020-01-01T00:00:00Z\tHello\n", string(data))
+
+	assert.Nil(setFeedPrev(data, "abc http://foo", "\n"))
+
+	data = setFeedPrev(data, "def http://bar", "\n")
+	assert.Equal("# nick = alice\n# prev = def http://bar\n2020-01-01T00:00:00Z\tHello\n", string(data))
+
+	data = setFeedPrev(data, "", "\n")
+	assert.Equal(feed, string(data))
+	assert.Nil(setFeedPrev([]byte(feed), "", "\n"))
+}
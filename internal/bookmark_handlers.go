@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	"github.com/vcraescu/go-paginator"
+	"github.com/vcraescu/go-paginator/adapter"
+
+	"github.com/prologic/twtxt/types"
+)
+
+// BookmarkHandler ...
+func (s *Server) BookmarkHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		ctx := NewContext(s.config, s.db, r)
+
+		hash := strings.TrimSpace(r.FormValue("hash"))
+
+		user := ctx.User
+		if user == nil {
+			log.Fatalf("user not found in context")
+			return
+		}
+
+		if err := AddBookmark(s.db, user, hash); err != nil {
+			ctx.Error = true
+			ctx.Message = fmt.Sprintf("Error bookmarking twt %s: %s", hash, err)
+			s.render("error", w, ctx)
+			return
+		}
+
+		http.Redirect(w, r, RedirectURL(r, s.config, "/bookmarks"), http.StatusFound)
+	}
+}
+
+// UnbookmarkHandler ...
+func (s *Server) UnbookmarkHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		ctx := NewContext(s.config, s.db, r)
+
+		hash := strings.TrimSpace(r.FormValue("hash"))
+
+		user := ctx.User
+		if user == nil {
+			log.Fatalf("user not found in context")
+			return
+		}
+
+		if err := RemoveBookmark(s.db, user, hash); err != nil {
+			ctx.Error = true
+			ctx.Message = fmt.Sprintf("Error removing bookmark of twt %s: %s", hash, err)
+			s.render("error", w, ctx)
+			return
+		}
+
+		http.Redirect(w, r, RedirectURL(r, s.config, "/bookmarks"), http.StatusFound)
+	}
+}
+
+// BookmarksHandler ...
+func (s *Server) BookmarksHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		ctx := NewContext(s.config, s.db, r)
+
+		twts, err := ListBookmarks(s.config, s.cache, ctx.User)
+		if err != nil {
+			ctx.Error = true
+			ctx.Message = "An error occurred while loading bookmarks"
+			s.render("error", w, ctx)
+			return
+		}
+
+		var pagedTwts types.Twts
+
+		page := SafeParseInt(r.FormValue("p"), 1)
+		pager := paginator.New(adapter.NewSliceAdapter(twts), s.config.TwtsPerPage)
+		pager.SetPage(page)
+
+		if err := pager.Results(&pagedTwts); err != nil {
+			ctx.Error = true
+			ctx.Message = "An error occurred while loading bookmarks"
+			s.render("error", w, ctx)
+			return
+		}
+
+		ctx.Title = "Bookmarks"
+		ctx.Twts = FilterTwts(ctx.User, pagedTwts)
+		ctx.Pager = &pager
+		s.render("timeline", w, ctx)
+	}
+}
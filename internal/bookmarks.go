@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prologic/twtxt/types"
+)
+
+var (
+	ErrInvalidBookmark  = errors.New("error: invalid bookmark hash")
+	ErrBookmarkNotFound = errors.New("error: bookmark not found")
+
+	validTwtHash = regexp.MustCompile(`^[a-z0-9]+$`)
+)
+
+// AddBookmark bookmarks the twt with the given hash for the user to read
+// later saving it in the user's record. Bookmarking an already bookmarked
+// twt is a no-op.
+func AddBookmark(db Store, user *User, hash string) error {
+	if !validTwtHash.MatchString(hash) {
+		return ErrInvalidBookmark
+	}
+
+	if user.HasBookmark(hash) {
+		return nil
+	}
+	user.Bookmarks = append(user.Bookmarks, hash)
+
+	if err := db.SetUser(user.Username, user); err != nil {
+		log.WithError(err).Errorf("error saving bookmarks of %s", user.Username)
+		return err
+	}
+
+	return nil
+}
+
+// RemoveBookmark removes the user's bookmark of the twt with the given hash.
+func RemoveBookmark(db Store, user *User, hash string) error {
+	if !user.HasBookmark(hash) {
+		return ErrBookmarkNotFound
+	}
+	user.Bookmarks = RemoveString(user.Bookmarks, hash)
+
+	if err := db.SetUser(user.Username, user); err != nil {
+		log.WithError(err).Errorf("error saving bookmarks of %s", user.Username)
+		return err
+	}
+
+	return nil
+}
+
+// ListBookmarks returns the twts the user has bookmarked, most recent first.
+// Twts are looked up in the cache (if any) and otherwise in the local feeds
+// (see ResolveHashes). Bookmarked twts that no longer exist (e.g: deleted by
+// their author) are skipped but remain bookmarked until removed.
+func ListBookmarks(conf *Config, cache *Cache, user *User) (types.Twts, error) {
+	if len(user.Bookmarks) == 0 {
+		return nil, nil
+	}
+
+	var (
+		twts    types.Twts
+		missing []string
+	)
+	for _, hash := range user.Bookmarks {
+		if cache != nil {
+			if twt, ok := cache.Lookup(ResolveHash(conf, hash)); ok {
+				twts = append(twts, twt)
+				continue
+			}
+		}
+		missing = append(missing, hash)
+	}
+
+	if len(missing) > 0 {
+		resolved, err := ResolveHashes(conf, missing)
+		if err != nil {
+			log.WithError(err).Error("error resolving bookmarks")
+			return nil, err
+		}
+		for _, hash := range missing {
+			if twt, ok := resolved[hash]; ok {
+				twts = append(twts, twt)
+			}
+		}
+	}
+
+	sort.Sort(twts)
+
+	return twts, nil
+}
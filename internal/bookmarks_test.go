@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBookmarks(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	alice := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	first, err := AppendTwt(conf, nil, alice, "First", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	second, err := AppendTwt(conf, nil, alice, "Second")
+	require.NoError(t, err)
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	user := &User{Username: "bob"}
+	require.NoError(t, db.SetUser(user.Username, user))
+	require.NoError(t, AddBookmark(db, user, first.Hash()))
+	require.NoError(t, AddBookmark(db, user, second.Hash()))
+	require.NoError(t, AddBookmark(db, user, first.Hash()))
+	assert.Equal(ErrInvalidBookmark, AddBookmark(db, user, "../foo"))
+
+	// Bookmarks of twts that no longer exist are skipped
+	require.NoError(t, AddBookmark(db, user, "deleted"))
+
+	twts, err := ListBookmarks(conf, nil, user)
+	require.NoError(t, err)
+	require.Len(t, twts, 2)
+	assert.Equal(first.Hash(), twts[1].Hash())
+
+	// Bookmarks are kept on the user's record
+	saved, err := db.GetUser(user.Username)
+	require.NoError(t, err)
+	assert.True(saved.HasBookmark(first.Hash()))
+	assert.Len(saved.Bookmarks, 3)
+
+	require.NoError(t, RemoveBookmark(db, user, first.Hash()))
+	assert.Equal(ErrBookmarkNotFound, RemoveBookmark(db, user, first.Hash()))
+
+	twts, err = ListBookmarks(conf, nil, user)
+	require.NoError(t, err)
+	require.Len(t, twts, 1)
+	assert.Equal(second.Hash(), twts[0].Hash())
+}
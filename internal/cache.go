@@ -3,9 +3,11 @@ package internal
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/gob"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -29,6 +31,15 @@ type Cached struct {
 	cache        types.TwtMap
 	Twts         types.Twts
 	Lastmodified string
+
+	// DeclaredURL is the url the feed declares for itself if it does not
+	// match the url it was fetched from (see VerifyFeedSelfURL)
+	DeclaredURL string
+
+	// Fetched is when the feed was last fetched and Refresh how often the
+	// feed asks to be polled (see RefreshInterval), zero for local feeds
+	Fetched time.Time
+	Refresh time.Duration
 }
 
 // Lookup ...
@@ -131,7 +142,7 @@ func LoadCache(path string) (*Cache, error) {
 const maxfetchers = 50
 
 // FetchTwts ...
-func (cache *Cache) FetchTwts(conf *Config, archive Archiver, feeds types.Feeds, followers map[types.Feed][]string) {
+func (cache *Cache) FetchTwts(conf *Config, db Store, archive Archiver, feeds types.Feeds, followers map[types.Feed][]string) {
 	stime := time.Now()
 	defer func() {
 		metrics.Gauge(
@@ -200,17 +211,34 @@ func (cache *Cache) FetchTwts(conf *Config, archive Archiver, feeds types.Feeds,
 				}
 			}
 
+			// The pod can always read its own feeds requiring a token
+			if name, ok := localFeedName(conf, feed.URL); ok && db != nil {
+				if required, token := FeedRequiresToken(db, name); required {
+					headers.Set("Token", token)
+				}
+			}
+
 			cache.mu.RLock()
-			if cached, ok := cache.Twts[feed.URL]; ok {
+			cached, isCached := cache.Twts[feed.URL]
+			cache.mu.RUnlock()
+			if isCached {
+				// Honor the refresh interval declared by the feed
+				if cached.Refresh > 0 && time.Since(cached.Fetched) < cached.Refresh {
+					twtsch <- cached.Twts
+					return
+				}
 				if cached.Lastmodified != "" {
 					headers.Set("If-Modified-Since", cached.Lastmodified)
 				}
 			}
-			cache.mu.RUnlock()
 
-			res, err := Request(conf, http.MethodGet, feed.URL, headers)
+			defer func(start time.Time) {
+				getFeedMetrics().Observe(MetricFeedReadDuration, time.Since(start).Seconds())
+			}(time.Now())
+
+			res, err := FetchFeed(conf, feed.URL, headers)
 			if err != nil {
-				log.WithError(err).Errorf("error fetching feed %s", feed)
+				conf.feedLog(feed.URL).WithError(err).Error("error fetching feed")
 				twtsch <- nil
 				return
 			}
@@ -218,12 +246,12 @@ func (cache *Cache) FetchTwts(conf *Config, archive Archiver, feeds types.Feeds,
 
 			actualurl := res.Request.URL.String()
 			if actualurl != feed.URL {
-				log.WithError(err).Errorf("feed for %s changed from %s to %s", feed.Nick, feed.URL, actualurl)
+				conf.feedLog(feed.URL).Warnf("feed for %s changed to %s", feed.Nick, actualurl)
 				feed.URL = actualurl
 			}
 
 			if feed.URL == "" {
-				log.WithField("feed", feed).Warn("empty url")
+				conf.feedLog(feed.Nick).Warn("empty url")
 				twtsch <- nil
 				return
 			}
@@ -233,25 +261,64 @@ func (cache *Cache) FetchTwts(conf *Config, archive Archiver, feeds types.Feeds,
 			switch res.StatusCode {
 			case http.StatusOK: // 200
 				limitedReader := &io.LimitedReader{R: res.Body, N: conf.MaxFetchLimit}
-				scanner := bufio.NewScanner(limitedReader)
+				data, err := ioutil.ReadAll(limitedReader)
+				if err != nil {
+					conf.feedLog(feed.URL).WithError(err).Error("error reading feed")
+					twtsch <- nil
+					return
+				}
+				scanner := bufio.NewScanner(bytes.NewReader(data))
 				twter := types.Twter{Nick: feed.Nick}
+				var (
+					declaredURL string
+					authors     []types.Twter
+					refresh     time.Duration
+				)
 				if strings.HasPrefix(feed.URL, conf.BaseURL) {
 					twter.URL = URLForUser(conf, feed.Nick)
 					twter.Avatar = URLForAvatar(conf, feed.Nick)
 				} else {
 					twter.URL = feed.URL
-					avatar := GetExternalAvatar(conf, feed.Nick, feed.URL)
+					var avatar string
+					meta, err := ParseFeedMetadata(bytes.NewReader(data))
+					if err == nil && meta.Avatar != "" {
+						avatar = GetDeclaredExternalAvatar(conf, feed.URL, meta.Avatar)
+					}
+					if err == nil {
+						cacheFeedMetadata(feed.URL, *meta)
+						refresh = RefreshInterval(*meta)
+						authors = meta.Authors
+						fetchedURL := feed.URL
+						if res.Request != nil {
+							fetchedURL = res.Request.URL.String()
+						}
+						if ok, err := VerifyFeedSelfURL(fetchedURL, *meta); err == nil && !ok {
+							conf.feedLog(feed.URL).Warnf("feed fetched from %s declares a different url %s", fetchedURL, meta.URL)
+							declaredURL = meta.URL
+						}
+					}
+					if avatar == "" {
+						avatar = GetExternalAvatar(conf, feed.Nick, feed.URL)
+					}
 					if avatar != "" {
 						twter.Avatar = URLForExternalAvatar(conf, feed.URL)
 					}
 				}
-				twts, old, err := ParseFile(scanner, twter, conf.MaxCacheTTL, conf.MaxCacheItems)
+				twts, old, err := ParseFileContext(context.Background(), conf, scanner, twter, conf.MaxCacheTTL, conf.MaxCacheItems)
 				if err != nil {
-					log.WithError(err).Errorf("error parsing feed %s", feed)
+					conf.feedLog(feed.URL).WithError(err).Error("error parsing feed")
 					twtsch <- nil
 					return
 				}
 
+				// Attribute twts republished by aggregator feeds
+				for i := range twts {
+					twts[i] = AttributeTwt(twts[i], authors)
+				}
+				for i := range old {
+					old[i] = AttributeTwt(old[i], authors)
+				}
+
 				// Archive old twts
 				for _, twt := range old {
 					if !archive.Has(twt.Hash()) {
@@ -270,12 +337,22 @@ func (cache *Cache) FetchTwts(conf *Config, archive Archiver, feeds types.Feeds,
 					cache:        make(map[string]types.Twt),
 					Twts:         twts,
 					Lastmodified: lastmodified,
+					DeclaredURL:  declaredURL,
+					Fetched:      time.Now(),
+					Refresh:      refresh,
 				}
 				cache.mu.Unlock()
 			case http.StatusNotModified: // 304
-				cache.mu.RLock()
+				cache.mu.Lock()
 				twts = cache.Twts[feed.URL].Twts
-				cache.mu.RUnlock()
+				cache.Twts[feed.URL] = Cached{
+					Twts:         twts,
+					Lastmodified: cache.Twts[feed.URL].Lastmodified,
+					DeclaredURL:  cache.Twts[feed.URL].DeclaredURL,
+					Fetched:      time.Now(),
+					Refresh:      cache.Twts[feed.URL].Refresh,
+				}
+				cache.mu.Unlock()
 			}
 
 			twtsch <- twts
@@ -308,9 +385,11 @@ func (cache *Cache) Lookup(hash string) (types.Twt, bool) {
 	for _, cached := range cache.Twts {
 		twt, ok := cached.Lookup(hash)
 		if ok {
+			getFeedMetrics().Inc(MetricCacheHits)
 			return twt, true
 		}
 	}
+	getFeedMetrics().Inc(MetricCacheMisses)
 	return types.Twt{}, false
 }
 
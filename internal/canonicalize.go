@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	backupsDir = "backups"
+)
+
+var (
+	// timeZoneRe matches the timezone (if any) at the end of a timestamp
+	timeZoneRe = regexp.MustCompile(`(?i)(Z|[+-]\d{2}:?\d{2})$`)
+)
+
+// backupFeed saves a copy of the named feed's current contents data in the
+// backups directory returning the path of the backup.
+func backupFeed(conf *Config, name string, data []byte) (string, error) {
+	name, err := sanitizeFeedName(name)
+	if err != nil {
+		return "", err
+	}
+
+	p := filepath.Join(conf.Data, backupsDir)
+	if err := os.MkdirAll(p, 0755); err != nil {
+		log.WithError(err).Error("error creating backups directory")
+		return "", err
+	}
+
+	fn := filepath.Join(p, fmt.Sprintf("%s.%d", name, time.Now().UnixNano()))
+	if err := WriteFileAtomic(fn, data, 0644); err != nil {
+		log.WithError(err).Errorf("error writing backup %s", fn)
+		return "", err
+	}
+
+	return fn, nil
+}
+
+// CanonicalizeFeed cleans up the named local feed rewriting it (atomically)
+// with its twts sorted oldest first, duplicate twts (by hash) removed and
+// all timestamps in a consistent (RFC 3339) format separated from the text
+// by a tab. Twt hashes are unchanged as timestamps keep their precision and
+// offset. Comments (and any other lines that are not twts) are preserved in
+// their original order ahead of the twts.
+//
+// The feed is only rewritten if anything changed, in which case a backup of
+// the original is saved in the backups directory first.
+func CanonicalizeFeed(conf *Config, name string) (bool, error) {
+	twter := types.Twter{Nick: name, URL: URLForUser(conf, name)}
+
+	changed := false
+	if err := conf.FeedStore().Update(name, func(data []byte) ([]byte, error) {
+		var (
+			comments []string
+			twts     types.Twts
+			seen     = make(map[string]bool)
+		)
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSuffix(line, "\r")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+
+			twt, err := parseLine(conf, line, twter)
+			if err != nil || twt.IsZero() {
+				comments = append(comments, line)
+				continue
+			}
+			if seen[twt.Hash()] {
+				continue
+			}
+			seen[twt.Hash()] = true
+			twts = append(twts, twt)
+		}
+
+		sort.SliceStable(twts, func(i, j int) bool {
+			return twts[i].Created.Before(twts[j].Created)
+		})
+
+		var buf strings.Builder
+		for _, line := range comments {
+			buf.WriteString(line + conf.EOL())
+		}
+		for _, twt := range twts {
+			buf.WriteString(fmt.Sprintf("%s\t%s%s", twt.Created.Format(time.RFC3339Nano), twt.Text, conf.EOL()))
+		}
+
+		if buf.String() == string(data) {
+			return nil, nil
+		}
+
+		fn, err := backupFeed(conf, name, data)
+		if err != nil {
+			return nil, err
+		}
+		conf.feedLog(name).Infof("backed up feed to %s", fn)
+
+		changed = true
+		return []byte(buf.String()), nil
+	}); err != nil {
+		conf.feedLog(name).WithError(err).Error("error canonicalizing feed")
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// CheckFeedOrder reports whether the twts in the named local feed are sorted
+// by timestamp, either oldest first (non-decreasing) or newest first
+// (non-increasing) as given by the first twts with differing timestamps. If
+// not, the (1-based) line number of the first twt out of order is returned.
+// Comments and invalid lines are ignored. The feed is read a line at a time
+// and only once, e.g: to decide whether a feed needs CanonicalizeFeed.
+func CheckFeedOrder(conf *Config, name string) (bool, int, error) {
+	f, err := conf.FeedStore().Open(name)
+	if err != nil {
+		return false, 0, err
+	}
+	defer f.Close()
+
+	twter := types.Twter{Nick: name, URL: URLForUser(conf, name)}
+
+	var (
+		last      time.Time
+		direction int // 1 oldest first, -1 newest first, 0 unknown
+		lineNo    int
+	)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineNo++
+
+		twt, err := parseLine(conf, strings.TrimSuffix(scanner.Text(), "\r"), twter)
+		if err != nil || twt.IsZero() {
+			continue
+		}
+
+		if !last.IsZero() {
+			var step int
+			switch {
+			case twt.Created.After(last):
+				step = 1
+			case twt.Created.Before(last):
+				step = -1
+			}
+
+			if direction == 0 {
+				direction = step
+			} else if step != 0 && step != direction {
+				return false, lineNo, nil
+			}
+		}
+		last = twt.Created
+	}
+	if err := scanner.Err(); err != nil {
+		conf.feedLog(name).WithError(err).Error("error reading feed")
+		return false, 0, err
+	}
+
+	return true, 0, nil
+}
+
+// NormalizeFeedTimestamps rewrites (atomically) the timestamp of every twt in
+// the named local feed in canonical form, UTC in RFC 3339 format (with
+// nanoseconds if any) separated from the text by a tab, e.g: to fix the
+// ordering of a feed imported with timestamps in mixed formats or offsets.
+// Timestamps without a timezone are assumed to be in assumeZone, or UTC if
+// nil. Comments and other lines are left untouched. The number of lines
+// changed is returned.
+//
+// The hashes of twts whose timestamps change also change, these are recorded
+// as edits if conf.EditRedirects is enabled (see ResolveHash). The feed is
+// only rewritten if anything changed, in which case a backup of the original
+// is saved in the backups directory first.
+func NormalizeFeedTimestamps(conf *Config, name string, assumeZone *time.Location) (int, error) {
+	if assumeZone == nil {
+		assumeZone = time.UTC
+	}
+
+	twter := types.Twter{Nick: name, URL: URLForUser(conf, name)}
+
+	var (
+		changed   int
+		redirects = make(map[string]string)
+	)
+
+	if err := conf.FeedStore().Update(name, func(data []byte) ([]byte, error) {
+		lines := strings.Split(string(data), "\n")
+		for i, line := range lines {
+			cr := strings.HasSuffix(line, "\r")
+			line = strings.TrimSuffix(line, "\r")
+
+			twt, err := parseLine(conf, line, twter)
+			if err != nil || twt.IsZero() {
+				continue
+			}
+
+			created := twt.Created
+			timestr := strings.TrimSpace(twtLineRe.FindStringSubmatch(line)[1])
+			if !timeZoneRe.MatchString(timestr) {
+				created = time.Date(
+					created.Year(), created.Month(), created.Day(),
+					created.Hour(), created.Minute(), created.Second(), created.Nanosecond(),
+					assumeZone,
+				)
+			}
+
+			newLine := fmt.Sprintf("%s\t%s", created.UTC().Format(time.RFC3339Nano), twt.Text)
+			if newLine == line {
+				continue
+			}
+
+			if normalized, err := parseLine(conf, newLine, twter); err == nil {
+				redirects[twt.Hash()] = normalized.Hash()
+			}
+
+			if cr {
+				newLine += "\r"
+			}
+			lines[i] = newLine
+			changed++
+		}
+
+		if changed == 0 {
+			return nil, nil
+		}
+
+		fn, err := backupFeed(conf, name, data)
+		if err != nil {
+			return nil, err
+		}
+		conf.feedLog(name).Infof("backed up feed to %s", fn)
+
+		return []byte(strings.Join(lines, "\n")), nil
+	}); err != nil {
+		conf.feedLog(name).WithError(err).Error("error normalizing timestamps of feed")
+		return 0, err
+	}
+
+	if changed == 0 {
+		return 0, nil
+	}
+
+	if conf.EditRedirects {
+		if err := recordEdits(conf, redirects); err != nil {
+			conf.feedLog(name).WithError(err).Warn("error recording edits of normalized twts")
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalizeFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	original := "# nick = alice\n" +
+		"2020-01-03T00:00:00+00:00\tThird\n" +
+		"2020-01-01T00:00:00Z\tFirst\n" +
+		"# a comment\n" +
+		"2020-01-02T00:00:00.5Z Second\n" +
+		"2020-01-01T00:00:00Z\tFirst\n"
+
+	store := conf.FeedStore()
+	require.NoError(t, store.Write("alice", []byte(original)))
+
+	before, err := GetAllTwts(conf, "alice")
+	require.NoError(t, err)
+
+	changed, err := CanonicalizeFeed(conf, "alice")
+	require.NoError(t, err)
+	assert.True(changed)
+
+	data, err := readFeed(store, "alice")
+	require.NoError(t, err)
+	assert.Equal(
+		"# nick = alice\n"+
+			"# a comment\n"+
+			"2020-01-01T00:00:00Z\tFirst\n"+
+			"2020-01-02T00:00:00.5Z\tSecond\n"+
+			"2020-01-03T00:00:00Z\tThird\n",
+		string(data),
+	)
+
+	// Hashes are preserved
+	after, err := GetAllTwts(conf, "alice")
+	require.NoError(t, err)
+	require.Len(t, after, 3)
+	for _, twt := range after {
+		found := false
+		for _, old := range before {
+			found = found || old.Hash() == twt.Hash()
+		}
+		assert.True(found, twt.Text)
+	}
+
+	// The original is backed up
+	backups, err := filepath.Glob(filepath.Join(conf.Data, backupsDir, "alice.*"))
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	backup, err := ioutil.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Equal(original, string(backup))
+
+	// Already canonical feeds are left alone
+	changed, err = CanonicalizeFeed(conf, "alice")
+	require.NoError(t, err)
+	assert.False(changed)
+}
+
+func TestCheckFeedOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	store := conf.FeedStore()
+
+	require.NoError(t, store.Write("oldest", []byte(
+		"# nick = oldest\n"+
+			"2021-01-01T00:00:00Z\tOne\n"+
+			"2021-01-01T00:00:00Z\tSame time\n"+
+			"2021-01-02T00:00:00Z\tTwo\n",
+	)))
+	require.NoError(t, store.Write("newest", []byte(
+		"2021-01-02T00:00:00Z\tTwo\n"+
+			"2021-01-01T00:00:00Z\tOne\n",
+	)))
+	require.NoError(t, store.Write("unsorted", []byte(
+		"# nick = unsorted\n"+
+			"2021-01-01T00:00:00Z\tOne\n"+
+			"2021-01-02T00:00:00Z\tTwo\n"+
+			"# a comment\n"+
+			"2020-12-31T00:00:00Z\tOut of order\n"+
+			"2021-01-03T00:00:00Z\tThree\n",
+	)))
+
+	for name, expected := range map[string]struct {
+		sorted bool
+		line   int
+	}{
+		"oldest":   {true, 0},
+		"newest":   {true, 0},
+		"unsorted": {false, 5},
+	} {
+		sorted, line, err := CheckFeedOrder(conf, name)
+		require.NoError(t, err)
+		assert.Equal(expected.sorted, sorted, name)
+		assert.Equal(expected.line, line, name)
+	}
+
+	_, _, err := CheckFeedOrder(conf, "missing")
+	assert.Error(err)
+}
+
+func TestNormalizeFeedTimestamps(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithEditRedirects(true)(conf))
+
+	original := "# nick = alice\n" +
+		"2020-01-01T00:00:00Z\tCanonical\n" +
+		"2020-01-01T10:00:00+10:00\tOffset\n" +
+		"2020-01-01T12:30:00.5\tNo timezone\r\n" +
+		"2020-01-02T00:00 \t Padded\n"
+	require.NoError(t, conf.FeedStore().Write("alice", []byte(original)))
+
+	before, err := GetAllTwts(conf, "alice")
+	require.NoError(t, err)
+
+	zone := time.FixedZone("EST", -5*60*60)
+	changed, err := NormalizeFeedTimestamps(conf, "alice", zone)
+	require.NoError(t, err)
+	assert.Equal(3, changed)
+
+	data, err := readFeed(conf.FeedStore(), "alice")
+	require.NoError(t, err)
+	assert.Equal("# nick = alice\n"+
+		"2020-01-01T00:00:00Z\tCanonical\n"+
+		"2020-01-01T00:00:00Z\tOffset\n"+
+		"2020-01-01T17:30:00.5Z\tNo timezone\r\n"+
+		"2020-01-02T05:00:00Z\tPadded\n", string(data))
+
+	// A backup of the original is kept
+	backups, err := ioutil.ReadDir(filepath.Join(conf.Data, backupsDir))
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+
+	// References to the old hashes resolve to the normalized twts
+	after, err := GetAllTwts(conf, "alice")
+	require.NoError(t, err)
+	hashes := make(map[string]bool)
+	for _, twt := range after {
+		hashes[twt.Hash()] = true
+	}
+	for _, twt := range before {
+		assert.True(hashes[ResolveHash(conf, twt.Hash())], twt.Text)
+	}
+
+	// Normalizing again changes nothing
+	changed, err = NormalizeFeedTimestamps(conf, "alice", zone)
+	require.NoError(t, err)
+	assert.Zero(changed)
+}
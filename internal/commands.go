@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// SlashCommand transforms the arguments of a slash command posted by user
+// (e.g: "waves" for `/me waves`) into the text of the twt to post.
+type SlashCommand func(conf *Config, user *User, args string) string
+
+var (
+	slashCommandRe = regexp.MustCompile(`(?s)^/([a-z][a-z0-9_-]*)(?:\s+(.*))?$`)
+
+	slashCommandsMu sync.RWMutex
+	slashCommands   = make(map[string]SlashCommand)
+)
+
+func init() {
+	RegisterSlashCommand("me", func(conf *Config, user *User, args string) string {
+		if args == "" {
+			return ""
+		}
+		return fmt.Sprintf("_%s %s_", user.Username, args)
+	})
+
+	RegisterTextTransform("slash-commands", BeforeExpand, 0, applySlashCommand)
+}
+
+// RegisterSlashCommand registers a SlashCommand for the (lower case) command
+// name, replacing any command already registered with that name.
+func RegisterSlashCommand(name string, command SlashCommand) {
+	slashCommandsMu.Lock()
+	defer slashCommandsMu.Unlock()
+
+	slashCommands[strings.ToLower(name)] = command
+}
+
+// applySlashCommand is a TextTransform that runs the slash command the text
+// starts with, if any, when SlashCommands are enabled. Only a leading
+// `/command` is recognized so slashes elsewhere (e.g: in URLs) are never
+// treated as commands and unknown commands are posted unchanged.
+func applySlashCommand(conf *Config, user *User, text string) string {
+	if !conf.SlashCommands {
+		return text
+	}
+
+	match := slashCommandRe.FindStringSubmatch(text)
+	if match == nil {
+		return text
+	}
+
+	slashCommandsMu.RLock()
+	command, ok := slashCommands[match[1]]
+	slashCommandsMu.RUnlock()
+	if !ok {
+		return text
+	}
+
+	return command(conf, user, strings.TrimSpace(match[2]))
+}
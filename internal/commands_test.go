@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlashCommands(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "alice"}
+
+	// Opt-in
+	twt, err := AppendTwt(conf, nil, user, "/me waves")
+	require.NoError(t, err)
+	assert.Equal("/me waves", twt.Text)
+
+	require.NoError(t, WithSlashCommands(true)(conf))
+
+	RegisterSlashCommand("shout", func(conf *Config, user *User, args string) string {
+		return args + "!!!"
+	})
+	defer func() {
+		slashCommandsMu.Lock()
+		delete(slashCommands, "shout")
+		slashCommandsMu.Unlock()
+	}()
+
+	testCases := []struct {
+		text     string
+		expected string
+	}{
+		{"/me waves", "_alice waves_"},
+		{"/shout hello", "hello!!!"},
+		{"/unknown command", "/unknown command"},
+		{"/usr/bin is where binaries live", "/usr/bin is where binaries live"},
+		{"see https://example.com/me for details", "see https://example.com/me for details"},
+		{"not /me a command", "not /me a command"},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(testCase.expected, applySlashCommand(conf, user, testCase.text), testCase.text)
+	}
+
+	twt, err = AppendTwt(conf, nil, user, "/me waves")
+	require.NoError(t, err)
+	assert.Equal("_alice waves_", twt.Text)
+
+	_, err = AppendTwt(conf, nil, user, "/me")
+	assert.Error(err)
+}
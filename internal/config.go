@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -16,7 +17,10 @@ import (
 )
 
 var (
-	ErrConfigPathMissing = errors.New("error: config file missing")
+	ErrConfigPathMissing        = errors.New("error: config file missing")
+	ErrInvalidLineEnding        = errors.New("error: invalid line ending (expected lf or crlf)")
+	ErrInvalidMentionPrecedence = errors.New("error: invalid mention precedence (expected following or local)")
+	ErrInvalidTagNamespace      = errors.New("error: invalid tag namespace")
 )
 
 // Settings contains Pod Settings that can be customised via the Web UI
@@ -44,19 +48,44 @@ type Config struct {
 	AdminName         string
 	AdminEmail        string
 	FeedSources       []string
+	SystemFeeds       []string
+	ReactionMarkers   []string
+	TrackingParams    []string
 	RegisterMessage   string
 	CookieSecret      string
 	TwtPrompts        []string
 	TwtsPerPage       int
 	MaxUploadSize     int64
 	MaxTwtLength      int
+	MaxMentions       int
+	PostBurst         int
+	PostRate          time.Duration
+	SpamThreshold     float64
+	SpamTimeout       time.Duration
 	MaxCacheTTL       time.Duration
 	MaxCacheItems     int
+	FeedTTL           time.Duration
+	LineEnding        string
+	MentionPrecedence string
+	TagNamespace      string
 	OpenProfiles      bool
 	OpenRegistrations bool
 	SessionExpiry     time.Duration
 	SessionCacheTTL   time.Duration
 	TranscoderTimeout time.Duration
+	StripReplyTargets bool
+	EnablePolls       bool
+	EditRedirects     bool
+	RenameRedirects   bool
+	UpdateMovedFeeds  bool
+	EmptyEditDeletes  bool
+	MarkEdits         bool
+	LookupMentions    bool
+	SlashCommands     bool
+	CleanLinks        bool
+	QuarantineSpam    bool
+	StrictSeparator   bool
+	StripImportedHTML bool
 
 	MagicLinkSecret string
 
@@ -70,12 +99,16 @@ type Config struct {
 
 	APISessionTime time.Duration
 	APISigningKey  string
+	PingSigningKey string
 
 	baseURL *url.URL
 
 	whitelistedDomains []*regexp.Regexp
 	WhitelistedDomains []string
 
+	feedStore FeedStore
+	logger    log.FieldLogger
+
 	path string
 }
 
@@ -91,6 +124,50 @@ func (c *Config) Settings() *Settings {
 	return settings
 }
 
+// FeedStore returns the FeedStore local feeds are stored in, by default
+// a DiskFeedStore in the feeds directory of the data directory.
+func (c *Config) FeedStore() FeedStore {
+	if c.feedStore != nil {
+		return c.feedStore
+	}
+	return &DiskFeedStore{path: filepath.Join(c.Data, feedsDir)}
+}
+
+// Logger returns the logger feed operations are logged to, by default (or
+// for a nil Config) the standard logrus logger.
+func (c *Config) Logger() log.FieldLogger {
+	if c != nil && c.logger != nil {
+		return c.logger
+	}
+	return log.StandardLogger()
+}
+
+// feedLog returns a logger for operations on the named feed
+func (c *Config) feedLog(name string) *log.Entry {
+	return c.Logger().WithField("feed", name)
+}
+
+// userLog returns a logger for operations on behalf of the named user
+func (c *Config) userLog(name string) *log.Entry {
+	return c.Logger().WithField("user", name)
+}
+
+// EOL returns the line ending written to local feeds as per the configured
+// LineEnding, "\n" (lf) unless configured as "\r\n" (crlf).
+func (c *Config) EOL() string {
+	if c.LineEnding == "crlf" {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// LocalMentionsFirst returns true if mentions of a nick that is both
+// followed and a local user or feed expand to the local user or feed as per
+// the configured MentionPrecedence, "following" (the default) or "local".
+func (c *Config) LocalMentionsFirst() bool {
+	return c.MentionPrecedence == "local"
+}
+
 // WhitelistedDomain returns true if the domain provided is a whiltelisted
 // domain as per the configuration
 func (c *Config) WhitelistedDomain(domain string) (bool, bool) {
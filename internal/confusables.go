@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"errors"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrConfusableUsername = errors.New("error: username contains confusable characters")
+
+	// confusables maps non-Latin runes that are visually indistinguishable
+	// from a (lowercase) Latin letter or digit to that letter or digit. This
+	// is a small subset of Unicode's confusables covering the Cyrillic and
+	// Greek lookalikes commonly used to spoof nicks (e.g: Cyrillic а for a).
+	confusables = map[rune]rune{
+		// Cyrillic
+		'\u0430': 'a', '\u0432': 'b', '\u0435': 'e', '\u0451': 'e',
+		'\u043a': 'k', '\u043c': 'm', '\u043d': 'h', '\u043e': 'o',
+		'\u0440': 'p', '\u0441': 'c', '\u0442': 't', '\u0443': 'y',
+		'\u0445': 'x', '\u0455': 's', '\u0456': 'i', '\u0457': 'i',
+		'\u0458': 'j', '\u0501': 'd', '\u051b': 'q', '\u051d': 'w',
+		'\u04bb': 'h', '\u04cf': 'l',
+		// Greek
+		'\u03b1': 'a', '\u03b9': 'i', '\u03ba': 'k', '\u03bd': 'v',
+		'\u03bf': 'o', '\u03c1': 'p', '\u03c4': 't', '\u03c5': 'u',
+		'\u03c7': 'x',
+		// Latin lookalikes outside of ASCII
+		'\u0131': 'i', '\u0261': 'g', '\u0251': 'a',
+	}
+)
+
+// IsConfusableUsername returns true if username (once normalized, see
+// NormalizeUsername) contains characters that are visually confusable with
+// Latin letters or digits and could be used to impersonate another user,
+// e.g: `аlice` using a Cyrillic а to pass as `alice`.
+func IsConfusableUsername(username string) bool {
+	for _, r := range NormalizeUsername(username) {
+		if r <= unicode.MaxASCII {
+			continue
+		}
+		if _, ok := confusables[r]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeUnicode applies NFKC normalization so that compatibility forms
+// of characters (e.g: fullwidth ａ) compare equal to their canonical form.
+func normalizeUnicode(s string) string {
+	return norm.NFKC.String(s)
+}
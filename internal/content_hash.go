@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"encoding/base32"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+var (
+	blanksRe = regexp.MustCompile(`[ \t]+`)
+
+	contentHashesMu sync.Mutex
+	contentHashes   = make(map[string]contentHash)
+)
+
+type contentHash struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+// FeedContentHash returns a hash of the twts in the named feed that, unlike
+// the feed's ETag (see FeedETag), only changes when its twts do: comments,
+// blank lines, line endings, the order of twts and whitespace around and
+// within their text (other than line separators) are all ignored, e.g: so
+// feeds that only changed cosmetically need not be reprocessed. Hashes are
+// cached until the feed's modification time or size changes.
+func FeedContentHash(conf *Config, name string) (string, error) {
+	store := conf.FeedStore()
+
+	fileInfo, err := store.Stat(name)
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("%s\x00%s", conf.Data, name)
+
+	contentHashesMu.Lock()
+	cached, ok := contentHashes[key]
+	contentHashesMu.Unlock()
+	if ok && cached.modTime.Equal(fileInfo.ModTime()) && cached.size == fileInfo.Size() {
+		return cached.hash, nil
+	}
+
+	twts, err := GetAllTwts(conf, name)
+	if err != nil {
+		if err != ErrInvalidFeed {
+			return "", err
+		}
+		twts = nil
+	}
+
+	lines := make([]string, 0, len(twts))
+	for _, twt := range twts {
+		text := blanksRe.ReplaceAllString(strings.TrimSpace(twt.Text), " ")
+		lines = append(lines, fmt.Sprintf("%s\t%s", twt.Created.UTC().Format(time.RFC3339Nano), text))
+	}
+	sort.Strings(lines)
+
+	sum := blake2b.Sum256([]byte(strings.Join(lines, "\n")))
+	hash := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:]))
+
+	contentHashesMu.Lock()
+	contentHashes[key] = contentHash{modTime: fileInfo.ModTime(), size: fileInfo.Size(), hash: hash}
+	contentHashesMu.Unlock()
+
+	return hash, nil
+}
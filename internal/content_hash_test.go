@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedContentHash(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	store := conf.FeedStore()
+
+	_, err := FeedContentHash(conf, "test")
+	assert.Error(err)
+
+	require.NoError(t, store.Write("test", []byte(
+		"# nick = test\n"+
+			"2021-01-01T00:00:00Z\tHello World!\n"+
+			"2021-01-02T00:00:00Z\tSecond  twt\n",
+	)))
+
+	hash, err := FeedContentHash(conf, "test")
+	require.NoError(t, err)
+	assert.NotEmpty(hash)
+
+	// Only cosmetic differences: comments, blank lines, line endings,
+	// separators, whitespace and order
+	require.NoError(t, store.Write("test", []byte(
+		"2021-01-02T00:00:00Z Second twt  \r\n"+
+			"\r\n"+
+			"2021-01-01T00:00:00Z\t  Hello World!\r\n",
+	)))
+
+	same, err := FeedContentHash(conf, "test")
+	require.NoError(t, err)
+	assert.Equal(hash, same)
+
+	// A new twt changes the hash
+	require.NoError(t, store.Append("test", []byte("2021-01-03T00:00:00Z\tThird twt\n")))
+
+	changed, err := FeedContentHash(conf, "test")
+	require.NoError(t, err)
+	assert.NotEqual(hash, changed)
+}
@@ -66,6 +66,7 @@ type Context struct {
 
 	Twter       types.Twter
 	Twts        types.Twts
+	Reactions   map[string]int
 	BlogPost    *BlogPost
 	BlogPosts   BlogPosts
 	Feeds       []*Feed
@@ -112,6 +113,11 @@ func NewContext(conf *Config, db Store, req *http.Request) *Context {
 				Title: fmt.Sprintf("%s local feed", conf.Name),
 				URL:   fmt.Sprintf("%s/atom.xml", conf.BaseURL),
 			},
+			types.Alternative{
+				Type:  "application/feed+json",
+				Title: fmt.Sprintf("%s local feed", conf.Name),
+				URL:   fmt.Sprintf("%s/feed.json", conf.BaseURL),
+			},
 		},
 	}
 
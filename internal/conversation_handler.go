@@ -45,6 +45,14 @@ func (s *Server) ConversationHandler() httprouter.Handle {
 			}
 		}
 
+		if twt.IsZero() && s.config.EditRedirects {
+			// The twt may have been edited since
+			if resolved := ResolveHash(s.config, hash); resolved != hash {
+				http.Redirect(w, r, fmt.Sprintf("/conv/%s", resolved), http.StatusMovedPermanently)
+				return
+			}
+		}
+
 		if twt.IsZero() {
 			ctx.Error = true
 			ctx.Message = "No matching twt found!"
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	// TimelineCursor is the key of the read cursor of a user's timeline,
+	// other read cursors are keyed by the feed's url.
+	TimelineCursor = "timeline"
+)
+
+var (
+	ErrReadCursorNotFound = errors.New("error: read cursor not found")
+)
+
+// ReadCursor records the twt a user most recently read in a feed or their
+// timeline.
+type ReadCursor struct {
+	Hash    string
+	Created time.Time
+}
+
+// SetReadCursor records twt as the twt the user most recently read in the
+// feed or timeline with the given key (see TimelineCursor) saving it in the
+// user's record.
+func SetReadCursor(db Store, user *User, key string, twt types.Twt) error {
+	if user.ReadCursors == nil {
+		user.ReadCursors = make(map[string]ReadCursor)
+	}
+	user.ReadCursors[key] = ReadCursor{Hash: twt.Hash(), Created: twt.Created}
+
+	if err := db.SetUser(user.Username, user); err != nil {
+		log.WithError(err).Errorf("error saving read cursors of %s", user.Username)
+		return err
+	}
+
+	return nil
+}
+
+// GetReadCursor returns the user's read cursor for the feed or timeline with
+// the given key, or ErrReadCursorNotFound if they have not read it yet.
+func GetReadCursor(user *User, key string) (ReadCursor, error) {
+	cursor, ok := user.ReadCursors[key]
+	if !ok {
+		return ReadCursor{}, ErrReadCursorNotFound
+	}
+
+	return cursor, nil
+}
+
+// CountTwtsSince returns the number of twts newer than the cursor's twt,
+// i.e: the number of unread twts. Twts are compared by timestamp so the
+// count is still correct if the cursor's twt was since edited or deleted.
+func CountTwtsSince(twts types.Twts, cursor ReadCursor) int {
+	var n int
+	for _, twt := range twts {
+		if twt.Created.After(cursor.Created) && twt.Hash() != cursor.Hash {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCursors(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+	now := time.Now().Truncate(time.Second)
+
+	var twts []string
+	for i := 5; i > 0; i-- {
+		twt, err := AppendTwt(conf, nil, user, "Hello World!", now.Add(-time.Duration(i)*time.Minute))
+		require.NoError(t, err)
+		twts = append(twts, twt.Hash())
+	}
+
+	all, err := GetAllTwts(conf, "test")
+	require.NoError(t, err)
+
+	_, err = GetReadCursor(user, TimelineCursor)
+	assert.Equal(ErrReadCursorNotFound, err)
+
+	// Newest first, read up to the 2nd oldest twt
+	require.NoError(t, SetReadCursor(db, user, TimelineCursor, all[3]))
+	cursor, err := GetReadCursor(user, TimelineCursor)
+	require.NoError(t, err)
+	assert.Equal(twts[1], cursor.Hash)
+	assert.True(all[3].Created.Equal(cursor.Created))
+	assert.Equal(3, CountTwtsSince(all, cursor))
+
+	// Cursors are per key
+	_, err = GetReadCursor(user, URLForUser(conf, "test"))
+	assert.Equal(ErrReadCursorNotFound, err)
+
+	require.NoError(t, SetReadCursor(db, user, TimelineCursor, all[0]))
+	cursor, err = GetReadCursor(user, TimelineCursor)
+	require.NoError(t, err)
+	assert.Equal(0, CountTwtsSince(all, cursor))
+
+	// Cursors are saved in the user's record
+	saved, err := db.GetUser("test")
+	require.NoError(t, err)
+	cursor, err = GetReadCursor(saved, TimelineCursor)
+	require.NoError(t, err)
+	assert.Equal(all[0].Hash(), cursor.Hash)
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prologic/twtxt/types"
+)
+
+var (
+	ErrInvalidDraftID = errors.New("error: invalid draft id")
+	ErrDraftNotFound  = errors.New("error: draft not found")
+
+	validDraftID = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// Draft is an unpublished twt saved by a user to be posted later. Drafts are
+// stored in the user's record so they never appear in any feed.
+type Draft struct {
+	ID    string
+	Text  string
+	Saved time.Time
+}
+
+type Drafts []Draft
+
+func (ds Drafts) Len() int {
+	return len(ds)
+}
+func (ds Drafts) Less(i, j int) bool {
+	return ds[i].Saved.After(ds[j].Saved)
+}
+func (ds Drafts) Swap(i, j int) {
+	ds[i], ds[j] = ds[j], ds[i]
+}
+
+// SaveDraft saves (or overwrites) the user's draft with the given id in the
+// user's record.
+func SaveDraft(db Store, user *User, id, text string) error {
+	if !validDraftID.MatchString(id) {
+		return ErrInvalidDraftID
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("cowardly refusing to save empty draft, or only spaces")
+	}
+
+	if user.Drafts == nil {
+		user.Drafts = make(map[string]Draft)
+	}
+	user.Drafts[id] = Draft{ID: id, Text: text, Saved: time.Now()}
+
+	if err := db.SetUser(user.Username, user); err != nil {
+		log.WithError(err).Errorf("error saving draft %s of %s", id, user.Username)
+		return err
+	}
+
+	return nil
+}
+
+// ListDrafts returns all of the user's drafts, most recently saved first.
+func ListDrafts(user *User) Drafts {
+	drafts := make(Drafts, 0, len(user.Drafts))
+	for id, draft := range user.Drafts {
+		draft.ID = id
+		drafts = append(drafts, draft)
+	}
+
+	sort.Sort(drafts)
+
+	return drafts
+}
+
+// DeleteDraft removes the user's draft with the given id.
+func DeleteDraft(db Store, user *User, id string) error {
+	if !validDraftID.MatchString(id) {
+		return ErrInvalidDraftID
+	}
+	if _, ok := user.Drafts[id]; !ok {
+		return ErrDraftNotFound
+	}
+
+	delete(user.Drafts, id)
+
+	if err := db.SetUser(user.Username, user); err != nil {
+		log.WithError(err).Errorf("error deleting draft %s of %s", id, user.Username)
+		return err
+	}
+
+	return nil
+}
+
+// PublishDraft posts the user's draft with the given id to their feed and
+// removes the draft once it has been successfully posted.
+func PublishDraft(conf *Config, db Store, user *User, id string) (types.Twt, error) {
+	if !validDraftID.MatchString(id) {
+		return types.Twt{}, ErrInvalidDraftID
+	}
+	draft, ok := user.Drafts[id]
+	if !ok {
+		return types.Twt{}, ErrDraftNotFound
+	}
+
+	twt, err := AppendTwt(conf, db, user, draft.Text)
+	if err != nil {
+		return types.Twt{}, err
+	}
+
+	if err := DeleteDraft(db, user, id); err != nil {
+		log.WithError(err).Warnf("error removing published draft %s of %s", id, user.Username)
+	}
+
+	return twt, nil
+}
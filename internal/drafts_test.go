@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrafts(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	user := NewUser()
+	user.Username = "alice"
+	user.URL = URLForUser(conf, "alice")
+	require.NoError(t, db.SetUser("alice", user))
+
+	assert.Empty(ListDrafts(user))
+
+	require.NoError(t, SaveDraft(db, user, "first", "  Hello World!  "))
+	require.NoError(t, SaveDraft(db, user, "second", "Second draft"))
+	require.NoError(t, SaveDraft(db, user, "first", "Hello again!"))
+	assert.Error(SaveDraft(db, user, "empty", "   "))
+
+	// Drafts are saved in the user's record, most recently saved first
+	saved, err := db.GetUser("alice")
+	require.NoError(t, err)
+	drafts := ListDrafts(saved)
+	require.Len(t, drafts, 2)
+	assert.Equal("first", drafts[0].ID)
+	assert.Equal("Hello again!", drafts[0].Text)
+	assert.Equal("second", drafts[1].ID)
+
+	require.NoError(t, DeleteDraft(db, saved, "second"))
+	assert.Equal(ErrDraftNotFound, DeleteDraft(db, saved, "second"))
+
+	twt, err := PublishDraft(conf, db, saved, "first")
+	require.NoError(t, err)
+	assert.Equal("Hello again!", twt.Text)
+	_, err = PublishDraft(conf, db, saved, "first")
+	assert.Equal(ErrDraftNotFound, err)
+
+	saved, err = db.GetUser("alice")
+	require.NoError(t, err)
+	assert.Empty(ListDrafts(saved))
+
+	twts, err := GetAllTwts(conf, "alice")
+	require.NoError(t, err)
+	assert.Len(twts, 1)
+}
+
+func TestDraftIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	user := &User{Username: "alice"}
+
+	for _, id := range []string{"", "../../tmp", "a/b", "draft.txt", "with space"} {
+		assert.Equal(ErrInvalidDraftID, SaveDraft(db, user, id, "Hello World!"), id)
+		assert.Equal(ErrInvalidDraftID, DeleteDraft(db, user, id), id)
+		_, err := PublishDraft(conf, db, user, id)
+		assert.Equal(ErrInvalidDraftID, err, id)
+	}
+	assert.Empty(ListDrafts(user))
+
+	assert.NoError(SaveDraft(db, user, "quarantined-0123_abc", "Hello World!"))
+}
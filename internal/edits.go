@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	editsFile = "edits.json"
+
+	// editedFile records the twts EditTwt marked as edited (see EditedAt)
+	editedFile = "edited.json"
+
+	// maxEditRedirects is the maximum number of edit redirects followed by
+	// ResolveHash to guard against cycles in the edits mapping
+	maxEditRedirects = 32
+)
+
+// RecordEdit records that the twt with oldHash was edited and is now the
+// twt with newHash so that references to oldHash can be resolved with
+// ResolveHash.
+func RecordEdit(conf *Config, oldHash, newHash string) error {
+	return recordEdits(conf, map[string]string{oldHash: newHash})
+}
+
+func recordEdits(conf *Config, redirects map[string]string) error {
+	err := getSidecar(conf, editsFile).Update(func(edits map[string]string) {
+		for oldHash, newHash := range redirects {
+			if oldHash == newHash {
+				continue
+			}
+			edits[oldHash] = newHash
+			// An edit to a previously edited twt (back to its original text)
+			// must not create a cycle
+			delete(edits, newHash)
+		}
+	})
+	if err != nil {
+		log.WithError(err).Error("error recording edits")
+		return err
+	}
+
+	return nil
+}
+
+// ResolveHash follows any edit redirects recorded for hash (see EditTwt)
+// returning the hash of the current version of the twt, or hash itself if
+// the twt was never edited.
+//
+// Twt hashes are derived from a twt's content so strictly twts are
+// immutable and an edit is really a new twt replacing the old one. Edit
+// redirects keep existing replies (whose subject references the old hash)
+// attached to the edited twt, at the cost of a reply possibly referring to
+// text the twt no longer has. Redirects are only known to this pod and other
+// pods and clients still see replies to the old hash as dangling.
+func ResolveHash(conf *Config, hash string) string {
+	return editsResolver(conf)(hash)
+}
+
+// editsResolver returns a func resolving hashes like ResolveHash against the
+// edits recorded at the time of the call, for resolving many hashes at once
+// (e.g: while scanning feeds)
+func editsResolver(conf *Config) func(hash string) string {
+	edits, err := getSidecar(conf, editsFile).Snapshot()
+	if err != nil {
+		log.WithError(err).Error("error loading edits")
+	}
+
+	return func(hash string) string {
+		return resolveRedirects(edits, hash, maxEditRedirects)
+	}
+}
+
+// resolveRedirects follows the redirects (e.g: edits or renames) of key at
+// most max times returning where key is redirected to
+func resolveRedirects(redirects map[string]string, key string, max int) string {
+	for i := 0; i < max; i++ {
+		next, ok := redirects[key]
+		if !ok {
+			break
+		}
+		key = next
+	}
+	return key
+}
+
+// recordEditedAt records that EditTwt marked the twt with hash (replacing the
+// twt with oldHash) as edited at editedAt
+func recordEditedAt(conf *Config, oldHash, hash string, editedAt time.Time) error {
+	err := getSidecar(conf, editedFile).Update(func(edited map[string]string) {
+		delete(edited, oldHash)
+		edited[hash] = editedAt.UTC().Format(time.RFC3339)
+	})
+	if err != nil {
+		log.WithError(err).Error("error recording edited twt")
+		return err
+	}
+
+	return nil
+}
+
+// EditedAt returns the time a twt was edited given by the edited marker in
+// its text (see types.EditedMarker), or the zero time if it has none. The
+// marker is only honoured if EditTwt wrote it so a twt cannot claim to be
+// edited by merely ending with something that looks like a marker.
+func EditedAt(conf *Config, twt types.Twt) time.Time {
+	editedAt := types.ParseEditedAt(twt.Text)
+	if editedAt.IsZero() {
+		return editedAt
+	}
+
+	recorded, ok, err := getSidecar(conf, editedFile).Get(twt.Hash())
+	if err != nil {
+		log.WithError(err).Error("error loading edited twts")
+		return time.Time{}
+	}
+	if !ok || recorded != editedAt.UTC().Format(time.RFC3339) {
+		return time.Time{}
+	}
+
+	return editedAt
+}
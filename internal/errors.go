@@ -3,6 +3,7 @@ package internal
 import (
 	"fmt"
 	"syscall"
+	"time"
 )
 
 type ErrCommandKilled struct {
@@ -92,3 +93,18 @@ func (e *ErrVideoUploadFailed) Error() string {
 func (e *ErrVideoUploadFailed) Unwrap() error {
 	return e.Err
 }
+
+type ErrRateLimited struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrRateLimited) Is(target error) bool {
+	if _, ok := target.(*ErrRateLimited); ok {
+		return true
+	}
+	return false
+}
+
+func (e *ErrRateLimited) Error() string {
+	return fmt.Sprintf("error: rate limited, retry after %s", e.RetryAfter)
+}
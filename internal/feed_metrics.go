@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"sync"
+
+	"github.com/prologic/observe"
+)
+
+// Names of the feed level metrics collected
+const (
+	MetricTwtsAppended     = "twts_appended"
+	MetricParseErrors      = "parse_errors"
+	MetricFeedReadDuration = "read_seconds"
+	MetricCacheHits        = "cache_hits"
+	MetricCacheMisses      = "cache_misses"
+	MetricSpaceSeparators  = "space_separators"
+)
+
+// FeedMetrics is an interface for collecting feed level metrics such as the
+// number of twts appended, parse errors, feed read durations, cache
+// hits/misses and twts separated by spaces so pods can wire them up to their
+// metrics system or discard them entirely.
+type FeedMetrics interface {
+	Inc(name string)
+	Observe(name string, value float64)
+}
+
+// NullFeedMetrics discards all metrics
+type NullFeedMetrics struct{}
+
+func (m NullFeedMetrics) Inc(name string)                    {}
+func (m NullFeedMetrics) Observe(name string, value float64) {}
+
+var (
+	feedMetricsMu sync.RWMutex
+	feedMetrics   FeedMetrics = NullFeedMetrics{}
+)
+
+// SetFeedMetrics sets the FeedMetrics used to collect feed level metrics,
+// a nil FeedMetrics discards all metrics.
+func SetFeedMetrics(m FeedMetrics) {
+	if m == nil {
+		m = NullFeedMetrics{}
+	}
+
+	feedMetricsMu.Lock()
+	feedMetrics = m
+	feedMetricsMu.Unlock()
+}
+
+func getFeedMetrics() FeedMetrics {
+	feedMetricsMu.RLock()
+	defer feedMetricsMu.RUnlock()
+	return feedMetrics
+}
+
+// ObserveFeedMetrics implements FeedMetrics using Prometheus counters and
+// summaries under the "feed" subsystem.
+type ObserveFeedMetrics struct {
+	metrics *observe.Metrics
+}
+
+func NewObserveFeedMetrics(metrics *observe.Metrics) FeedMetrics {
+	metrics.NewCounter(
+		"feed", MetricTwtsAppended,
+		"Number of twts appended to local feeds",
+	)
+	metrics.NewCounter(
+		"feed", MetricParseErrors,
+		"Number of invalid lines encountered parsing feeds",
+	)
+	metrics.NewSummary(
+		"feed", MetricFeedReadDuration,
+		"Duration in seconds of reading and parsing feeds",
+	)
+	metrics.NewCounter(
+		"feed", MetricCacheHits,
+		"Number of twts found in the global feed cache",
+	)
+	metrics.NewCounter(
+		"feed", MetricCacheMisses,
+		"Number of twts not found in the global feed cache",
+	)
+	metrics.NewCounter(
+		"feed", MetricSpaceSeparators,
+		"Number of twts parsed with a space instead of a tab separator",
+	)
+
+	return &ObserveFeedMetrics{metrics: metrics}
+}
+
+func (m *ObserveFeedMetrics) Inc(name string) {
+	m.metrics.Counter("feed", name).Inc()
+}
+
+func (m *ObserveFeedMetrics) Observe(name string, value float64) {
+	m.metrics.Summary("feed", name).Observe(value)
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prologic/observe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedMetricsDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(NullFeedMetrics{}, getFeedMetrics())
+
+	metrics := countingFeedMetrics{}
+	SetFeedMetrics(metrics)
+	assert.Equal(metrics, getFeedMetrics())
+
+	// nil discards all metrics
+	SetFeedMetrics(nil)
+	assert.Equal(NullFeedMetrics{}, getFeedMetrics())
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	_, err := AppendTwt(conf, nil, &User{Username: "test", URL: URLForUser(conf, "test")}, "Hello World!")
+	require.NoError(t, err)
+	assert.Empty(metrics)
+}
+
+var (
+	observeFeedMetricsOnce sync.Once
+	observeMetrics         *observe.Metrics
+	observeFeedMetrics     FeedMetrics
+)
+
+func TestObserveFeedMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	// Metrics are registered with the default Prometheus registry which
+	// only allows registering each metric once
+	observeFeedMetricsOnce.Do(func() {
+		observeMetrics = observe.NewMetrics("feedmetricstest")
+		observeFeedMetrics = NewObserveFeedMetrics(observeMetrics)
+	})
+	SetFeedMetrics(observeFeedMetrics)
+	defer SetFeedMetrics(nil)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+	for _, text := range []string{"Hello", "World"} {
+		_, err := AppendTwt(conf, nil, user, text)
+		require.NoError(t, err)
+	}
+	require.NoError(t, conf.FeedStore().Append("test", []byte("invalid\n")))
+
+	twts, err := GetAllTwts(conf, "test")
+	require.NoError(t, err)
+	assert.Len(twts, 2)
+
+	w := httptest.NewRecorder()
+	observeMetrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(w.Body)
+	require.NoError(t, err)
+
+	assert.Regexp(`feedmetricstest_feed_twts_appended [1-9]`, string(body))
+	assert.Regexp(`feedmetricstest_feed_parse_errors [1-9]`, string(body))
+	assert.Regexp(`feedmetricstest_feed_read_seconds_count [1-9]`, string(body))
+}
@@ -0,0 +1,314 @@
+package internal
+
+import (
+	"bufio"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	// maxStatsDays is the maximum number of days (buckets) in the posts per
+	// day histogram of FeedStats, older days are dropped
+	maxStatsDays = 366
+
+	// maxStatsTop is the maximum number of top hashtags and mentioned users
+	// in FeedStats
+	maxStatsTop = 10
+
+	statsDayFormat = "2006-01-02"
+)
+
+// TagCount is a hashtag and the number of twts using it
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// MentionCount is a user and the number of twts mentioning them
+type MentionCount struct {
+	Twter types.Twter
+	Count int
+}
+
+// FeedStats are reading statistics for a feed (see GetFeedStats)
+type FeedStats struct {
+	Total int
+	First time.Time
+	Last  time.Time
+
+	// PerDay is the number of twts posted per day (YYYY-MM-DD in UTC) for at
+	// most the maxStatsDays days up to the last twt
+	PerDay map[string]int
+
+	TopTags     []TagCount
+	TopMentions []MentionCount
+}
+
+// GetFeedStats computes reading statistics (e.g: a "year in review") for the
+// named local feed in a single pass over the feed.
+func GetFeedStats(conf *Config, name string) (*FeedStats, error) {
+	f, err := conf.FeedStore().Open(name)
+	if err != nil {
+		conf.feedLog(name).WithError(err).Warn("error opening feed")
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		stats    = &FeedStats{PerDay: make(map[string]int)}
+		tags     = make(map[string]int)
+		mentions = make(map[string]*MentionCount)
+	)
+
+	twter := types.Twter{Nick: name, URL: URLForUser(conf, name)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		twt, err := parseLine(conf, strings.TrimSuffix(scanner.Text(), "\r"), twter)
+		if err != nil || twt.IsZero() {
+			continue
+		}
+
+		stats.Total++
+		if stats.First.IsZero() || twt.Created.Before(stats.First) {
+			stats.First = twt.Created
+		}
+		if twt.Created.After(stats.Last) {
+			stats.Last = twt.Created
+		}
+
+		stats.PerDay[twt.Created.UTC().Format(statsDayFormat)]++
+
+		for _, tag := range twt.Tags() {
+			tags[tag]++
+		}
+
+		for _, mention := range twt.Mentions() {
+			key := NormalizeURL(mention.URL)
+			if count, ok := mentions[key]; ok {
+				count.Count++
+			} else {
+				mentions[key] = &MentionCount{Twter: mention, Count: 1}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		conf.feedLog(name).WithError(err).Error("error reading feed")
+		return nil, err
+	}
+
+	// Cap the histogram for very old feeds
+	if len(stats.PerDay) > maxStatsDays {
+		cutoff := stats.Last.UTC().AddDate(0, 0, -maxStatsDays).Format(statsDayFormat)
+		for day := range stats.PerDay {
+			if day <= cutoff {
+				delete(stats.PerDay, day)
+			}
+		}
+	}
+
+	for tag, count := range tags {
+		stats.TopTags = append(stats.TopTags, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(stats.TopTags, func(i, j int) bool {
+		if stats.TopTags[i].Count != stats.TopTags[j].Count {
+			return stats.TopTags[i].Count > stats.TopTags[j].Count
+		}
+		return stats.TopTags[i].Tag < stats.TopTags[j].Tag
+	})
+	if len(stats.TopTags) > maxStatsTop {
+		stats.TopTags = stats.TopTags[:maxStatsTop]
+	}
+
+	for _, count := range mentions {
+		stats.TopMentions = append(stats.TopMentions, *count)
+	}
+	sort.Slice(stats.TopMentions, func(i, j int) bool {
+		if stats.TopMentions[i].Count != stats.TopMentions[j].Count {
+			return stats.TopMentions[i].Count > stats.TopMentions[j].Count
+		}
+		return stats.TopMentions[i].Twter.Nick < stats.TopMentions[j].Twter.Nick
+	})
+	if len(stats.TopMentions) > maxStatsTop {
+		stats.TopMentions = stats.TopMentions[:maxStatsTop]
+	}
+
+	return stats, nil
+}
+
+// MentionsIndex looks up the twts mentioning a user (e.g: the Cache)
+type MentionsIndex interface {
+	GetMentions(u *User) types.Twts
+}
+
+// MentionStats are engagement statistics of the mentions a user received
+// (see GetMentionStats)
+type MentionStats struct {
+	Total int
+
+	// PerDay is the number of twts mentioning the user per day (YYYY-MM-DD
+	// in UTC) for at most the maxStatsDays most recent days
+	PerDay map[string]int
+
+	TopMentioners []MentionCount
+}
+
+// GetMentionStats computes how many times user was mentioned, by whom and
+// when within the given window (a zero window counts all mentions), e.g: for
+// a personal dashboard. Mentions are looked up in index (e.g: the Cache) or,
+// if index is nil, by scanning all local feeds. Users mentioning themselves
+// are not counted.
+func GetMentionStats(conf *Config, index MentionsIndex, user *User, window time.Duration) (*MentionStats, error) {
+	var since time.Time
+	if window > 0 {
+		since = time.Now().Add(-window)
+	}
+
+	var (
+		stats      = &MentionStats{PerDay: make(map[string]int)}
+		mentioners = make(map[string]*MentionCount)
+		last       time.Time
+	)
+
+	count := func(twt types.Twt) {
+		if twt.Created.Before(since) || user.Is(twt.Twter.URL) {
+			return
+		}
+
+		mentioned := false
+		for _, mention := range twt.Mentions() {
+			if user.Is(mention.URL) {
+				mentioned = true
+				break
+			}
+		}
+		if !mentioned {
+			return
+		}
+
+		stats.Total++
+		stats.PerDay[twt.Created.UTC().Format(statsDayFormat)]++
+		if twt.Created.After(last) {
+			last = twt.Created
+		}
+
+		key := NormalizeURL(twt.Twter.URL)
+		if count, ok := mentioners[key]; ok {
+			count.Count++
+		} else {
+			mentioners[key] = &MentionCount{Twter: twt.Twter, Count: 1}
+		}
+	}
+
+	if index != nil {
+		for _, twt := range index.GetMentions(user) {
+			count(twt)
+		}
+	} else if err := scanFeeds(conf, count); err != nil {
+		conf.userLog(user.Username).WithError(err).Error("error scanning feeds for mentions")
+		return nil, err
+	}
+
+	// Cap the histogram for very long windows
+	if len(stats.PerDay) > maxStatsDays {
+		cutoff := last.UTC().AddDate(0, 0, -maxStatsDays).Format(statsDayFormat)
+		for day := range stats.PerDay {
+			if day <= cutoff {
+				delete(stats.PerDay, day)
+			}
+		}
+	}
+
+	for _, count := range mentioners {
+		stats.TopMentioners = append(stats.TopMentioners, *count)
+	}
+	sort.Slice(stats.TopMentioners, func(i, j int) bool {
+		if stats.TopMentioners[i].Count != stats.TopMentioners[j].Count {
+			return stats.TopMentioners[i].Count > stats.TopMentioners[j].Count
+		}
+		return stats.TopMentioners[i].Twter.Nick < stats.TopMentioners[j].Twter.Nick
+	})
+	if len(stats.TopMentioners) > maxStatsTop {
+		stats.TopMentioners = stats.TopMentioners[:maxStatsTop]
+	}
+
+	return stats, nil
+}
+
+// postingDay is the calendar date of t (in t's own timezone) as the number of
+// days since the Unix epoch
+func postingDay(t time.Time) int64 {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+// PostingStreak computes the current and longest streaks of consecutive days
+// the named local feed posted at least one twt on, in a single pass over the
+// feed. Days are the calendar dates of twts as written in the feed, that is in
+// the timezone (offset) of each twt's timestamp, and "today" is the current
+// date in the timezone of the feed's most recent twt. Not having posted yet
+// today does not break the current streak, so a streak ending yesterday is
+// still current.
+func PostingStreak(conf *Config, name string) (current, longest int, err error) {
+	f, err := conf.FeedStore().Open(name)
+	if err != nil {
+		conf.feedLog(name).WithError(err).Warn("error opening feed")
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var (
+		days = make(map[int64]bool)
+		last time.Time
+	)
+
+	twter := types.Twter{Nick: name, URL: URLForUser(conf, name)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		twt, err := parseLine(conf, strings.TrimSuffix(scanner.Text(), "\r"), twter)
+		if err != nil || twt.IsZero() {
+			continue
+		}
+
+		days[postingDay(twt.Created)] = true
+		if last.IsZero() || twt.Created.After(last) {
+			last = twt.Created
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		conf.feedLog(name).WithError(err).Error("error reading feed")
+		return 0, 0, err
+	}
+
+	if len(days) == 0 {
+		return 0, 0, nil
+	}
+
+	// Each streak is counted once from its first day
+	for day := range days {
+		if days[day-1] {
+			continue
+		}
+		n := 1
+		for days[day+int64(n)] {
+			n++
+		}
+		if n > longest {
+			longest = n
+		}
+	}
+
+	today := postingDay(time.Now().In(last.Location()))
+	day := today
+	if !days[day] {
+		day--
+	}
+	for days[day] {
+		current++
+		day--
+	}
+
+	return current, longest, nil
+}
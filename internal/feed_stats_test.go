@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestGetFeedStats(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	require.NoError(t, conf.FeedStore().Write("alice", []byte(
+		"# nick = alice\n"+
+			"2020-01-01T10:00:00Z\tHello #twtxt\n"+
+			"2020-01-01T12:00:00Z\t@<bob https://example.com/bob.txt> Hi #twtxt #go\n"+
+			"2020-01-03T00:00:00Z\t@<bob https://example.com/bob.txt> @<eve https://example.com/eve.txt> Bye\n",
+	)))
+
+	stats, err := GetFeedStats(conf, "alice")
+	require.NoError(t, err)
+
+	assert.Equal(3, stats.Total)
+	assert.Equal(time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC), stats.First.UTC())
+	assert.Equal(time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC), stats.Last.UTC())
+	assert.Equal(map[string]int{"2020-01-01": 2, "2020-01-03": 1}, stats.PerDay)
+	assert.Equal([]TagCount{{Tag: "twtxt", Count: 2}, {Tag: "go", Count: 1}}, stats.TopTags)
+	require.Len(t, stats.TopMentions, 2)
+	assert.Equal("bob", stats.TopMentions[0].Twter.Nick)
+	assert.Equal(2, stats.TopMentions[0].Count)
+
+	_, err = GetFeedStats(conf, "unknown")
+	assert.Error(err)
+}
+
+func TestGetFeedStatsCapsHistogram(t *testing.T) {
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	var buf strings.Builder
+	start := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < maxStatsDays*2; i++ {
+		buf.WriteString(fmt.Sprintf("%s\tDay %d\n", start.AddDate(0, 0, i).Format(time.RFC3339), i))
+	}
+	require.NoError(t, conf.FeedStore().Write("bot", []byte(buf.String())))
+
+	stats, err := GetFeedStats(conf, "bot")
+	require.NoError(t, err)
+	assert.Equal(t, maxStatsDays*2, stats.Total)
+	assert.Len(t, stats.PerDay, maxStatsDays)
+}
+
+type testMentionsIndex types.Twts
+
+func (idx testMentionsIndex) GetMentions(u *User) types.Twts { return types.Twts(idx) }
+
+func TestGetMentionStats(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	alice := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	mention := fmt.Sprintf("@<alice %s>", alice.URL)
+
+	now := time.Now().UTC()
+	day := func(days int) string {
+		return now.AddDate(0, 0, -days).Format(time.RFC3339)
+	}
+
+	require.NoError(t, conf.FeedStore().Write("alice", []byte(
+		day(0)+"\t"+mention+" talking to myself\n",
+	)))
+	require.NoError(t, conf.FeedStore().Write("bob", []byte(
+		day(0)+"\t"+mention+" Hi\n"+
+			day(1)+"\t"+mention+" Hello again\n"+
+			day(1)+"\tNo mention here\n"+
+			day(30)+"\t"+mention+" Long ago\n",
+	)))
+	require.NoError(t, conf.FeedStore().Write("carol", []byte(
+		day(1)+"\t"+mention+" Hey\n",
+	)))
+
+	stats, err := GetMentionStats(conf, nil, alice, 7*24*time.Hour)
+	require.NoError(t, err)
+
+	assert.Equal(3, stats.Total)
+	assert.Equal(map[string]int{
+		now.Format(statsDayFormat):                   1,
+		now.AddDate(0, 0, -1).Format(statsDayFormat): 2,
+	}, stats.PerDay)
+	require.Len(t, stats.TopMentioners, 2)
+	assert.Equal("bob", stats.TopMentioners[0].Twter.Nick)
+	assert.Equal(2, stats.TopMentioners[0].Count)
+	assert.Equal("carol", stats.TopMentioners[1].Twter.Nick)
+
+	stats, err = GetMentionStats(conf, nil, alice, 0)
+	require.NoError(t, err)
+	assert.Equal(4, stats.Total)
+
+	t.Run("MentionsIndex", func(t *testing.T) {
+		twter := types.Twter{Nick: "dave", URL: "https://example.com/dave.txt"}
+		index := testMentionsIndex{
+			{Twter: twter, Created: now, Text: mention + " Hi"},
+			{Twter: twter, Created: now, Text: "Not a mention"},
+		}
+
+		stats, err := GetMentionStats(conf, index, alice, 0)
+		require.NoError(t, err)
+		assert.Equal(1, stats.Total)
+		assert.Equal([]MentionCount{{Twter: twter, Count: 1}}, stats.TopMentioners)
+	})
+}
+
+func TestPostingStreak(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	day := func(n int) string {
+		return time.Now().UTC().AddDate(0, 0, n).Format(time.RFC3339)
+	}
+
+	testCases := []struct {
+		name    string
+		feed    string
+		current int
+		longest int
+	}{
+		{"empty", "# nick = empty\n", 0, 0},
+		{
+			"today",
+			fmt.Sprintf("%s\tA\n%s\tB\n%s\tC\n%s\tD\n", day(-2), day(-1), day(0), day(0)),
+			3, 3,
+		},
+		{
+			// Not having posted yet today does not break the streak
+			"yesterday",
+			fmt.Sprintf("%s\tA\n%s\tB\n", day(-2), day(-1)),
+			2, 2,
+		},
+		{
+			"broken",
+			fmt.Sprintf("%s\tA\n%s\tB\n%s\tC\n%s\tD\n", day(-10), day(-9), day(-8), day(-2)),
+			0, 3,
+		},
+		{
+			"gap",
+			fmt.Sprintf("%s\tA\n%s\tB\n%s\tC\n%s\tD\n", day(0), day(-1), day(-3), day(-4)),
+			2, 2,
+		},
+	}
+
+	for _, testCase := range testCases {
+		require.NoError(t, conf.FeedStore().Write(testCase.name, []byte(testCase.feed)))
+
+		current, longest, err := PostingStreak(conf, testCase.name)
+		require.NoError(t, err)
+		assert.Equal(testCase.current, current, testCase.name)
+		assert.Equal(testCase.longest, longest, testCase.name)
+	}
+
+	// Days are bounded in the timezone of each twt's timestamp
+	require.NoError(t, conf.FeedStore().Write("offset", []byte(
+		"2020-01-01T23:30:00-05:00\tA\n"+
+			"2020-01-02T00:30:00-05:00\tB\n",
+	)))
+	_, longest, err := PostingStreak(conf, "offset")
+	require.NoError(t, err)
+	assert.Equal(2, longest)
+
+	_, _, err = PostingStreak(conf, "unknown")
+	assert.Error(err)
+}
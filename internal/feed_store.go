@@ -0,0 +1,369 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrFeedsDirNotFound = errors.New("error: feeds directory not found")
+
+	safeFeedName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+)
+
+// sanitizeFeedName validates that a feed name (which may come from user
+// input) is safe to use as a path component, i.e: it consists only of a safe
+// set of characters and contains no path separators or `..` that could
+// escape the feeds directory. ErrInvalidFeedName is returned otherwise.
+func sanitizeFeedName(name string) (string, error) {
+	if !safeFeedName.MatchString(name) || strings.Contains(name, "..") {
+		return "", ErrInvalidFeedName
+	}
+	return name, nil
+}
+
+// FeedFile is a local feed opened for reading from a FeedStore
+type FeedFile interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+
+	Stat() (os.FileInfo, error)
+}
+
+// FeedStore is an interface for storing local feeds so that feeds can be
+// stored in backends other than the local filesystem (e.g: object storage).
+// Errors for feeds that do not exist must satisfy os.IsNotExist.
+type FeedStore interface {
+	// Open opens the named feed for reading
+	Open(name string) (FeedFile, error)
+	// Stat returns information about the named feed
+	Stat(name string) (os.FileInfo, error)
+	// Append appends data to the named feed creating it if necessary
+	Append(name string, data []byte) error
+	// Truncate truncates the named feed to size bytes
+	Truncate(name string, size int64) error
+	// Write (atomically) replaces the contents of the named feed with data
+	// creating it if necessary
+	Write(name string, data []byte) error
+	// Create creates the named feed with data failing with an error
+	// satisfying os.IsExist if the feed already exists
+	Create(name string, data []byte) error
+	// Update (atomically) replaces the contents of the named feed with what
+	// fn returns for its current contents with no other write to the feed
+	// in between, the feed is left untouched if fn returns nil or an error
+	Update(name string, fn func(data []byte) ([]byte, error)) error
+	// Rename renames the feed oldName to newName which must not exist
+	Rename(oldName, newName string) error
+	// Remove removes the named feed
+	Remove(name string) error
+	// List returns the names of all feeds
+	List() ([]string, error)
+}
+
+// DiskFeedStore implements FeedStore using one file per feed in a directory
+// on the local filesystem. The directory is only ever created by writes so
+// feeds can be served from a read-only mount (e.g: a read-only replica).
+// Writes to a feed are serialized by a per-feed lock shared by all stores
+// (see lockFeed), reads take no lock.
+type DiskFeedStore struct {
+	path string
+}
+
+// feedLocks holds the lock of every feed written to keyed by its path
+var feedLocks sync.Map
+
+// lockFeed locks the feed at path fn for writing returning the func to
+// unlock it
+func lockFeed(fn string) func() {
+	if abs, err := filepath.Abs(fn); err == nil {
+		fn = abs
+	}
+	v, _ := feedLocks.LoadOrStore(fn, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+func NewDiskFeedStore(p string) (FeedStore, error) {
+	if err := os.MkdirAll(p, 0755); err != nil {
+		log.WithError(err).Error("error creating feeds directory")
+		return nil, err
+	}
+
+	return &DiskFeedStore{path: p}, nil
+}
+
+func (s *DiskFeedStore) makePath(name string) (string, error) {
+	return feedPath(s.path, name)
+}
+
+// feedPath returns the path of the named feed in the feeds directory dir.
+// All feed path construction goes through feedPath: the name is normalized
+// (see NormalizeUsername) and sanitized (see sanitizeFeedName) and if no
+// feed by the normalized name exists an existing feed whose name only
+// differs in case (e.g: created before names were normalized) is used.
+func feedPath(dir, name string) (string, error) {
+	name, err := sanitizeFeedName(NormalizeUsername(name))
+	if err != nil {
+		return "", err
+	}
+
+	fn := filepath.Join(dir, name)
+	if _, err := os.Stat(fn); err == nil || !os.IsNotExist(err) {
+		return fn, nil
+	}
+
+	if legacy, ok := legacyFeedName(dir, name); ok {
+		legacyFn := filepath.Join(dir, legacy)
+		if _, err := os.Stat(legacyFn); err == nil {
+			return legacyFn, nil
+		}
+	}
+
+	return fn, nil
+}
+
+var (
+	legacyFeedsMu sync.Mutex
+	legacyFeeds   = make(map[string]map[string]string)
+)
+
+// legacyFeedName returns the name of the feed in dir whose name differs from
+// the normalized name only in case, if any. Feeds are only ever created with
+// normalized names so the legacy feeds of a directory are listed once (on the
+// first lookup) and kept in memory rather than listing the directory for
+// every feed that does not exist.
+func legacyFeedName(dir, name string) (string, bool) {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	legacyFeedsMu.Lock()
+	defer legacyFeedsMu.Unlock()
+
+	names, ok := legacyFeeds[dir]
+	if !ok {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return "", false
+		}
+
+		names = make(map[string]string)
+		for _, fileInfo := range files {
+			if lower := strings.ToLower(fileInfo.Name()); lower != fileInfo.Name() {
+				names[lower] = fileInfo.Name()
+			}
+		}
+		legacyFeeds[dir] = names
+	}
+
+	legacy, ok := names[name]
+	return legacy, ok
+}
+
+func (s *DiskFeedStore) ensurePath() error {
+	if err := os.MkdirAll(s.path, 0755); err != nil {
+		log.WithError(err).Error("error creating feeds directory")
+		return err
+	}
+	return nil
+}
+
+func (s *DiskFeedStore) Open(name string) (FeedFile, error) {
+	fn, err := s.makePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(fn)
+}
+
+func (s *DiskFeedStore) Stat(name string) (os.FileInfo, error) {
+	fn, err := s.makePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Stat(fn)
+}
+
+func (s *DiskFeedStore) Append(name string, data []byte) error {
+	if err := s.ensurePath(); err != nil {
+		return err
+	}
+
+	fn, err := s.makePath(name)
+	if err != nil {
+		return err
+	}
+
+	defer lockFeed(fn)()
+
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	return err
+}
+
+func (s *DiskFeedStore) Truncate(name string, size int64) error {
+	fn, err := s.makePath(name)
+	if err != nil {
+		return err
+	}
+
+	defer lockFeed(fn)()
+
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Truncate(size)
+}
+
+func (s *DiskFeedStore) Write(name string, data []byte) error {
+	if err := s.ensurePath(); err != nil {
+		return err
+	}
+
+	fn, err := s.makePath(name)
+	if err != nil {
+		return err
+	}
+
+	defer lockFeed(fn)()
+
+	return writeFeedFile(fn, data)
+}
+
+func (s *DiskFeedStore) Create(name string, data []byte) error {
+	if err := s.ensurePath(); err != nil {
+		return err
+	}
+
+	fn, err := s.makePath(name)
+	if err != nil {
+		return err
+	}
+
+	defer lockFeed(fn)()
+
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeFeedFile (atomically) writes the feed at fn keeping its permissions
+func writeFeedFile(fn string, data []byte) error {
+	perm := os.FileMode(0644)
+	if stat, err := os.Stat(fn); err == nil {
+		perm = stat.Mode()
+	}
+
+	return WriteFileAtomic(fn, data, perm)
+}
+
+func (s *DiskFeedStore) Update(name string, fn func(data []byte) ([]byte, error)) error {
+	p, err := s.makePath(name)
+	if err != nil {
+		return err
+	}
+
+	defer lockFeed(p)()
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+
+	data, err = fn(data)
+	if err != nil || data == nil {
+		return err
+	}
+
+	return writeFeedFile(p, data)
+}
+
+func (s *DiskFeedStore) Rename(oldName, newName string) error {
+	src, err := s.makePath(oldName)
+	if err != nil {
+		return err
+	}
+	dst, err := s.makePath(newName)
+	if err != nil {
+		return err
+	}
+
+	// Lock both feeds in a consistent order so concurrent renames of the
+	// same two feeds cannot deadlock
+	first, second := src, dst
+	if second < first {
+		first, second = second, first
+	}
+	defer lockFeed(first)()
+	if second != first {
+		defer lockFeed(second)()
+	}
+
+	if _, err := os.Stat(dst); err == nil {
+		return os.ErrExist
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Rename(src, dst)
+}
+
+func (s *DiskFeedStore) Remove(name string) error {
+	fn, err := s.makePath(name)
+	if err != nil {
+		return err
+	}
+
+	defer lockFeed(fn)()
+
+	return os.Remove(fn)
+}
+
+func (s *DiskFeedStore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Errorf("feeds directory %s does not exist", s.path)
+			return nil, ErrFeedsDirNotFound
+		}
+		log.WithError(err).Error("error reading feeds directory")
+		return nil, err
+	}
+
+	names := []string{}
+	for _, fileInfo := range files {
+		// Skip temporary files from atomic writes
+		if strings.HasPrefix(fileInfo.Name(), ".") {
+			continue
+		}
+		names = append(names, filepath.Base(fileInfo.Name()))
+	}
+	return names, nil
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeFeedName(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, name := range []string{"prologic", "news", "my_feed", "my-feed", "Feed1", "v1.2"} {
+		actual, err := sanitizeFeedName(name)
+		assert.NoError(err, name)
+		assert.Equal(name, actual)
+	}
+
+	for _, name := range []string{
+		"",
+		".",
+		"..",
+		"../../etc/passwd",
+		"../feeds/admin",
+		"foo/../../bar",
+		"foo/bar",
+		`foo\bar`,
+		"/etc/passwd",
+		".hidden",
+		"foo..",
+		"foo\x00bar",
+		"foo bar",
+	} {
+		_, err := sanitizeFeedName(name)
+		assert.Equal(ErrInvalidFeedName, err, name)
+	}
+}
+
+func TestFeedPathTraversal(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	_, err := GetAllTwts(conf, "../../etc/passwd")
+	assert.Equal(ErrInvalidFeedName, err)
+
+	_, err = AppendTwt(conf, nil, &User{Username: "../escape"}, "Hello World!")
+	assert.Equal(ErrInvalidFeedName, err)
+
+	assert.Equal(ErrInvalidFeedName, conf.FeedStore().Remove("../drafts"))
+}
+
+func TestFeedPathMixedCase(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	// A legacy feed created before names were normalized
+	newTestFeed(t, conf, "noeol.txt", "Alice")
+
+	for _, name := range []string{"alice", "Alice", "ALICE", " alice "} {
+		assert.True(FeedExists(conf, name), name)
+
+		twts, err := GetAllTwts(conf, name)
+		require.NoError(t, err, name)
+		assert.Len(twts, 2, name)
+	}
+
+	// New feeds are always created with normalized names
+	_, err := AppendTwt(conf, nil, &User{Username: "Bob"}, "Hello World!")
+	require.NoError(t, err)
+
+	names, err := GetAllFeeds(conf)
+	require.NoError(t, err)
+	assert.ElementsMatch([]string{"Alice", "bob"}, names)
+
+	twts, err := GetAllTwts(conf, "BOB")
+	require.NoError(t, err)
+	assert.Len(twts, 1)
+}
+
+func TestFeedStoreReadsDoNotCreateDir(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	store := conf.FeedStore()
+	p := filepath.Join(conf.Data, feedsDir)
+	require.NoError(t, os.RemoveAll(p))
+
+	_, err := store.List()
+	assert.Equal(ErrFeedsDirNotFound, err)
+
+	_, err = GetAllTwts(conf, "alice")
+	assert.True(os.IsNotExist(err))
+	_, err = GetFeedCount(conf, "alice")
+	assert.True(os.IsNotExist(err))
+	_, _, err = GetLastTwt(conf, &User{Username: "alice"})
+	assert.True(os.IsNotExist(err))
+
+	_, err = os.Stat(p)
+	assert.True(os.IsNotExist(err))
+
+	require.NoError(t, store.Append("alice", []byte("2020-01-01T00:00:00Z\tHello\n")))
+	names, err := store.List()
+	require.NoError(t, err)
+	assert.Equal([]string{"alice"}, names)
+}
+
+func TestFeedStoreUpdateLocksFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	require.NoError(t, conf.FeedStore().Append("alice", []byte("# nick = alice\n")))
+
+	// Appends racing rewrites (through separate stores) are never lost
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			line := fmt.Sprintf("2020-01-01T00:00:%02dZ\tHello %d\n", i, i)
+			assert.NoError(conf.FeedStore().Append("alice", []byte(line)))
+		}(i)
+		go func() {
+			defer wg.Done()
+			assert.NoError(conf.FeedStore().Update("alice", func(data []byte) ([]byte, error) {
+				time.Sleep(time.Millisecond)
+				return append([]byte{}, data...), nil
+			}))
+		}()
+	}
+	wg.Wait()
+
+	twts, err := GetAllTwts(conf, "alice")
+	require.NoError(t, err)
+	assert.Len(twts, 20)
+
+	// Errors and nil data leave the feed untouched
+	assert.Equal(ErrTwtNotFound, conf.FeedStore().Update("alice", func(data []byte) ([]byte, error) {
+		return []byte{}, ErrTwtNotFound
+	}))
+	assert.NoError(conf.FeedStore().Update("alice", func(data []byte) ([]byte, error) {
+		return nil, nil
+	}))
+	twts, err = GetAllTwts(conf, "alice")
+	require.NoError(t, err)
+	assert.Len(twts, 20)
+
+	require.NoError(t, conf.FeedStore().Append("bob", []byte("# nick = bob\n")))
+	assert.True(os.IsExist(conf.FeedStore().Create("bob", []byte("# nick = clobbered\n"))))
+	data, err := readFeed(conf.FeedStore(), "bob")
+	require.NoError(t, err)
+	assert.Equal("# nick = bob\n", string(data))
+	assert.True(os.IsExist(conf.FeedStore().Rename("alice", "bob")))
+	require.NoError(t, conf.FeedStore().Rename("alice", "carol"))
+	_, err = conf.FeedStore().Stat("alice")
+	assert.True(os.IsNotExist(err))
+	_, err = conf.FeedStore().Stat("carol")
+	assert.NoError(err)
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetFeedToken sets the access token required to read the named local feed,
+// an empty token makes the feed public again. The token is stored on the
+// user's (or feed's) record.
+//
+// Feed tokens are a soft privacy measure (obscurity) and not access control
+// in any strong sense: the token is a shared secret sent in the clear with
+// every request (often in the url) and anyone who has it can read and
+// redistribute the feed. Only reads of the raw feed (its twtxt.txt and Atom
+// feed) are gated, use signed feeds for stronger guarantees.
+func SetFeedToken(db Store, name, token string) error {
+	name = NormalizeUsername(name)
+
+	if db.HasUser(name) {
+		user, err := db.GetUser(name)
+		if err != nil {
+			log.WithError(err).Errorf("error loading user object for %s", name)
+			return err
+		}
+		user.FeedToken = token
+		return db.SetUser(name, user)
+	}
+
+	if db.HasFeed(name) {
+		feed, err := db.GetFeed(name)
+		if err != nil {
+			log.WithError(err).Errorf("error loading feed object for %s", name)
+			return err
+		}
+		feed.FeedToken = token
+		return db.SetFeed(name, feed)
+	}
+
+	return ErrFeedNotFound
+}
+
+// FeedRequiresToken returns true and the token if reading the named local
+// feed requires an access token (see SetFeedToken).
+func FeedRequiresToken(db Store, name string) (bool, string) {
+	name = NormalizeUsername(name)
+
+	var token string
+	if db.HasUser(name) {
+		user, err := db.GetUser(name)
+		if err != nil {
+			log.WithError(err).Errorf("error loading user object for %s", name)
+			return false, ""
+		}
+		token = user.FeedToken
+	} else if db.HasFeed(name) {
+		feed, err := db.GetFeed(name)
+		if err != nil {
+			log.WithError(err).Errorf("error loading feed object for %s", name)
+			return false, ""
+		}
+		token = feed.FeedToken
+	}
+
+	return token != "", token
+}
+
+// CheckFeedToken returns true if the request may read the named local feed,
+// i.e: the feed requires no token or the request provides it either as the
+// `token` query parameter or the `Token` header.
+func CheckFeedToken(db Store, name string, r *http.Request) bool {
+	required, token := FeedRequiresToken(db, name)
+	if !required {
+		return true
+	}
+
+	provided := r.URL.Query().Get("token")
+	if provided == "" {
+		provided = r.Header.Get("Token")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// localFeedName returns the name of the local feed with the given url
+func localFeedName(conf *Config, uri string) (string, bool) {
+	prefix := strings.TrimSuffix(conf.BaseURL, "/") + "/user/"
+	if !strings.HasPrefix(uri, prefix) || !strings.HasSuffix(uri, "/twtxt.txt") {
+		return "", false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(uri, prefix), "/twtxt.txt")
+	if name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	return name, true
+}
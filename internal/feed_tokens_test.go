@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.SetUser("alice", &User{Username: "alice"}))
+	require.NoError(t, db.SetFeed("news", &Feed{Name: "news"}))
+
+	required, _ := FeedRequiresToken(db, "alice")
+	assert.False(required)
+	assert.True(CheckFeedToken(db, "alice", httptest.NewRequest("GET", "/user/alice/twtxt.txt", nil)))
+
+	require.NoError(t, SetFeedToken(db, "alice", "s3cr3t"))
+
+	required, token := FeedRequiresToken(db, "Alice")
+	assert.True(required)
+	assert.Equal("s3cr3t", token)
+
+	// The token is kept on the user's record
+	user, err := db.GetUser("alice")
+	require.NoError(t, err)
+	assert.Equal("s3cr3t", user.FeedToken)
+
+	assert.False(CheckFeedToken(db, "alice", httptest.NewRequest("GET", "/user/alice/twtxt.txt", nil)))
+	assert.False(CheckFeedToken(db, "alice", httptest.NewRequest("GET", "/user/alice/twtxt.txt?token=wrong", nil)))
+	assert.True(CheckFeedToken(db, "alice", httptest.NewRequest("GET", "/user/alice/twtxt.txt?token=s3cr3t", nil)))
+
+	r := httptest.NewRequest("GET", "/user/alice/twtxt.txt", nil)
+	r.Header.Set("Token", "s3cr3t")
+	assert.True(CheckFeedToken(db, "alice", r))
+
+	// Other feeds are unaffected
+	assert.True(CheckFeedToken(db, "news", httptest.NewRequest("GET", "/user/news/twtxt.txt", nil)))
+
+	// Feed records can require a token too
+	require.NoError(t, SetFeedToken(db, "news", "t0k3n"))
+	required, token = FeedRequiresToken(db, "news")
+	assert.True(required)
+	assert.Equal("t0k3n", token)
+
+	assert.Equal(ErrFeedNotFound, SetFeedToken(db, "unknown", "foo"))
+
+	require.NoError(t, SetFeedToken(db, "alice", ""))
+	required, _ = FeedRequiresToken(db, "alice")
+	assert.False(required)
+
+	name, ok := localFeedName(conf, URLForUser(conf, "alice"))
+	assert.True(ok)
+	assert.Equal("alice", name)
+	_, ok = localFeedName(conf, "https://example.com/user/alice/twtxt.txt")
+	assert.False(ok)
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prologic/twtxt"
+)
+
+const (
+	// maxFeedRedirects is the maximum number of permanent redirects followed
+	// by ResolveFeedRedirect
+	maxFeedRedirects = 10
+)
+
+var (
+	ErrFeedRedirectLoop = errors.New("error: feed redirect loop detected")
+)
+
+// FetchFunc fetches the resource at the given URL returning a response
+// compatible with a http GET response (Request, StatusCode, Header and Body).
+type FetchFunc func(conf *Config, uri string, headers http.Header) (*http.Response, error)
+
+// fetchers are the additional non-http(s) schemes feeds can be fetched from
+// which are registered by optional (build tagged) support for the scheme.
+var fetchers = make(map[string]FetchFunc)
+
+// RegisterFetcher registers a FetchFunc used to fetch feeds with the given
+// URL scheme.
+func RegisterFetcher(scheme string, fetch FetchFunc) {
+	fetchers[strings.ToLower(scheme)] = fetch
+}
+
+// FetchFeed fetches the feed at the given URL using the fetcher registered
+// for the URL's scheme falling back to a http GET request.
+func FetchFeed(conf *Config, uri string, headers http.Header) (*http.Response, error) {
+	if u, err := url.Parse(uri); err == nil {
+		if fetch, ok := fetchers[strings.ToLower(u.Scheme)]; ok {
+			return fetch(conf, uri, headers)
+		}
+	}
+
+	return Request(conf, http.MethodGet, uri, headers)
+}
+
+// ResolveFeedRedirect follows any permanent redirects (301 or 308) of the feed
+// at the given URL returning the URL the feed has moved to and true, or the
+// URL itself and false if the feed has not moved. Temporary redirects do not
+// move a feed and are not followed. Redirect loops (and chains longer than
+// maxFeedRedirects) return ErrFeedRedirectLoop.
+func ResolveFeedRedirect(uri string) (string, bool, error) {
+	client := &http.Client{
+		Timeout: requestTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	seen := make(map[string]bool)
+	current := uri
+	for i := 0; i <= maxFeedRedirects; i++ {
+		if u, err := url.Parse(current); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return current, current != uri, err
+		}
+
+		if seen[NormalizeURL(current)] {
+			return "", false, ErrFeedRedirectLoop
+		}
+		seen[NormalizeURL(current)] = true
+
+		req, err := http.NewRequest(http.MethodGet, current, nil)
+		if err != nil {
+			return "", false, err
+		}
+		req.Header.Set("User-Agent", fmt.Sprintf("twtxt/%s", twtxt.FullVersion()))
+
+		res, err := client.Do(req)
+		if err != nil {
+			return "", false, err
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusMovedPermanently && res.StatusCode != http.StatusPermanentRedirect {
+			return current, current != uri, nil
+		}
+
+		location, err := res.Location()
+		if err != nil {
+			return "", false, err
+		}
+		current = location.String()
+	}
+
+	return "", false, ErrFeedRedirectLoop
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestResolveFeedRedirect(t *testing.T) {
+	assert := assert.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/twtxt.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "2020-07-18T12:39:06Z\tHello World!")
+	})
+	mux.Handle("/old.txt", http.RedirectHandler("/older.txt", http.StatusMovedPermanently))
+	mux.Handle("/older.txt", http.RedirectHandler("/twtxt.txt", http.StatusPermanentRedirect))
+	mux.Handle("/temp.txt", http.RedirectHandler("/twtxt.txt", http.StatusFound))
+	mux.Handle("/loop1.txt", http.RedirectHandler("/loop2.txt", http.StatusMovedPermanently))
+	mux.Handle("/loop2.txt", http.RedirectHandler("/loop1.txt", http.StatusMovedPermanently))
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	url, moved, err := ResolveFeedRedirect(server.URL + "/twtxt.txt")
+	require.NoError(t, err)
+	assert.False(moved)
+	assert.Equal(server.URL+"/twtxt.txt", url)
+
+	url, moved, err = ResolveFeedRedirect(server.URL + "/old.txt")
+	require.NoError(t, err)
+	assert.True(moved)
+	assert.Equal(server.URL+"/twtxt.txt", url)
+
+	url, moved, err = ResolveFeedRedirect(server.URL + "/temp.txt")
+	require.NoError(t, err)
+	assert.False(moved)
+	assert.Equal(server.URL+"/temp.txt", url)
+
+	_, _, err = ResolveFeedRedirect(server.URL + "/loop1.txt")
+	assert.Equal(ErrFeedRedirectLoop, err)
+}
+
+var setupCacheMetricsOnce sync.Once
+
+// setupCacheMetrics registers the metrics updated by FetchTwts (see
+// Server.setupMetrics) once for all tests
+func setupCacheMetrics() {
+	setupCacheMetricsOnce.Do(func() {
+		for _, name := range []string{"sources", "feeds", "twts", "last_processed_seconds"} {
+			metrics.NewGauge("cache", name, "")
+		}
+		metrics.NewCounter("archive", "size", "")
+		metrics.NewCounter("archive", "error", "")
+	})
+}
+
+func TestFetchTwtsRefreshInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	hits := make(map[string]int)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/plain.txt", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		fmt.Fprintf(w, "%s\tHello World!\n", time.Now().Format(time.RFC3339))
+	})
+	mux.HandleFunc("/hourly.txt", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		fmt.Fprintln(w, "# refresh = 1h")
+		fmt.Fprintf(w, "%s\tHello World!\n", time.Now().Format(time.RFC3339))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	require.NoError(t, WithMaxFetchLimit(DefaultMaxFetchLimit)(conf))
+	setupCacheMetrics()
+
+	cache, err := LoadCache(conf.Data)
+	require.NoError(t, err)
+
+	feeds := types.Feeds{
+		types.Feed{Nick: "plain", URL: server.URL + "/plain.txt"}:   true,
+		types.Feed{Nick: "hourly", URL: server.URL + "/hourly.txt"}: true,
+	}
+
+	cache.FetchTwts(conf, nil, &NullArchiver{}, feeds, nil)
+	cache.FetchTwts(conf, nil, &NullArchiver{}, feeds, nil)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Feeds declaring no refresh interval are fetched on every run
+	assert.Equal(2, hits["/plain.txt"])
+	assert.Equal(1, hits["/hourly.txt"])
+
+	assert.Len(cache.GetByURL(server.URL+"/plain.txt"), 1)
+	assert.Len(cache.GetByURL(server.URL+"/hourly.txt"), 1)
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// FeedRef is a reference to a feed by its nick and url
+type FeedRef struct {
+	Nick string
+	URL  string
+}
+
+// FeedFollows returns the feeds (nick and url) a feed follows, e.g: to build
+// "people followed by people you follow" suggestions. name is either a local
+// feed whose `# follow = nick url` metadata is read (along with the users it
+// follows if it is a local user and db is not nil) or the url of a
+// remote feed whose metadata was last fetched by the feed cache (remote
+// feeds not yet fetched follow no one). Urls are normalized and deduped
+// keeping the first nick declared for each and a feed following itself is
+// ignored.
+func FeedFollows(conf *Config, db Store, name string) ([]FeedRef, error) {
+	var (
+		follows []FeedRef
+		self    string
+	)
+
+	if strings.Contains(name, "://") {
+		if local, ok := localFeedName(conf, NormalizeURL(name)); ok {
+			name = local
+		}
+	}
+
+	if strings.Contains(name, "://") {
+		self = name
+		if meta, ok := getFeedMetadata(name); ok {
+			follows = append(follows, meta.Follows...)
+		}
+	} else {
+		self = URLForUser(conf, name)
+
+		var user *User
+		if db != nil {
+			if u, err := db.GetUser(name); err == nil {
+				user = u
+			} else if err != ErrUserNotFound {
+				conf.userLog(name).WithError(err).Warn("error looking up user")
+			}
+		}
+
+		data, err := readFeed(conf.FeedStore(), name)
+		if err != nil && user == nil {
+			conf.feedLog(name).WithError(err).Error("error reading feed")
+			return nil, err
+		}
+		if err == nil {
+			meta, err := ParseFeedMetadata(bytes.NewReader(data))
+			if err != nil {
+				conf.feedLog(name).WithError(err).Error("error parsing feed metadata")
+				return nil, err
+			}
+			follows = append(follows, meta.Follows...)
+		}
+
+		if user != nil {
+			nicks := make([]string, 0, len(user.Following))
+			for nick := range user.Following {
+				nicks = append(nicks, nick)
+			}
+			sort.Strings(nicks)
+			for _, nick := range nicks {
+				follows = append(follows, FeedRef{Nick: nick, URL: user.Following[nick]})
+			}
+		}
+	}
+
+	self = NormalizeURL(self)
+	seen := make(map[string]bool)
+
+	var refs []FeedRef
+	for _, follow := range follows {
+		url := NormalizeURL(follow.URL)
+		if url == "" || url == self || seen[url] {
+			continue
+		}
+		seen[url] = true
+		refs = append(refs, FeedRef{Nick: follow.Nick, URL: url})
+	}
+
+	return refs, nil
+}
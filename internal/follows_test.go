@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedFollows(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, conf.FeedStore().Write("alice", []byte(
+		"# nick = alice\n"+
+			"# follow = bob https://example.com/bob/twtxt.txt\n"+
+			"# follow = bob2 https://EXAMPLE.com:443/bob/twtxt.txt/\n"+
+			"# follow = alice "+URLForUser(conf, "alice")+"\n"+
+			"# follow = invalid\n"+
+			"2021-01-01T12:00:00Z\tHello World!\n",
+	)))
+
+	follows, err := FeedFollows(conf, db, "alice")
+	require.NoError(t, err)
+	assert.Equal([]FeedRef{{Nick: "bob", URL: "https://example.com/bob/twtxt.txt"}}, follows)
+
+	// Local users also follow the feeds in their user record
+	user := &User{
+		Username: "alice",
+		URL:      URLForUser(conf, "alice"),
+		Following: map[string]string{
+			"bob":   "https://example.com/bob/twtxt.txt",
+			"carol": "https://example.org/carol.txt",
+		},
+	}
+	require.NoError(t, db.SetUser("alice", user))
+
+	follows, err = FeedFollows(conf, db, URLForUser(conf, "alice"))
+	require.NoError(t, err)
+	assert.Equal([]FeedRef{
+		{Nick: "bob", URL: "https://example.com/bob/twtxt.txt"},
+		{Nick: "carol", URL: "https://example.org/carol.txt"},
+	}, follows)
+
+	// Remote feeds from their cached metadata
+	cacheFeedMetadata("https://example.com/bob/twtxt.txt", FeedMetadata{
+		Follows: []FeedRef{{Nick: "dave", URL: "https://example.net/dave.txt"}},
+	})
+	follows, err = FeedFollows(conf, db, "https://example.com/bob/twtxt.txt")
+	require.NoError(t, err)
+	assert.Equal([]FeedRef{{Nick: "dave", URL: "https://example.net/dave.txt"}}, follows)
+
+	follows, err = FeedFollows(conf, db, "https://example.com/unknown/twtxt.txt")
+	assert.NoError(err)
+	assert.Empty(follows)
+
+	_, err = FeedFollows(conf, db, "unknown")
+	assert.Error(err)
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/prologic/twtxt/types"
+)
+
+var (
+	ErrInvalidFragment = errors.New("error: invalid feed fragment")
+)
+
+// FragmentMeta describes a fragment of a feed returned by GetFeedFragment
+type FragmentMeta struct {
+	// Page is the number of the fragment, 1 being the most recent twts
+	Page int
+	// Pages is the number of fragments of size twts the feed is split into
+	Pages int
+	// Size is the (maximum) number of twts in each fragment
+	Size int
+	// Total is the number of twts in the feed
+	Total int
+	// Prev is the url of the fragment of older twts (if any)
+	Prev string
+	// Next is the url of the fragment of newer twts (if any)
+	Next string
+}
+
+// URLForFeedFragment returns the url of a fragment of the named feed
+func URLForFeedFragment(conf *Config, name string, page, size int) string {
+	return fmt.Sprintf("%s?page=%d&size=%d", URLForUser(conf, name), page, size)
+}
+
+// GetFeedFragment returns one page of size twts of the named local feed as a
+// valid twtxt.txt feed in its own right so that clients unable to download
+// a large feed can fetch it in fragments. Page 1 holds the most recent twts
+// and higher pages successively older twts; twt lines are served verbatim
+// (in their order in the feed) so their hashes are unchanged. Comments of
+// the feed are not included.
+//
+// Fragments are linked following the archive feed convention: each fragment
+// followed by older twts has a `# prev = <hash> <url>` comment where hash is
+// the hash of the last twt in the previous (older) fragment and url its
+// location, so clients walking `prev` pointers recover the whole feed.
+// A `# next = <url>` comment points back to the fragment of newer twts.
+func GetFeedFragment(conf *Config, name string, page, size int) ([]byte, FragmentMeta, error) {
+	if size <= 0 {
+		size = conf.TwtsPerPage
+	}
+
+	meta := FragmentMeta{Page: page, Size: size}
+
+	data, err := readFeed(conf.FeedStore(), name)
+	if err != nil {
+		conf.feedLog(name).WithError(err).Error("error reading feed")
+		return nil, meta, err
+	}
+
+	twter := types.Twter{Nick: name, URL: URLForUser(conf, name)}
+
+	var (
+		lines []string
+		twts  types.Twts
+	)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		twt, err := parseLine(conf, line, twter)
+		if err != nil || twt.IsZero() {
+			continue
+		}
+		lines = append(lines, line)
+		twts = append(twts, twt)
+	}
+
+	meta.Total = len(lines)
+	meta.Pages = (meta.Total + size - 1) / size
+	if meta.Pages == 0 {
+		meta.Pages = 1
+	}
+
+	if page < 1 || page > meta.Pages {
+		return nil, meta, ErrInvalidFragment
+	}
+
+	// Pages are counted back from the end of the feed
+	end := meta.Total - (page-1)*size
+	start := end - size
+	if start < 0 {
+		start = 0
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# nick = %s%s", name, conf.EOL()))
+	sb.WriteString(fmt.Sprintf("# url = %s%s", URLForUser(conf, name), conf.EOL()))
+
+	if page < meta.Pages {
+		meta.Prev = URLForFeedFragment(conf, name, page+1, size)
+		sb.WriteString(fmt.Sprintf("# prev = %s %s%s", twts[start-1].Hash(), meta.Prev, conf.EOL()))
+	}
+	if page > 1 {
+		meta.Next = URLForFeedFragment(conf, name, page-1, size)
+		sb.WriteString(fmt.Sprintf("# next = %s%s", meta.Next, conf.EOL()))
+	}
+
+	for _, line := range lines[start:end] {
+		sb.WriteString(line + conf.EOL())
+	}
+
+	return []byte(sb.String()), meta, nil
+}
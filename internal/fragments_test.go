@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestGetFeedFragment(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+	now := time.Now().Truncate(time.Second)
+
+	require.NoError(t, conf.FeedStore().Write("test", []byte("# nick = test\n")))
+	for i := 5; i > 0; i-- {
+		_, err := AppendTwt(conf, nil, user, fmt.Sprintf("Twt %d", 6-i), now.Add(-time.Duration(i)*time.Minute))
+		require.NoError(t, err)
+	}
+
+	all, err := GetAllTwts(conf, "test")
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+
+	// Walking prev pointers from the first page recovers the whole feed
+	var (
+		hashes  = make(map[string]bool)
+		prevs   []string
+		page    = 1
+		expects = []int{2, 2, 1}
+	)
+	for page != 0 {
+		data, meta, err := GetFeedFragment(conf, "test", page, 2)
+		require.NoError(t, err)
+		assert.Equal(3, meta.Pages)
+		assert.Equal(5, meta.Total)
+
+		twts, _, err := ParseFile(bufio.NewScanner(bytes.NewReader(data)), types.Twter{Nick: "test", URL: URLForUser(conf, "test")}, 0, 0)
+		require.NoError(t, err)
+		assert.Len(twts, expects[page-1])
+		for _, twt := range twts {
+			hashes[twt.Hash()] = true
+		}
+
+		if page > 1 {
+			assert.Contains(string(data), fmt.Sprintf("# next = %s\n", URLForFeedFragment(conf, "test", page-1, 2)))
+		}
+
+		if meta.Prev == "" {
+			assert.Equal(3, page)
+			page = 0
+			continue
+		}
+		prev := fmt.Sprintf(" %s\n", meta.Prev)
+		i := bytes.Index(data, []byte(prev))
+		require.True(t, i > 0)
+		line := string(data[:i])
+		prevs = append(prevs, line[strings.LastIndex(line, "# prev = ")+len("# prev = "):])
+		page++
+	}
+
+	for _, twt := range all {
+		assert.True(hashes[twt.Hash()])
+	}
+	// Prev pointers reference the hash of the last (newest) twt of the
+	// older fragment
+	assert.Equal([]string{all[2].Hash(), all[4].Hash()}, prevs)
+
+	_, _, err = GetFeedFragment(conf, "test", 4, 2)
+	assert.Equal(ErrInvalidFragment, err)
+	_, _, err = GetFeedFragment(conf, "test", 0, 2)
+	assert.Equal(ErrInvalidFragment, err)
+}
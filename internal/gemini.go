@@ -0,0 +1,201 @@
+//go:build gemini
+// +build gemini
+
+package internal
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	geminiDefaultPort   = "1965"
+	geminiMaxRedirects  = 5
+	geminiMaxHeaderSize = 1029 // 2 digit status, space, 1024 byte meta, CRLF
+
+	// geminiKnownHostsFile is the sidecar (in the data directory) the
+	// certificates of gemini servers are pinned in (see geminiVerifyTOFU)
+	geminiKnownHostsFile = "gemini_known_hosts.json"
+)
+
+var (
+	ErrInvalidGeminiResponse     = errors.New("error: invalid gemini response")
+	ErrTooManyGeminiRedirects    = errors.New("error: too many gemini redirects")
+	ErrGeminiCertificateMismatch = errors.New("error: gemini server certificate does not match the pinned certificate")
+)
+
+func init() {
+	RegisterFetcher("gemini", FetchGemini)
+}
+
+type geminiBody struct {
+	io.Reader
+	conn net.Conn
+}
+
+func (b geminiBody) Close() error {
+	return b.conn.Close()
+}
+
+// FetchGemini fetches a resource over the Gemini protocol following any
+// redirects and returns it as a equivalent http response. Gemini status
+// codes are mapped onto their closest http equivalents. Gemini servers
+// commonly use self-signed certificates so rather than being verified
+// against a CA certificates are trusted on first use (see geminiVerifyTOFU).
+func FetchGemini(conf *Config, uri string, headers http.Header) (*http.Response, error) {
+	for i := 0; i < geminiMaxRedirects; i++ {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), geminiDefaultPort)
+		}
+
+		dialer := &net.Dialer{Timeout: requestTimeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", host, &tls.Config{
+			ServerName: u.Hostname(),
+			// Verification against a CA is replaced by TOFU pinning
+			InsecureSkipVerify:    true,
+			VerifyPeerCertificate: geminiVerifyTOFU(conf, host),
+		})
+		if err != nil {
+			log.WithError(err).Errorf("%s: gemini dial fail: %s", uri, err)
+			return nil, err
+		}
+
+		if _, err := fmt.Fprintf(conn, "%s\r\n", u.String()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		br := bufio.NewReader(io.LimitReader(conn, conf.MaxFetchLimit+geminiMaxHeaderSize))
+		header, err := br.ReadString('\n')
+		if err != nil || len(header) < 3 || len(header) > geminiMaxHeaderSize {
+			conn.Close()
+			return nil, ErrInvalidGeminiResponse
+		}
+
+		status, err := strconv.Atoi(header[:2])
+		if err != nil {
+			conn.Close()
+			return nil, ErrInvalidGeminiResponse
+		}
+		meta := strings.TrimSpace(header[2:])
+
+		res := &http.Response{
+			Status:  header[:2],
+			Header:  make(http.Header),
+			Request: &http.Request{Method: http.MethodGet, URL: u, Header: headers},
+		}
+
+		switch status / 10 {
+		case 2: // SUCCESS
+			res.StatusCode = http.StatusOK
+			res.Header.Set("Content-Type", meta)
+			res.Body = geminiBody{Reader: br, conn: conn}
+			return res, nil
+		case 3: // REDIRECT
+			conn.Close()
+			next, err := u.Parse(meta)
+			if err != nil {
+				return nil, ErrInvalidGeminiResponse
+			}
+			uri = next.String()
+			continue
+		case 5: // PERMANENT FAILURE
+			if status == 51 {
+				res.StatusCode = http.StatusNotFound
+			} else {
+				res.StatusCode = http.StatusBadRequest
+			}
+		default: // INPUT, TEMPORARY FAILURE, CLIENT CERTIFICATE REQUIRED
+			res.StatusCode = http.StatusServiceUnavailable
+		}
+
+		conn.Close()
+		res.Body = ioutil.NopCloser(strings.NewReader(""))
+		return res, nil
+	}
+
+	return nil, ErrTooManyGeminiRedirects
+}
+
+// geminiVerifyTOFU returns a tls.Config.VerifyPeerCertificate func trusting
+// the certificate presented by host on first use: the (sha256) fingerprint
+// of the first certificate seen is pinned in the geminiKnownHostsFile sidecar
+// until it expires and any other certificate presented by the host in the
+// meantime is rejected with ErrGeminiCertificateMismatch. Once the pinned
+// certificate has expired the next certificate seen is pinned instead.
+func geminiVerifyTOFU(conf *Config, host string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return ErrInvalidGeminiResponse
+		}
+
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			return x509.CertificateInvalidError{Cert: cert, Reason: x509.Expired}
+		}
+
+		sum := sha256.Sum256(rawCerts[0])
+		pin := fmt.Sprintf("%s %d", hex.EncodeToString(sum[:]), cert.NotAfter.Unix())
+
+		// pinned returns true if the pin is still current, that is it has
+		// not expired yet
+		pinned := func(pin string) bool {
+			fields := strings.Fields(pin)
+			if len(fields) != 2 {
+				return false
+			}
+			expires, err := strconv.ParseInt(fields[1], 10, 64)
+			return err == nil && now.Before(time.Unix(expires, 0))
+		}
+
+		known := getSidecar(conf, geminiKnownHostsFile)
+		current, ok, err := known.Get(host)
+		if err != nil {
+			return err
+		}
+		if !ok || !pinned(current) {
+			if err := known.Update(func(values map[string]string) {
+				// Another fetch may have pinned a certificate meanwhile
+				if current, ok := values[host]; !ok || !pinned(current) {
+					values[host] = pin
+				}
+			}); err != nil {
+				return err
+			}
+			if current, _, err = known.Get(host); err != nil {
+				return err
+			}
+		}
+		if current != pin {
+			log.Warnf("gemini server %s presented a certificate that does not match the one pinned", host)
+			return ErrGeminiCertificateMismatch
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,214 @@
+//go:build gemini
+// +build gemini
+
+package internal
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGeminiTestCert returns a self-signed certificate for 127.0.0.1 valid
+// until notAfter
+func newGeminiTestCert(t *testing.T, notAfter time.Time) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// geminiTestServer is a minimal gemini server serving canned responses by
+// path whose certificate can be swapped out
+type geminiTestServer struct {
+	mu   sync.Mutex
+	cert tls.Certificate
+
+	ln        net.Listener
+	responses map[string]string
+}
+
+func newGeminiTestServer(t *testing.T, responses map[string]string) *geminiTestServer {
+	s := &geminiTestServer{
+		cert:      newGeminiTestCert(t, time.Now().Add(time.Hour)),
+		responses: responses,
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return &s.cert, nil
+		},
+	})
+	require.NoError(t, err)
+	s.ln = ln
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				req, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				path := strings.TrimPrefix(strings.TrimSpace(req), s.URL(""))
+				res, ok := s.responses[path]
+				if !ok {
+					res = "51 Not found\r\n"
+				}
+				fmt.Fprint(conn, res)
+			}(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *geminiTestServer) URL(path string) string {
+	return fmt.Sprintf("gemini://%s%s", s.ln.Addr(), path)
+}
+
+func (s *geminiTestServer) SetCert(cert tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cert = cert
+}
+
+func (s *geminiTestServer) Close() {
+	s.ln.Close()
+}
+
+func TestFetchGemini(t *testing.T) {
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	s := newGeminiTestServer(t, map[string]string{
+		"/twtxt.txt": "20 text/plain\r\n2020-01-01T00:00:00Z\tHello World!\n",
+		"/moved.txt": "31 /twtxt.txt\r\n",
+		"/loop.txt":  "30 /loop.txt\r\n",
+		"/slow.txt":  "44 60\r\n",
+		"/bad.txt":   "Hello World!\r\n",
+	})
+	defer s.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		res, err := FetchGemini(conf, s.URL("/twtxt.txt"), nil)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "2020-01-01T00:00:00Z\tHello World!\n", string(body))
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		res, err := FetchGemini(conf, s.URL("/moved.txt"), nil)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "/twtxt.txt", res.Request.URL.Path)
+	})
+
+	t.Run("TooManyRedirects", func(t *testing.T) {
+		_, err := FetchGemini(conf, s.URL("/loop.txt"), nil)
+		assert.Equal(t, ErrTooManyGeminiRedirects, err)
+	})
+
+	t.Run("Failures", func(t *testing.T) {
+		for path, code := range map[string]int{
+			"/missing.txt": http.StatusNotFound,
+			"/slow.txt":    http.StatusServiceUnavailable,
+		} {
+			res, err := FetchGemini(conf, s.URL(path), nil)
+			require.NoError(t, err)
+			res.Body.Close()
+			assert.Equal(t, code, res.StatusCode, path)
+		}
+	})
+
+	t.Run("InvalidResponse", func(t *testing.T) {
+		_, err := FetchGemini(conf, s.URL("/bad.txt"), nil)
+		assert.Equal(t, ErrInvalidGeminiResponse, err)
+	})
+}
+
+func TestFetchGeminiTOFU(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	s := newGeminiTestServer(t, map[string]string{
+		"/twtxt.txt": "20 text/plain\r\n",
+	})
+	defer s.Close()
+
+	fetch := func() error {
+		res, err := FetchGemini(conf, s.URL("/twtxt.txt"), nil)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	// The first certificate seen is pinned
+	require.NoError(t, fetch())
+	pinned, ok, err := getSidecar(conf, geminiKnownHostsFile).Get(s.ln.Addr().String())
+	require.NoError(t, err)
+	assert.True(ok)
+	require.NoError(t, fetch())
+
+	// Any other certificate is rejected while the pinned one is current
+	s.SetCert(newGeminiTestCert(t, time.Now().Add(time.Hour)))
+	assert.Equal(ErrGeminiCertificateMismatch, fetch())
+
+	current, _, err := getSidecar(conf, geminiKnownHostsFile).Get(s.ln.Addr().String())
+	require.NoError(t, err)
+	assert.Equal(pinned, current)
+
+	// Once the pinned certificate has expired the next one seen is pinned
+	require.NoError(t, getSidecar(conf, geminiKnownHostsFile).Update(func(values map[string]string) {
+		fingerprint := strings.Fields(values[s.ln.Addr().String()])[0]
+		values[s.ln.Addr().String()] = fmt.Sprintf("%s %d", fingerprint, time.Now().Add(-time.Minute).Unix())
+	}))
+	require.NoError(t, fetch())
+
+	current, _, err = getSidecar(conf, geminiKnownHostsFile).Get(s.ln.Addr().String())
+	require.NoError(t, err)
+	assert.NotEqual(pinned, current)
+}
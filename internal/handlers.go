@@ -1,13 +1,13 @@
 package internal
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"image/png"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -18,7 +18,6 @@ import (
 
 	rice "github.com/GeertJohan/go.rice"
 	"github.com/chai2010/webp"
-	securejoin "github.com/cyphar/filepath-securejoin"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/html"
@@ -203,6 +202,12 @@ func (s *Server) ProfileHandler() httprouter.Handle {
 			}
 			profile = feed.Profile(s.config.BaseURL, ctx.User)
 		} else {
+			if s.config.RenameRedirects {
+				if name := ResolveFeedName(s.config, nick); name != nick {
+					http.Redirect(w, r, UserURL(URLForUser(s.config, name)), http.StatusMovedPermanently)
+					return
+				}
+			}
 			ctx.Error = true
 			ctx.Message = "User or Feed Not Found"
 			s.render("404", w, ctx)
@@ -227,9 +232,14 @@ func (s *Server) ProfileHandler() httprouter.Handle {
 				Title: fmt.Sprintf("%s's Atom Feed", profile.Username),
 				URL:   fmt.Sprintf("%s/atom.xml", UserURL(profile.URL)),
 			},
+			types.Alternative{
+				Type:  "application/feed+json",
+				Title: fmt.Sprintf("%s's JSON Feed", profile.Username),
+				URL:   fmt.Sprintf("%s/feed.json", UserURL(profile.URL)),
+			},
 		}...)
 
-		twts := s.cache.GetByURL(profile.URL)
+		twts := FilterReplies(s.cache.GetByURL(profile.URL), ParseReplyFilter(r.FormValue("replies")))
 
 		sort.Sort(twts)
 
@@ -392,6 +402,54 @@ func (s *Server) ArchiveFeedHandler() httprouter.Handle {
 	}
 }
 
+// RenameFeedHandler ...
+func (s *Server) RenameFeedHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		ctx := NewContext(s.config, s.db, r)
+		feedName := NormalizeFeedName(p.ByName("name"))
+		newName := NormalizeFeedName(r.FormValue("name"))
+
+		if feedName == "" || newName == "" {
+			ctx.Error = true
+			ctx.Message = "No feed specified"
+			s.render("error", w, ctx)
+			return
+		}
+
+		if !s.db.HasFeed(feedName) {
+			ctx.Error = true
+			ctx.Message = "Feed not found"
+			s.render("404", w, ctx)
+			return
+		}
+
+		if !ctx.User.OwnsFeed(feedName) {
+			ctx.Error = true
+			s.render("401", w, ctx)
+			return
+		}
+
+		if err := RenameFeed(s.config, s.db, feedName, newName); err != nil {
+			log.WithError(err).Errorf("error renaming feed %s to %s", feedName, newName)
+			ctx.Error = true
+			switch err {
+			case ErrFeedAlreadyExists:
+				ctx.Message = "A feed with that name already exists"
+			case ErrInvalidFeedName, ErrFeedNameTooLong:
+				ctx.Message = "Invalid feed name"
+			default:
+				ctx.Message = "Error renaming feed"
+			}
+			s.render("error", w, ctx)
+			return
+		}
+
+		ctx.Error = false
+		ctx.Message = fmt.Sprintf("Successfully renamed feed to %s", newName)
+		s.render("error", w, ctx)
+	}
+}
+
 // OldTwtxtHandler ...
 // Redirect old URIs (twtxt <= v0.0.8) of the form /u/<nick> -> /user/<nick>/twtxt.txt
 // TODO: Remove this after v1
@@ -551,15 +609,21 @@ func (s *Server) TwtxtHandler() httprouter.Handle {
 			return
 		}
 
-		fn, err := securejoin.SecureJoin(filepath.Join(s.config.Data, "feeds"), nick)
-		if err != nil {
-			http.Error(w, "Bad Request", http.StatusBadRequest)
-			return
-		}
+		store := s.config.FeedStore()
 
-		fileInfo, err := os.Stat(fn)
+		fileInfo, err := store.Stat(nick)
 		if err != nil {
+			if err == ErrInvalidFeedName {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
 			if os.IsNotExist(err) {
+				if s.config.RenameRedirects {
+					if name := ResolveFeedName(s.config, nick); name != nick {
+						http.Redirect(w, r, URLForUser(s.config, name), http.StatusMovedPermanently)
+						return
+					}
+				}
 				http.Error(w, "Feed Not Found", http.StatusNotFound)
 				return
 			}
@@ -568,9 +632,15 @@ func (s *Server) TwtxtHandler() httprouter.Handle {
 			return
 		}
 
+		if !CheckFeedToken(s.db, nick, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.Header().Set("Link", fmt.Sprintf(`<%s/user/%s/webmention>; rel="webmention"`, s.config.BaseURL, nick))
 		w.Header().Set("Last-Modified", fileInfo.ModTime().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", feedETag(fileInfo))
 
 		followerClient, err := DetectFollowerFromUserAgent(r.UserAgent())
 		if err != nil {
@@ -601,7 +671,34 @@ func (s *Server) TwtxtHandler() httprouter.Handle {
 			}
 		}
 
-		f, err := os.Open(fn)
+		// Serve a fragment of the feed, e.g: /user/<nick>/twtxt.txt?page=2
+		if r.FormValue("page") != "" {
+			page := SafeParseInt(r.FormValue("page"), 0)
+			size := SafeParseInt(r.FormValue("size"), s.config.TwtsPerPage)
+
+			data, _, err := GetFeedFragment(s.config, nick, page, size)
+			if err != nil {
+				if err == ErrInvalidFragment {
+					http.Error(w, "Fragment Not Found", http.StatusNotFound)
+					return
+				}
+				if err == ErrInvalidFeedName {
+					http.Error(w, "Bad Request", http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			if r.Method == http.MethodHead {
+				return
+			}
+
+			http.ServeContent(w, r, nick, fileInfo.ModTime(), bytes.NewReader(data))
+			return
+		}
+
+		f, err := store.Open(nick)
 		if err != nil {
 			log.WithError(err).Error("error opening feed")
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -613,14 +710,53 @@ func (s *Server) TwtxtHandler() httprouter.Handle {
 			return
 		}
 
-		http.ServeContent(w, r, filepath.Base(fn), fileInfo.ModTime(), f)
+		http.ServeContent(w, r, nick, fileInfo.ModTime(), f)
+	}
+}
+
+// ArchiveFeedsHandler serves the archive feeds of a feed (see
+// CompactArchives) e.g: /user/<nick>/archive/2020.txt
+func (s *Server) ArchiveFeedsHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		nick := NormalizeUsername(p.ByName("nick"))
+		period := strings.TrimSuffix(p.ByName("period"), ".txt")
+		if nick == "" || period == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if !CheckFeedToken(s.db, nick, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		data, err := GetArchiveFeed(s.config, nick, period)
+		if err != nil {
+			if err == ErrInvalidFeedName || err == ErrInvalidArchivePeriod {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+			if os.IsNotExist(err) {
+				http.Error(w, "Archive Feed Not Found", http.StatusNotFound)
+				return
+			}
+			log.WithError(err).Errorf("error reading archive feed %s of %s", period, nick)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		http.ServeContent(w, r, nick, time.Time{}, bytes.NewReader(data))
 	}
 }
 
 // PostHandler ...
 func (s *Server) PostHandler() httprouter.Handle {
-	isLocalURL := IsLocalURLFactory(s.config)
-	isExternalFeed := IsExternalFeedFactory(s.config)
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		ctx := NewContext(s.config, s.db, r)
 
@@ -635,7 +771,7 @@ func (s *Server) PostHandler() httprouter.Handle {
 			}
 
 			// Update user's own timeline with their own new post.
-			s.cache.FetchTwts(s.config, s.archive, ctx.User.Source(), nil)
+			s.cache.FetchTwts(s.config, s.db, s.archive, ctx.User.Source(), nil)
 
 			// Re-populate/Warm cache with local twts for this pod
 			s.cache.GetByPrefix(s.config.BaseURL, true)
@@ -654,19 +790,14 @@ func (s *Server) PostHandler() httprouter.Handle {
 			return
 		}
 
-		if hash != "" && lastTwt.Hash() == hash {
-			if err := DeleteLastTwt(s.config, ctx.User); err != nil {
-				ctx.Error = true
-				ctx.Message = "Error deleting last twt"
-				s.render("error", w, ctx)
-			}
-		} else {
+		if hash != "" && lastTwt.Hash() != hash {
 			log.Warnf("hash mismatch %s != %s", lastTwt.Hash(), hash)
 		}
 
 		text := CleanTwt(r.FormValue("text"))
 
-		if text == "" {
+		// An empty edit may delete the twt being edited
+		if text == "" && (hash == "" || !s.config.EmptyEditDeletes) {
 			ctx.Error = true
 			ctx.Message = "No post content provided!"
 			s.render("error", w, ctx)
@@ -691,21 +822,29 @@ func (s *Server) PostHandler() httprouter.Handle {
 			return
 		}
 
-		var twt types.Twt
-
 		switch postas {
 		case "", user.Username:
 			if hash != "" && lastTwt.Hash() == hash {
-				twt, err = AppendTwt(s.config, s.db, user, text, lastTwt.Created)
+				if _, err = EditTwt(s.config, s.db, user, hash, text); err == ErrEditDeleted {
+					err = nil
+				}
 			} else {
-				twt, err = AppendTwt(s.config, s.db, user, text)
+				_, err = AppendTwt(s.config, s.db, user, text)
 			}
 		default:
 			if user.OwnsFeed(postas) {
 				if hash != "" && lastTwt.Hash() == hash {
-					twt, err = AppendSpecial(s.config, s.db, postas, text, lastTwt.Created)
+					if err := DeleteLastTwt(s.config, ctx.User); err != nil {
+						ctx.Error = true
+						ctx.Message = "Error deleting last twt"
+						s.render("error", w, ctx)
+						return
+					}
+					if text != "" {
+						_, err = AppendSpecial(s.config, s.db, postas, text, lastTwt.Created)
+					}
 				} else {
-					twt, err = AppendSpecial(s.config, s.db, postas, text)
+					_, err = AppendSpecial(s.config, s.db, postas, text)
 				}
 			} else {
 				err = ErrFeedImposter
@@ -716,24 +855,25 @@ func (s *Server) PostHandler() httprouter.Handle {
 			log.WithError(err).Error("error posting twt")
 			ctx.Error = true
 			ctx.Message = "Error posting twt"
+			var rateLimited *ErrRateLimited
+			if errors.As(err, &rateLimited) {
+				ctx.Message = fmt.Sprintf("You're posting too fast, please try again in %s", rateLimited.RetryAfter.Round(time.Second))
+			} else if err == ErrSpamQuarantined {
+				ctx.Message = "Your twt looks like spam and was saved to your drafts for review"
+			} else if err == ErrSpamRejected {
+				ctx.Message = "Your twt looks like spam and was not posted"
+			}
 			s.render("error", w, ctx)
 			return
 		}
 
 		// Update user's own timeline with their own new post.
-		s.cache.FetchTwts(s.config, s.archive, user.Source(), nil)
+		s.cache.FetchTwts(s.config, s.db, s.archive, user.Source(), nil)
 
 		// Re-populate/Warm cache with local twts for this pod
 		s.cache.GetByPrefix(s.config.BaseURL, true)
 
-		// WebMentions ...
-		for _, twter := range twt.Mentions() {
-			if !isLocalURL(twter.URL) || isExternalFeed(twter.URL) {
-				if err := WebMention(twter.URL, URLForTwt(s.config.BaseURL, twt.Hash())); err != nil {
-					log.WithError(err).Warnf("error sending webmention to %s", twter.URL)
-				}
-			}
-		}
+		// Remote feeds mentioned are notified by the NotifyMentionedHook
 
 		http.Redirect(w, r, RedirectURL(r, s.config, "/"), http.StatusFound)
 	}
@@ -844,17 +984,12 @@ func (s *Server) PermalinkHandler() httprouter.Handle {
 			return
 		}
 
-		var (
-			who   string
-			image string
-		)
+		var who string
 
 		if isLocal(twt.Twter.URL) {
 			who = fmt.Sprintf("%s@%s", twt.Twter.Nick, s.config.baseURL.Hostname())
-			image = URLForAvatar(s.config, twt.Twter.Nick)
 		} else {
 			who = fmt.Sprintf("@<%s %s>", twt.Twter.Nick, twt.Twter.URL)
-			image = URLForExternalAvatar(s.config, twt.Twter.URL)
 		}
 
 		when := twt.Created.Format(time.RFC3339)
@@ -889,14 +1024,18 @@ func (s *Server) PermalinkHandler() httprouter.Handle {
 
 		title := fmt.Sprintf("%s \"%s\"", who, what)
 
+		ctx.Reactions = CountReactions(s.config, s.cache, twt.Hash())
+
+		og := OpenGraphMeta(s.config, twt)
+
 		ctx.Title = title
 		ctx.Meta = Meta{
-			Title:       fmt.Sprintf("Twt #%s", twt.Hash()),
-			Description: what,
+			Title:       og["og:title"],
+			Description: og["og:description"],
 			UpdatedAt:   when,
 			Author:      who,
-			Image:       image,
-			URL:         URLForTwt(s.config.BaseURL, hash),
+			Image:       og["og:image"],
+			URL:         og["og:url"],
 			Keywords:    strings.Join(ks, ", "),
 		}
 		if strings.HasPrefix(twt.Twter.URL, s.config.BaseURL) {
@@ -931,6 +1070,10 @@ func (s *Server) DiscoverHandler() httprouter.Handle {
 
 		localTwts := s.cache.GetByPrefix(s.config.BaseURL, false)
 
+		if lang := r.FormValue("lang"); lang != "" {
+			localTwts = FilterByLang(localTwts, lang)
+		}
+
 		sort.Sort(localTwts)
 
 		var pagedTwts types.Twts
@@ -1003,30 +1146,24 @@ func (s *Server) SearchHandler() httprouter.Handle {
 		var twts types.Twts
 
 		tag := r.URL.Query().Get("tag")
+		namespace := r.URL.Query().Get("ns")
+		query := r.URL.Query().Get("q")
 
-		if tag == "" {
+		if tag == "" && query == "" {
 			ctx.Error = true
 			ctx.Message = "At least search query is required"
 			s.render("error", w, ctx)
+			return
 		}
 
-		getTweetsByTag := func() types.Twts {
-			var result types.Twts
-			seen := make(map[string]bool)
+		if query != "" {
+			twts = SearchTwts(s.cache.GetAll(), query, getSearchScoreFunc()).Twts()
+		} else {
 			// TODO: Improve this by making this an O(1) lookup on the tag
-			for _, twt := range s.cache.GetAll() {
-				if HasString(UniqStrings(twt.Tags()), tag) && !seen[twt.Hash()] {
-					result = append(result, twt)
-					seen[twt.Hash()] = true
-				}
-			}
-			return result
+			twts = GetTwtsByTag(s.cache.GetAll(), tag, namespace)
+			sort.Sort(twts)
 		}
 
-		twts = getTweetsByTag()
-
-		sort.Sort(twts)
-
 		var pagedTwts types.Twts
 
 		page := SafeParseInt(r.FormValue("p"), 1)
@@ -1255,27 +1392,14 @@ func (s *Server) RegisterHandler() httprouter.Handle {
 			return
 		}
 
-		p := filepath.Join(s.config.Data, feedsDir)
-		if err := os.MkdirAll(p, 0755); err != nil {
-			log.WithError(err).Error("error creating feeds directory")
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		fn := filepath.Join(p, username)
-		if _, err := os.Stat(fn); err == nil {
+		store := s.config.FeedStore()
+		if _, err := store.Stat(username); err == nil {
 			ctx.Error = true
 			ctx.Message = "Deleted user with that username already exists! Please pick another!"
 			s.render("error", w, ctx)
 			return
 		}
 
-		if err := ioutil.WriteFile(fn, []byte{}, 0644); err != nil {
-			log.WithError(err).Error("error creating new user feed")
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
 		hash, err := s.pm.CreatePassword(password)
 		if err != nil {
 			log.WithError(err).Error("error creating password hash")
@@ -1292,6 +1416,12 @@ func (s *Server) RegisterHandler() httprouter.Handle {
 		user.URL = URLForUser(s.config, username)
 		user.CreatedAt = time.Now()
 
+		if err := EnsureUserFeed(s.config, s.db, user); err != nil {
+			log.WithError(err).Error("error creating new user feed")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		if err := s.db.SetUser(username, user); err != nil {
 			log.WithError(err).Error("error saving user object for new user")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -1361,10 +1491,12 @@ func (s *Server) SettingsHandler() httprouter.Handle {
 		// XXX: We DO NOT store this! (EVER)
 		email := strings.TrimSpace(r.FormValue("email"))
 		tagline := strings.TrimSpace(r.FormValue("tagline"))
+		signature := strings.TrimSpace(r.FormValue("signature"))
 		password := r.FormValue("password")
 
 		theme := r.FormValue("theme")
 		displayDatesInTimezone := r.FormValue("displayDatesInTimezone")
+		displayDatesInFormat := strings.TrimSpace(r.FormValue("displayDatesInFormat"))
 		isFollowersPubliclyVisible := r.FormValue("isFollowersPubliclyVisible") == "on"
 		isFollowingPubliclyVisible := r.FormValue("isFollowingPubliclyVisible") == "on"
 
@@ -1414,9 +1546,11 @@ func (s *Server) SettingsHandler() httprouter.Handle {
 
 		user.Recovery = recoveryHash
 		user.Tagline = tagline
+		user.Signature = signature
 
 		user.Theme = theme
 		user.DisplayDatesInTimezone = displayDatesInTimezone
+		user.DisplayDatesInFormat = displayDatesInFormat
 		user.IsFollowersPubliclyVisible = isFollowersPubliclyVisible
 		user.IsFollowingPubliclyVisible = isFollowingPubliclyVisible
 
@@ -1581,7 +1715,7 @@ func (s *Server) ExternalHandler() httprouter.Handle {
 		if !s.cache.IsCached(uri) {
 			sources := make(types.Feeds)
 			sources[types.Feed{Nick: nick, URL: uri}] = true
-			s.cache.FetchTwts(s.config, s.archive, sources, nil)
+			s.cache.FetchTwts(s.config, s.db, s.archive, sources, nil)
 		}
 
 		twts := s.cache.GetByURL(uri)
@@ -1936,6 +2070,11 @@ func (s *Server) SyndicationHandler() httprouter.Handle {
 
 		nick := NormalizeUsername(p.ByName("nick"))
 		if nick != "" {
+			if !CheckFeedToken(s.db, nick, r) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
 			if s.db.HasUser(nick) {
 				if user, err := s.db.GetUser(nick); err == nil {
 					profile = user.Profile(s.config.BaseURL, nil)
@@ -1986,6 +2125,24 @@ func (s *Server) SyndicationHandler() httprouter.Handle {
 			return
 		}
 
+		if strings.HasSuffix(r.URL.Path, ".json") {
+			twter := types.Twter{
+				Nick:    profile.Username,
+				URL:     profile.URL,
+				Tagline: profile.Tagline,
+			}
+			data, err := RenderJSONFeed(s.config, twter, twts)
+			if err != nil {
+				log.WithError(err).Error("error serializing feed")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+			w.Write(data)
+			return
+		}
+
 		now := time.Now()
 
 		feed := &feeds.Feed{
@@ -2001,10 +2158,10 @@ func (s *Server) SyndicationHandler() httprouter.Handle {
 		for _, twt := range twts {
 			items = append(items, &feeds.Item{
 				Id:          twt.Hash(),
-				Title:       string(formatTwt(twt.Text)),
+				Title:       string(formatTwt(TwtDisplayText(twt))),
 				Link:        &feeds.Link{Href: URLForTwt(s.config.BaseURL, twt.Hash())},
 				Author:      &feeds.Author{Name: twt.Twter.Nick},
-				Description: string(formatTwt(twt.Text)),
+				Description: string(formatTwt(TwtDisplayText(twt))),
 				Created:     twt.Created,
 			},
 			)
@@ -2228,9 +2385,8 @@ func (s *Server) DeleteAllHandler() httprouter.Handle {
 				}
 
 				// Delete feeds's twtxt.txt
-				fn := filepath.Join(s.config.Data, feedsDir, nick)
-				if FileExists(fn) {
-					if err := os.Remove(fn); err != nil {
+				if err := s.config.FeedStore().Remove(nick); err != nil {
+					if !os.IsNotExist(err) {
 						log.WithError(err).Error("error removing feed")
 						ctx.Error = true
 						ctx.Message = "An error occured whilst deleting your account"
@@ -2299,9 +2455,8 @@ func (s *Server) DeleteAllHandler() httprouter.Handle {
 		}
 
 		// Delete user's twtxt.txt
-		fn := filepath.Join(s.config.Data, feedsDir, ctx.User.Username)
-		if FileExists(fn) {
-			if err := os.Remove(fn); err != nil {
+		if err := s.config.FeedStore().Remove(ctx.User.Username); err != nil {
+			if !os.IsNotExist(err) {
 				log.WithError(err).Error("error removing user's feed")
 				ctx.Error = true
 				ctx.Message = "An error occured whilst deleting your account"
@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/prologic/twtxt/types"
+)
+
+var (
+	ErrInvalidImportCheckpoint = errors.New("error: invalid import checkpoint")
+	ErrUnknownArchiveFormat    = errors.New("error: unknown archive format (expected tar, tar.gz or zip)")
+	ErrArchiveEntryTooLarge    = errors.New("error: archive entry too large")
+	ErrArchiveTooLarge         = errors.New("error: archive too large")
+)
+
+// ImportCheckpoint records how far an ImportFeed got through its source so
+// an interrupted import can be resumed without rescanning the whole source.
+// Checkpoints are plain values the caller can persist wherever it likes.
+type ImportCheckpoint struct {
+	// Offset is the byte offset in the source just after the last twt imported
+	Offset int64
+	// Hash is the hash (in the local feed) of the last twt imported
+	Hash string
+	// Created is the timestamp of the last twt imported
+	Created time.Time
+}
+
+// StripHTML removes any HTML tags erroneously embedded in a twt's text so
+// they never render as markup, keeping the text in between (as is, entities
+// included). The contents of elements that are never text to display, such
+// as <script> and <style>, are removed along with their tags.
+func StripHTML(text string) string {
+	if !strings.Contains(text, "<") {
+		return text
+	}
+
+	var (
+		buf  strings.Builder
+		skip string
+	)
+
+	z := html.NewTokenizer(strings.NewReader(text))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(buf.String())
+		case html.TextToken:
+			if skip == "" {
+				buf.Write(z.Raw())
+			}
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if skip == "" && (string(name) == "script" || string(name) == "style") {
+				skip = string(name)
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if string(name) == skip {
+				skip = ""
+			}
+		}
+	}
+}
+
+// ImportFeed imports twts from the twtxt feed src into the local feed name
+// preserving their timestamps. Comments and invalid lines are skipped, as
+// are twts already in the local feed (e.g: imported before an interruption
+// but after the last checkpoint was saved). If conf.StripImportedHTML is
+// enabled any HTML in the text of twts is stripped (see StripHTML).
+//
+// A nil checkpoint imports from the start of src, otherwise src is read from
+// the checkpoint's offset onwards. The checkpoint after the last twt imported
+// is returned, also with an error, so that a failed import can be resumed.
+func ImportFeed(conf *Config, name string, src io.ReadSeeker, checkpoint *ImportCheckpoint) (*ImportCheckpoint, error) {
+	next := &ImportCheckpoint{}
+	if checkpoint != nil {
+		*next = *checkpoint
+	}
+
+	size, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return next, err
+	}
+	if next.Offset < 0 || next.Offset > size {
+		return next, ErrInvalidImportCheckpoint
+	}
+	if _, err := src.Seek(next.Offset, io.SeekStart); err != nil {
+		return next, err
+	}
+
+	hashes, err := feedHashes(conf, name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			conf.feedLog(name).WithError(err).Error("error reading feed")
+			return next, err
+		}
+		hashes = make(map[string]bool)
+	}
+
+	store := conf.FeedStore()
+
+	// Ensure we don't append onto the end of a last line missing its newline
+	eol, err := hasTrailingNewline(store, name)
+	if err != nil {
+		conf.feedLog(name).WithError(err).Error("error reading feed")
+		return next, err
+	}
+
+	twter := types.Twter{Nick: name, URL: URLForUser(conf, name)}
+
+	offset := next.Offset
+	r := bufio.NewReader(src)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return next, err
+		}
+		if line == "" {
+			break
+		}
+		offset += int64(len(line))
+
+		twt, perr := parseLine(conf, strings.TrimRight(line, "\r\n"), twter)
+		if perr == nil && !twt.IsZero() && conf.StripImportedHTML {
+			if text := StripHTML(twt.Text); text != twt.Text {
+				if text == "" {
+					perr = ErrInvalidTwtLine
+				}
+				twt = types.Twt{Twter: twter, Created: twt.Created, Text: text}
+			}
+		}
+		// The twt's hash is that of the line as written (and read back) so
+		// re-importing the same source is recognized as such
+		var entry string
+		if perr == nil && !twt.IsZero() {
+			entry = fmt.Sprintf("%s\t%s", twt.Created.Format(time.RFC3339Nano), twt.Text)
+			twt, perr = parseLine(conf, entry, twter)
+		}
+		if perr == nil && !twt.IsZero() && !hashes[twt.Hash()] {
+			data := entry + conf.EOL()
+			if !eol {
+				data = conf.EOL() + data
+				eol = true
+			}
+			if err := store.Append(name, []byte(data)); err != nil {
+				conf.feedLog(name).WithError(err).Error("error appending imported twt to feed")
+				return next, err
+			}
+			hashes[twt.Hash()] = true
+			next.Hash = twt.Hash()
+			next.Created = twt.Created
+		}
+		next.Offset = offset
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return next, nil
+}
+
+// ImportResult is the outcome of importing a single feed by ImportArchive
+type ImportResult struct {
+	Name    string
+	Created bool
+	Err     error
+}
+
+// ImportReport reports the outcome of importing each feed by ImportArchive
+type ImportReport struct {
+	Results []ImportResult
+}
+
+// Failed returns the results of feeds that failed to import
+func (r ImportReport) Failed() []ImportResult {
+	var failed []ImportResult
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// importArchiveEntry imports a single feed entry of an archive creating a
+// user for the feed if no user or feed by that name exists. Users created
+// have no password and must recover their account before logging in.
+func importArchiveEntry(conf *Config, db Store, name string, r io.Reader) ImportResult {
+	result := ImportResult{Name: name}
+
+	name = strings.TrimPrefix(name, "./")
+	if err := ValidateUsername(name); err != nil {
+		result.Err = err
+		return result
+	}
+	name = NormalizeUsername(name)
+	result.Name = name
+
+	if conf.MaxUploadSize > 0 {
+		r = io.LimitReader(r, conf.MaxUploadSize+1)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if conf.MaxUploadSize > 0 && int64(len(data)) > conf.MaxUploadSize {
+		result.Err = ErrArchiveEntryTooLarge
+		return result
+	}
+
+	if !db.HasUser(name) && !db.HasFeed(name) {
+		user := NewUser()
+		user.Username = name
+		user.URL = URLForUser(conf, name)
+		user.CreatedAt = time.Now()
+
+		if err := EnsureUserFeed(conf, db, user); err != nil {
+			conf.userLog(name).WithError(err).Error("error creating feed for imported user")
+			result.Err = err
+			return result
+		}
+
+		if err := db.SetUser(name, user); err != nil {
+			conf.userLog(name).WithError(err).Error("error saving user object for imported feed")
+			result.Err = err
+			return result
+		}
+		result.Created = true
+	}
+
+	if _, err := ImportFeed(conf, name, bytes.NewReader(data), nil); err != nil {
+		result.Err = err
+	}
+
+	return result
+}
+
+// ImportArchive imports the feeds in a tar (format "tar"), gzipped tar
+// ("tar.gz" or "tgz") or zip ("zip") archive where each entry is a feed file
+// named by username, e.g: to migrate an entire pod. Feeds are merged into
+// any existing local feeds (see ImportFeed) and users are created as needed.
+// Entries whose names are not valid usernames (including any with a path)
+// fail to import; directories are skipped. Failures of individual feeds are
+// reported in the ImportReport, an error is only returned if the archive
+// itself cannot be read. Entries, and zip archives as a whole, larger than
+// conf.MaxUploadSize are rejected.
+func ImportArchive(conf *Config, db Store, r io.Reader, format string) (ImportReport, error) {
+	var report ImportReport
+
+	switch strings.ToLower(format) {
+	case "tar.gz", "tgz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return report, err
+		}
+		defer gz.Close()
+		r = gz
+		fallthrough
+	case "tar":
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return report, err
+			}
+			if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+				continue
+			}
+			report.Results = append(report.Results, importArchiveEntry(conf, db, hdr.Name, tr))
+		}
+	case "zip":
+		// zip archives are read into memory as zip.NewReader needs random access
+		if conf.MaxUploadSize > 0 {
+			r = io.LimitReader(r, conf.MaxUploadSize+1)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return report, err
+		}
+		if conf.MaxUploadSize > 0 && int64(len(data)) > conf.MaxUploadSize {
+			return report, ErrArchiveTooLarge
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return report, err
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				report.Results = append(report.Results, ImportResult{Name: f.Name, Err: err})
+				continue
+			}
+			report.Results = append(report.Results, importArchiveEntry(conf, db, f.Name, rc))
+			rc.Close()
+		}
+	default:
+		return report, ErrUnknownArchiveFormat
+	}
+
+	return report, nil
+}
@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	lines := []string{
+		"# nick = old",
+		"2019-01-01T00:00:00Z\tFirst",
+		"2019-06-01T00:00:00Z\tSecond",
+		"not a twt",
+		"2020-01-01T00:00:00Z\tThird",
+		"2020-06-01T00:00:00.5Z\tFractional seconds",
+	}
+	source := strings.Join(lines, "\n") + "\n"
+
+	// Simulate an import interrupted after the first two twts
+	partial := strings.Join(lines[:3], "\n") + "\n"
+	checkpoint, err := ImportFeed(conf, "imported", strings.NewReader(partial), nil)
+	require.NoError(t, err)
+	assert.Equal(int64(len(partial)), checkpoint.Offset)
+	assert.Equal("2019-06-01T00:00:00Z", checkpoint.Created.Format(time.RFC3339))
+
+	checkpoint, err = ImportFeed(conf, "imported", strings.NewReader(source), checkpoint)
+	require.NoError(t, err)
+	assert.Equal(int64(len(source)), checkpoint.Offset)
+
+	// Re-running from the start does not duplicate anything
+	_, err = ImportFeed(conf, "imported", strings.NewReader(source), nil)
+	require.NoError(t, err)
+
+	twts, err := GetAllTwts(conf, "imported")
+	require.NoError(t, err)
+	require.Len(t, twts, 4)
+	assert.Equal("Fractional seconds", twts[0].Text)
+	assert.Equal(500*time.Millisecond, time.Duration(twts[0].Created.Nanosecond()))
+	assert.Equal("First", twts[3].Text)
+	assert.Equal(twts[0].Hash(), checkpoint.Hash)
+
+	_, err = ImportFeed(conf, "imported", strings.NewReader(partial), checkpoint)
+	assert.Equal(ErrInvalidImportCheckpoint, err)
+}
+
+func TestStripHTML(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("Hello World!", StripHTML("Hello World!"))
+	assert.Equal("a < b", StripHTML("a < b"))
+	assert.Equal("Hello World!", StripHTML("<p>Hello <b>World</b>!</p>"))
+	assert.Equal("Tom &amp; Jerry", StripHTML("<i>Tom &amp; Jerry</i>"))
+	assert.Equal("Hi  there", StripHTML(`Hi <script>alert("pwned")</script> there`))
+	assert.Equal("Hi", StripHTML(`Hi <style>body { display: none }</style>`))
+	assert.Equal("click", StripHTML(`<a href="javascript:alert(1)" onclick="alert(1)">click</a>`))
+}
+
+func TestImportFeedStripHTML(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithStripImportedHTML(true)(conf))
+
+	source := "2020-01-01T00:00:00Z\tHello <script>alert(\"pwned\")</script><b>World</b>\n" +
+		"2020-01-02T00:00:00Z\t<script>alert(\"pwned\")</script>\n" +
+		"2020-01-03T00:00:00Z\tPlain text\n"
+
+	_, err := ImportFeed(conf, "imported", strings.NewReader(source), nil)
+	require.NoError(t, err)
+
+	twts, err := GetAllTwts(conf, "imported")
+	require.NoError(t, err)
+	require.Len(t, twts, 2)
+	assert.Equal("Plain text", twts[0].Text)
+	assert.Equal("Hello World", twts[1].Text)
+
+	html := string(FormatTwtFactory(conf)(twts[1].Text))
+	assert.NotContains(html, "script")
+	assert.NotContains(html, "pwned")
+}
+
+func TestImportArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	feeds := []struct {
+		name, data string
+	}{
+		{"./alice", "# nick = alice\n2020-01-01T00:00:00Z\tHello from alice\n"},
+		{"bob", "2020-01-02T00:00:00Z\tHello from bob\n"},
+		{"../evil", "2020-01-03T00:00:00Z\tGotcha\n"},
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, feed := range feeds {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name: feed.name, Mode: 0644, Size: int64(len(feed.data)), Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(feed.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	report, err := ImportArchive(conf, db, &buf, "tar")
+	require.NoError(t, err)
+	require.Len(t, report.Results, 3)
+	assert.Equal(ImportResult{Name: "alice", Created: true}, report.Results[0])
+	assert.Equal(ImportResult{Name: "bob", Created: true}, report.Results[1])
+	require.Len(t, report.Failed(), 1)
+	assert.Equal("../evil", report.Failed()[0].Name)
+
+	assert.True(db.HasUser("alice"))
+	twts, err := GetAllTwts(conf, "bob")
+	require.NoError(t, err)
+	require.Len(t, twts, 1)
+	assert.Equal("Hello from bob", twts[0].Text)
+
+	_, err = ImportArchive(conf, db, &buf, "rar")
+	assert.Equal(ErrUnknownArchiveFormat, err)
+}
+
+func TestImportArchiveTooLarge(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithMaxUploadSize(64)(conf))
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	data := strings.Repeat("2020-01-01T00:00:00Z\tHello World!\n", 4)
+
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name: "alice", Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg,
+	}))
+	_, err = tw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	report, err := ImportArchive(conf, db, &tarBuf, "tar")
+	require.NoError(t, err)
+	require.Len(t, report.Failed(), 1)
+	assert.Equal(ErrArchiveEntryTooLarge, report.Failed()[0].Err)
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, err := zw.Create("alice")
+	require.NoError(t, err)
+	_, err = w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	_, err = ImportArchive(conf, db, &zipBuf, "zip")
+	assert.Equal(ErrArchiveTooLarge, err)
+	assert.False(db.HasUser("alice"))
+}
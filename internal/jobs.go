@@ -2,8 +2,7 @@ package internal
 
 import (
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
+	"strings"
 
 	"github.com/prologic/twtxt/types"
 	"github.com/robfig/cron"
@@ -30,11 +29,14 @@ func init() {
 		"SyncStore":         NewJobSpec("@every 1m", NewSyncStoreJob),
 		"UpdateFeeds":       NewJobSpec("@every 5m", NewUpdateFeedsJob),
 		"UpdateFeedSources": NewJobSpec("@every 15m", NewUpdateFeedSourcesJob),
+		"PublishDueTwts":    NewJobSpec("@every 1m", NewPublishDueTwtsJob),
 		"FixUserAccounts":   NewJobSpec("@hourly", NewFixUserAccountsJob),
 		"DeleteOldSessions": NewJobSpec("@hourly", NewDeleteOldSessionsJob),
 		"FixMissingTwts":    NewJobSpec("@daily", NewFixMissingTwtsJob),
 		"Stats":             NewJobSpec("@daily", NewStatsJob),
 		"MergeStore":        NewJobSpec("@daily", NewMergeStoreJob),
+		"UpdateMovedFeeds":  NewJobSpec("@daily", NewUpdateMovedFeedsJob),
+		"CompactArchives":   NewJobSpec("@daily", NewCompactArchivesJob),
 
 		"RemoveEmailAddresses": NewJobSpec("", NewRemoveEmailAddressesJob),
 	}
@@ -130,7 +132,7 @@ func (job *StatsJob) Run() {
 	for _, feed := range allFeeds {
 		count, err := GetFeedCount(job.conf, feed)
 		if err != nil {
-			log.WithError(err).Warnf("error getting feed count for %s", feed)
+			job.conf.feedLog(feed).WithError(err).Warn("error getting feed count")
 			return
 		}
 		twts += count
@@ -200,7 +202,7 @@ func (job *UpdateFeedsJob) Run() {
 	}
 
 	log.Infof("updating %d sources", len(sources))
-	job.cache.FetchTwts(job.conf, job.archive, sources, followers)
+	job.cache.FetchTwts(job.conf, job.db, job.archive, sources, followers)
 
 	log.Infof("warming cache with local twts for %s", job.conf.BaseURL)
 	job.cache.GetByPrefix(job.conf.BaseURL, true)
@@ -268,7 +270,7 @@ func (job *FixUserAccountsJob) Run() {
 
 		for _, feed := range specialUsernames {
 			if err := CreateFeed(job.conf, job.db, adminUser, feed, true); err != nil {
-				log.WithError(err).Warnf("error creating new feed %s for adminUser", feed)
+				job.conf.feedLog(feed).WithError(err).Warn("error creating new feed for adminUser")
 			}
 		}
 
@@ -288,7 +290,7 @@ func (job *FixUserAccountsJob) Run() {
 	// Create twtxtBots feeds
 	for _, feed := range twtxtBots {
 		if err := CreateFeed(job.conf, job.db, nil, feed, true); err != nil {
-			log.WithError(err).Warnf("error creating new feed %s", feed)
+			job.conf.feedLog(feed).WithError(err).Warn("error creating new feed")
 		}
 	}
 }
@@ -306,15 +308,13 @@ func NewFixMissingTwtsJob(conf *Config, blogs *BlogsCache, cache *Cache, archive
 }
 
 func (job *FixMissingTwtsJob) Run() {
-	p := filepath.Join(job.conf.Data, feedsDir)
-	fileInfos, err := ioutil.ReadDir(p)
+	names, err := GetAllFeeds(job.conf)
 	if err != nil {
 		log.WithError(err).Error("error reading feeds")
 		return
 	}
 
-	for _, fileInfo := range fileInfos {
-		name := fileInfo.Name()
+	for _, name := range names {
 		twts, err := GetAllTwts(job.conf, name)
 		if err != nil {
 			log.WithError(err).Errorf("error loading twts for %s", name)
@@ -421,3 +421,119 @@ func (job *RemoveEmailAddresses) Run() {
 
 	log.Info("finished removing email addresses from user accounts")
 }
+
+type UpdateMovedFeedsJob struct {
+	conf    *Config
+	blogs   *BlogsCache
+	cache   *Cache
+	archive Archiver
+	db      Store
+}
+
+func NewUpdateMovedFeedsJob(conf *Config, blogs *BlogsCache, cache *Cache, archive Archiver, db Store) cron.Job {
+	return &UpdateMovedFeedsJob{conf: conf, blogs: blogs, cache: cache, archive: archive, db: db}
+}
+
+func (job *UpdateMovedFeedsJob) Run() {
+	if !job.conf.UpdateMovedFeeds {
+		return
+	}
+
+	users, err := job.db.GetAllUsers()
+	if err != nil {
+		log.WithError(err).Warn("unable to get all users from database")
+		return
+	}
+
+	// Resolve each followed feed only once
+	moves := make(map[string]string)
+	for _, user := range users {
+		for _, url := range user.Following {
+			if _, ok := moves[url]; ok || strings.HasPrefix(url, job.conf.BaseURL) {
+				continue
+			}
+			newURL, moved, err := ResolveFeedRedirect(url)
+			if err != nil {
+				job.conf.feedLog(url).WithError(err).Warn("error resolving redirects of feed")
+				continue
+			}
+			if moved {
+				moves[url] = newURL
+			} else {
+				moves[url] = ""
+			}
+		}
+	}
+
+	for _, user := range users {
+		changed := false
+		for nick, url := range user.Following {
+			if newURL := moves[url]; newURL != "" {
+				job.conf.userLog(user.Username).WithField("feed", url).Infof("updating followed feed which has moved to %s", newURL)
+				user.Following[nick] = newURL
+				changed = true
+			}
+		}
+		if changed {
+			if err := job.db.SetUser(user.Username, user); err != nil {
+				job.conf.userLog(user.Username).WithError(err).Warn("error updating user object")
+			}
+		}
+	}
+}
+
+type PublishDueTwtsJob struct {
+	conf    *Config
+	blogs   *BlogsCache
+	cache   *Cache
+	archive Archiver
+	db      Store
+}
+
+func NewPublishDueTwtsJob(conf *Config, blogs *BlogsCache, cache *Cache, archive Archiver, db Store) cron.Job {
+	return &PublishDueTwtsJob{conf: conf, blogs: blogs, cache: cache, archive: archive, db: db}
+}
+
+func (job *PublishDueTwtsJob) Run() {
+	published, err := PublishDueTwts(job.conf, job.db)
+	if err != nil {
+		log.WithError(err).Warn("error publishing scheduled twts")
+		return
+	}
+	if len(published) == 0 {
+		return
+	}
+	log.Infof("published %d scheduled twts", len(published))
+
+	feeds := make(types.Feeds)
+	for _, twt := range published {
+		feeds[types.Feed{Nick: twt.Twter.Nick, URL: twt.Twter.URL}] = true
+	}
+	job.cache.FetchTwts(job.conf, job.db, job.archive, feeds, nil)
+}
+
+type CompactArchivesJob struct {
+	conf    *Config
+	blogs   *BlogsCache
+	cache   *Cache
+	archive Archiver
+	db      Store
+}
+
+func NewCompactArchivesJob(conf *Config, blogs *BlogsCache, cache *Cache, archive Archiver, db Store) cron.Job {
+	return &CompactArchivesJob{conf: conf, blogs: blogs, cache: cache, archive: archive, db: db}
+}
+
+func (job *CompactArchivesJob) Run() {
+	names, err := ListArchivedFeeds(job.conf)
+	if err != nil {
+		log.WithError(err).Warn("error listing archived feeds")
+		return
+	}
+
+	for _, name := range names {
+		if err := CompactArchives(job.conf, name, YearlyArchives); err != nil {
+			job.conf.feedLog(name).WithError(err).Warn("error compacting archive feeds")
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/gorilla/feeds"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	jsonFeedVersion = "https://jsonfeed.org/version/1"
+)
+
+// RenderJSONFeed renders the twts of twter as a JSON Feed (jsonfeed.org)
+// with one item per twt identified by its hash, with its content formatted as
+// HTML (see FormatTwtFactory) and published at the time the twt was created.
+func RenderJSONFeed(conf *Config, twter types.Twter, twts types.Twts) ([]byte, error) {
+	formatTwt := FormatTwtFactory(conf)
+
+	feed := &feeds.JSONFeed{
+		Version:     jsonFeedVersion,
+		Title:       fmt.Sprintf("%s Twtxt JSON Feed", twter.Nick),
+		HomePageUrl: twter.URL,
+		Description: twter.Tagline,
+		Icon:        twter.Avatar,
+		Author:      &feeds.JSONAuthor{Name: twter.Nick, Url: twter.URL, Avatar: twter.Avatar},
+	}
+
+	for _, twt := range twts {
+		created := twt.Created
+		feed.Items = append(feed.Items, &feeds.JSONItem{
+			Id:            twt.Hash(),
+			Url:           URLForTwt(conf.BaseURL, twt.Hash()),
+			ContentHTML:   string(formatTwt(TwtDisplayText(twt))),
+			PublishedDate: &created,
+			Author: &feeds.JSONAuthor{
+				Name:   twt.Twter.Nick,
+				Url:    twt.Twter.URL,
+				Avatar: twt.Twter.Avatar,
+			},
+			Tags: twt.Tags(),
+		})
+	}
+
+	data, err := feed.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(data), nil
+}
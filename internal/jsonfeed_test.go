@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestRenderJSONFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := NewConfig()
+	require.NoError(t, WithBaseURL(DefaultBaseURL)(conf))
+
+	twter := types.Twter{Nick: "alice", URL: URLForUser(conf, "alice")}
+	twt := types.Twt{
+		Twter:   twter,
+		Text:    "Hello **World**!",
+		Created: time.Date(2020, 7, 18, 12, 39, 6, 0, time.UTC),
+	}
+
+	data, err := RenderJSONFeed(conf, twter, types.Twts{twt})
+	require.NoError(t, err)
+
+	var feed struct {
+		Version string `json:"version"`
+		Title   string `json:"title"`
+		Items   []struct {
+			ID            string    `json:"id"`
+			URL           string    `json:"url"`
+			ContentHTML   string    `json:"content_html"`
+			DatePublished time.Time `json:"date_published"`
+		} `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(data, &feed))
+
+	assert.Equal("https://jsonfeed.org/version/1", feed.Version)
+	assert.Equal("alice Twtxt JSON Feed", feed.Title)
+	require.Len(t, feed.Items, 1)
+	assert.Equal(twt.Hash(), feed.Items[0].ID)
+	assert.Equal(URLForTwt(conf.BaseURL, twt.Hash()), feed.Items[0].URL)
+	assert.Contains(feed.Items[0].ContentHTML, "<strong>World</strong>")
+	assert.True(twt.Created.Equal(feed.Items[0].DatePublished))
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prologic/twtxt/types"
+)
+
+// LanguageDetector detects the language of a twt's text returning a
+// language code (e.g: "en") or the empty string if the language cannot be
+// determined.
+type LanguageDetector func(text string) string
+
+const (
+	// feedLanguageSample is the number of most recent twts sampled by
+	// FeedLanguage
+	feedLanguageSample = 50
+)
+
+var (
+	langDetectorMu sync.RWMutex
+	langDetector   LanguageDetector
+)
+
+// SetLanguageDetector sets the LanguageDetector used for twts without an
+// explicit `lang:xx` token, nil (the default) disables detection so only
+// explicit tokens are used.
+func SetLanguageDetector(fn LanguageDetector) {
+	langDetectorMu.Lock()
+	defer langDetectorMu.Unlock()
+
+	langDetector = fn
+}
+
+// parseLang returns the language of a twt's text from an explicit `lang:xx`
+// token falling back to the configured LanguageDetector (if any).
+func parseLang(text string) string {
+	if lang := types.ParseLang(text); lang != "" {
+		return lang
+	}
+
+	langDetectorMu.RLock()
+	detect := langDetector
+	langDetectorMu.RUnlock()
+
+	if detect == nil {
+		return ""
+	}
+	return detect(text)
+}
+
+// FilterByLang returns the twts whose language matches lang (see
+// types.MatchLang), twts of unknown language are excluded.
+func FilterByLang(twts types.Twts, lang string) types.Twts {
+	var filtered types.Twts
+	for _, twt := range twts {
+		if types.MatchLang(twt.Lang, lang) {
+			filtered = append(filtered, twt)
+		}
+	}
+	return filtered
+}
+
+// FeedLanguage returns the predominant language of the named local feed,
+// e.g: to group feeds by language in a directory, along with its confidence,
+// the fraction of twts sampled in that language. Only the most recent twts
+// are sampled, each by its explicit `lang:xx` token or the LanguageDetector
+// (if any). Regions are ignored so "en" and "en-gb" count as the same
+// language. The empty string is returned if no language could be determined.
+func FeedLanguage(conf *Config, name string) (string, float64, error) {
+	twts, err := GetLastNTwts(conf, name, feedLanguageSample)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(twts) == 0 {
+		return "", 0, nil
+	}
+
+	counts := make(map[string]int)
+	for _, twt := range twts {
+		if twt.Lang == "" {
+			continue
+		}
+		counts[strings.SplitN(twt.Lang, "-", 2)[0]]++
+	}
+
+	var (
+		lang string
+		max  int
+	)
+	for l, count := range counts {
+		if count > max || (count == max && l < lang) {
+			lang, max = l, count
+		}
+	}
+
+	return lang, float64(max) / float64(len(twts)), nil
+}
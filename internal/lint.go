@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	// maxLintLineLength is the length (in characters) above which a feed
+	// line is considered overly long by LintFeed
+	maxLintLineLength = 1024
+)
+
+// LintWarning is an advisory warning about a non-spec-compliant construct
+// found on a line of a feed.
+type LintWarning struct {
+	Line    int
+	Message string
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("line %d: %s", w.Line, w.Message)
+}
+
+// LintFeed checks a feed for constructs that aren't compliant with the twtxt
+// spec or that hurt interoperability with other clients, such as timestamps
+// without a timezone, fields separated by spaces instead of a tab, overly
+// long lines and duplicate timestamps. Unlike ParseFile invalid lines are
+// reported rather than dropped.
+func LintFeed(r io.Reader) []LintWarning {
+	var warnings []LintWarning
+
+	warn := func(line int, format string, args ...interface{}) {
+		warnings = append(warnings, LintWarning{Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	seen := make(map[time.Time]int)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if l := utf8.RuneCountInString(line); l > maxLintLineLength {
+			warn(n, "line is overly long (%d > %d characters)", l, maxLintLineLength)
+		}
+
+		twt, err := ParseLine(line, types.Twter{})
+		if err != nil {
+			if parts := twtLineRe.FindStringSubmatch(line); len(parts) == 4 {
+				warn(n, "invalid timestamp %q", strings.TrimSpace(parts[1]))
+			} else {
+				warn(n, "invalid line, expected a timestamp and text separated by a tab")
+			}
+			continue
+		}
+
+		// The line parsed so it splits into a timestamp, separator and text
+		parts := twtLineRe.FindStringSubmatch(line)
+		timestr := strings.TrimSpace(parts[1])
+
+		if parts[2] != "\t" {
+			warn(n, "timestamp and text should be separated by a single tab")
+		}
+
+		if _, hasZone, _ := parseTime(timestr); !hasZone {
+			warn(n, "timestamp %q has no timezone and is assumed to be UTC", timestr)
+		}
+
+		if first, ok := seen[twt.Created]; ok {
+			warn(n, "duplicate timestamp %q (first seen on line %d)", timestr, first)
+		} else {
+			seen[twt.Created] = n
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		warn(n+1, "error reading feed: %s", err)
+	}
+
+	return warnings
+}
@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	feed := strings.Join([]string{
+		"# nick = test",
+		"2020-07-18T12:00:00Z\tHello World!",
+		"2020-07-18T12:01:00\tNo timezone",
+		"2020-07-18T12:02:00Z    Spaces instead of a tab",
+		"2020-07-18T12:00:00Z\tDuplicate timestamp",
+		"not a twt",
+		"2020-07-18T12:03:00Z\t" + strings.Repeat("x", maxLintLineLength),
+		"",
+	}, "\n")
+
+	warnings := LintFeed(strings.NewReader(feed))
+
+	var lines []int
+	for _, warning := range warnings {
+		lines = append(lines, warning.Line)
+	}
+	assert.Equal([]int{3, 4, 5, 6, 7}, lines)
+
+	assert.Empty(LintFeed(strings.NewReader("2020-07-18T12:00:00Z\tHello World!\n")))
+
+	// Lines are linted as they are parsed, e.g: fractional seconds with a
+	// comma and timezones without a colon are valid
+	assert.Empty(LintFeed(strings.NewReader("2020-07-18T12:00:00,5+0100\tHello World!\n")))
+}
@@ -2,7 +2,6 @@ package internal
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -160,27 +159,14 @@ func (s *Server) AddUserHandler() httprouter.Handle {
 			return
 		}
 
-		p := filepath.Join(s.config.Data, feedsDir)
-		if err := os.MkdirAll(p, 0755); err != nil {
-			log.WithError(err).Error("error creating feeds directory")
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		fn := filepath.Join(p, username)
-		if _, err := os.Stat(fn); err == nil {
+		store := s.config.FeedStore()
+		if _, err := store.Stat(username); err == nil {
 			ctx.Error = true
 			ctx.Message = "Deleted user with that username already exists! Please pick another!"
 			s.render("error", w, ctx)
 			return
 		}
 
-		if err := ioutil.WriteFile(fn, []byte{}, 0644); err != nil {
-			log.WithError(err).Error("error creating new user feed")
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
 		hash, err := s.pm.CreatePassword(password)
 		if err != nil {
 			log.WithError(err).Error("error creating password hash")
@@ -197,6 +183,12 @@ func (s *Server) AddUserHandler() httprouter.Handle {
 		user.URL = URLForUser(s.config, username)
 		user.CreatedAt = time.Now()
 
+		if err := EnsureUserFeed(s.config, s.db, user); err != nil {
+			log.WithError(err).Error("error creating new user feed")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		if err := s.db.SetUser(username, user); err != nil {
 			log.WithError(err).Error("error saving user object for new user")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -308,9 +300,8 @@ func (s *Server) DelUserHandler() httprouter.Handle {
 				}
 
 				// Delete feeds's twtxt.txt
-				fn := filepath.Join(s.config.Data, feedsDir, nick)
-				if FileExists(fn) {
-					if err := os.Remove(fn); err != nil {
+				if err := s.config.FeedStore().Remove(nick); err != nil {
+					if !os.IsNotExist(err) {
 						log.WithError(err).Error("error removing feed")
 						ctx.Error = true
 						ctx.Message = "An error occured whilst deleting your account"
@@ -379,9 +370,8 @@ func (s *Server) DelUserHandler() httprouter.Handle {
 		}
 
 		// Delete user's twtxt.txt
-		fn := filepath.Join(s.config.Data, feedsDir, user.Username)
-		if FileExists(fn) {
-			if err := os.Remove(fn); err != nil {
+		if err := s.config.FeedStore().Remove(user.Username); err != nil {
+			if !os.IsNotExist(err) {
 				log.WithError(err).Error("error removing user's feed")
 				ctx.Error = true
 				ctx.Message = "An error occured whilst deleting your account"
@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	// minRefreshInterval and maxRefreshInterval bound the refresh interval a
+	// feed may declare, defaultRefreshInterval is used for feeds that do not
+	// declare one (no throttling, polled on every update of the feed cache)
+	minRefreshInterval     = 5 * time.Minute
+	maxRefreshInterval     = 24 * time.Hour
+	defaultRefreshInterval = 0
+)
+
+var (
+	ErrFeedURLNotDeclared = errors.New("error: feed does not declare its url")
+	ErrInvalidFeedURL     = errors.New("error: invalid feed url")
+
+	metadataRe = regexp.MustCompile(`^#\s*([a-zA-Z0-9_-]+)\s*=\s*(.*?)\s*$`)
+
+	// attributionRe matches the author attribution of a twt republished by an
+	// aggregator feed, a leading mention of the author followed by a colon
+	attributionRe = regexp.MustCompile(`^@<([^ >]+) ([^>]+)>:\s+(.+)$`)
+)
+
+// FeedMetadata is the metadata a feed declares about itself in comment lines
+// of the form `# key = value`, typically at the top of a twtxt.txt feed.
+type FeedMetadata struct {
+	Nick        string
+	URL         string
+	URLs        []string
+	Description string
+	Avatar      string
+	Signature   string
+	Refresh     string
+	Authors     []types.Twter
+	Follows     []FeedRef
+}
+
+// ParseMetadataLine parses a single `# key = value` metadata line returning
+// the lowercased key and value. ok is false if the line is not metadata.
+func ParseMetadataLine(line string) (key, value string, ok bool) {
+	match := metadataRe.FindStringSubmatch(line)
+	if match == nil {
+		return "", "", false
+	}
+	return strings.ToLower(match[1]), match[2], true
+}
+
+// ParseFeedMetadata reads all metadata lines from a feed. Where a key is
+// declared more than once the first value wins, except for the url where
+// all declared urls (e.g: of mirrors) are also kept in URLs, authors
+// (`# author = nick url`) of which an aggregator feed may declare many and
+// the feeds it follows (`# follow = nick url`).
+func ParseFeedMetadata(r io.Reader) (*FeedMetadata, error) {
+	meta := &FeedMetadata{}
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := ParseMetadataLine(scanner.Text())
+		if ok && key == "url" && value != "" {
+			meta.URLs = append(meta.URLs, value)
+		}
+		if ok && key == "author" {
+			if author, valid := parseFeedRef(value); valid {
+				meta.Authors = append(meta.Authors, types.Twter{Nick: author.Nick, URL: author.URL})
+			}
+			continue
+		}
+		if ok && key == "follow" {
+			if follow, valid := parseFeedRef(value); valid {
+				meta.Follows = append(meta.Follows, follow)
+			}
+			continue
+		}
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		switch key {
+		case "nick":
+			meta.Nick = value
+		case "url":
+			meta.URL = value
+		case "description":
+			meta.Description = value
+		case "avatar":
+			// Only accept absolute http(s) avatar URLs
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				seen[key] = false
+				continue
+			}
+			meta.Avatar = value
+		case "sig":
+			meta.Signature = value
+		case "refresh":
+			meta.Refresh = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// RefreshInterval returns how often the feed asks to be polled by its
+// `# refresh = ...` metadata, either a number of seconds or a duration such
+// as 30m or 2h, clamped between minRefreshInterval and maxRefreshInterval.
+// Feeds declaring no (or an invalid) refresh interval are polled on every
+// update of the feed cache (defaultRefreshInterval).
+func RefreshInterval(meta FeedMetadata) time.Duration {
+	value := strings.TrimSpace(meta.Refresh)
+	if value == "" {
+		return defaultRefreshInterval
+	}
+
+	var interval time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		interval = time.Duration(seconds) * time.Second
+	} else if d, err := time.ParseDuration(value); err == nil {
+		interval = d
+	} else {
+		return defaultRefreshInterval
+	}
+
+	switch {
+	case interval <= 0:
+		return defaultRefreshInterval
+	case interval < minRefreshInterval:
+		return minRefreshInterval
+	case interval > maxRefreshInterval:
+		return maxRefreshInterval
+	}
+	return interval
+}
+
+// parseFeedRef parses the `nick url` value of an `# author` or `# follow`
+// metadata line, only absolute http(s) urls are accepted.
+func parseFeedRef(value string) (FeedRef, bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return FeedRef{}, false
+	}
+	u, err := url.Parse(fields[1])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return FeedRef{}, false
+	}
+	return FeedRef{Nick: fields[0], URL: fields[1]}, true
+}
+
+// AttributeTwt attributes a twt republished by an aggregator feed to its
+// real author. Only twts starting with an attribution of the form
+// `@<nick url>: text` where url is one of the authors the feed declares in
+// its metadata (see ParseFeedMetadata) are attributed, so a feed can never
+// attribute twts to anyone it has not declared. Attributed twts keep their
+// hash (see types.Twt.AttributedTo), other twts are returned unchanged.
+func AttributeTwt(twt types.Twt, authors []types.Twter) types.Twt {
+	if len(authors) == 0 {
+		return twt
+	}
+
+	match := attributionRe.FindStringSubmatch(twt.Text)
+	if match == nil {
+		return twt
+	}
+
+	for _, author := range authors {
+		if NormalizeURL(author.URL) == NormalizeURL(match[2]) {
+			return twt.AttributedTo(author, match[3])
+		}
+	}
+
+	return twt
+}
+
+// ParseAttributedLine parses a feed line like ParseLine attributing the twt
+// to its real author if the feed declares authors (see AttributeTwt).
+func ParseAttributedLine(line string, twter types.Twter, authors []types.Twter) (types.Twt, error) {
+	twt, err := ParseLine(line, twter)
+	if err != nil || twt.IsZero() {
+		return twt, err
+	}
+	return AttributeTwt(twt, authors), nil
+}
+
+// VerifyFeedSelfURL returns true if the feed fetched from fetchedURL declares
+// that url (normalized, see NormalizeURL) as one of its own urls, false if it
+// declares other urls only. A mismatch is not necessarily impersonation (e.g:
+// a mirror) so callers should surface it rather than reject the feed. Feeds
+// that declare no url return ErrFeedURLNotDeclared.
+func VerifyFeedSelfURL(fetchedURL string, meta FeedMetadata) (bool, error) {
+	urls := meta.URLs
+	if len(urls) == 0 && meta.URL != "" {
+		urls = []string{meta.URL}
+	}
+	if len(urls) == 0 {
+		return false, ErrFeedURLNotDeclared
+	}
+
+	fetched := NormalizeURL(fetchedURL)
+	if fetched == "" {
+		return false, ErrInvalidFeedURL
+	}
+
+	for _, url := range urls {
+		if NormalizeURL(url) == fetched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
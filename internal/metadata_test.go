@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestVerifyFeedSelfURL(t *testing.T) {
+	assert := assert.New(t)
+
+	meta, err := ParseFeedMetadata(strings.NewReader(
+		"# nick = alice\n" +
+			"# url = https://example.com/twtxt.txt\n" +
+			"# url = https://mirror.example.org/alice/twtxt.txt\n" +
+			"2020-07-18T12:39:06Z\tHello World!\n",
+	))
+	require.NoError(t, err)
+	assert.Equal("https://example.com/twtxt.txt", meta.URL)
+	assert.Len(meta.URLs, 2)
+
+	for _, fetchedURL := range []string{
+		"https://example.com/twtxt.txt",
+		"https://EXAMPLE.com:443/twtxt.txt",
+		"https://mirror.example.org/alice/twtxt.txt",
+	} {
+		ok, err := VerifyFeedSelfURL(fetchedURL, *meta)
+		assert.NoError(err)
+		assert.True(ok, fetchedURL)
+	}
+
+	ok, err := VerifyFeedSelfURL("https://impostor.example.net/twtxt.txt", *meta)
+	assert.NoError(err)
+	assert.False(ok)
+
+	_, err = VerifyFeedSelfURL("https://example.com/twtxt.txt", FeedMetadata{Nick: "alice"})
+	assert.Equal(ErrFeedURLNotDeclared, err)
+
+	// Declared url set without parsing
+	ok, err = VerifyFeedSelfURL("https://example.com/twtxt.txt", FeedMetadata{URL: "https://example.com/twtxt.txt"})
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func TestAttributeTwt(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "aggregator.txt"))
+	require.NoError(t, err)
+
+	meta, err := ParseFeedMetadata(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	alice := types.Twter{Nick: "alice", URL: "https://alice.example.com/twtxt.txt"}
+	bob := types.Twter{Nick: "bob", URL: "https://bob.example.com/twtxt.txt"}
+	assert.Equal([]types.Twter{alice, bob}, meta.Authors)
+
+	planet := types.Twter{Nick: "planet", URL: "https://planet.example.com/twtxt.txt"}
+
+	var twts types.Twts
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		twt, err := ParseAttributedLine(scanner.Text(), planet, meta.Authors)
+		require.NoError(t, err)
+		if !twt.IsZero() {
+			twts = append(twts, twt)
+		}
+	}
+	require.Len(t, twts, 5)
+
+	assert.Equal(alice, twts[0].Twter)
+	assert.Equal("Hello from Alice", twts[0].Text)
+	assert.Equal(bob, twts[1].Twter)
+	assert.Equal("Hello from Bob", twts[1].Text)
+
+	// Undeclared authors, replies and the feed's own twts are not attributed
+	for _, twt := range twts[2:] {
+		assert.Equal(planet, twt.Twter)
+	}
+
+	// Attributed twts keep their hash as published in the aggregator feed
+	original, err := ParseLine("2021-01-01T00:00:00Z\t@<alice https://alice.example.com/twtxt.txt>: Hello from Alice", planet)
+	require.NoError(t, err)
+	assert.Equal(original.Hash(), twts[0].Hash())
+
+	// Without declared authors nothing is attributed
+	assert.Equal(original, AttributeTwt(original, nil))
+}
+
+func TestRefreshInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	meta, err := ParseFeedMetadata(strings.NewReader(
+		"# nick = alice\n" +
+			"# refresh = 3600\n" +
+			"2020-07-18T12:39:06Z\tHello World!\n",
+	))
+	require.NoError(t, err)
+	assert.Equal("3600", meta.Refresh)
+	assert.Equal(time.Hour, RefreshInterval(*meta))
+
+	testCases := []struct {
+		refresh  string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"30m", 30 * time.Minute},
+		{" 2h ", 2 * time.Hour},
+		{"10", minRefreshInterval},
+		{"1s", minRefreshInterval},
+		{"720h", maxRefreshInterval},
+		{"999999999", maxRefreshInterval},
+		{"0", defaultRefreshInterval},
+		{"-60", defaultRefreshInterval},
+		{"hourly", defaultRefreshInterval},
+	}
+
+	for _, testCase := range testCases {
+		actual := RefreshInterval(FeedMetadata{Refresh: testCase.refresh})
+		assert.Equal(testCase.expected, actual, testCase.refresh)
+	}
+}
+
+func TestParseFeedMetadataAvatar(t *testing.T) {
+	assert := assert.New(t)
+
+	testCases := []struct {
+		avatar   string
+		expected string
+	}{
+		{"https://example.com/avatar.png", "https://example.com/avatar.png"},
+		{"http://example.com/avatar.png", "http://example.com/avatar.png"},
+		{"/avatar.png", ""},
+		{"avatar.png", ""},
+		{"ftp://example.com/avatar.png", ""},
+		{"javascript:alert(1)", ""},
+		{"https:///avatar.png", ""},
+	}
+
+	for _, testCase := range testCases {
+		meta, err := ParseFeedMetadata(strings.NewReader(
+			"# nick = alice\n" +
+				"# avatar = " + testCase.avatar + "\n" +
+				"2020-07-18T12:39:06Z\tHello World!\n",
+		))
+		require.NoError(t, err)
+		assert.Equal(testCase.expected, meta.Avatar, testCase.avatar)
+	}
+}
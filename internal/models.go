@@ -4,10 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -34,6 +31,7 @@ type Feed struct {
 	CreatedAt   time.Time
 
 	Followers map[string]string `default:"{}"`
+	FeedToken string
 
 	remotes map[string]string
 }
@@ -43,6 +41,7 @@ type User struct {
 	Username  string
 	Password  string
 	Tagline   string
+	Signature string
 	Email     string // DEPRECATED: In favor of storing a Hashed Email
 	URL       string
 	CreatedAt time.Time
@@ -50,16 +49,23 @@ type User struct {
 	Theme                      string `default:"auto"`
 	Recovery                   string `default:"auto"`
 	DisplayDatesInTimezone     string `default:"UTC"`
+	DisplayDatesInFormat       string `default:""`
 	IsFollowersPubliclyVisible bool   `default:"true"`
 	IsFollowingPubliclyVisible bool   `default:"true"`
 
-	Feeds  []string `default:"[]"`
-	Tokens []string `default:"[]"`
+	Feeds     []string `default:"[]"`
+	Tokens    []string `default:"[]"`
+	Bookmarks []string `default:"[]"`
+	FeedToken string
 
 	Followers map[string]string `default:"{}"`
 	Following map[string]string `default:"{}"`
 	Muted     map[string]string `default:"{}"`
 
+	ReadCursors map[string]ReadCursor   `default:"{}"`
+	Drafts      map[string]Draft        `default:"{}"`
+	Scheduled   map[string]ScheduledTwt `default:"{}"`
+
 	muted   map[string]string
 	remotes map[string]string
 	sources map[string]string
@@ -102,15 +108,15 @@ func CreateFeed(conf *Config, db Store, user *User, name string, force bool) err
 		}
 	}
 
-	fn := filepath.Join(conf.Data, feedsDir, name)
-	stat, err := os.Stat(fn)
+	store := conf.FeedStore()
+	stat, err := store.Stat(name)
 
 	if err == nil && !force {
 		return ErrFeedAlreadyExists
 	}
 
 	if stat == nil {
-		if err := ioutil.WriteFile(fn, []byte{}, 0644); err != nil {
+		if err := store.Write(name, []byte{}); err != nil {
 			return err
 		}
 	}
@@ -457,6 +463,8 @@ func (u *User) Profile(baseURL string, viewer *User) types.Profile {
 		URL:      u.URL,
 		BlogsURL: URLForBlogs(baseURL, u.Username),
 
+		DateFormat: u.DisplayDatesInFormat,
+
 		Follows:    follows,
 		FollowedBy: followedBy,
 		Muted:      muted,
@@ -509,6 +517,12 @@ func (u *User) Reply(twt types.Twt) string {
 	return fmt.Sprintf("%s ", strings.Join(mentions, " "))
 }
 
+// HasBookmark returns true if the user has bookmarked the twt with the given
+// hash (see AddBookmark)
+func (u *User) HasBookmark(hash string) bool {
+	return HasString(u.Bookmarks, hash)
+}
+
 func (u *User) Bytes() ([]byte, error) {
 	data, err := json.Marshal(u)
 	if err != nil {
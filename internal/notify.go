@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prologic/twtxt/internal/webmention"
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	// pingKeyPath is the path the public key verifying our signed mention
+	// pings is published at (see NotifyMentioned)
+	pingKeyPath = "/.well-known/twtxt-ping-key"
+
+	// PingTimestampHeader and PingSignatureHeader are the headers of signed
+	// mention pings (see NotifyMentioned)
+	PingTimestampHeader = "X-Twtxt-Ping-Timestamp"
+	PingSignatureHeader = "X-Twtxt-Ping-Signature"
+
+	// maxPingSkew is how old (or far in the future) the timestamp of a signed
+	// mention ping may be for it to verify
+	maxPingSkew = 5 * time.Minute
+)
+
+var (
+	ErrInvalidPingSigningKey = errors.New("error: invalid ping signing key (expected a base64 encoded ed25519 seed)")
+	ErrInvalidPingSignature  = errors.New("error: invalid mention ping signature")
+
+	// notifyMentionedTimeout bounds each request made by NotifyMentioned
+	notifyMentionedTimeout = 10 * time.Second
+
+	podFeedPathRe = regexp.MustCompile(`^/user/[^/]+/twtxt\.txt$`)
+
+	appendHooksMu sync.RWMutex
+	appendHooksID int
+	appendHooks   []appendHookEntry
+)
+
+// AppendHook is called (asynchronously) with each twt appended to a local
+// feed by AppendTwt or AppendTwts, or edited by EditTwt, once it has been
+// written.
+type AppendHook func(conf *Config, twt types.Twt)
+
+type appendHookEntry struct {
+	id   int
+	hook AppendHook
+}
+
+// AddAppendHook registers a hook to be called for every twt appended and
+// returns a func removing it again (e.g: for tests).
+func AddAppendHook(hook AppendHook) func() {
+	appendHooksMu.Lock()
+	defer appendHooksMu.Unlock()
+
+	appendHooksID++
+	id := appendHooksID
+	appendHooks = append(appendHooks, appendHookEntry{id: id, hook: hook})
+
+	return func() { removeAppendHook(id) }
+}
+
+func removeAppendHook(id int) {
+	appendHooksMu.Lock()
+	defer appendHooksMu.Unlock()
+
+	for i, entry := range appendHooks {
+		if entry.id == id {
+			appendHooks = append(appendHooks[:i:i], appendHooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// runAppendHooks runs all registered hooks for each of the twts in the
+// background so hooks never block (or fail) the write.
+func runAppendHooks(conf *Config, twts ...types.Twt) {
+	appendHooksMu.RLock()
+	hooks := append([]appendHookEntry{}, appendHooks...)
+	appendHooksMu.RUnlock()
+
+	for _, entry := range hooks {
+		for _, twt := range twts {
+			go entry.hook(conf, twt)
+		}
+	}
+}
+
+// NotifyMentionedHook is an AppendHook notifying every remote feed mentioned
+// by the twt (see NotifyMentioned).
+func NotifyMentionedHook(conf *Config, twt types.Twt) {
+	for _, mentioned := range twt.Mentions() {
+		NotifyMentioned(conf, mentioned, twt)
+	}
+}
+
+// NotifyMentioned makes a best-effort attempt to notify the pod of a remote
+// feed mentioned by source, sending a webmention to the endpoint advertised
+// by the mentioned feed (a `Link` header or HTML link with rel="webmention")
+// or, for feeds hosted on twtxt pods, the feed's /user/<nick>/webmention
+// endpoint. Mentions of local feeds are never pinged. Failures are logged
+// only.
+//
+// If conf.PingSigningKey is set pings are signed so receivers can verify they
+// came from this pod, receivers unaware of signatures simply ignore them. A
+// signed ping carries two extra headers:
+//
+//	X-Twtxt-Ping-Timestamp: <unix time the ping was sent>
+//	X-Twtxt-Ping-Signature: <base64 ed25519 signature>
+//
+// The signature is over "<source>\n<target>\n<timestamp>" and is verified
+// with the base64 encoded public key served (as text/plain) at
+// /.well-known/twtxt-ping-key by the pod of the source url, e.g: using
+// VerifyMentionPing which also rejects pings older than a few minutes.
+func NotifyMentioned(conf *Config, mentioned types.Twter, source types.Twt) {
+	isLocalURL := IsLocalURLFactory(conf)
+	isExternalFeed := IsExternalFeedFactory(conf)
+	if isLocalURL(mentioned.URL) && !isExternalFeed(mentioned.URL) {
+		return
+	}
+
+	target, err := url.Parse(mentioned.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return
+	}
+
+	client := &http.Client{Timeout: notifyMentionedTimeout}
+
+	endpoint, err := mentionEndpoint(client, target)
+	if err != nil {
+		log.WithError(err).Warnf("error discovering mention endpoint of %s", mentioned.URL)
+		return
+	}
+	if endpoint == nil {
+		log.Debugf("no mention endpoint found for %s", mentioned.URL)
+		return
+	}
+
+	values := make(url.Values)
+	values.Set("source", URLForTwt(conf.BaseURL, source.Hash()))
+	values.Set("target", mentioned.URL)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.String(), strings.NewReader(values.Encode()))
+	if err != nil {
+		log.WithError(err).Warnf("error notifying %s of mention", mentioned.URL)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if conf.PingSigningKey != "" {
+		key, err := parsePingSigningKey(conf.PingSigningKey)
+		if err != nil {
+			log.WithError(err).Warn("error signing mention ping, sending it unsigned")
+		} else {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set(PingTimestampHeader, timestamp)
+			req.Header.Set(PingSignatureHeader, SignMentionPing(key, values.Get("source"), values.Get("target"), timestamp))
+		}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		log.WithError(err).Warnf("error notifying %s of mention", mentioned.URL)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		log.Warnf("error notifying %s of mention: %s", mentioned.URL, res.Status)
+		return
+	}
+
+	log.Infof("notified %s of mention by %s", mentioned.URL, source.Hash())
+}
+
+// mentionEndpoint discovers the webmention endpoint of target (see
+// webmention.DiscoverEndpoint) falling back to the pod endpoint for feeds
+// hosted on twtxt pods
+func mentionEndpoint(client *http.Client, target *url.URL) (*url.URL, error) {
+	endpoint, err := webmention.DiscoverEndpoint(client, target)
+	if err != nil || endpoint != nil {
+		return endpoint, err
+	}
+
+	if podFeedPathRe.MatchString(target.Path) {
+		endpoint := *target
+		endpoint.Path = strings.TrimSuffix(target.Path, "/twtxt.txt") + "/webmention"
+		endpoint.RawQuery = ""
+		endpoint.Fragment = ""
+		return &endpoint, nil
+	}
+
+	return nil, nil
+}
+
+// parsePingSigningKey parses a base64 encoded ed25519 seed
+func parsePingSigningKey(key string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrInvalidPingSigningKey
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func pingMessage(source, target, timestamp string) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s", source, target, timestamp))
+}
+
+// SignMentionPing returns the base64 encoded signature of a mention ping of
+// target by source sent at timestamp (unix time) as sent by NotifyMentioned
+func SignMentionPing(key ed25519.PrivateKey, source, target, timestamp string) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, pingMessage(source, target, timestamp)))
+}
+
+// VerifyMentionPing verifies the signature of a mention ping of target by
+// source sent at timestamp (the X-Twtxt-Ping-* headers) with the public key
+// of the source's pod, returning ErrInvalidPingSignature if the signature is
+// invalid or the timestamp is not recent.
+func VerifyMentionPing(key ed25519.PublicKey, source, target, timestamp, signature string) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidPingSignature
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > maxPingSkew || skew < -maxPingSkew {
+		return ErrInvalidPingSignature
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return ErrInvalidPingSignature
+	}
+
+	if !ed25519.Verify(key, pingMessage(source, target, timestamp), sig) {
+		return ErrInvalidPingSignature
+	}
+
+	return nil
+}
+
+// PingKeyHandler serves the (base64 encoded) public key verifying the mention
+// pings signed by this pod, if any
+func (s *Server) PingKeyHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if s.config.PingSigningKey == "" {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+
+		key, err := parsePingSigningKey(s.config.PingSigningKey)
+		if err != nil {
+			log.WithError(err).Error("error parsing ping signing key")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	}
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestNotifyMentioned(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	mentions := make(chan [2]string, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/bob/webmention", func(w http.ResponseWriter, r *http.Request) {
+		mentions <- [2]string{r.FormValue("source"), r.FormValue("target")}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/user/bob/twtxt.txt", func(w http.ResponseWriter, r *http.Request) {})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	bob := types.Twter{Nick: "bob", URL: ts.URL + "/user/bob/twtxt.txt"}
+	user := &User{
+		Username:  "alice",
+		URL:       URLForUser(conf, "alice"),
+		Following: map[string]string{"bob": bob.URL},
+	}
+
+	hooked := make(chan types.Twt, 1)
+	removeHook := AddAppendHook(func(hookConf *Config, twt types.Twt) {
+		if hookConf == conf {
+			hooked <- twt
+		}
+	})
+	defer removeHook()
+
+	twt, err := AppendTwt(conf, nil, user, "Hello @bob")
+	require.NoError(t, err)
+
+	select {
+	case hookedTwt := <-hooked:
+		assert.Equal(twt.Hash(), hookedTwt.Hash())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for append hook")
+	}
+
+	// Edits are hooked too
+	edited, err := EditTwt(conf, nil, user, twt.Hash(), "Hello again @bob")
+	require.NoError(t, err)
+
+	select {
+	case hookedTwt := <-hooked:
+		assert.Equal(edited.Hash(), hookedTwt.Hash())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for append hook of edit")
+	}
+
+	NotifyMentioned(conf, bob, twt)
+
+	select {
+	case mention := <-mentions:
+		assert.Equal(URLForTwt(conf.BaseURL, twt.Hash()), mention[0])
+		assert.Equal(bob.URL, mention[1])
+	default:
+		t.Fatal("mentioned feed was not notified")
+	}
+
+	// Endpoints advertised by HTML documents are discovered
+	mux.HandleFunc("/~bob", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head><link rel="webmention" href="/user/bob/webmention"></head></html>`)
+	})
+	NotifyMentioned(conf, types.Twter{Nick: "bob", URL: ts.URL + "/~bob"}, twt)
+
+	select {
+	case mention := <-mentions:
+		assert.Equal(ts.URL+"/~bob", mention[1])
+	default:
+		t.Fatal("mentioned page was not notified")
+	}
+
+	// Local feeds are never pinged
+	NotifyMentioned(conf, types.Twter{Nick: "carol", URL: URLForUser(conf, "carol")}, twt)
+	assert.Empty(mentions)
+}
+
+func TestNotifyMentionedSigned(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	assert.Equal(ErrInvalidPingSigningKey, WithPingSigningKey("invalid")(conf))
+
+	seed := make([]byte, ed25519.SeedSize)
+	require.NoError(t, WithPingSigningKey(base64.StdEncoding.EncodeToString(seed))(conf))
+	public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+
+	pings := make(chan *http.Request, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/bob/webmention", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		pings <- r
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/user/bob/twtxt.txt", func(w http.ResponseWriter, r *http.Request) {})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	bob := types.Twter{Nick: "bob", URL: ts.URL + "/user/bob/twtxt.txt"}
+	twt := types.Twt{
+		Twter:   types.Twter{Nick: "alice", URL: URLForUser(conf, "alice")},
+		Created: time.Now(),
+		Text:    fmt.Sprintf("@<bob %s> Hello", bob.URL),
+	}
+
+	NotifyMentioned(conf, bob, twt)
+
+	var ping *http.Request
+	select {
+	case ping = <-pings:
+	default:
+		t.Fatal("mentioned feed was not notified")
+	}
+
+	source, target := ping.FormValue("source"), ping.FormValue("target")
+	assert.Equal(URLForTwt(conf.BaseURL, twt.Hash()), source)
+	timestamp, signature := ping.Header.Get(PingTimestampHeader), ping.Header.Get(PingSignatureHeader)
+	assert.NoError(VerifyMentionPing(public, source, target, timestamp, signature))
+
+	// Tampered or stale pings are rejected
+	assert.Equal(ErrInvalidPingSignature, VerifyMentionPing(public, source, ts.URL+"/user/eve/twtxt.txt", timestamp, signature))
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	key := ed25519.NewKeyFromSeed(seed)
+	assert.Equal(ErrInvalidPingSignature, VerifyMentionPing(public, source, target, stale, SignMentionPing(key, source, target, stale)))
+
+	// Unsigned pings are sent without the headers
+	conf.PingSigningKey = ""
+	NotifyMentioned(conf, bob, twt)
+	select {
+	case ping = <-pings:
+		assert.Empty(ping.Header.Get(PingSignatureHeader))
+		assert.Equal(source, ping.FormValue("source"))
+	default:
+		t.Fatal("mentioned feed was not notified")
+	}
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	// ogDescriptionLength is the maximum length (in characters) of the
+	// og:description of a twt
+	ogDescriptionLength = 200
+)
+
+var (
+	// ogImageRe matches the url of Markdown images `![alt](URL)` and HTML
+	// <img src="URL"> elements
+	ogImageRe = regexp.MustCompile(`(?i)!\[[^\]]*\]\(([^)\s]+)[^)]*\)|<img\b[^>]*\bsrc="([^"]+)"`)
+
+	// ogNonImageExts are the extensions of media that are not images
+	ogNonImageExts = map[string]bool{
+		".mp3": true, ".ogg": true, ".mp4": true, ".webm": true,
+	}
+)
+
+// OpenGraphMeta returns the Open Graph metadata (og:title, og:description,
+// og:url and og:image) of a twt's permalink for unfurling shared links. The
+// description is the twt's text as plain text truncated on a word boundary
+// and the image is the first image in the twt, or otherwise the author's
+// avatar.
+func OpenGraphMeta(conf *Config, twt types.Twt) map[string]string {
+	isLocalURL := IsLocalURLFactory(conf)
+
+	var (
+		who    string
+		avatar string
+	)
+	if isLocalURL(twt.Twter.URL) {
+		who = fmt.Sprintf("%s@%s", twt.Twter.Nick, conf.baseURL.Hostname())
+		avatar = URLForAvatar(conf, twt.Twter.Nick)
+	} else {
+		who = fmt.Sprintf("@%s", twt.Twter.Nick)
+		avatar = URLForExternalAvatar(conf, twt.Twter.URL)
+	}
+
+	image := avatar
+	for _, match := range ogImageRe.FindAllStringSubmatch(twt.Text, -1) {
+		src := match[1]
+		if src == "" {
+			src = match[2]
+		}
+		if !ogNonImageExts[strings.ToLower(filepath.Ext(src))] {
+			image = src
+			break
+		}
+	}
+
+	text := ogImageRe.ReplaceAllString(types.StripLang(types.StripYarn(twt.Text)), " ")
+	text = FormatMentionsAndTags(conf, text, TextFmt)
+
+	return map[string]string{
+		"og:title":       fmt.Sprintf("Twt #%s by %s", twt.Hash(), who),
+		"og:description": truncateWords(text, ogDescriptionLength),
+		"og:url":         URLForTwt(conf.BaseURL, twt.Hash()),
+		"og:image":       image,
+	}
+}
+
+// truncateWords collapses the whitespace (including Line Separators) in text
+// and truncates it to at most n characters on a word boundary, adding an
+// ellipsis if anything was cut. A single word longer than n is cut short.
+func truncateWords(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+
+	cut := string(runes[:n-1])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+
+	return strings.TrimRight(cut, " .,;:!?") + "…"
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestOpenGraphMeta(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	alice := types.Twter{Nick: "alice", URL: URLForUser(conf, "alice")}
+	twt := types.Twt{Twter: alice, Created: time.Now(), Text: "Hello World! lang:en"}
+
+	og := OpenGraphMeta(conf, twt)
+	assert.Equal("Twt #"+twt.Hash()+" by alice@0.0.0.0", og["og:title"])
+	assert.Equal("Hello World!", og["og:description"])
+	assert.Equal(URLForTwt(conf.BaseURL, twt.Hash()), og["og:url"])
+	assert.Equal(URLForAvatar(conf, "alice"), og["og:image"])
+
+	// The first image (not audio or video) is preferred over the avatar
+	twt.Text = "Listen ![](https://example.com/song.mp3) and look ![cat](https://example.com/cat.png)"
+	og = OpenGraphMeta(conf, twt)
+	assert.Equal("https://example.com/cat.png", og["og:image"])
+	assert.Equal("Listen and look", og["og:description"])
+
+	bob := types.Twter{Nick: "bob", URL: "https://example.com/bob.txt"}
+	og = OpenGraphMeta(conf, types.Twt{Twter: bob, Created: time.Now(), Text: `<img src="https://example.com/dog.jpg"> Woof`})
+	assert.Equal("https://example.com/dog.jpg", og["og:image"])
+	assert.True(strings.HasSuffix(og["og:title"], " by @bob"))
+}
+
+func TestTruncateWords(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("Hello World!", truncateWords("Hello\u2028 World!", 20))
+	assert.Equal("The quick brown…", truncateWords("The quick brown fox, jumps over the lazy dog", 20))
+	assert.Equal("The quick…", truncateWords("The quick, brown fox", 15))
+	assert.Equal("abcdefghi…", truncateWords("abcdefghijklmnopqrstuvwxyz", 10))
+	assert.Equal(strings.Repeat("é", 9)+"…", truncateWords(strings.Repeat("é", 20), 10))
+}
@@ -3,7 +3,10 @@ package internal
 import (
 	"net/url"
 	"regexp"
+	"strings"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -51,6 +54,26 @@ const (
 	// DefaultMaxTwtLength is the default maximum length of posts permitted
 	DefaultMaxTwtLength = 288
 
+	// DefaultMaxMentions is the default maximum number of mentions expanded
+	// in a single twt (0 for no limit)
+	DefaultMaxMentions = 20
+
+	// DefaultPostBurst is the default number of twts a user can post in a
+	// burst before being rate limited (0 for no limit)
+	DefaultPostBurst = 0
+
+	// DefaultPostRate is the default sustained rate of posting once a user's
+	// burst is used up, i.e: one twt per DefaultPostRate
+	DefaultPostRate = time.Minute
+
+	// DefaultSpamThreshold is the default spam score (between 0 and 1) above
+	// which twts are rejected (see SetSpamClassifier)
+	DefaultSpamThreshold = 0.9
+
+	// DefaultSpamTimeout is the default time allowed to classify a twt as spam
+	// before it is assumed not to be
+	DefaultSpamTimeout = 250 * time.Millisecond
+
 	// DefaultMaxCacheTTL is the default maximum cache ttl of twts in memory
 	DefaultMaxCacheTTL = time.Hour * 24 * 10 // 10 days 28 days 28 days 28 days
 
@@ -58,6 +81,21 @@ const (
 	// of twts in memory
 	DefaultMaxCacheItems = DefaultTwtsPerPage * 3 // We get bored after paging thorughh > 3 pages :D
 
+	// DefaultFeedTTL is the default age after which twts in local feeds are
+	// considered old (0 considers all twts fresh)
+	DefaultFeedTTL = time.Duration(0)
+
+	// DefaultLineEnding is the default line ending written to local feeds
+	DefaultLineEnding = "lf"
+
+	// DefaultMentionPrecedence is the default precedence of mentions of a nick
+	// that is both followed and a local user or feed
+	DefaultMentionPrecedence = "following"
+
+	// DefaultTagNamespace is the default namespace of tags expanded in twts,
+	// empty for global tags
+	DefaultTagNamespace = ""
+
 	// DefaultOpenProfiles is the default for whether or not to have open user profiles
 	DefaultOpenProfiles = false
 
@@ -73,6 +111,58 @@ const (
 	// DefaultTranscoderTimeout is the default vodeo transcoding timeout
 	DefaultTranscoderTimeout = 10 * time.Minute // 10mins
 
+	// DefaultStripReplyTargets is the default for whether or not to strip the
+	// leading mentions of who a twt is replying to when displayed
+	DefaultStripReplyTargets = false
+
+	// DefaultEnablePolls is the default for whether or not to parse and
+	// render inline polls (`poll: Question? | opt1 | opt2`) in twts
+	DefaultEnablePolls = false
+
+	// DefaultEmptyEditDeletes is the default for whether or not editing a twt
+	// to empty text deletes it (instead of being rejected)
+	DefaultEmptyEditDeletes = false
+
+	// DefaultMarkEdits is the default for whether or not to mark edited twts
+	// with the time they were edited
+	DefaultMarkEdits = false
+
+	// DefaultEditRedirects is the default for whether or not to redirect
+	// references to the old hash of an edited twt to the edited twt
+	DefaultEditRedirects = false
+
+	// DefaultRenameRedirects is the default for whether or not to redirect
+	// the old profile and feed urls of a renamed feed to the new ones
+	DefaultRenameRedirects = false
+
+	// DefaultUpdateMovedFeeds is the default for whether or not to update
+	// the urls of followed feeds that have permanently moved
+	DefaultUpdateMovedFeeds = false
+
+	// DefaultLookupMentions is the default for whether or not to look up the
+	// feed url of @nick@domain mentions in the remote pod's directory
+	DefaultLookupMentions = false
+
+	// DefaultSlashCommands is the default for whether or not to process
+	// slash commands (e.g: `/me waves`) when posting twts
+	DefaultSlashCommands = false
+
+	// DefaultCleanLinks is the default for whether or not to strip tracking
+	// parameters (see DefaultTrackingParams) from links in twts when displayed
+	DefaultCleanLinks = false
+
+	// DefaultQuarantineSpam is the default for whether or not to save twts
+	// classified as spam as drafts instead of rejecting them outright
+	DefaultQuarantineSpam = false
+
+	// DefaultStrictSeparator is the default for whether or not to require
+	// twts in feeds to separate their timestamp and text with a tab
+	DefaultStrictSeparator = false
+
+	// DefaultStripImportedHTML is the default for whether or not to strip
+	// HTML from the text of imported twts
+	DefaultStripImportedHTML = false
+
 	// DefaultMagicLinkSecret is the jwt magic link secret
 	DefaultMagicLinkSecret = "PLEASE_CHANGE_ME!!!"
 
@@ -91,6 +181,10 @@ const (
 
 	// DefaultAPISigningKey is the default API JWT signing key for tokens
 	DefaultAPISigningKey = "PLEASE_CHANGE_ME!!!"
+
+	// DefaultPingSigningKey is the default key for signing mention pings
+	// (empty to send unsigned pings)
+	DefaultPingSigningKey = ""
 )
 
 var (
@@ -101,6 +195,22 @@ var (
 		"https://raw.githubusercontent.com/mdom/we-are-twtxt/master/we-are-twtxt.txt",
 	}
 
+	// DefaultSystemFeeds is the default list of additional local feeds that
+	// are considered system/bot feeds (the pod's own special feeds and bots
+	// are always considered system feeds)
+	DefaultSystemFeeds = []string{}
+
+	// DefaultReactionMarkers is the default list of markers (besides single
+	// emoji) that make a reply a reaction to the twt replied to
+	DefaultReactionMarkers = []string{"+1"}
+
+	// DefaultTrackingParams is the default list of tracking parameters
+	// stripped from links with CleanLinks, a trailing * matches any suffix
+	DefaultTrackingParams = []string{
+		"utm_*", "fbclid", "gclid", "dclid", "msclkid",
+		"mc_cid", "mc_eid", "igshid", "yclid", "_hsenc", "_hsmi",
+	}
+
 	// DefaultTwtPrompts are the set of default prompts  for twt text(s)
 	DefaultTwtPrompts = []string{
 		`What's on your mind?`,
@@ -137,6 +247,13 @@ func NewConfig() *Config {
 		TwtPrompts:        DefaultTwtPrompts,
 		TwtsPerPage:       DefaultTwtsPerPage,
 		MaxTwtLength:      DefaultMaxTwtLength,
+		MaxMentions:       DefaultMaxMentions,
+		PostBurst:         DefaultPostBurst,
+		PostRate:          DefaultPostRate,
+		SpamThreshold:     DefaultSpamThreshold,
+		SpamTimeout:       DefaultSpamTimeout,
+		ReactionMarkers:   DefaultReactionMarkers,
+		TrackingParams:    DefaultTrackingParams,
 		OpenProfiles:      DefaultOpenProfiles,
 		OpenRegistrations: DefaultOpenRegistrations,
 		SessionExpiry:     DefaultSessionExpiry,
@@ -220,6 +337,141 @@ func WithFeedSources(feedSources []string) Option {
 	}
 }
 
+// WithTrackingParams sets the tracking parameters stripped from links with
+// CleanLinks, a trailing * matches any suffix (e.g: utm_*)
+func WithTrackingParams(trackingParams []string) Option {
+	return func(cfg *Config) error {
+		cfg.TrackingParams = trackingParams
+		return nil
+	}
+}
+
+// WithReactionMarkers sets the markers (besides single emoji) that make a
+// reply a reaction to the twt replied to
+func WithReactionMarkers(reactionMarkers []string) Option {
+	return func(cfg *Config) error {
+		cfg.ReactionMarkers = reactionMarkers
+		return nil
+	}
+}
+
+// WithSystemFeeds sets the additional local feeds considered system/bot feeds
+func WithSystemFeeds(systemFeeds []string) Option {
+	return func(cfg *Config) error {
+		cfg.SystemFeeds = systemFeeds
+		return nil
+	}
+}
+
+// WithEnablePolls sets whether or not to parse and render inline polls in twts
+func WithEnablePolls(enable bool) Option {
+	return func(cfg *Config) error {
+		cfg.EnablePolls = enable
+		return nil
+	}
+}
+
+// WithEditRedirects sets whether or not to redirect references to the old
+// hash of an edited twt to the edited twt (see ResolveHash)
+func WithEditRedirects(editRedirects bool) Option {
+	return func(cfg *Config) error {
+		cfg.EditRedirects = editRedirects
+		return nil
+	}
+}
+
+// WithRenameRedirects sets whether or not to redirect the old profile and
+// feed urls of a renamed feed to the new ones (see RenameFeed)
+func WithRenameRedirects(renameRedirects bool) Option {
+	return func(cfg *Config) error {
+		cfg.RenameRedirects = renameRedirects
+		return nil
+	}
+}
+
+// WithUpdateMovedFeeds sets whether or not to update the urls of followed
+// feeds that have permanently moved (see ResolveFeedRedirect)
+func WithUpdateMovedFeeds(updateMovedFeeds bool) Option {
+	return func(cfg *Config) error {
+		cfg.UpdateMovedFeeds = updateMovedFeeds
+		return nil
+	}
+}
+
+// WithLookupMentions sets whether or not to look up the feed url of
+// @nick@domain mentions in the remote pod's directory (see
+// LookupRemoteMention)
+func WithLookupMentions(lookupMentions bool) Option {
+	return func(cfg *Config) error {
+		cfg.LookupMentions = lookupMentions
+		return nil
+	}
+}
+
+// WithCleanLinks sets whether or not to strip tracking parameters from
+// links in twts when displayed (see CleanTrackingParams)
+func WithCleanLinks(cleanLinks bool) Option {
+	return func(cfg *Config) error {
+		cfg.CleanLinks = cleanLinks
+		return nil
+	}
+}
+
+// WithQuarantineSpam sets whether or not to save twts classified as spam as
+// drafts instead of rejecting them outright
+func WithQuarantineSpam(quarantineSpam bool) Option {
+	return func(cfg *Config) error {
+		cfg.QuarantineSpam = quarantineSpam
+		return nil
+	}
+}
+
+// WithStrictSeparator sets whether or not to require twts in feeds to
+// separate their timestamp and text with a tab (as per the spec) rather than
+// also accepting a run of spaces (see ParseLine)
+func WithStrictSeparator(strictSeparator bool) Option {
+	return func(cfg *Config) error {
+		cfg.StrictSeparator = strictSeparator
+		return nil
+	}
+}
+
+// WithStripImportedHTML sets whether or not to strip HTML erroneously
+// embedded in the text of imported twts (see StripHTML)
+func WithStripImportedHTML(stripImportedHTML bool) Option {
+	return func(cfg *Config) error {
+		cfg.StripImportedHTML = stripImportedHTML
+		return nil
+	}
+}
+
+// WithEmptyEditDeletes sets whether or not editing a twt to empty text
+// deletes it (instead of being rejected)
+func WithEmptyEditDeletes(emptyEditDeletes bool) Option {
+	return func(cfg *Config) error {
+		cfg.EmptyEditDeletes = emptyEditDeletes
+		return nil
+	}
+}
+
+// WithMarkEdits sets whether or not to mark edited twts with the time they
+// were edited, shown as "(edited)" when displayed
+func WithMarkEdits(markEdits bool) Option {
+	return func(cfg *Config) error {
+		cfg.MarkEdits = markEdits
+		return nil
+	}
+}
+
+// WithSlashCommands sets whether or not to process slash commands (e.g:
+// `/me waves`) when posting twts (see RegisterSlashCommand)
+func WithSlashCommands(slashCommands bool) Option {
+	return func(cfg *Config) error {
+		cfg.SlashCommands = slashCommands
+		return nil
+	}
+}
+
 // WithName sets the instance's name
 func WithName(name string) Option {
 	return func(cfg *Config) error {
@@ -276,6 +528,14 @@ func WithMaxTwtLength(maxTwtLength int) Option {
 	}
 }
 
+// WithMaxMentions sets the maximum number of mentions expanded in a single twt
+func WithMaxMentions(maxMentions int) Option {
+	return func(cfg *Config) error {
+		cfg.MaxMentions = maxMentions
+		return nil
+	}
+}
+
 // WithMaxCacheTTL sets the maximum cache ttl of twts in memory
 func WithMaxCacheTTL(maxCacheTTL time.Duration) Option {
 	return func(cfg *Config) error {
@@ -292,6 +552,88 @@ func WithMaxCacheItems(maxCacheItems int) Option {
 	}
 }
 
+// WithPostBurst sets the number of twts a user can post in a burst before
+// being rate limited (0 for no limit)
+func WithPostBurst(postBurst int) Option {
+	return func(cfg *Config) error {
+		cfg.PostBurst = postBurst
+		return nil
+	}
+}
+
+// WithPostRate sets the sustained rate of posting once a user's burst is
+// used up, i.e: one twt per postRate
+func WithPostRate(postRate time.Duration) Option {
+	return func(cfg *Config) error {
+		cfg.PostRate = postRate
+		return nil
+	}
+}
+
+// WithSpamThreshold sets the spam score (between 0 and 1) above which twts
+// are rejected (or quarantined)
+func WithSpamThreshold(spamThreshold float64) Option {
+	return func(cfg *Config) error {
+		cfg.SpamThreshold = spamThreshold
+		return nil
+	}
+}
+
+// WithSpamTimeout sets the time allowed to classify a twt as spam
+func WithSpamTimeout(spamTimeout time.Duration) Option {
+	return func(cfg *Config) error {
+		cfg.SpamTimeout = spamTimeout
+		return nil
+	}
+}
+
+// WithLineEnding sets the line ending written to local feeds, either "lf"
+// or "crlf"
+func WithLineEnding(lineEnding string) Option {
+	return func(cfg *Config) error {
+		lineEnding = strings.ToLower(lineEnding)
+		if lineEnding != "lf" && lineEnding != "crlf" {
+			return ErrInvalidLineEnding
+		}
+		cfg.LineEnding = lineEnding
+		return nil
+	}
+}
+
+// WithMentionPrecedence sets whether mentions of a nick that is both
+// followed and a local user or feed expand to the followed feed
+// ("following") or the local user or feed ("local")
+func WithMentionPrecedence(mentionPrecedence string) Option {
+	return func(cfg *Config) error {
+		mentionPrecedence = strings.ToLower(mentionPrecedence)
+		if mentionPrecedence != "following" && mentionPrecedence != "local" {
+			return ErrInvalidMentionPrecedence
+		}
+		cfg.MentionPrecedence = mentionPrecedence
+		return nil
+	}
+}
+
+// WithTagNamespace sets the namespace of tags expanded in twts posted to the
+// pod (see URLForNamespacedTag), empty for global tags
+func WithTagNamespace(tagNamespace string) Option {
+	return func(cfg *Config) error {
+		if !validTagNamespace.MatchString(tagNamespace) {
+			return ErrInvalidTagNamespace
+		}
+		cfg.TagNamespace = tagNamespace
+		return nil
+	}
+}
+
+// WithFeedTTL sets the age after which twts in local feeds are considered old
+func WithFeedTTL(feedTTL time.Duration) Option {
+	return func(cfg *Config) error {
+		cfg.FeedTTL = feedTTL
+		return nil
+	}
+}
+
 // WithOpenProfiles sets whether or not to have open user profiles
 func WithOpenProfiles(openProfiles bool) Option {
 	return func(cfg *Config) error {
@@ -332,6 +674,15 @@ func WithTranscoderTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithStripReplyTargets sets whether or not to strip the leading mentions of
+// who a twt is replying to when displayed
+func WithStripReplyTargets(strip bool) Option {
+	return func(cfg *Config) error {
+		cfg.StripReplyTargets = strip
+		return nil
+	}
+}
+
 // WithMagicLinkSecret sets the MagicLinkSecert used to create password reset tokens
 func WithMagicLinkSecret(secret string) Option {
 	return func(cfg *Config) error {
@@ -396,6 +747,23 @@ func WithAPISessionTime(duration time.Duration) Option {
 	}
 }
 
+// WithFeedStore sets the FeedStore to store local feeds in
+func WithFeedStore(store FeedStore) Option {
+	return func(cfg *Config) error {
+		cfg.feedStore = store
+		return nil
+	}
+}
+
+// WithLogger sets the logger feed operations are logged to, e.g: to route
+// them to a pod's own log aggregation
+func WithLogger(logger log.FieldLogger) Option {
+	return func(cfg *Config) error {
+		cfg.logger = logger
+		return nil
+	}
+}
+
 // WithAPISigningKey sets the API JWT signing key for tokens
 func WithAPISigningKey(key string) Option {
 	return func(cfg *Config) error {
@@ -404,6 +772,21 @@ func WithAPISigningKey(key string) Option {
 	}
 }
 
+// WithPingSigningKey sets the (base64 encoded) ed25519 seed used to sign
+// mention pings sent to remote pods, empty to send unsigned pings (see
+// NotifyMentioned)
+func WithPingSigningKey(key string) Option {
+	return func(cfg *Config) error {
+		if key != "" {
+			if _, err := parsePingSigningKey(key); err != nil {
+				return err
+			}
+		}
+		cfg.PingSigningKey = key
+		return nil
+	}
+}
+
 // WithWhitelistedDomains sets the list of domains whitelisted and permitted for external iamges
 func WithWhitelistedDomains(whitelistedDomains []string) Option {
 	return func(cfg *Config) error {
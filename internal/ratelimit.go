@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// postBucket is a token bucket of the twts a user can post, holding up to
+// PostBurst tokens and regaining one token every PostRate.
+type postBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *postBucket) refill(conf *Config, now time.Time) {
+	if now.After(b.last) {
+		b.tokens += float64(now.Sub(b.last)) / float64(conf.PostRate)
+		b.last = now
+	}
+	if max := float64(conf.PostBurst); b.tokens > max {
+		b.tokens = max
+	}
+}
+
+var (
+	postBucketsMu sync.Mutex
+	postBuckets   = make(map[string]*postBucket)
+)
+
+// loadPostBucket reconstructs a user's bucket from their recent twts (see
+// GetLastNTwts) so that rate limits survive restarts.
+func loadPostBucket(conf *Config, user *User) *postBucket {
+	bucket := &postBucket{tokens: float64(conf.PostBurst)}
+
+	twts, err := GetLastNTwts(conf, user.Username, conf.PostBurst)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).Warnf("error reading recent twts of %s", user.Username)
+		}
+		return bucket
+	}
+
+	for i := len(twts) - 1; i >= 0; i-- {
+		if bucket.last.IsZero() {
+			bucket.last = twts[i].Created
+		}
+		bucket.refill(conf, twts[i].Created)
+		if bucket.tokens -= 1; bucket.tokens < 0 {
+			bucket.tokens = 0
+		}
+	}
+
+	return bucket
+}
+
+// checkPostRate takes a token from the user's bucket for each of the n twts
+// they are posting returning ErrRateLimited (with the time until enough
+// tokens are available) if there are not enough tokens, so more than
+// PostBurst twts can never be posted at once. Posting is never rate limited
+// if conf.PostBurst is zero.
+func checkPostRate(conf *Config, user *User, n int) error {
+	if conf.PostBurst <= 0 || conf.PostRate <= 0 {
+		return nil
+	}
+
+	postBucketsMu.Lock()
+	defer postBucketsMu.Unlock()
+
+	key := URLForUser(conf, user.Username)
+	bucket, ok := postBuckets[key]
+	if !ok {
+		bucket = loadPostBucket(conf, user)
+		postBuckets[key] = bucket
+	}
+
+	now := time.Now()
+	bucket.refill(conf, now)
+
+	if need := float64(n); bucket.tokens < need {
+		retryAfter := time.Duration((need - bucket.tokens) * float64(conf.PostRate))
+		return &ErrRateLimited{RetryAfter: retryAfter}
+	}
+	bucket.tokens -= float64(n)
+
+	return nil
+}
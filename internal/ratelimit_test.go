@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPostRate(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithPostBurst(3)(conf))
+	require.NoError(t, WithPostRate(time.Hour)(conf))
+
+	// Recent twts (e.g: from before a restart) count against the burst
+	now := time.Now()
+	require.NoError(t, conf.FeedStore().Write("ratelimited", []byte(fmt.Sprintf(
+		"2020-01-01T00:00:00Z\tAncient\n%s\tRecent\n",
+		now.Add(-time.Minute).Format(time.RFC3339),
+	))))
+
+	user := &User{Username: "ratelimited"}
+
+	_, err := AppendTwt(conf, nil, user, "First")
+	require.NoError(t, err)
+	_, err = AppendTwt(conf, nil, user, "Second")
+	require.NoError(t, err)
+
+	_, err = AppendTwt(conf, nil, user, "Third")
+	var rateLimited *ErrRateLimited
+	require.True(t, errors.As(err, &rateLimited))
+	assert.True(rateLimited.RetryAfter > 0 && rateLimited.RetryAfter <= time.Hour)
+
+	_, err = AppendTwts(conf, nil, user, []string{"Fourth", "Fifth"})
+	assert.True(errors.Is(err, &ErrRateLimited{}))
+
+	// Edits preserving the original timestamp are not rate limited
+	_, err = AppendTwt(conf, nil, user, "Edited", now)
+	assert.NoError(err)
+}
+
+func TestCheckPostRateSpecial(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithPostBurst(2)(conf))
+	require.NoError(t, WithPostRate(time.Hour)(conf))
+
+	// Posting as a feed (e.g: postas) is rate limited like any other post
+	for _, text := range []string{"First", "Second"} {
+		_, err := AppendSpecial(conf, nil, "ratelimitedfeed", text)
+		require.NoError(t, err)
+	}
+	_, err := AppendSpecial(conf, nil, "ratelimitedfeed", "Third")
+	assert.True(errors.Is(err, &ErrRateLimited{}))
+
+	// Edits preserving the original timestamp are not rate limited
+	_, err = AppendSpecial(conf, nil, "ratelimitedfeed", "Edited", time.Now())
+	assert.NoError(err)
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	// maxReactionRunes is the maximum number of runes in a single emoji
+	// reaction (e.g: emoji with skin tone modifiers or joined with ZWJ)
+	maxReactionRunes = 8
+)
+
+var (
+	leadingSubjectRe = regexp.MustCompile(`^\(#(?:[a-z0-9]+|<[^ >]+ [^>]+>)\)\s*`)
+)
+
+// isEmoji returns true if s consists solely of a single emoji, possibly a
+// sequence of emoji joined with ZWJ or with modifiers and variation selectors.
+func isEmoji(s string) bool {
+	runes := []rune(s)
+	if len(runes) == 0 || len(runes) > maxReactionRunes {
+		return false
+	}
+
+	symbols := 0
+	for _, r := range runes {
+		switch {
+		case r == '\u200d' || r == '\ufe0f' || unicode.Is(unicode.Sk, r):
+			// ZWJ, variation selector or modifier (e.g: skin tone)
+		case unicode.Is(unicode.So, r):
+			symbols++
+		default:
+			return false
+		}
+	}
+	return symbols > 0
+}
+
+// Reaction returns the reaction of a reaction reply and true, or false if
+// the twt is not a reaction. A reaction is a reply (see Twt.IsReply) whose
+// text besides its reply targets and subject is either a single emoji or
+// one of the configured ReactionMarkers (e.g: +1).
+func Reaction(conf *Config, twt types.Twt) (string, bool) {
+	if !twt.IsReply() {
+		return "", false
+	}
+
+	text := leadingSubjectRe.ReplaceAllString(twt.TextWithoutReplyTargets(), "")
+	text = strings.TrimSpace(text)
+
+	for _, marker := range conf.ReactionMarkers {
+		if text == marker {
+			return text, true
+		}
+	}
+
+	if isEmoji(text) {
+		return text, true
+	}
+
+	return "", false
+}
+
+// CountReactions returns the number of reactions (see Reaction) to the twt
+// with the given hash from the cached local feeds tallied by reaction.
+func CountReactions(conf *Config, cache *Cache, hash string) map[string]int {
+	reactions := make(map[string]int)
+
+	for _, twt := range cache.GetByPrefix(conf.BaseURL, false) {
+		if subjectHash(twt) != hash {
+			continue
+		}
+		if reaction, ok := Reaction(conf, twt); ok {
+			reactions[reaction]++
+		}
+	}
+
+	return reactions
+}
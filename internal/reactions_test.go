@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestReaction(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := NewConfig()
+
+	testCases := []struct {
+		text     string
+		reaction string
+		ok       bool
+	}{
+		{text: "(#abcdefg) 👍", reaction: "👍", ok: true},
+		{text: "@<bob https://example.com/twtxt.txt> (#abcdefg) ❤️", reaction: "❤️", ok: true},
+		{text: "(#abcdefg) 👍🏽", reaction: "👍🏽", ok: true},
+		{text: "(#abcdefg) 👩‍💻", reaction: "👩‍💻", ok: true},
+		{text: "(#<abcdefg https://twtxt.net/search?tag=abcdefg>) +1", reaction: "+1", ok: true},
+		{text: "(#abcdefg) 👍 Great point!", ok: false},
+		{text: "(#abcdefg) +2", ok: false},
+		{text: "(#abcdefg) 1", ok: false},
+		{text: "👍", ok: false},
+	}
+
+	for _, testCase := range testCases {
+		reaction, ok := Reaction(conf, types.Twt{Text: testCase.text})
+		assert.Equal(testCase.ok, ok, testCase.text)
+		assert.Equal(testCase.reaction, reaction, testCase.text)
+	}
+
+	require.NoError(t, WithReactionMarkers([]string{"+1", "like"})(conf))
+	reaction, ok := Reaction(conf, types.Twt{Text: "(#abcdefg) like"})
+	assert.True(ok)
+	assert.Equal("like", reaction)
+}
+
+func TestCountReactions(t *testing.T) {
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	alice := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	bob := &User{Username: "bob", URL: URLForUser(conf, "bob")}
+
+	root, err := AppendTwt(conf, nil, alice, "Hello World!")
+	require.NoError(t, err)
+
+	for _, text := range []string{"👍", "👍", "+1", "Nice one!"} {
+		_, err := AppendTwt(conf, nil, bob, fmt.Sprintf("(#%s) %s", root.Hash(), text))
+		require.NoError(t, err)
+	}
+	_, err = AppendTwt(conf, nil, alice, "(#abcdefg) 👍")
+	require.NoError(t, err)
+
+	cache, err := LoadCache(conf.Data)
+	require.NoError(t, err)
+	for _, user := range []*User{alice, bob} {
+		twts, err := GetAllTwts(conf, user.Username)
+		require.NoError(t, err)
+		cache.Twts[user.URL] = Cached{Twts: twts}
+	}
+
+	reactions := CountReactions(conf, cache, root.Hash())
+	assert.Equal(t, map[string]int{"👍": 2, "+1": 1}, reactions)
+}
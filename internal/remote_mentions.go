@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// remoteMentionCacheTTL is how long remote directory lookups (including
+	// failed lookups) are cached for
+	remoteMentionCacheTTL = time.Hour
+
+	// maxWebFingerSize is the maximum size of a WebFinger response read
+	maxWebFingerSize = 1 << 16
+)
+
+var (
+	// remoteMentionTimeout bounds each remote directory lookup
+	remoteMentionTimeout = 5 * time.Second
+
+	// remoteMentionWait is how long expanding a mention waits for a remote
+	// directory lookup before falling back, the lookup carries on in the
+	// background and its result is used for later mentions
+	remoteMentionWait = 500 * time.Millisecond
+
+	// remoteMentionScheme is the scheme used to contact remote pods
+	remoteMentionScheme = "https"
+
+	remoteMentions = NewTTLCache(remoteMentionCacheTTL)
+
+	remoteMentionLookupsMu sync.Mutex
+	remoteMentionLookups   = make(map[string]chan struct{})
+)
+
+// webFingerResource is the subset of a WebFinger JRD (RFC 7033) used to find
+// the feed of an account
+type webFingerResource struct {
+	Links []struct {
+		Rel  string `json:"rel"`
+		Type string `json:"type"`
+		Href string `json:"href"`
+	} `json:"links"`
+}
+
+// defaultRemoteMentionURL returns the url of nick's feed on domain assuming
+// domain is a twtxt pod
+func defaultRemoteMentionURL(nick, domain string) string {
+	return fmt.Sprintf("https://%s/user/%s/twtxt.txt", domain, nick)
+}
+
+// LookupRemoteMention resolves the feed url of an @nick@domain mention. If
+// conf.LookupMentions is enabled the remote pod's directory is queried via
+// WebFinger (acct:nick@domain) for a link to a text/plain feed, so that pods
+// hosting feeds at other paths resolve correctly. Otherwise, or if the lookup
+// fails or does not complete within remoteMentionWait, the url of nick's feed
+// on a twtxt pod at domain is returned. Lookups run in the background and are
+// cached (including failures).
+func LookupRemoteMention(conf *Config, nick, domain string) string {
+	fallback := defaultRemoteMentionURL(nick, domain)
+	if !conf.LookupMentions {
+		return fallback
+	}
+
+	key := fmt.Sprintf("%s@%s", strings.ToLower(nick), strings.ToLower(domain))
+
+	if feedURL, ok := remoteMentions.GetValue(key); ok {
+		return feedURL.(string)
+	}
+
+	remoteMentionLookupsMu.Lock()
+	done, ok := remoteMentionLookups[key]
+	if !ok {
+		done = make(chan struct{})
+		remoteMentionLookups[key] = done
+
+		go func() {
+			feedURL, err := webFingerFeed(nick, domain)
+			if err != nil {
+				log.WithError(err).Warnf("error looking up %s, assuming %s", key, fallback)
+			}
+			if feedURL == "" {
+				feedURL = fallback
+			}
+			remoteMentions.SetValue(key, feedURL)
+
+			remoteMentionLookupsMu.Lock()
+			delete(remoteMentionLookups, key)
+			remoteMentionLookupsMu.Unlock()
+			close(done)
+		}()
+	}
+	remoteMentionLookupsMu.Unlock()
+
+	select {
+	case <-done:
+		if feedURL, ok := remoteMentions.GetValue(key); ok {
+			return feedURL.(string)
+		}
+	case <-time.After(remoteMentionWait):
+		log.Debugf("lookup of %s is taking a while, assuming %s", key, fallback)
+	}
+
+	return fallback
+}
+
+// webFingerFeed queries domain's WebFinger endpoint for the feed of nick
+// returning an empty url if the account has no feed link.
+func webFingerFeed(nick, domain string) (string, error) {
+	u := url.URL{
+		Scheme:   remoteMentionScheme,
+		Host:     domain,
+		Path:     "/.well-known/webfinger",
+		RawQuery: url.Values{"resource": {fmt.Sprintf("acct:%s@%s", nick, domain)}}.Encode(),
+	}
+
+	client := &http.Client{Timeout: remoteMentionTimeout}
+	res, err := client.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("non-200 response: %s", res.Status)
+	}
+
+	var resource webFingerResource
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxWebFingerSize)).Decode(&resource); err != nil {
+		return "", err
+	}
+
+	for _, link := range resource.Links {
+		if link.Type != "text/plain" {
+			continue
+		}
+		if href, err := url.Parse(link.Href); err == nil && (href.Scheme == "http" || href.Scheme == "https") {
+			return link.Href, nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupRemoteMention(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	var lookups int32
+	slow := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&lookups, 1)
+		if r.URL.Query().Get("resource") == fmt.Sprintf("acct:carol@%s", r.Host) {
+			<-slow
+		}
+		if r.URL.Path != "/.well-known/webfinger" || r.URL.Query().Get("resource") != fmt.Sprintf("acct:alice@%s", r.Host) {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"links": [{"rel": "self", "type": "text/plain", "href": "https://%s/~alice/twtxt.txt"}]}`, r.Host)
+	}))
+	defer ts.Close()
+
+	defer func(scheme string) { remoteMentionScheme = scheme }(remoteMentionScheme)
+	remoteMentionScheme = "http"
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	domain := u.Host
+
+	// Lookups are opt-in
+	assert.Equal(fmt.Sprintf("https://%s/user/alice/twtxt.txt", domain), LookupRemoteMention(conf, "alice", domain))
+	assert.Equal(int32(0), atomic.LoadInt32(&lookups))
+
+	require.NoError(t, WithLookupMentions(true)(conf))
+	assert.Equal(fmt.Sprintf("https://%s/~alice/twtxt.txt", domain), LookupRemoteMention(conf, "alice", domain))
+
+	// Unknown accounts fall back to the constructed path
+	assert.Equal(fmt.Sprintf("https://%s/user/bob/twtxt.txt", domain), LookupRemoteMention(conf, "bob", domain))
+
+	// Lookups are cached
+	LookupRemoteMention(conf, "alice", domain)
+	LookupRemoteMention(conf, "bob", domain)
+	assert.Equal(int32(2), atomic.LoadInt32(&lookups))
+
+	// Slow lookups fall back and complete in the background
+	defer func(wait time.Duration) { remoteMentionWait = wait }(remoteMentionWait)
+	remoteMentionWait = 10 * time.Millisecond
+
+	assert.Equal(fmt.Sprintf("https://%s/user/carol/twtxt.txt", domain), LookupRemoteMention(conf, "carol", domain))
+	close(slow)
+	assert.Eventually(func() bool {
+		_, ok := remoteMentions.GetValue(fmt.Sprintf("carol@%s", domain))
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(int32(3), atomic.LoadInt32(&lookups))
+}
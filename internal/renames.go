@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	renamesFile = "renames.json"
+
+	// maxRenameRedirects is the maximum number of rename redirects followed
+	// by ResolveFeedName to guard against cycles in the renames mapping
+	maxRenameRedirects = 32
+)
+
+func recordRename(conf *Config, oldName, newName string) error {
+	err := getSidecar(conf, renamesFile).Update(func(renames map[string]string) {
+		renames[oldName] = newName
+		// Renaming a feed back to a previous name must not create a cycle
+		delete(renames, newName)
+	})
+	if err != nil {
+		log.WithError(err).Error("error recording rename")
+		return err
+	}
+
+	return nil
+}
+
+// ResolveFeedName follows any rename redirects recorded for name (see
+// RenameFeed) returning the current name of the feed, or name itself if the
+// feed was never renamed.
+func ResolveFeedName(conf *Config, name string) string {
+	renames, err := getSidecar(conf, renamesFile).Snapshot()
+	if err != nil {
+		log.WithError(err).Error("error loading renames")
+		return name
+	}
+
+	return resolveRedirects(renames, name, maxRenameRedirects)
+}
+
+// RenameFeed renames the local feed (a user's own feed or a feed owned by a
+// user) oldName to newName, moving the feed and its avatar and updating the
+// user or feed record as well as any local users owning or following it.
+//
+// Twt hashes are derived from the feed's url so every twt in the renamed feed
+// gets a new hash; with EditRedirects enabled the old hashes are resolved to
+// the new ones. With RenameRedirects enabled the feed's old urls redirect to
+// the new ones for as long as no other feed takes the old name.
+func RenameFeed(conf *Config, db Store, oldName, newName string) error {
+	isUser := db.HasUser(oldName)
+	if !isUser && !db.HasFeed(oldName) {
+		return ErrFeedNotFound
+	}
+
+	if isUser {
+		if err := ValidateUsername(newName); err != nil {
+			return err
+		}
+	} else {
+		if err := ValidateFeedName(conf.Data, newName); err != nil {
+			return err
+		}
+	}
+
+	store := conf.FeedStore()
+
+	if db.HasUser(newName) || db.HasFeed(newName) {
+		return ErrFeedAlreadyExists
+	}
+	if _, err := store.Stat(newName); err == nil {
+		return ErrFeedAlreadyExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	oldURL := URLForUser(conf, oldName)
+	newURL := URLForUser(conf, newName)
+
+	twts, err := GetAllTwts(conf, oldName)
+	if err != nil && !os.IsNotExist(err) {
+		conf.feedLog(oldName).WithError(err).Error("error reading feed")
+		return err
+	}
+
+	// Move the feed
+	moved := true
+	if err := store.Rename(oldName, newName); err != nil {
+		if !os.IsNotExist(err) {
+			conf.feedLog(oldName).WithError(err).Errorf("error moving feed to %s", newName)
+			return err
+		}
+		moved = false
+	}
+
+	// Record the rename redirect before anything else refers to the new name
+	// so a failure leaves the feed as it was
+	if conf.RenameRedirects {
+		if err := recordRename(conf, oldName, newName); err != nil {
+			log.WithError(err).Errorf("error recording rename of %s to %s", oldName, newName)
+			if moved {
+				if err := store.Rename(newName, oldName); err != nil {
+					conf.feedLog(newName).WithError(err).Errorf("error moving feed back to %s", oldName)
+				}
+			}
+			return err
+		}
+	}
+
+	// Move the avatar (if any)
+	for _, ext := range []string{"png", "webp"} {
+		src := filepath.Join(conf.Data, avatarsDir, fmt.Sprintf("%s.%s", oldName, ext))
+		dst := filepath.Join(conf.Data, avatarsDir, fmt.Sprintf("%s.%s", newName, ext))
+		if err := os.Rename(src, dst); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Warnf("error moving avatar %s", src)
+		}
+	}
+
+	// Update the user or feed record
+	if isUser {
+		user, err := db.GetUser(oldName)
+		if err != nil {
+			conf.userLog(oldName).WithError(err).Error("error loading user object")
+			return err
+		}
+		user.Username = newName
+		user.URL = newURL
+		if err := db.SetUser(newName, user); err != nil {
+			conf.userLog(newName).WithError(err).Error("error saving user object")
+			return err
+		}
+		if err := db.DelUser(oldName); err != nil {
+			conf.userLog(oldName).WithError(err).Error("error deleting user object")
+			return err
+		}
+	} else {
+		feed, err := db.GetFeed(oldName)
+		if err != nil {
+			conf.feedLog(oldName).WithError(err).Error("error loading feed object")
+			return err
+		}
+		feed.Name = newName
+		feed.URL = newURL
+		if err := db.SetFeed(newName, feed); err != nil {
+			conf.feedLog(newName).WithError(err).Error("error saving feed object")
+			return err
+		}
+		if err := db.DelFeed(oldName); err != nil {
+			conf.feedLog(oldName).WithError(err).Error("error deleting feed object")
+			return err
+		}
+	}
+
+	// Update local owners and followers
+	users, err := db.GetAllUsers()
+	if err != nil {
+		log.WithError(err).Error("error loading all users")
+		return err
+	}
+	for _, user := range users {
+		changed := false
+		if user.OwnsFeed(oldName) {
+			user.Feeds = append(RemoveString(user.Feeds, oldName), newName)
+			changed = true
+		}
+		for nick, url := range user.Following {
+			if NormalizeURL(url) == NormalizeURL(oldURL) {
+				delete(user.Following, nick)
+				if nick == oldName {
+					nick = newName
+				}
+				user.Following[nick] = newURL
+				changed = true
+			}
+		}
+		if changed {
+			if err := db.SetUser(user.Username, user); err != nil {
+				conf.userLog(user.Username).WithError(err).Warn("error updating user object")
+			}
+		}
+	}
+
+	if conf.EditRedirects && len(twts) > 0 {
+		twter := types.Twter{Nick: newName, URL: newURL}
+		redirects := make(map[string]string, len(twts))
+		for _, twt := range twts {
+			redirects[twt.Hash()] = types.Twt{Twter: twter, Text: twt.Text, Created: twt.Created}.Hash()
+		}
+		if err := recordEdits(conf, redirects); err != nil {
+			conf.feedLog(newName).WithError(err).Warn("error recording hash redirects for renamed feed")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prologic/twtxt/types"
+)
+
+var (
+	ErrInvalidScheduledID = errors.New("error: invalid scheduled twt id")
+	ErrScheduledNotFound  = errors.New("error: scheduled twt not found")
+	ErrScheduledInPast    = errors.New("error: cannot schedule a twt in the past")
+
+	validScheduledID = regexp.MustCompile(`^[a-f0-9]+$`)
+)
+
+// ScheduledTwt is a twt a user has scheduled to be posted at a later time.
+// Scheduled twts are stored in the user's record so they never appear in any
+// feed until they are published (see PublishDueTwts).
+type ScheduledTwt struct {
+	ID   string
+	Text string
+	At   time.Time
+}
+
+type ScheduledTwts []ScheduledTwt
+
+func (sts ScheduledTwts) Len() int {
+	return len(sts)
+}
+func (sts ScheduledTwts) Less(i, j int) bool {
+	return sts[i].At.Before(sts[j].At)
+}
+func (sts ScheduledTwts) Swap(i, j int) {
+	sts[i], sts[j] = sts[j], sts[i]
+}
+
+func checkScheduledTwt(text string, at time.Time) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", fmt.Errorf("cowardly refusing to schedule empty text, or only spaces")
+	}
+	if !at.After(time.Now()) {
+		return "", ErrScheduledInPast
+	}
+	return text, nil
+}
+
+func saveScheduledTwt(db Store, user *User, scheduled ScheduledTwt) error {
+	if user.Scheduled == nil {
+		user.Scheduled = make(map[string]ScheduledTwt)
+	}
+	user.Scheduled[scheduled.ID] = scheduled
+
+	if err := db.SetUser(user.Username, user); err != nil {
+		log.WithError(err).WithField("user", user.Username).Errorf("error saving scheduled twt %s", scheduled.ID)
+		return err
+	}
+
+	return nil
+}
+
+// ScheduleTwt schedules text to be posted to the user's feed at the given
+// (future) time returning the id of the scheduled twt.
+func ScheduleTwt(db Store, user *User, text string, at time.Time) (string, error) {
+	text, err := checkScheduledTwt(text, at)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+
+	if err := saveScheduledTwt(db, user, ScheduledTwt{ID: id, Text: text, At: at}); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// EditScheduledTwt changes the text and time of the user's scheduled twt
+// with the given id, ErrScheduledNotFound is returned if it has already been
+// published or cancelled.
+func EditScheduledTwt(db Store, user *User, id, text string, at time.Time) error {
+	if !validScheduledID.MatchString(id) {
+		return ErrInvalidScheduledID
+	}
+
+	text, err := checkScheduledTwt(text, at)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := user.Scheduled[id]; !ok {
+		return ErrScheduledNotFound
+	}
+
+	return saveScheduledTwt(db, user, ScheduledTwt{ID: id, Text: text, At: at})
+}
+
+// CancelScheduledTwt removes the user's scheduled twt with the given id.
+func CancelScheduledTwt(db Store, user *User, id string) error {
+	if !validScheduledID.MatchString(id) {
+		return ErrInvalidScheduledID
+	}
+	if _, ok := user.Scheduled[id]; !ok {
+		return ErrScheduledNotFound
+	}
+
+	delete(user.Scheduled, id)
+
+	if err := db.SetUser(user.Username, user); err != nil {
+		log.WithError(err).WithField("user", user.Username).Errorf("error cancelling scheduled twt %s", id)
+		return err
+	}
+
+	return nil
+}
+
+// ListScheduledTwts returns all of the user's scheduled twts, soonest first.
+func ListScheduledTwts(user *User) ScheduledTwts {
+	sts := make(ScheduledTwts, 0, len(user.Scheduled))
+	for id, scheduled := range user.Scheduled {
+		scheduled.ID = id
+		sts = append(sts, scheduled)
+	}
+
+	sort.Sort(sts)
+
+	return sts
+}
+
+// PublishDueTwts posts every scheduled twt (of all users) whose time has
+// come to its user's feed via AppendTwt, returning the twts published.
+// Scheduled twts that fail to post (e.g: rate limited) are kept and retried
+// on the next run.
+func PublishDueTwts(conf *Config, db Store) (types.Twts, error) {
+	users, err := db.GetAllUsers()
+	if err != nil {
+		conf.Logger().WithError(err).Error("error loading all users to publish scheduled twts")
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var published types.Twts
+	for _, user := range users {
+		changed := false
+		for _, scheduled := range ListScheduledTwts(user) {
+			if scheduled.At.After(now) {
+				break
+			}
+
+			twt, err := AppendTwt(conf, db, user, scheduled.Text)
+			if err != nil {
+				conf.userLog(user.Username).WithError(err).Warnf("error publishing scheduled twt %s", scheduled.ID)
+				continue
+			}
+			published = append(published, twt)
+			conf.userLog(user.Username).Infof("published scheduled twt %s as %s", scheduled.ID, twt.Hash())
+
+			delete(user.Scheduled, scheduled.ID)
+			changed = true
+		}
+
+		if changed {
+			if err := db.SetUser(user.Username, user); err != nil {
+				conf.userLog(user.Username).WithError(err).Error("error removing published scheduled twts")
+			}
+		}
+	}
+
+	return published, nil
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleTwt(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+	require.NoError(t, db.SetUser(user.Username, user))
+
+	_, err = ScheduleTwt(db, user, "Too late", time.Now().Add(-time.Minute))
+	assert.Equal(ErrScheduledInPast, err)
+	_, err = ScheduleTwt(db, user, "  ", time.Now().Add(time.Hour))
+	assert.Error(err)
+
+	later, err := ScheduleTwt(db, user, "Later", time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	cancelled, err := ScheduleTwt(db, user, "Never", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	sts := ListScheduledTwts(user)
+	require.Len(t, sts, 2)
+	assert.Equal(cancelled, sts[0].ID)
+	assert.Equal(later, sts[1].ID)
+
+	require.NoError(t, EditScheduledTwt(db, user, later, "Later (edited)", time.Now().Add(3*time.Hour)))
+	require.NoError(t, CancelScheduledTwt(db, user, cancelled))
+	assert.Equal(ErrScheduledNotFound, CancelScheduledTwt(db, user, cancelled))
+	assert.Equal(ErrScheduledNotFound, EditScheduledTwt(db, user, cancelled, "Never", time.Now().Add(time.Hour)))
+	assert.Equal(ErrInvalidScheduledID, CancelScheduledTwt(db, user, "../../feeds/test"))
+
+	// Scheduled twts are kept on the user's record
+	saved, err := db.GetUser(user.Username)
+	require.NoError(t, err)
+	require.Len(t, saved.Scheduled, 1)
+	assert.Equal("Later (edited)", saved.Scheduled[later].Text)
+
+	sts = ListScheduledTwts(user)
+	require.Len(t, sts, 1)
+	assert.Equal("Later (edited)", sts[0].Text)
+
+	// Simulate a scheduled twt whose time has come
+	require.NoError(t, saveScheduledTwt(db, user, ScheduledTwt{ID: "abcdef", Text: "Now", At: time.Now().Add(-time.Second)}))
+
+	published, err := PublishDueTwts(conf, db)
+	require.NoError(t, err)
+	require.Len(t, published, 1)
+	assert.Equal("Now", published[0].Text)
+
+	twts, err := GetAllTwts(conf, user.Username)
+	require.NoError(t, err)
+	require.Len(t, twts, 1)
+	assert.Equal(published[0].Hash(), twts[0].Hash())
+
+	// Published twts are removed and never published again
+	published, err = PublishDueTwts(conf, db)
+	require.NoError(t, err)
+	assert.Empty(published)
+
+	user, err = db.GetUser(user.Username)
+	require.NoError(t, err)
+	sts = ListScheduledTwts(user)
+	require.Len(t, sts, 1)
+	assert.Equal(later, sts[0].ID)
+}
@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	// tagMatchWeight is the weight given to a query term matching a #tag
+	tagMatchWeight = 3.0
+
+	// mentionMatchWeight is the weight given to a query term matching a @mention
+	mentionMatchWeight = 3.0
+
+	// bodyMatchWeight is the weight given to a query term found in the text
+	bodyMatchWeight = 1.0
+
+	// recencyHalfLife is the age at which a twt's recency boost is halved
+	recencyHalfLife = WeekAgo
+)
+
+// ScoreFunc scores how relevant a twt is to the given (lowercased) query
+// terms at the time now. A score of zero (or less) means the twt does not
+// match and is excluded from the search results.
+type ScoreFunc func(twt types.Twt, terms []string, now time.Time) float64
+
+var (
+	searchScoreFuncMu sync.RWMutex
+	searchScoreFunc   ScoreFunc = DefaultScoreFunc
+)
+
+// SetSearchScoreFunc sets the ScoreFunc the search page ranks twts with so
+// pods can tune their search, nil restores DefaultScoreFunc.
+func SetSearchScoreFunc(score ScoreFunc) {
+	if score == nil {
+		score = DefaultScoreFunc
+	}
+
+	searchScoreFuncMu.Lock()
+	searchScoreFunc = score
+	searchScoreFuncMu.Unlock()
+}
+
+func getSearchScoreFunc() ScoreFunc {
+	searchScoreFuncMu.RLock()
+	defer searchScoreFuncMu.RUnlock()
+	return searchScoreFunc
+}
+
+// SearchResult is a single twt matching a search along with its Score
+type SearchResult struct {
+	Twt   types.Twt
+	Score float64
+}
+
+// SearchResults typedef to be able to attach sort methods
+type SearchResults []SearchResult
+
+func (rs SearchResults) Len() int {
+	return len(rs)
+}
+func (rs SearchResults) Less(i, j int) bool {
+	if rs[i].Score == rs[j].Score {
+		return rs[i].Twt.Created.After(rs[j].Twt.Created)
+	}
+	return rs[i].Score > rs[j].Score
+}
+func (rs SearchResults) Swap(i, j int) {
+	rs[i], rs[j] = rs[j], rs[i]
+}
+
+// Twts returns the twts of the search results in ranked order
+func (rs SearchResults) Twts() types.Twts {
+	twts := make(types.Twts, len(rs))
+	for i, r := range rs {
+		twts[i] = r.Twt
+	}
+	return twts
+}
+
+// DefaultScoreFunc is the default ScoreFunc used by SearchTwts.
+//
+// For each query term a twt scores tagMatchWeight if the term matches one of
+// its #tags, mentionMatchWeight if it matches one of its @mentions, otherwise
+// bodyMatchWeight plus a bonus (up to bodyMatchWeight) the earlier the term
+// appears in the text as displayed, that is with @mentions and #tags reduced
+// to their nick or tag so their urls never match. The sum of all term scores is then boosted by up to 2x
+// for recency, where the boost halves every recencyHalfLife.
+func DefaultScoreFunc(twt types.Twt, terms []string, now time.Time) float64 {
+	var score float64
+
+	text := strings.ToLower(FormatMentionsAndTagsForSubject(twt.Text))
+
+	// fast-path: #tags and @mentions are part of the text too
+	matched := false
+	for _, term := range terms {
+		if strings.Contains(text, term) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return 0
+	}
+
+	tags := MapStrings(twt.Tags(), strings.ToLower)
+
+	var nicks []string
+	for _, twter := range twt.Mentions() {
+		nicks = append(nicks, strings.ToLower(twter.Nick))
+	}
+
+	for _, term := range terms {
+		switch {
+		case HasString(tags, term):
+			score += tagMatchWeight
+		case HasString(nicks, term):
+			score += mentionMatchWeight
+		default:
+			if i := strings.Index(text, term); i != -1 {
+				score += bodyMatchWeight * (2 - float64(i)/float64(len(text)))
+			}
+		}
+	}
+
+	if score <= 0 {
+		return 0
+	}
+
+	age := now.Sub(twt.Created)
+	if age < 0 {
+		age = 0
+	}
+	recency := math.Pow(0.5, float64(age)/float64(recencyHalfLife))
+
+	return score * (1 + recency)
+}
+
+// SearchTwts searches twts for the given query and returns the matching
+// twts ranked by their score (highest first). The query is split into terms
+// on whitespace and any leading # or @ is ignored. If score is nil then
+// DefaultScoreFunc is used.
+func SearchTwts(twts types.Twts, query string, score ScoreFunc) SearchResults {
+	if score == nil {
+		score = DefaultScoreFunc
+	}
+
+	var terms []string
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		if term = strings.TrimLeft(term, "#@"); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	terms = UniqStrings(terms)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var results SearchResults
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, twt := range twts {
+		s := score(twt, terms, now)
+		if s <= 0 || seen[twt.Hash()] {
+			continue
+		}
+		seen[twt.Hash()] = true
+
+		results = append(results, SearchResult{Twt: twt, Score: s})
+	}
+
+	sort.Sort(results)
+
+	return results
+}
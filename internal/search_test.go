@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestSearchTwts(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	twter := types.Twter{Nick: "prologic", URL: "https://twtxt.net/user/prologic/twtxt.txt"}
+
+	body := types.Twt{Twter: twter, Created: now, Text: "I really like golang"}
+	early := types.Twt{Twter: twter, Created: now, Text: "golang is what I really like"}
+	tag := types.Twt{Twter: twter, Created: now, Text: "Hello #<golang https://twtxt.net/search?tag=golang>"}
+	old := types.Twt{Twter: twter, Created: now.Add(-YearAgo), Text: "I really like golang"}
+	mention := types.Twt{Twter: twter, Created: now, Text: "@<golang https://golang.org/twtxt.txt> hi!"}
+	nomatch := types.Twt{Twter: twter, Created: now, Text: "Nothing to see here"}
+
+	t.Run("NoTerms", func(t *testing.T) {
+		assert.Empty(SearchTwts(types.Twts{body}, "  # @ ", nil))
+	})
+
+	t.Run("Ranking", func(t *testing.T) {
+		results := SearchTwts(types.Twts{old, body, nomatch, early, tag, mention}, "#golang", nil)
+		assert.Len(results, 5)
+
+		// Tag and mention matches rank above body matches.
+		assert.ElementsMatch(types.Twts{tag, mention}, results[:2].Twts())
+
+		// Earlier matches in the body rank higher than later ones.
+		assert.Equal(early.Hash(), results[2].Twt.Hash())
+		assert.Equal(body.Hash(), results[3].Twt.Hash())
+
+		// Recency matters, old twts get less of a boost.
+		assert.Equal(old.Hash(), results[4].Twt.Hash())
+
+		for i := 1; i < len(results); i++ {
+			assert.True(results[i-1].Score >= results[i].Score)
+		}
+	})
+
+	t.Run("CustomScoreFunc", func(t *testing.T) {
+		oldest := func(twt types.Twt, terms []string, now time.Time) float64 {
+			return float64(now.Sub(twt.Created))
+		}
+		results := SearchTwts(types.Twts{tag, old}, "golang", oldest)
+		assert.Equal(old.Hash(), results[0].Twt.Hash())
+	})
+
+	t.Run("Dedupe", func(t *testing.T) {
+		assert.Len(SearchTwts(types.Twts{tag, tag}, "golang", nil), 1)
+	})
+
+	t.Run("IgnoresMarkupURLs", func(t *testing.T) {
+		for _, query := range []string{"twtxt", "https", "search", "org"} {
+			assert.Empty(SearchTwts(types.Twts{tag, mention}, query, nil), query)
+		}
+		assert.Len(SearchTwts(types.Twts{tag, mention}, "hello", nil), 1)
+	})
+
+	t.Run("SetSearchScoreFunc", func(t *testing.T) {
+		defer SetSearchScoreFunc(nil)
+
+		never := func(twt types.Twt, terms []string, now time.Time) float64 { return 0 }
+		SetSearchScoreFunc(never)
+		assert.Empty(SearchTwts(types.Twts{body}, "golang", getSearchScoreFunc()))
+
+		SetSearchScoreFunc(nil)
+		assert.Len(SearchTwts(types.Twts{body}, "golang", getSearchScoreFunc()), 1)
+	})
+}
+
+func BenchmarkSearchTwts(b *testing.B) {
+	words := []string{
+		"hello", "world", "twtxt", "golang", "pod", "decentralised", "feed",
+		"coffee", "weekend", "music", "photo", "morning", "running", "code",
+	}
+
+	rnd := rand.New(rand.NewSource(42))
+	now := time.Now()
+
+	// ~50k twts is roughly a busy pod's cache
+	var twts types.Twts
+	for i := 0; i < 50000; i++ {
+		twter := types.Twter{
+			Nick: fmt.Sprintf("user%d", i%500),
+			URL:  fmt.Sprintf("https://twtxt.net/user/user%d/twtxt.txt", i%500),
+		}
+		text := fmt.Sprintf(
+			"@<user%d https://twtxt.net/user/user%d/twtxt.txt> %s %s %s #<%s https://twtxt.net/search?tag=%s>",
+			rnd.Intn(500), rnd.Intn(500),
+			words[rnd.Intn(len(words))], words[rnd.Intn(len(words))], words[rnd.Intn(len(words))],
+			words[i%len(words)], words[i%len(words)],
+		)
+		twts = append(twts, types.Twt{
+			Twter:   twter,
+			Created: now.Add(-time.Duration(rnd.Intn(int(MonthAgo)))),
+			Text:    text,
+		})
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SearchTwts(twts, "golang coffee", nil)
+	}
+}
@@ -237,6 +237,9 @@ func (s *Server) setupMetrics() {
 		},
 	)
 
+	// feed level metrics
+	SetFeedMetrics(NewObserveFeedMetrics(metrics))
+
 	// feed cache processing time
 	metrics.NewGauge(
 		"cache", "last_processed_seconds",
@@ -391,6 +394,8 @@ func (s *Server) processWebMention(source, target *url.URL, sourceData *microfor
 func (s *Server) setupWebMentions() {
 	webmentions = webmention.New()
 	webmentions.Mention = s.processWebMention
+
+	AddAppendHook(NotifyMentionedHook)
 }
 
 func (s *Server) setupCronJobs() error {
@@ -453,8 +458,11 @@ func (s *Server) initRoutes() {
 	s.router.GET("/robots.txt", s.RobotsHandler())
 	s.router.HEAD("/robots.txt", s.RobotsHandler())
 
+	s.router.GET(pingKeyPath, s.PingKeyHandler())
+
 	s.router.GET("/discover", s.am.MustAuth(s.DiscoverHandler()))
 	s.router.GET("/mentions", s.am.MustAuth(s.MentionsHandler()))
+	s.router.GET("/bookmarks", s.am.MustAuth(s.BookmarksHandler()))
 	s.router.GET("/search", s.SearchHandler())
 
 	s.router.HEAD("/twt/:hash", s.PermalinkHandler())
@@ -498,6 +506,8 @@ func (s *Server) initRoutes() {
 	s.router.HEAD("/user/:nick/avatar", s.AvatarHandler())
 	s.router.HEAD("/user/:nick/twtxt.txt", s.TwtxtHandler())
 	s.router.GET("/user/:nick/twtxt.txt", s.TwtxtHandler())
+	s.router.HEAD("/user/:nick/archive/:period", s.ArchiveFeedsHandler())
+	s.router.GET("/user/:nick/archive/:period", s.ArchiveFeedsHandler())
 	s.router.GET("/user/:nick/followers", s.FollowersHandler())
 	s.router.GET("/user/:nick/following", s.FollowingHandler())
 
@@ -519,10 +529,15 @@ func (s *Server) initRoutes() {
 	s.router.HEAD("/user/:nick/atom.xml", s.SyndicationHandler())
 	s.router.GET("/atom.xml", s.SyndicationHandler())
 	s.router.GET("/user/:nick/atom.xml", s.SyndicationHandler())
+	s.router.HEAD("/feed.json", s.SyndicationHandler())
+	s.router.HEAD("/user/:nick/feed.json", s.SyndicationHandler())
+	s.router.GET("/feed.json", s.SyndicationHandler())
+	s.router.GET("/user/:nick/feed.json", s.SyndicationHandler())
 
 	s.router.GET("/feed/:name/manage", s.am.MustAuth(s.ManageFeedHandler()))
 	s.router.POST("/feed/:name/manage", s.am.MustAuth(s.ManageFeedHandler()))
 	s.router.POST("/feed/:name/archive", s.am.MustAuth(s.ArchiveFeedHandler()))
+	s.router.POST("/feed/:name/rename", s.am.MustAuth(s.RenameFeedHandler()))
 
 	s.router.GET("/login", s.LoginHandler())
 	s.router.POST("/login", s.LoginHandler())
@@ -564,6 +579,11 @@ func (s *Server) initRoutes() {
 	s.router.GET("/unmute", s.am.MustAuth(s.UnmuteHandler()))
 	s.router.POST("/unmute", s.am.MustAuth(s.UnmuteHandler()))
 
+	s.router.GET("/bookmark", s.am.MustAuth(s.BookmarkHandler()))
+	s.router.POST("/bookmark", s.am.MustAuth(s.BookmarkHandler()))
+	s.router.GET("/unbookmark", s.am.MustAuth(s.UnbookmarkHandler()))
+	s.router.POST("/unbookmark", s.am.MustAuth(s.UnbookmarkHandler()))
+
 	s.router.GET("/transferFeed/:name", s.TransferFeedHandler())
 	s.router.GET("/transferFeed/:name/:transferTo", s.TransferFeedHandler())
 
@@ -646,7 +666,7 @@ func NewServer(bind string, options ...Option) (*Server, error) {
 		return nil, err
 	}
 
-	templates, err := NewTemplates(config, blogs, cache)
+	templates, err := NewTemplates(config, db, blogs, cache)
 	if err != nil {
 		log.WithError(err).Error("error loading templates")
 		return nil, err
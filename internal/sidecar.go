@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sidecarStore is a small keyed store of pod wide data that belongs to no
+// user or feed (e.g: edit and rename redirects) kept as a JSON object in a
+// file in the data directory. The file is loaded once and cached in memory,
+// changes are written through (atomically) before they are visible and
+// replace the cached values (which are never modified in place).
+type sidecarStore struct {
+	mu     sync.RWMutex
+	path   string
+	loaded bool
+	values map[string]string
+}
+
+var (
+	sidecarsMu sync.Mutex
+	sidecars   = make(map[string]*sidecarStore)
+)
+
+// getSidecar returns the sidecar store kept in the named file of the data
+// directory, stores are shared by path so every user of a file sees the same
+// (cached) values
+func getSidecar(conf *Config, name string) *sidecarStore {
+	fn := filepath.Join(conf.Data, name)
+	if abs, err := filepath.Abs(fn); err == nil {
+		fn = abs
+	}
+
+	sidecarsMu.Lock()
+	defer sidecarsMu.Unlock()
+
+	s, ok := sidecars[fn]
+	if !ok {
+		s = &sidecarStore{path: fn}
+		sidecars[fn] = s
+	}
+	return s
+}
+
+// load reads the store's file if it has not been loaded yet, the caller must
+// hold the write lock
+func (s *sidecarStore) load() error {
+	if s.loaded {
+		return nil
+	}
+
+	values := make(map[string]string)
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Errorf("error reading %s", s.path)
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &values); err != nil {
+			log.WithError(err).Errorf("error decoding %s", s.path)
+			return err
+		}
+	}
+
+	s.values = values
+	s.loaded = true
+
+	return nil
+}
+
+// rlock read locks the store loading it first if needed
+func (s *sidecarStore) rlock() error {
+	s.mu.RLock()
+	if s.loaded {
+		return nil
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	err := s.load()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	return nil
+}
+
+// Get returns the value of key
+func (s *sidecarStore) Get(key string) (string, bool, error) {
+	if err := s.rlock(); err != nil {
+		return "", false, err
+	}
+	defer s.mu.RUnlock()
+
+	value, ok := s.values[key]
+	return value, ok, nil
+}
+
+// Snapshot returns the store's current values without copying them, e.g: to
+// resolve many keys without locking for each. The values must not be
+// modified, they never change once returned as Update replaces them.
+func (s *sidecarStore) Snapshot() (map[string]string, error) {
+	if err := s.rlock(); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	return s.values, nil
+}
+
+// Update calls fn with a copy of the store's values for it to modify and
+// writes them to the store's file, the cached values are only replaced once
+// written successfully
+func (s *sidecarStore) Update(fn func(values map[string]string)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	values := make(map[string]string, len(s.values))
+	for key, value := range s.values {
+		values[key] = value
+	}
+	fn(values)
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		log.WithError(err).Errorf("error creating directory for %s", s.path)
+		return err
+	}
+
+	if err := WriteFileAtomic(s.path, data, 0644); err != nil {
+		log.WithError(err).Errorf("error writing %s", s.path)
+		return err
+	}
+
+	s.values = values
+
+	return nil
+}
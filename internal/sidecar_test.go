@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSidecarStore(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	s := getSidecar(conf, "test.json")
+	assert.Same(s, getSidecar(conf, "test.json"))
+
+	_, ok, err := s.Get("foo")
+	require.NoError(t, err)
+	assert.False(ok)
+
+	require.NoError(t, s.Update(func(values map[string]string) {
+		values["foo"] = "bar"
+		values["baz"] = "qux"
+	}))
+	require.NoError(t, s.Update(func(values map[string]string) {
+		delete(values, "baz")
+	}))
+
+	value, ok, err := s.Get("foo")
+	require.NoError(t, err)
+	assert.True(ok)
+	assert.Equal("bar", value)
+
+	data, err := ioutil.ReadFile(filepath.Join(conf.Data, "test.json"))
+	require.NoError(t, err)
+	assert.JSONEq(`{"foo": "bar"}`, string(data))
+
+	// A fresh store loads the values written
+	fresh := &sidecarStore{path: s.path}
+	values, err := fresh.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(map[string]string{"foo": "bar"}, values)
+
+	// Snapshots are unaffected by later updates
+	require.NoError(t, fresh.Update(func(values map[string]string) {
+		values["foo"] = "baz"
+	}))
+	assert.Equal("bar", values["foo"])
+}
+
+func TestSidecarStoreInvalid(t *testing.T) {
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(conf.Data, "invalid.json"), []byte("{"), 0644))
+
+	s := getSidecar(conf, "invalid.json")
+	_, _, err := s.Get("foo")
+	assert.Error(t, err)
+	assert.Error(t, s.Update(func(values map[string]string) { values["foo"] = "bar" }))
+}
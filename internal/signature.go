@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrFeedNotSigned        = errors.New("error: feed is not signed (unverified)")
+	ErrInvalidFeedSignature = errors.New("error: invalid feed signature")
+)
+
+// StripFeedSignature returns the feed body with any `# sig = ...` metadata
+// lines removed. This is the payload that is signed and verified.
+func StripFeedSignature(body []byte) []byte {
+	var buf bytes.Buffer
+
+	for _, line := range bytes.SplitAfter(body, []byte("\n")) {
+		key, _, ok := ParseMetadataLine(string(bytes.TrimRight(line, "\r\n")))
+		if ok && key == "sig" {
+			continue
+		}
+		buf.Write(line)
+	}
+
+	return buf.Bytes()
+}
+
+// SignFeed signs the feed body (excluding any existing signature) with the
+// given key and returns a `# sig = ...` metadata line (without a trailing
+// newline) that can be added to the feed.
+func SignFeed(key ed25519.PrivateKey, body []byte) string {
+	sig := ed25519.Sign(key, StripFeedSignature(body))
+	return fmt.Sprintf("# sig = %s", base64.StdEncoding.EncodeToString(sig))
+}
+
+// VerifyFeedSignature verifies the feed body against the signature declared
+// in its metadata using the feed's public key. Feeds without a signature are
+// not rejected, instead ErrFeedNotSigned is returned so they can be marked
+// as unverified by the caller.
+func VerifyFeedSignature(meta FeedMetadata, body []byte, key ed25519.PublicKey) error {
+	if meta.Signature == "" {
+		return ErrFeedNotSigned
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(meta.Signature)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return ErrInvalidFeedSignature
+	}
+
+	if !ed25519.Verify(key, StripFeedSignature(body), sig) {
+		return ErrInvalidFeedSignature
+	}
+
+	return nil
+}
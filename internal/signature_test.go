@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	body := []byte("# nick = test\n2020-07-18T12:39:06Z\tHello World!\n")
+	signed := append([]byte(SignFeed(priv, body)+"\n"), body...)
+
+	meta, err := ParseFeedMetadata(bytes.NewReader(signed))
+	require.NoError(t, err)
+	assert.Equal("test", meta.Nick)
+	assert.NotEmpty(meta.Signature)
+
+	assert.NoError(VerifyFeedSignature(*meta, signed, pub))
+
+	tampered := append(signed, []byte("2020-07-18T12:40:00Z\tInjected!\n")...)
+	assert.Equal(ErrInvalidFeedSignature, VerifyFeedSignature(*meta, tampered, pub))
+
+	assert.Equal(ErrFeedNotSigned, VerifyFeedSignature(FeedMetadata{}, body, pub))
+}
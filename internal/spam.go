@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrSpamRejected    = errors.New("error: twt rejected as likely spam")
+	ErrSpamQuarantined = errors.New("error: twt quarantined as likely spam (saved as a draft)")
+)
+
+// SpamClassifier scores the text of twts being posted, returning a score
+// between 0 (certainly not spam) and 1 (certainly spam).
+type SpamClassifier interface {
+	Score(text string) (float64, error)
+}
+
+// noopClassifier is the default SpamClassifier scoring every twt as not spam
+type noopClassifier struct{}
+
+func (noopClassifier) Score(text string) (float64, error) { return 0, nil }
+
+var (
+	spamClassifierMu sync.RWMutex
+	spamClassifier   SpamClassifier = noopClassifier{}
+)
+
+// SetSpamClassifier sets the SpamClassifier consulted by AppendTwt and
+// AppendTwts, nil restores the default which never classifies twts as spam.
+func SetSpamClassifier(classifier SpamClassifier) {
+	spamClassifierMu.Lock()
+	defer spamClassifierMu.Unlock()
+
+	if classifier == nil {
+		classifier = noopClassifier{}
+	}
+	spamClassifier = classifier
+}
+
+// scoreSpam scores text with the configured SpamClassifier giving up after
+// conf.SpamTimeout, so a slow classifier never holds up posting. Twts whose
+// score cannot be determined (errors or timeouts) are treated as not spam.
+func scoreSpam(conf *Config, text string) float64 {
+	spamClassifierMu.RLock()
+	classifier := spamClassifier
+	spamClassifierMu.RUnlock()
+
+	if _, ok := classifier.(noopClassifier); ok {
+		return 0
+	}
+
+	type result struct {
+		score float64
+		err   error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		score, err := classifier.Score(text)
+		ch <- result{score, err}
+	}()
+
+	timeout := conf.SpamTimeout
+	if timeout <= 0 {
+		timeout = DefaultSpamTimeout
+	}
+
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			log.WithError(res.err).Warn("error classifying twt, assuming not spam")
+			return 0
+		}
+		return res.score
+	case <-time.After(timeout):
+		log.Warnf("timed out classifying twt after %s, assuming not spam", timeout)
+		return 0
+	}
+}
+
+// checkSpam rejects text posted by user if its spam score exceeds
+// conf.SpamThreshold returning ErrSpamRejected, or if conf.QuarantineSpam is
+// enabled saves it as one of the user's drafts for them to review and
+// returns ErrSpamQuarantined.
+func checkSpam(conf *Config, db Store, user *User, text string) error {
+	score := scoreSpam(conf, text)
+	if score <= conf.SpamThreshold {
+		return nil
+	}
+
+	log.Warnf("twt by %s scored %.2f as spam (threshold %.2f)", user.Username, score, conf.SpamThreshold)
+
+	if !conf.QuarantineSpam || db == nil {
+		return ErrSpamRejected
+	}
+
+	// The same text is always quarantined as the same draft
+	id := fmt.Sprintf("quarantined-%x", sha256.Sum256([]byte(text)))[:24]
+	if err := SaveDraft(db, user, id, text); err != nil {
+		log.WithError(err).Errorf("error quarantining twt by %s", user.Username)
+		return ErrSpamRejected
+	}
+
+	return ErrSpamQuarantined
+}
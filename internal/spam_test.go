@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSpamClassifier func(text string) (float64, error)
+
+func (f testSpamClassifier) Score(text string) (float64, error) { return f(text) }
+
+func TestCheckSpam(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithSpamTimeout(50*time.Millisecond)(conf))
+
+	SetSpamClassifier(testSpamClassifier(func(text string) (float64, error) {
+		switch {
+		case strings.Contains(text, "viagra"):
+			return 1, nil
+		case strings.Contains(text, "slow"):
+			time.Sleep(time.Second)
+			return 1, nil
+		case strings.Contains(text, "broken"):
+			return 1, errors.New("classifier unavailable")
+		}
+		return 0, nil
+	}))
+	defer SetSpamClassifier(nil)
+
+	user := &User{Username: "spammer"}
+
+	_, err := AppendTwt(conf, nil, user, "Hello World")
+	assert.NoError(err)
+
+	_, err = AppendTwt(conf, nil, user, "Cheap viagra")
+	assert.Equal(ErrSpamRejected, err)
+
+	_, err = AppendTwts(conf, nil, user, []string{"Fine", "More viagra"})
+	assert.True(errors.Is(err, ErrSpamRejected))
+
+	// Slow or failing classifiers never block posting
+	_, err = AppendTwt(conf, nil, user, "A slow twt")
+	assert.NoError(err)
+	_, err = AppendTwt(conf, nil, user, "A broken twt")
+	assert.NoError(err)
+
+	// Edits preserving the original timestamp are not checked
+	_, err = AppendTwt(conf, nil, user, "Edited viagra", time.Now())
+	assert.NoError(err)
+
+	// Nor can posting as a feed (e.g: postas) skip the check
+	_, err = AppendSpecial(conf, nil, "spamfeed", "Feed viagra")
+	assert.Equal(ErrSpamRejected, err)
+	_, err = AppendSpecial(conf, nil, "spamfeed", "Edited feed viagra", time.Now())
+	assert.NoError(err)
+
+	twts, err := GetAllTwts(conf, user.Username)
+	require.NoError(t, err)
+	assert.Len(twts, 4)
+
+	// Quarantined twts are saved (once) as drafts
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, WithQuarantineSpam(true)(conf))
+	for i := 0; i < 2; i++ {
+		_, err = AppendTwt(conf, db, user, "Quarantined viagra")
+		assert.Equal(ErrSpamQuarantined, err)
+	}
+
+	saved, err := db.GetUser(user.Username)
+	require.NoError(t, err)
+	drafts := ListDrafts(saved)
+	require.Len(t, drafts, 1)
+	assert.Equal("Quarantined viagra", drafts[0].Text)
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"net/url"
+	"regexp"
+
+	"github.com/prologic/twtxt/types"
+)
+
+var (
+	validTagNamespace = regexp.MustCompile(`^[-\w]*$`)
+
+	// plainTagRe matches unexpanded tags (#tag) and tagTokenRe expanded tags
+	// (#<tag URL>) in a twt's text
+	plainTagRe = regexp.MustCompile(`#([-\w]+)`)
+	tagTokenRe = regexp.MustCompile(`#<([^ >]+) ([^>]*)>`)
+)
+
+// tagNamespace returns the namespace of the url of an expanded tag (see
+// URLForNamespacedTag), empty for global tags.
+func tagNamespace(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("ns")
+}
+
+// hasTag returns true if the text of twt is tagged with tag in namespace.
+// Unexpanded tags and tags expanded without a namespace (including those
+// from other pods) are global tags.
+func hasTag(twt types.Twt, tag, namespace string) bool {
+	for _, match := range tagTokenRe.FindAllStringSubmatch(twt.Text, -1) {
+		if match[1] == tag && tagNamespace(match[2]) == namespace {
+			return true
+		}
+	}
+
+	if namespace != "" {
+		return false
+	}
+
+	text := tagTokenRe.ReplaceAllString(twt.Text, " ")
+	for _, match := range plainTagRe.FindAllStringSubmatch(text, -1) {
+		if match[1] == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetTwtsByTag returns the twts (without duplicates) tagged with tag in the
+// given namespace, an empty namespace for global tags (see ExpandTag).
+func GetTwtsByTag(twts types.Twts, tag, namespace string) types.Twts {
+	var result types.Twts
+
+	seen := make(map[string]bool)
+	for _, twt := range twts {
+		if !seen[twt.Hash()] && hasTag(twt, tag, namespace) {
+			result = append(result, twt)
+			seen[twt.Hash()] = true
+		}
+	}
+
+	return result
+}
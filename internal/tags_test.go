@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestTagNamespaces(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	// Tags are global by default
+	assert.Equal(
+		"#<go http://0.0.0.0:8000/search?tag=go>",
+		ExpandTag(conf, nil, nil, "#go"),
+	)
+
+	require.NoError(t, WithTagNamespace("gophers")(conf))
+	assert.Equal(
+		"#<go http://0.0.0.0:8000/search?tag=go&ns=gophers>",
+		ExpandTag(conf, nil, nil, "#go"),
+	)
+	assert.Equal(ErrInvalidTagNamespace, WithTagNamespace("not/valid")(conf))
+
+	twter := types.Twter{Nick: "test", URL: "http://0.0.0.0:8000/user/test/twtxt.txt"}
+	now := time.Now()
+
+	scoped := types.Twt{Twter: twter, Created: now, Text: ExpandTag(conf, nil, nil, "Scoped #go")}
+	conf.TagNamespace = ""
+	global := types.Twt{Twter: twter, Created: now, Text: ExpandTag(conf, nil, nil, "Global #go")}
+	plain := types.Twt{Twter: twter, Created: now, Text: "Plain #go"}
+	other := types.Twt{Twter: twter, Created: now, Text: "Other #rust"}
+
+	twts := types.Twts{scoped, global, plain, other, scoped}
+
+	assert.Equal(types.Twts{scoped}, GetTwtsByTag(twts, "go", "gophers"))
+	assert.Equal(types.Twts{global, plain}, GetTwtsByTag(twts, "go", ""))
+	assert.Empty(GetTwtsByTag(twts, "go", "rustaceans"))
+}
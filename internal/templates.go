@@ -28,7 +28,7 @@ type Templates struct {
 	templates map[string]*template.Template
 }
 
-func NewTemplates(conf *Config, blogs *BlogsCache, cache *Cache) (*Templates, error) {
+func NewTemplates(conf *Config, db Store, blogs *BlogsCache, cache *Cache) (*Templates, error) {
 	templates := make(map[string]*template.Template)
 
 	funcMap := sprig.FuncMap()
@@ -37,11 +37,14 @@ func NewTemplates(conf *Config, blogs *BlogsCache, cache *Cache) (*Templates, er
 	funcMap["prettyURL"] = PrettyURL
 	funcMap["isLocalURL"] = IsLocalURLFactory(conf)
 	funcMap["formatTwt"] = FormatTwtFactory(conf)
+	funcMap["twtText"] = TwtDisplayText
 	funcMap["unparseTwt"] = UnparseTwtFactory(conf)
 	funcMap["formatForDateTime"] = FormatForDateTime
+	funcMap["formatForDisplay"] = FormatForDisplay
 	funcMap["urlForBlog"] = URLForBlogFactory(conf, blogs)
 	funcMap["urlForConv"] = URLForConvFactory(conf, cache)
 	funcMap["isAdminUser"] = IsAdminUserFactory(conf)
+	funcMap["resolveTwter"] = ResolveTwterFactory(conf, db)
 
 	box, err := rice.FindBox("templates")
 	if err != nil {
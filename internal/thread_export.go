@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	// threadExportTimeFormat is the format of timestamps in exported threads
+	threadExportTimeFormat = "2006-01-02 15:04 MST"
+)
+
+var (
+	ErrUnknownExportFormat = errors.New("error: unknown export format (expected markdown or html)")
+
+	threadExportTemplate = template.Must(template.New("thread").Parse(`<article class="thread">
+<h1>Thread (#{{ .Hash }})</h1>
+{{ if not .HasRoot }}<p><em>The root twt (#{{ .Hash }}) is not available on this pod.</em></p>
+{{ end }}{{ range .Entries }}{{ if .Placeholder }}<section class="twt unavailable">
+<p><em>The twt (#{{ .InReplyTo }}) replied to is not available on this pod.</em></p>
+</section>
+{{ end }}<section class="twt">
+<p><a href="{{ .Twt.Twter.URL }}">@{{ .Twt.Twter.Nick }}</a> &middot; <time datetime="{{ .Datetime }}">{{ .Time }}</time> &middot; <a href="{{ .Permalink }}">permalink</a></p>
+{{ if .InReplyTo }}<p><small>In reply to <a href="{{ .InReplyToURL }}">#{{ .InReplyTo }}</a></small></p>
+{{ end }}{{ .HTML }}
+</section>
+{{ end }}</article>
+`))
+)
+
+// threadExportEntry is a twt of an exported thread
+type threadExportEntry struct {
+	Twt          types.Twt
+	Time         string
+	Datetime     string
+	Permalink    string
+	InReplyTo    string
+	InReplyToURL string
+	HTML         template.HTML
+
+	// Placeholder is true for the first twt replying to a twt that is not
+	// available locally (see Thread.Missing) which is noted before it
+	Placeholder bool
+}
+
+// ExportThread writes a transcript of the thread rooted at rootHash (see
+// BuildThread) to w as Markdown (format "markdown" or "md") or HTML ("html")
+// e.g: to share a conversation off the pod. Each twt is attributed to its
+// author with its timestamp and permalink, and replies to other replies
+// note the twt they reply to. If the root twt, or any twt replied to, is
+// not available locally (e.g: a remote twt) this is noted in its place.
+func ExportThread(conf *Config, rootHash string, w io.Writer, format string) error {
+	format = strings.ToLower(format)
+	if format != "markdown" && format != "md" && format != "html" {
+		return ErrUnknownExportFormat
+	}
+
+	thread, err := BuildThread(conf, rootHash)
+	if err != nil {
+		return err
+	}
+
+	formatTwt := FormatTwtFactory(conf)
+
+	var twts types.Twts
+	if thread.HasRoot() {
+		twts = append(twts, thread.Root)
+	}
+	twts = append(twts, thread.Replies...)
+
+	missing := make(map[string]bool, len(thread.Missing))
+	for _, hash := range thread.Missing {
+		missing[hash] = true
+	}
+
+	resolve := editsResolver(conf)
+	entries := make([]threadExportEntry, 0, len(twts))
+	for _, twt := range twts {
+		entry := threadExportEntry{
+			Twt:       twt,
+			Time:      twt.Created.UTC().Format(threadExportTimeFormat),
+			Datetime:  twt.Created.Format(time.RFC3339),
+			Permalink: URLForTwt(conf.BaseURL, twt.Hash()),
+		}
+		if twt.IsReply() {
+			if parent := resolve(subjectHash(twt)); parent != thread.Hash {
+				entry.InReplyTo = parent
+				entry.InReplyToURL = URLForTwt(conf.BaseURL, parent)
+				if missing[parent] {
+					entry.Placeholder = true
+					delete(missing, parent)
+				}
+			}
+		}
+		if format == "html" {
+			entry.HTML = formatTwt(TwtDisplayText(twt))
+		}
+		entries = append(entries, entry)
+	}
+
+	if format == "html" {
+		return threadExportTemplate.Execute(w, struct {
+			Hash    string
+			HasRoot bool
+			Entries []threadExportEntry
+		}{thread.Hash, thread.HasRoot(), entries})
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("# Thread (#%s)\n\n", thread.Hash))
+	if !thread.HasRoot() {
+		sb.WriteString(fmt.Sprintf("_The root twt (#%s) is not available on this pod._\n\n", thread.Hash))
+	}
+
+	for _, entry := range entries {
+		if entry.Placeholder {
+			sb.WriteString(fmt.Sprintf("_The twt (#%s) replied to is not available on this pod._\n\n", entry.InReplyTo))
+		}
+		sb.WriteString(fmt.Sprintf(
+			"**[@%s](%s)** · %s · [permalink](%s)\n\n",
+			entry.Twt.Twter.Nick, entry.Twt.Twter.URL, entry.Time, entry.Permalink,
+		))
+		if entry.InReplyTo != "" {
+			sb.WriteString(fmt.Sprintf("_In reply to [#%s](%s)_\n\n", entry.InReplyTo, entry.InReplyToURL))
+		}
+
+		text := FormatMentionsAndTags(conf, TwtDisplayText(entry.Twt), MarkdownFmt)
+		for _, line := range strings.Split(text, "\u2028") {
+			sb.WriteString("> " + line + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,364 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	// maxThreads is the maximum number of threads returned by BuildAllThreads
+	maxThreads = 500
+
+	// maxThreadDepth is the maximum number of subjects followed up a thread by
+	// ThreadCommonAncestor to guard against cycles
+	maxThreadDepth = 64
+)
+
+var (
+	ErrNoCommonAncestor = errors.New("error: twts have no common ancestor")
+)
+
+// Thread is a conversation of a root twt and its replies (oldest first). If
+// the root twt is not in a local feed (e.g: a reply to a remote twt) Root is
+// the zero twt and the thread is rooted at Hash alone. Missing holds the
+// hashes of twts the thread's twts reply to that are not in a local feed
+// (e.g: a remote reply a local root replies to) other than Hash itself.
+type Thread struct {
+	Hash    string
+	Root    types.Twt
+	Replies types.Twts
+	Missing []string
+}
+
+// HasRoot returns true if the thread's root twt is in a local feed
+func (t Thread) HasRoot() bool {
+	return !t.Root.IsZero()
+}
+
+// LastActive returns the thread's most recent twt
+func (t Thread) LastActive() (last types.Twt) {
+	last = t.Root
+	for _, reply := range t.Replies {
+		if reply.Created.After(last.Created) {
+			last = reply
+		}
+	}
+	return
+}
+
+// subjectHash returns the hash of the twt a reply's subject refers to
+func subjectHash(twt types.Twt) string {
+	return strings.TrimSuffix(strings.TrimPrefix(twt.Subject(), "(#"), ")")
+}
+
+// scanFeeds calls fn for every twt in every local feed reading each feed a
+// line at a time.
+func scanFeeds(conf *Config, fn func(twt types.Twt)) error {
+	store := conf.FeedStore()
+
+	names, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		f, err := store.Open(name)
+		if err != nil {
+			conf.feedLog(name).WithError(err).Warn("error opening feed")
+			continue
+		}
+
+		twter := types.Twter{Nick: name, URL: URLForUser(conf, name)}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			twt, err := parseLine(conf, strings.TrimSuffix(scanner.Text(), "\r"), twter)
+			if err != nil || twt.IsZero() {
+				continue
+			}
+			fn(twt)
+		}
+		if err := scanner.Err(); err != nil {
+			conf.feedLog(name).WithError(err).Warn("error reading feed")
+		}
+
+		f.Close()
+	}
+
+	return nil
+}
+
+// BuildAllThreads groups the replies in all local feeds into conversation
+// threads by their subject, most recently active thread first and capped at
+// maxThreads. Only threads with at least one reply are returned. Feeds are
+// streamed twice, first collecting replies and then their root twts, so only
+// replies are ever held in memory.
+func BuildAllThreads(conf *Config) ([]Thread, error) {
+	// Replies to the old hash of an edited twt belong to the edited twt
+	resolve := editsResolver(conf)
+	replies := make(map[string]types.Twts)
+	if err := scanFeeds(conf, func(twt types.Twt) {
+		if twt.IsReply() {
+			hash := resolve(subjectHash(twt))
+			replies[hash] = append(replies[hash], twt)
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	roots := make(map[string]types.Twt)
+	if err := scanFeeds(conf, func(twt types.Twt) {
+		if _, ok := replies[twt.Hash()]; ok {
+			roots[twt.Hash()] = twt
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	threads := make([]Thread, 0, len(replies))
+	for hash, twts := range replies {
+		sort.SliceStable(twts, func(i, j int) bool {
+			return twts[i].Created.Before(twts[j].Created)
+		})
+		threads = append(threads, Thread{Hash: hash, Root: roots[hash], Replies: twts})
+	}
+
+	sort.SliceStable(threads, func(i, j int) bool {
+		return threads[i].LastActive().Created.After(threads[j].LastActive().Created)
+	})
+
+	if len(threads) > maxThreads {
+		threads = threads[:maxThreads]
+	}
+
+	return threads, nil
+}
+
+// ThreadSummary summarizes the replies to a root twt (see TopThreads)
+type ThreadSummary struct {
+	Hash      string
+	Author    types.Twter
+	Replies   int
+	LastReply time.Time
+}
+
+// TopThreads returns the local root twts (twts that are not themselves
+// replies) with the most replies (by subject) within the given window, e.g:
+// for a list of hot conversations. A zero window counts all replies. Ties
+// are broken by the most recent reply. At most limit summaries (capped at
+// maxThreads) are returned. Feeds are streamed only once.
+func TopThreads(conf *Config, window time.Duration, limit int) ([]ThreadSummary, error) {
+	var since time.Time
+	if window > 0 {
+		since = time.Now().Add(-window)
+	}
+
+	var (
+		roots   = make(map[string]types.Twter)
+		replies = make(map[string]*ThreadSummary)
+	)
+	if err := scanFeeds(conf, func(twt types.Twt) {
+		if !twt.IsReply() {
+			roots[twt.Hash()] = twt.Twter
+			return
+		}
+		if twt.Created.Before(since) {
+			return
+		}
+		hash := subjectHash(twt)
+		summary, ok := replies[hash]
+		if !ok {
+			summary = &ThreadSummary{Hash: hash}
+			replies[hash] = summary
+		}
+		summary.Replies++
+		if twt.Created.After(summary.LastReply) {
+			summary.LastReply = twt.Created
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	// Replies to the old hash of an edited twt count towards the edited twt
+	resolve := editsResolver(conf)
+	threads := make(map[string]*ThreadSummary)
+	for hash, summary := range replies {
+		hash = resolve(hash)
+		author, ok := roots[hash]
+		if !ok {
+			continue
+		}
+		thread, ok := threads[hash]
+		if !ok {
+			thread = &ThreadSummary{Hash: hash, Author: author}
+			threads[hash] = thread
+		}
+		thread.Replies += summary.Replies
+		if summary.LastReply.After(thread.LastReply) {
+			thread.LastReply = summary.LastReply
+		}
+	}
+
+	summaries := make([]ThreadSummary, 0, len(threads))
+	for _, thread := range threads {
+		summaries = append(summaries, *thread)
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if summaries[i].Replies != summaries[j].Replies {
+			return summaries[i].Replies > summaries[j].Replies
+		}
+		if !summaries[i].LastReply.Equal(summaries[j].LastReply) {
+			return summaries[i].LastReply.After(summaries[j].LastReply)
+		}
+		return summaries[i].Hash < summaries[j].Hash
+	})
+
+	if limit <= 0 || limit > maxThreads {
+		limit = maxThreads
+	}
+	if len(summaries) > limit {
+		summaries = summaries[:limit]
+	}
+
+	return summaries, nil
+}
+
+// BuildThread returns the thread rooted at the twt with the given hash: the
+// root twt (if local) and every local reply reachable from it by following
+// subjects, including replies to replies, oldest first. Replies to a twt
+// that is not local (e.g: a remote reply) cannot be reached, the subjects
+// of the thread's twts that are not local are noted in the thread's Missing.
+// Feeds are streamed once holding only replies (and the hashes of all twts)
+// in memory. ErrTwtNotFound is returned if neither the root twt nor any
+// reply to it is local.
+func BuildThread(conf *Config, hash string) (Thread, error) {
+	hash = ResolveHash(conf, hash)
+
+	var (
+		root     types.Twt
+		children = make(map[string]types.Twts)
+		local    = make(map[string]bool)
+	)
+	if err := scanFeeds(conf, func(twt types.Twt) {
+		local[twt.Hash()] = true
+		if twt.Hash() == hash {
+			root = twt
+		}
+		if twt.IsReply() {
+			parent := subjectHash(twt)
+			children[parent] = append(children[parent], twt)
+		}
+	}); err != nil {
+		return Thread{}, err
+	}
+
+	// Replies to the old hash of an edited twt are replies to the edited twt
+	resolve := editsResolver(conf)
+	resolved := make(map[string]types.Twts, len(children))
+	for parent, twts := range children {
+		parent = resolve(parent)
+		resolved[parent] = append(resolved[parent], twts...)
+	}
+
+	thread := Thread{Hash: hash, Root: root}
+
+	seen := map[string]bool{hash: true}
+	queue := []string{hash}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, reply := range resolved[parent] {
+			if seen[reply.Hash()] {
+				continue
+			}
+			seen[reply.Hash()] = true
+			thread.Replies = append(thread.Replies, reply)
+			queue = append(queue, reply.Hash())
+		}
+	}
+
+	if !thread.HasRoot() && len(thread.Replies) == 0 {
+		return Thread{}, ErrTwtNotFound
+	}
+
+	sort.SliceStable(thread.Replies, func(i, j int) bool {
+		return thread.Replies[i].Created.Before(thread.Replies[j].Created)
+	})
+
+	missing := make(map[string]bool)
+	for _, twt := range append(types.Twts{thread.Root}, thread.Replies...) {
+		if !twt.IsReply() {
+			continue
+		}
+		parent := resolve(subjectHash(twt))
+		if parent == hash || local[parent] || missing[parent] {
+			continue
+		}
+		missing[parent] = true
+		thread.Missing = append(thread.Missing, parent)
+	}
+
+	return thread, nil
+}
+
+// threadAncestors returns the chain of hashes from hash up its thread by
+// following each twt's subject (nearest first, starting with hash itself).
+// The chain ends at a root twt or at the first subject that cannot be
+// resolved to a local twt (e.g: deleted), which is included as the deepest
+// resolvable ancestor.
+func threadAncestors(parents map[string]string, hash string) []string {
+	var (
+		chain []string
+		seen  = make(map[string]bool)
+	)
+	for i := 0; i < maxThreadDepth && !seen[hash]; i++ {
+		chain = append(chain, hash)
+		seen[hash] = true
+
+		parent, ok := parents[hash]
+		if !ok || parent == hash {
+			break
+		}
+		hash = parent
+	}
+	return chain
+}
+
+// ThreadCommonAncestor returns the hash of the nearest twt both twts (by
+// hash) descend from by walking up the subject of each twt in turn, e.g: to
+// find where a conversation branches. A twt is its own ancestor, so if one
+// twt is an ancestor of the other it is returned. Walks are capped at
+// maxThreadDepth and stop at missing links (see threadAncestors) in which
+// case the missing twt's hash may still be returned if both twts reference
+// it. ErrNoCommonAncestor is returned if the twts share no ancestor.
+func ThreadCommonAncestor(conf *Config, hashA, hashB string) (string, error) {
+	resolve := editsResolver(conf)
+	hashA = resolve(hashA)
+	hashB = resolve(hashB)
+
+	// A single pass over all feeds indexes every twt's subject
+	parents := make(map[string]string)
+	if err := scanFeeds(conf, func(twt types.Twt) {
+		parents[twt.Hash()] = resolve(subjectHash(twt))
+	}); err != nil {
+		return "", err
+	}
+
+	ancestorsA := make(map[string]bool)
+	for _, hash := range threadAncestors(parents, hashA) {
+		ancestorsA[hash] = true
+	}
+
+	for _, hash := range threadAncestors(parents, hashB) {
+		if ancestorsA[hash] {
+			return hash, nil
+		}
+	}
+
+	return "", ErrNoCommonAncestor
+}
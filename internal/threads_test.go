@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestBuildAllThreads(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	alice := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	bob := &User{Username: "bob", URL: URLForUser(conf, "bob")}
+
+	now := time.Now()
+
+	root, err := AppendTwt(conf, nil, alice, "Hello World!", now.Add(-4*time.Hour))
+	require.NoError(t, err)
+	_, err = AppendTwt(conf, nil, alice, "Nobody replies to this", now.Add(-4*time.Hour))
+	require.NoError(t, err)
+
+	reply1, err := AppendTwt(conf, nil, bob, fmt.Sprintf("(#%s) Hi Alice!", root.Hash()), now.Add(-3*time.Hour))
+	require.NoError(t, err)
+	reply2, err := AppendTwt(conf, nil, alice, fmt.Sprintf("(#%s) Hi Bob!", root.Hash()), now.Add(-2*time.Hour))
+	require.NoError(t, err)
+
+	remote, err := AppendTwt(conf, nil, bob, "(#abcdefg) Replying to a remote twt", now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	threads, err := BuildAllThreads(conf)
+	require.NoError(t, err)
+	require.Len(t, threads, 2)
+
+	// Most recently active first
+	assert.Equal("abcdefg", threads[0].Hash)
+	assert.False(threads[0].HasRoot())
+	assert.Equal(remote.Hash(), threads[0].Replies[0].Hash())
+
+	assert.Equal(root.Hash(), threads[1].Hash)
+	assert.True(threads[1].HasRoot())
+	assert.Equal(root.Hash(), threads[1].Root.Hash())
+	require.Len(t, threads[1].Replies, 2)
+	assert.Equal(reply1.Hash(), threads[1].Replies[0].Hash())
+	assert.Equal(reply2.Hash(), threads[1].Replies[1].Hash())
+}
+
+func TestBuildAllThreadsEdited(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithEditRedirects(true)(conf))
+
+	alice := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	bob := &User{Username: "bob", URL: URLForUser(conf, "bob")}
+
+	now := time.Now()
+
+	root, err := AppendTwt(conf, nil, alice, "Hello World!", now.Add(-2*time.Hour))
+	require.NoError(t, err)
+	reply, err := AppendTwt(conf, nil, bob, fmt.Sprintf("(#%s) Hi Alice!", root.Hash()), now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	edited, err := EditTwt(conf, nil, alice, root.Hash(), "Hello Everyone!")
+	require.NoError(t, err)
+
+	threads, err := BuildAllThreads(conf)
+	require.NoError(t, err)
+	require.Len(t, threads, 1)
+
+	assert.Equal(edited.Hash(), threads[0].Hash)
+	assert.True(threads[0].HasRoot())
+	assert.Equal("Hello Everyone!", threads[0].Root.Text)
+	require.Len(t, threads[0].Replies, 1)
+	assert.Equal(reply.Hash(), threads[0].Replies[0].Hash())
+}
+
+func TestThreadCommonAncestor(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	alice := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	bob := &User{Username: "bob", URL: URLForUser(conf, "bob")}
+
+	now := time.Now()
+
+	//   root
+	//   └── branch
+	//       ├── left
+	//       └── right
+	root, err := AppendTwt(conf, nil, alice, "Hello World!", now.Add(-4*time.Hour))
+	require.NoError(t, err)
+	branch, err := AppendTwt(conf, nil, bob, fmt.Sprintf("(#%s) Hi!", root.Hash()), now.Add(-3*time.Hour))
+	require.NoError(t, err)
+	left, err := AppendTwt(conf, nil, alice, fmt.Sprintf("(#%s) Left", branch.Hash()), now.Add(-2*time.Hour))
+	require.NoError(t, err)
+	right, err := AppendTwt(conf, nil, bob, fmt.Sprintf("(#%s) Right", branch.Hash()), now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	hash, err := ThreadCommonAncestor(conf, left.Hash(), right.Hash())
+	require.NoError(t, err)
+	assert.Equal(branch.Hash(), hash)
+
+	hash, err = ThreadCommonAncestor(conf, left.Hash(), root.Hash())
+	require.NoError(t, err)
+	assert.Equal(root.Hash(), hash)
+
+	// Replies to a missing twt still share it as an ancestor
+	a, err := AppendTwt(conf, nil, alice, "(#abcdefg) One", now.Add(-time.Hour))
+	require.NoError(t, err)
+	b, err := AppendTwt(conf, nil, bob, "(#abcdefg) Two", now.Add(-time.Hour))
+	require.NoError(t, err)
+	hash, err = ThreadCommonAncestor(conf, a.Hash(), b.Hash())
+	require.NoError(t, err)
+	assert.Equal("abcdefg", hash)
+
+	_, err = ThreadCommonAncestor(conf, a.Hash(), left.Hash())
+	assert.Equal(ErrNoCommonAncestor, err)
+}
+
+func TestTopThreads(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	alice := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	bob := &User{Username: "bob", URL: URLForUser(conf, "bob")}
+
+	now := time.Now()
+
+	quiet, err := AppendTwt(conf, nil, alice, "Quiet", now.Add(-72*time.Hour))
+	require.NoError(t, err)
+	hot, err := AppendTwt(conf, nil, bob, "Hot", now.Add(-3*time.Hour))
+	require.NoError(t, err)
+
+	// An old reply outside the window only counts without a window
+	for _, reply := range []struct {
+		twt     types.Twt
+		user    *User
+		created time.Time
+	}{
+		{quiet, bob, now.Add(-48 * time.Hour)},
+		{quiet, bob, now.Add(-47 * time.Hour)},
+		{quiet, bob, now.Add(-46 * time.Hour)},
+		{hot, alice, now.Add(-2 * time.Hour)},
+		{hot, bob, now.Add(-time.Hour)},
+	} {
+		_, err := AppendTwt(conf, nil, reply.user, fmt.Sprintf("(#%s) Reply", reply.twt.Hash()), reply.created)
+		require.NoError(t, err)
+	}
+
+	// Replies to remote (or missing) twts are not local threads
+	_, err = AppendTwt(conf, nil, alice, "(#abcdefg) Remote", now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	threads, err := TopThreads(conf, 24*time.Hour, 10)
+	require.NoError(t, err)
+	require.Len(t, threads, 1)
+	assert.Equal(hot.Hash(), threads[0].Hash)
+	assert.Equal(bob.Twter(), threads[0].Author)
+	assert.Equal(2, threads[0].Replies)
+	assert.True(threads[0].LastReply.Equal(now.Add(-time.Hour).Truncate(time.Second)))
+
+	threads, err = TopThreads(conf, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, threads, 1)
+	assert.Equal(quiet.Hash(), threads[0].Hash)
+	assert.Equal(3, threads[0].Replies)
+}
+
+func TestExportThread(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	alice := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	bob := &User{Username: "bob", URL: URLForUser(conf, "bob")}
+
+	now := time.Now()
+
+	root, err := AppendTwt(conf, nil, alice, "Hello World!", now.Add(-3*time.Hour))
+	require.NoError(t, err)
+	reply, err := AppendTwt(conf, nil, bob, fmt.Sprintf("(#%s) Hi Alice!\u2028How are you?", root.Hash()), now.Add(-2*time.Hour))
+	require.NoError(t, err)
+	nested, err := AppendTwt(conf, nil, alice, fmt.Sprintf("(#%s) Great thanks", reply.Hash()), now.Add(-time.Hour))
+	require.NoError(t, err)
+	_, err = AppendTwt(conf, nil, bob, "Unrelated", now)
+	require.NoError(t, err)
+
+	thread, err := BuildThread(conf, root.Hash())
+	require.NoError(t, err)
+	assert.Equal(root.Hash(), thread.Root.Hash())
+	require.Len(t, thread.Replies, 2)
+	assert.Equal(reply.Hash(), thread.Replies[0].Hash())
+	assert.Equal(nested.Hash(), thread.Replies[1].Hash())
+	assert.Empty(thread.Missing)
+
+	var buf strings.Builder
+	require.NoError(t, ExportThread(conf, root.Hash(), &buf, "markdown"))
+	md := buf.String()
+	assert.True(strings.HasPrefix(md, fmt.Sprintf("# Thread (#%s)\n", root.Hash())))
+	assert.Contains(md, fmt.Sprintf("**[@bob](%s)**", bob.URL))
+	assert.Contains(md, "> How are you?\n")
+	assert.Contains(md, fmt.Sprintf("_In reply to [#%s](%s)_", reply.Hash(), URLForTwt(conf.BaseURL, reply.Hash())))
+	assert.True(strings.Index(md, "Hello World!") < strings.Index(md, "Hi Alice!"))
+	assert.True(strings.Index(md, "Hi Alice!") < strings.Index(md, "Great thanks"))
+	assert.NotContains(md, "Unrelated")
+
+	buf.Reset()
+	require.NoError(t, ExportThread(conf, root.Hash(), &buf, "html"))
+	assert.Contains(buf.String(), `<article class="thread">`)
+	assert.Contains(buf.String(), "Great thanks")
+
+	// Edited twts are exported as displayed
+	require.NoError(t, WithMarkEdits(true)(conf))
+	_, err = EditTwt(conf, nil, alice, nested.Hash(), fmt.Sprintf("(#%s) Great thanks!", reply.Hash()))
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, ExportThread(conf, root.Hash(), &buf, "markdown"))
+	assert.Contains(buf.String(), "Great thanks! *(edited)*")
+	assert.NotContains(buf.String(), "(edited 20")
+
+	// Threads rooted at a remote twt note the root is unavailable
+	_, err = AppendTwt(conf, nil, alice, "(#abcdefg) Remote reply", now)
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, ExportThread(conf, "abcdefg", &buf, "md"))
+	assert.Contains(buf.String(), "_The root twt (#abcdefg) is not available on this pod._")
+
+	// As are twts replied to that are unavailable
+	remote, err := AppendTwt(conf, nil, bob, "(#hijklmn) Replying to a remote reply", now)
+	require.NoError(t, err)
+	thread, err = BuildThread(conf, remote.Hash())
+	require.NoError(t, err)
+	assert.Equal([]string{"hijklmn"}, thread.Missing)
+	buf.Reset()
+	require.NoError(t, ExportThread(conf, remote.Hash(), &buf, "md"))
+	assert.Contains(buf.String(), "_The twt (#hijklmn) replied to is not available on this pod._")
+	buf.Reset()
+	require.NoError(t, ExportThread(conf, remote.Hash(), &buf, "html"))
+	assert.Contains(buf.String(), "The twt (#hijklmn) replied to is not available on this pod.")
+
+	assert.Equal(ErrUnknownExportFormat, ExportThread(conf, root.Hash(), &buf, "pdf"))
+	assert.Equal(ErrTwtNotFound, ExportThread(conf, "missing", &buf, "md"))
+}
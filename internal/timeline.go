@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prologic/twtxt/types"
+)
+
+var (
+	ErrInvalidPageCursor = errors.New("error: invalid page cursor")
+)
+
+// EncodePageCursor returns the opaque cursor of the position of twt in a
+// timeline, an unpadded url-safe base64 encoding of "<created> <hash>" with
+// the twt's timestamp in RFC 3339 format (with nanoseconds).
+func EncodePageCursor(twt types.Twt) string {
+	cursor := fmt.Sprintf("%s %s", twt.Created.UTC().Format(time.RFC3339Nano), twt.Hash())
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+// DecodePageCursor returns the timestamp and hash of the twt a cursor
+// returned by EncodePageCursor points to.
+func DecodePageCursor(cursor string) (time.Time, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidPageCursor
+	}
+
+	parts := strings.SplitN(string(data), " ", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return time.Time{}, "", ErrInvalidPageCursor
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", ErrInvalidPageCursor
+	}
+
+	return created, parts[1], nil
+}
+
+// timelineBefore reports whether a twt created at (created, hash) comes
+// before a twt at (otherCreated, otherHash) in a timeline; most recent first
+// with ties broken by hash so the order is stable.
+func timelineBefore(created time.Time, hash string, otherCreated time.Time, otherHash string) bool {
+	if !created.Equal(otherCreated) {
+		return created.After(otherCreated)
+	}
+	return hash > otherHash
+}
+
+// TimelineOption is a function that filters the twts of a timeline built by
+// BuildTimeline
+type TimelineOption func(*timelineOptions)
+
+type timelineOptions struct {
+	exclude func(twt types.Twt) bool
+}
+
+// ExcludeOwnTwts excludes the user's own twts from a timeline, e.g: for users
+// who prefer not to see their own posts on their home timeline. Twts are
+// matched by the (normalized) url of their twter.
+func ExcludeOwnTwts(user *User) TimelineOption {
+	own := NormalizeURL(user.URL)
+	return func(opts *timelineOptions) {
+		opts.exclude = func(twt types.Twt) bool {
+			return own != "" && NormalizeURL(twt.Twter.URL) == own
+		}
+	}
+}
+
+// BuildTimeline returns a page of at most limit twts (most recent first) and
+// the cursor of the next page, or an empty cursor if there are no more twts.
+// An empty before cursor returns the first page, otherwise the page starts
+// with the first twt after the one the cursor points to. Unlike offset based
+// paging, twts added to the timeline between loading pages never cause twts
+// to be repeated or skipped.
+func BuildTimeline(twts types.Twts, before string, limit int, options ...TimelineOption) (types.Twts, string, error) {
+	opts := &timelineOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+
+	sorted := make(types.Twts, 0, len(twts))
+	for _, twt := range twts {
+		if opts.exclude != nil && opts.exclude(twt) {
+			continue
+		}
+		sorted = append(sorted, twt)
+	}
+
+	hashes := make([]string, len(sorted))
+	for i, twt := range sorted {
+		hashes[i] = twt.Hash()
+	}
+	sort.Sort(timelineSorter{sorted, hashes})
+
+	start := 0
+	if before != "" {
+		created, hash, err := DecodePageCursor(before)
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.Search(len(sorted), func(i int) bool {
+			return timelineBefore(created, hash, sorted[i].Created, hashes[i])
+		})
+	}
+
+	end := len(sorted)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+
+	page := sorted[start:end]
+	if end == len(sorted) || len(page) == 0 {
+		return page, "", nil
+	}
+
+	return page, EncodePageCursor(page[len(page)-1]), nil
+}
+
+// timelineSorter sorts twts in timeline order (see timelineBefore)
+type timelineSorter struct {
+	twts   types.Twts
+	hashes []string
+}
+
+func (s timelineSorter) Len() int {
+	return len(s.twts)
+}
+func (s timelineSorter) Less(i, j int) bool {
+	return timelineBefore(s.twts[i].Created, s.hashes[i], s.twts[j].Created, s.hashes[j])
+}
+func (s timelineSorter) Swap(i, j int) {
+	s.twts[i], s.twts[j] = s.twts[j], s.twts[i]
+	s.hashes[i], s.hashes[j] = s.hashes[j], s.hashes[i]
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	assert := assert.New(t)
+
+	twter := types.Twter{Nick: "test", URL: "https://example.com/test/twtxt.txt"}
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var twts types.Twts
+	for i := 0; i < 7; i++ {
+		// Pairs of twts share a timestamp to exercise the tie-breaker
+		created := now.Add(-time.Duration(i/2) * time.Minute)
+		twts = append(twts, types.Twt{Twter: twter, Created: created, Text: fmt.Sprintf("Twt %d", i)})
+	}
+
+	page, cursor, err := BuildTimeline(twts, "", 3)
+	require.NoError(t, err)
+	require.Len(t, page, 3)
+	require.NotEmpty(t, cursor)
+
+	seen := make(map[string]bool)
+	for _, twt := range page {
+		seen[twt.Hash()] = true
+	}
+
+	// New twts arriving between page loads don't shift the next page
+	twts = append(twts,
+		types.Twt{Twter: twter, Created: now.Add(time.Minute), Text: "New 1"},
+		types.Twt{Twter: twter, Created: now.Add(2 * time.Minute), Text: "New 2"},
+	)
+
+	last := page[len(page)-1]
+	for cursor != "" {
+		page, cursor, err = BuildTimeline(twts, cursor, 3)
+		require.NoError(t, err)
+		for _, twt := range page {
+			assert.False(seen[twt.Hash()], "duplicate twt %s", twt.Text)
+			assert.False(twt.Created.After(last.Created))
+			seen[twt.Hash()] = true
+			last = twt
+		}
+	}
+	assert.Len(seen, 7)
+
+	_, _, err = BuildTimeline(twts, "not a cursor", 3)
+	assert.Equal(ErrInvalidPageCursor, err)
+
+	created, hash, err := DecodePageCursor(EncodePageCursor(twts[0]))
+	require.NoError(t, err)
+	assert.True(created.Equal(twts[0].Created))
+	assert.Equal(twts[0].Hash(), hash)
+}
+
+func TestBuildTimelineExcludeOwnTwts(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	// The user's own feed is among their follows under a differently cased url
+	own := types.Twter{Nick: "alice", URL: strings.ToUpper(user.URL[:7]) + user.URL[7:]}
+	other := types.Twter{Nick: "bob", URL: "https://example.com/bob/twtxt.txt"}
+
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	twts := types.Twts{
+		{Twter: own, Created: now, Text: "Mine"},
+		{Twter: other, Created: now.Add(-time.Minute), Text: "Hello"},
+		{Twter: own, Created: now.Add(-2 * time.Minute), Text: "Also mine"},
+		{Twter: other, Created: now.Add(-3 * time.Minute), Text: "Bye"},
+	}
+
+	page, cursor, err := BuildTimeline(twts, "", 10)
+	require.NoError(t, err)
+	assert.Len(page, 4)
+	assert.Empty(cursor)
+
+	page, cursor, err = BuildTimeline(twts, "", 1, ExcludeOwnTwts(user))
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal("Hello", page[0].Text)
+
+	page, cursor, err = BuildTimeline(twts, cursor, 1, ExcludeOwnTwts(user))
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal("Bye", page[0].Text)
+	assert.Empty(cursor)
+}
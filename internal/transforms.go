@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+)
+
+// TextTransform transforms the text of a twt being posted by user, e.g: to
+// auto-tag posts, link bare URLs or mask profanity.
+type TextTransform func(conf *Config, user *User, text string) string
+
+// TransformStage is the stage of posting a TextTransform is applied at
+type TransformStage int
+
+const (
+	// BeforeExpand transforms are applied to the text as written by the user
+	// before any @mentions and #tags are expanded
+	BeforeExpand TransformStage = iota
+	// AfterExpand transforms are applied after @mentions and #tags have been
+	// expanded to their @<nick url> and #<tag url> forms
+	AfterExpand
+)
+
+type textTransform struct {
+	name      string
+	stage     TransformStage
+	priority  int
+	transform TextTransform
+}
+
+var (
+	textTransformsMu sync.RWMutex
+	textTransforms   []textTransform
+)
+
+// RegisterTextTransform registers a named TextTransform applied to twts
+// when they are posted (see AppendTwt) at the given stage. Transforms of a
+// stage are applied in order of priority (lowest first) and then in the
+// order they were registered. Registering a transform with the name of an
+// already registered transform replaces it.
+func RegisterTextTransform(name string, stage TransformStage, priority int, transform TextTransform) {
+	textTransformsMu.Lock()
+	defer textTransformsMu.Unlock()
+
+	textTransforms = removeTextTransform(textTransforms, name)
+	textTransforms = append(textTransforms, textTransform{
+		name:      name,
+		stage:     stage,
+		priority:  priority,
+		transform: transform,
+	})
+
+	sort.SliceStable(textTransforms, func(i, j int) bool {
+		return textTransforms[i].priority < textTransforms[j].priority
+	})
+}
+
+// UnregisterTextTransform removes the named TextTransform
+func UnregisterTextTransform(name string) {
+	textTransformsMu.Lock()
+	defer textTransformsMu.Unlock()
+
+	textTransforms = removeTextTransform(textTransforms, name)
+}
+
+func removeTextTransform(transforms []textTransform, name string) []textTransform {
+	var result []textTransform
+	for _, t := range transforms {
+		if t.name != name {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// applyTextTransforms applies the registered transforms of the given stage
+// to text in order.
+func applyTextTransforms(conf *Config, user *User, stage TransformStage, text string) string {
+	textTransformsMu.RLock()
+	defer textTransformsMu.RUnlock()
+
+	for _, t := range textTransforms {
+		if t.stage == stage {
+			text = t.transform(conf, user, text)
+		}
+	}
+
+	return text
+}
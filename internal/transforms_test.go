@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withTextTransform registers a TextTransform for the duration of a test
+func withTextTransform(t *testing.T, name string, stage TransformStage, priority int, transform TextTransform) {
+	RegisterTextTransform(name, stage, priority, transform)
+	t.Cleanup(func() { UnregisterTextTransform(name) })
+}
+
+func TestTextTransforms(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "test"}
+
+	// Registered out of order, applied by priority
+	withTextTransform(t, "suffix", BeforeExpand, 20, func(conf *Config, user *User, text string) string {
+		return text + " #twtxt"
+	})
+	withTextTransform(t, "mask", BeforeExpand, 10, func(conf *Config, user *User, text string) string {
+		return strings.ReplaceAll(text, "darn", "d**n")
+	})
+	withTextTransform(t, "after", AfterExpand, 0, func(conf *Config, user *User, text string) string {
+		assert.Contains(text, "#<twtxt ")
+		return strings.ToUpper(text[:1]) + text[1:]
+	})
+
+	twt, err := AppendTwt(conf, nil, user, "darn it")
+	require.NoError(t, err)
+	assert.Equal("D**n it #<twtxt "+URLForTag(conf.BaseURL, "twtxt")+">", twt.Text)
+
+	// Replacing a transform by name
+	withTextTransform(t, "mask", BeforeExpand, 10, func(conf *Config, user *User, text string) string {
+		return ""
+	})
+	UnregisterTextTransform("suffix")
+	_, err = AppendTwt(conf, nil, user, "darn it")
+	assert.Error(err)
+}
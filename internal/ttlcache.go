@@ -6,7 +6,7 @@ import (
 )
 
 type CachedItem struct {
-	Value  int
+	Value  interface{}
 	Expiry time.Time
 }
 
@@ -38,7 +38,35 @@ func (cache *TTLCache) Get(k string) int {
 	if !ok {
 		return 0
 	}
-	return v.Value
+	n, _ := v.Value.(int)
+	return n
+}
+
+// GetValue returns the value of k if it is cached and has not expired
+func (cache *TTLCache) GetValue(k string) (interface{}, bool) {
+	cache.RLock()
+	defer cache.RUnlock()
+	v, ok := cache.items[k]
+	if !ok || v.Expired() {
+		return nil, false
+	}
+	return v.Value, true
+}
+
+// SetValue caches v as the value of k for the cache's ttl
+func (cache *TTLCache) SetValue(k string, v interface{}) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.items[k] = CachedItem{v, time.Now().Add(cache.ttl)}
+}
+
+// Del removes k from the cache
+func (cache *TTLCache) Del(k string) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	delete(cache.items, k)
 }
 
 func (cache *TTLCache) Set(k string, v int) int {
@@ -4,17 +4,20 @@ package internal
 
 import (
 	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
-	read_file_last_line "github.com/prologic/read-file-last-line"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/prologic/twtxt/types"
@@ -22,236 +25,1541 @@ import (
 
 const (
 	feedsDir = "feeds"
+
+	// maxListedFeeds is the maximum number of feeds returned by ListFeeds
+	maxListedFeeds = 1000
+)
+
+// SortKey is the key feeds are sorted by (see ListFeeds)
+type SortKey int
+
+const (
+	// SortByName sorts feeds by name
+	SortByName SortKey = iota
+	// SortByCount sorts feeds by number of twts, most first
+	SortByCount
+	// SortByActivity sorts feeds by last activity, most recent first
+	SortByActivity
+)
+
+// FeedSummary is a summary of a local feed (see ListFeeds)
+type FeedSummary struct {
+	Name       string
+	Count      int
+	LastActive time.Time
+}
+
+var (
+	ErrInvalidTwtLine  = errors.New("error: invalid twt line parsed")
+	ErrInvalidFeed     = errors.New("error: erroneous feed detected")
+	ErrTwtNotFound     = errors.New("error: twt not found in feed")
+	ErrDuplicateTwt    = errors.New("error: twt would duplicate an existing twt")
+	ErrRetwtOwnTwt     = errors.New("error: cannot retwt your own twt")
+	ErrTooManyMentions = errors.New("error: twt has too many mentions")
+	ErrTwtTooLong      = errors.New("error: twt with signature is too long")
+	ErrEditDeleted     = errors.New("error: twt deleted by an empty edit")
+	ErrEmptyTwt        = errors.New("cowardly refusing to twt empty text, or only spaces")
+
+	uriRe = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s>]+`)
+
+	commaFractionRe = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}(?::\d{2})?),(\d)`)
+
+	retwtRe = regexp.MustCompile(`^(\(#(?:[a-z0-9]+|<[^>]+>)\)) ♻️ (@<[^ >]+ [^>]+>): (.*)$`)
+
+	twtLineRe = regexp.MustCompile(`^(.+?)(\s+)(.+)$`) // .+? is ungreedy
+
 )
 
-var (
-	ErrInvalidTwtLine = errors.New("error: invalid twt line parsed")
-	ErrInvalidFeed    = errors.New("error: erroneous feed detected")
-)
+// ExpandMentions turns "@nick" into "@<nick URL>" if we're following the user or feed
+// or if they exist on the local pod. Also turns @user@domain into
+// @<user URL> as a convenient way to mention users across pods.
+//
+// A nick that is both followed and a local user or feed expands to the
+// followed feed's URL unless conf.MentionPrecedence is "local" in which case
+// it expands to the local user or feed (see Config.LocalMentionsFirst).
+//
+// At most conf.MaxMentions mentions are expanded (if non-zero), any further
+// mentions are left as text and their number is returned so that callers
+// can reject twts with too many mentions.
+//
+// Mentions in code (see SkipCode) are never expanded.
+func ExpandMentions(conf *Config, db Store, user *User, text string) (string, int) {
+	var (
+		expanded int
+		skipped  int
+	)
+
+	text = SkipCode(text, func(text string) string {
+		// Don't expand mention-like parts of URLs of any scheme (http(s)://,
+		// gemini://, ...) such as https://example.com/@nick
+		var sb strings.Builder
+
+		last := 0
+		for _, loc := range uriRe.FindAllStringIndex(text, -1) {
+			sb.WriteString(expandMentions(conf, db, user, text[last:loc[0]], &expanded, &skipped))
+			sb.WriteString(text[loc[0]:loc[1]])
+			last = loc[1]
+		}
+		sb.WriteString(expandMentions(conf, db, user, text[last:], &expanded, &skipped))
+
+		return sb.String()
+	})
+
+	return text, skipped
+}
+
+func expandMentions(conf *Config, db Store, user *User, text string, expanded, skipped *int) string {
+	re := regexp.MustCompile(`@([\pL\pN][\pL\pN_-]+)(?:@)?((?:[_a-z0-9](?:[_a-z0-9-]{0,61}[a-z0-9]\.)|(?:[0-9]+/[0-9]{2})\.)+(?:[a-z](?:[a-z0-9-]{0,61}[a-z0-9])?)?)?`)
+	return re.ReplaceAllStringFunc(text, func(match string) string {
+		parts := re.FindStringSubmatch(match)
+		mentionedNick := parts[1]
+		mentionedDomain := parts[2]
+
+		// Never expand nicks that could be used to impersonate another user
+		if IsConfusableUsername(mentionedNick) {
+			log.Warnf("not expanding mention of confusable nick %q", mentionedNick)
+			return match
+		}
+
+		var mention string
+
+		if mentionedNick != "" && mentionedDomain != "" {
+			// TODO: Validate the remote end for a valid Twtxt pod?
+			// XXX: Should we always assume https:// ?
+			mention = fmt.Sprintf("@<%s %s>", mentionedNick, LookupRemoteMention(conf, mentionedNick, mentionedDomain))
+		}
+
+		following := func() string {
+			for followedNick, followedURL := range user.Following {
+				if mentionedNick == followedNick {
+					return fmt.Sprintf("@<%s %s>", followedNick, followedURL)
+				}
+			}
+			return ""
+		}
+		local := func() string {
+			username := NormalizeUsername(mentionedNick)
+			if db.HasUser(username) || db.HasFeed(username) {
+				return fmt.Sprintf("@<%s %s>", username, URLForUser(conf, username))
+			}
+			return ""
+		}
+
+		lookups := []func() string{following, local}
+		if conf.LocalMentionsFirst() {
+			lookups = []func() string{local, following}
+		}
+		for _, lookup := range lookups {
+			if mention != "" {
+				break
+			}
+			mention = lookup()
+		}
+
+		// Not expanding if we're not following, not a local user/feed
+		if mention == "" {
+			return match
+		}
+
+		if conf.MaxMentions > 0 && *expanded >= conf.MaxMentions {
+			*skipped++
+			return match
+		}
+		*expanded++
+
+		return mention
+	})
+}
+
+// Turns #tag into "#<tag URL>" except in code (see SkipCode), the URL being
+// scoped to the pod's TagNamespace (if any)
+func ExpandTag(conf *Config, db Store, user *User, text string) string {
+	re := regexp.MustCompile(`#([-\w]+)`)
+	return SkipCode(text, func(text string) string {
+		return re.ReplaceAllStringFunc(text, func(match string) string {
+			parts := re.FindStringSubmatch(match)
+			tag := parts[1]
+
+			return fmt.Sprintf("#<%s %s>", tag, URLForNamespacedTag(conf.BaseURL, conf.TagNamespace, tag))
+		})
+	})
+}
+
+func DeleteLastTwt(conf *Config, user *User) error {
+	if err := conf.FeedStore().Update(user.Username, func(data []byte) ([]byte, error) {
+		_, n, err := ReadLastLine(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	}); err != nil {
+		conf.feedLog(user.Username).WithError(err).Error("error deleting last twt of feed")
+		return err
+	}
+
+	return nil
+}
+
+func AppendSpecial(conf *Config, db Store, specialUsername, text string, args ...interface{}) (types.Twt, error) {
+	user := &User{Username: specialUsername}
+	user.Following = make(map[string]string)
+	return AppendTwt(conf, db, user, text, args...)
+}
+
+// expandTwtText prepares the text of a twt being posted by user applying
+// any registered text transforms (see RegisterTextTransform) around
+// expanding its mentions and tags.
+func expandTwtText(conf *Config, db Store, user *User, text string) (string, error) {
+	text = strings.TrimSpace(applyTextTransforms(conf, user, BeforeExpand, strings.TrimSpace(text)))
+	if text == "" {
+		return "", ErrEmptyTwt
+	}
+
+	text, skipped := ExpandMentions(conf, db, user, text)
+	if skipped > 0 {
+		return "", ErrTooManyMentions
+	}
+
+	text = ExpandTag(conf, db, user, text)
+
+	text = applyTextTransforms(conf, user, AfterExpand, text)
+
+	return SanitizeForFeed(text), nil
+}
+
+// formatTwtLine formats text as a feed line (including its newline) created
+// at the given time (see expandTwtText).
+func formatTwtLine(conf *Config, db Store, user *User, text string, created time.Time) (string, error) {
+	text, err := expandTwtText(conf, db, user, text)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s\t%s%s", created.Format(time.RFC3339), text, conf.EOL()), nil
+}
+
+// appendSignature appends the user's signature (if any) to the text of a
+// twt being posted, before expansion so mentions and tags in the signature
+// are expanded. With a signature the text including the signature must fit
+// within MaxTwtLength or ErrTwtTooLong is returned. Empty text is rejected
+// with ErrEmptyTwt so a signature alone is never posted.
+func appendSignature(conf *Config, user *User, text string) (string, error) {
+	if user.Signature == "" {
+		return text, nil
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return "", ErrEmptyTwt
+	}
+
+	text = fmt.Sprintf("%s %s", strings.TrimSpace(text), user.Signature)
+	if conf.MaxTwtLength > 0 && utf8.RuneCountInString(text) > conf.MaxTwtLength {
+		return "", ErrTwtTooLong
+	}
+
+	return text, nil
+}
+
+func AppendTwt(conf *Config, db Store, user *User, text string, args ...interface{}) (types.Twt, error) {
+	// Support replacing/editing an existing Twt whilst preserving Created Timestamp
+	now := time.Now()
+	isEdit := false
+	if len(args) == 1 {
+		if t, ok := args[0].(time.Time); ok {
+			now = t
+			isEdit = true
+		}
+	}
+	if !isEdit {
+		signed, err := appendSignature(conf, user, text)
+		if err != nil {
+			return types.Twt{}, err
+		}
+		text = signed
+	}
+
+	line, err := formatTwtLine(conf, db, user, text, now)
+	if err != nil {
+		return types.Twt{}, err
+	}
+
+	// Edits (preserving the original timestamp) are not checked for spam
+	// or rate limited
+	if !isEdit {
+		if err := checkSpam(conf, db, user, text); err != nil {
+			return types.Twt{}, err
+		}
+		if err := checkPostRate(conf, user, 1); err != nil {
+			return types.Twt{}, err
+		}
+	}
+
+	store := conf.FeedStore()
+
+	// Ensure we don't append onto the end of a last line missing its newline
+	eol, err := hasTrailingNewline(store, user.Username)
+	if err != nil {
+		conf.feedLog(user.Username).WithError(err).Error("error reading feed")
+		return types.Twt{}, err
+	}
+
+	if !eol {
+		line = conf.EOL() + line
+	}
+
+	if err := store.Append(user.Username, []byte(line)); err != nil {
+		conf.feedLog(user.Username).WithError(err).Error("error appending twt to feed")
+		return types.Twt{}, err
+	}
+
+	getFeedMetrics().Inc(MetricTwtsAppended)
+
+	twt, err := parseLine(conf, strings.TrimSpace(line), user.Twter())
+	if err != nil {
+		conf.feedLog(user.Username).WithError(err).Error("error parsing appended twt")
+		return types.Twt{}, err
+	}
+
+	runAppendHooks(conf, twt)
+
+	return twt, nil
+}
+
+// PreviewExpansion runs the same expansion AppendTwt applies to text posted
+// by user (signature, transforms, mentions and tags) without writing anything
+// returning the text as it would be appended along with the mentions and tags
+// that were recognized, e.g: for a live preview when composing a twt.
+func PreviewExpansion(conf *Config, db Store, user *User, text string) (string, []types.Twter, []string, error) {
+	signed, err := appendSignature(conf, user, text)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	line, err := formatTwtLine(conf, db, user, signed, time.Now())
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	twt, err := parseLine(conf, strings.TrimSpace(line), user.Twter())
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return twt.Text, twt.Mentions(), twt.Tags(), nil
+}
+
+// AppendTwts appends multiple twts to the user's feed in a single write
+// under the feed's lock (see DiskFeedStore) returning the appended twts in
+// order, so no other write to the feed is interleaved with the batch. Either
+// all of the twts are appended or none are; if any of the texts is invalid
+// (e.g: empty or with too many mentions) an error identifying it is returned
+// and the feed is unchanged.
+//
+// All twts are created at the same time, so the texts must be distinct.
+func AppendTwts(conf *Config, db Store, user *User, texts []string) (types.Twts, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+
+	var (
+		buf    strings.Builder
+		lines  []string
+		hashes = make(map[string]bool)
+	)
+
+	for i, text := range texts {
+		text, err := appendSignature(conf, user, text)
+		if err != nil {
+			return nil, fmt.Errorf("error appending twt %d: %w", i+1, err)
+		}
+
+		line, err := formatTwtLine(conf, db, user, text, now)
+		if err != nil {
+			return nil, fmt.Errorf("error appending twt %d: %w", i+1, err)
+		}
+
+		lines = append(lines, strings.TrimSpace(line))
+		buf.WriteString(line)
+	}
+
+	twts := make(types.Twts, 0, len(lines))
+	for i, line := range lines {
+		twt, err := parseLine(conf, line, user.Twter())
+		if err != nil {
+			return nil, fmt.Errorf("error appending twt %d: %w", i+1, err)
+		}
+		if hashes[twt.Hash()] {
+			return nil, fmt.Errorf("error appending twt %d: %w", i+1, ErrDuplicateTwt)
+		}
+		hashes[twt.Hash()] = true
+		twts = append(twts, twt)
+	}
+
+	for i, text := range texts {
+		if err := checkSpam(conf, db, user, text); err != nil {
+			return nil, fmt.Errorf("error appending twt %d: %w", i+1, err)
+		}
+	}
+
+	if err := checkPostRate(conf, user, len(twts)); err != nil {
+		return nil, err
+	}
+
+	store := conf.FeedStore()
+
+	// Ensure we don't append onto the end of a last line missing its newline
+	eol, err := hasTrailingNewline(store, user.Username)
+	if err != nil {
+		conf.feedLog(user.Username).WithError(err).Error("error reading feed")
+		return nil, err
+	}
+
+	data := buf.String()
+	if !eol {
+		data = conf.EOL() + data
+	}
+
+	if err := store.Append(user.Username, []byte(data)); err != nil {
+		conf.feedLog(user.Username).WithError(err).WithField("twts", len(twts)).Error("error appending twts to feed")
+		return nil, err
+	}
+
+	for range twts {
+		getFeedMetrics().Inc(MetricTwtsAppended)
+	}
+
+	runAppendHooks(conf, twts...)
+
+	return twts, nil
+}
+
+// EditTwt replaces the text of the twt identified by hash in the user's feed
+// in place whilst preserving its original Created timestamp. As the twt's
+// hash is derived from its timestamp and text, ErrDuplicateTwt is returned
+// if the edited twt would have the same hash as another twt in the feed
+// (e.g: a twt posted in the same second with the same text). If the pod has
+// EditRedirects enabled references to the old hash are redirected to the
+// edited twt (see ResolveHash).
+//
+// Feeds are not required to be physically sorted by timestamp (readers sort
+// twts by their timestamp, see CanonicalizeFeed to sort a feed) and an edit
+// never reorders a feed: the twt keeps its position and timestamp.
+//
+// Editing a twt to empty text is rejected unless conf.EmptyEditDeletes is
+// enabled in which case the twt is deleted (see DeleteTwt) and
+// ErrEditDeleted is returned so callers know the twt is gone.
+//
+// With conf.MarkEdits enabled an edited marker is appended to the twt's text
+// (which must still fit within MaxTwtLength) and recorded (see EditedAt).
+func EditTwt(conf *Config, db Store, user *User, hash, text string) (types.Twt, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		if !conf.EmptyEditDeletes {
+			return types.Twt{}, ErrEmptyTwt
+		}
+		if err := DeleteTwt(conf, user, hash); err != nil {
+			return types.Twt{}, err
+		}
+		return types.Twt{}, ErrEditDeleted
+	}
+
+	// The marker counts towards the twt's length like a signature does (see
+	// appendSignature)
+	var (
+		editedAt time.Time
+		marker   string
+	)
+	text = types.StripEdited(text)
+	if conf.MarkEdits {
+		editedAt = time.Now()
+		marker = types.EditedMarker(editedAt)
+		if conf.MaxTwtLength > 0 && utf8.RuneCountInString(text+marker) > conf.MaxTwtLength {
+			return types.Twt{}, ErrTwtTooLong
+		}
+	}
+
+	text, err := expandTwtText(conf, db, user, text)
+	if err != nil {
+		return types.Twt{}, err
+	}
+	text += marker
+
+	twter := user.Twter()
+
+	var twt types.Twt
+	if err := conf.FeedStore().Update(user.Username, func(data []byte) ([]byte, error) {
+		lines := strings.Split(string(data), "\n")
+
+		idx := -1
+		hashes := make(map[string]int)
+		for i, line := range lines {
+			twt, err := parseLine(conf, strings.TrimSuffix(line, "\r"), twter)
+			if err != nil || twt.IsZero() {
+				continue
+			}
+			if twt.Hash() == hash && idx == -1 {
+				idx = i
+			}
+			hashes[twt.Hash()] = i
+		}
+		if idx == -1 {
+			return nil, ErrTwtNotFound
+		}
+
+		line := strings.TrimSuffix(lines[idx], "\r")
+		old, _ := parseLine(conf, line, twter)
+
+		newLine := fmt.Sprintf("%s%s", strings.TrimSuffix(line, old.Text), text)
+
+		var err error
+		twt, err = parseLine(conf, newLine, twter)
+		if err != nil {
+			return nil, err
+		}
+
+		if i, ok := hashes[twt.Hash()]; ok && i != idx {
+			return nil, ErrDuplicateTwt
+		}
+
+		if strings.HasSuffix(lines[idx], "\r") {
+			newLine += "\r"
+		}
+
+		lines[idx] = newLine
+
+		return []byte(strings.Join(lines, "\n")), nil
+	}); err != nil {
+		if err != ErrTwtNotFound && err != ErrDuplicateTwt {
+			conf.feedLog(user.Username).WithError(err).WithField("twt", hash).Error("error editing twt in feed")
+		}
+		return types.Twt{}, err
+	}
+
+	if conf.EditRedirects {
+		if err := RecordEdit(conf, hash, twt.Hash()); err != nil {
+			conf.feedLog(user.Username).WithError(err).WithField("twt", hash).Warn("error recording edit of twt")
+		}
+	}
+
+	if marker != "" {
+		if err := recordEditedAt(conf, hash, twt.Hash(), editedAt); err != nil {
+			conf.feedLog(user.Username).WithError(err).WithField("twt", hash).Warn("error recording edited twt")
+		} else {
+			twt.EditedAt = EditedAt(conf, twt)
+		}
+	}
+
+	runAppendHooks(conf, twt)
+
+	return twt, nil
+}
+
+// DeleteTwt removes the twt identified by hash from the user's feed leaving
+// every other line of the feed untouched, ErrTwtNotFound is returned if the
+// feed has no such twt.
+func DeleteTwt(conf *Config, user *User, hash string) error {
+	twter := user.Twter()
+
+	if err := conf.FeedStore().Update(user.Username, func(data []byte) ([]byte, error) {
+		lines := strings.Split(string(data), "\n")
+
+		for i, line := range lines {
+			twt, err := parseLine(conf, strings.TrimSuffix(line, "\r"), twter)
+			if err != nil || twt.IsZero() || twt.Hash() != hash {
+				continue
+			}
+
+			lines = append(lines[:i], lines[i+1:]...)
+			return []byte(strings.Join(lines, "\n")), nil
+		}
+
+		return nil, ErrTwtNotFound
+	}); err != nil {
+		if err != ErrTwtNotFound {
+			conf.feedLog(user.Username).WithError(err).WithField("twt", hash).Error("error deleting twt from feed")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Retwt reposts the original twt to the user's feed as a twt of the form:
+//
+//	(#hash) ♻️ @<nick url>: text
+//
+// where the subject references the original twt and the mention attributes
+// it to its author. ErrRetwtOwnTwt is returned if the original twt is from
+// the user's own feed or any of the feeds they own.
+func Retwt(conf *Config, db Store, user *User, original types.Twt) (types.Twt, error) {
+	if user.Is(original.Twter.URL) {
+		return types.Twt{}, ErrRetwtOwnTwt
+	}
+	for _, feed := range user.Feeds {
+		if NormalizeURL(URLForUser(conf, feed)) == NormalizeURL(original.Twter.URL) {
+			return types.Twt{}, ErrRetwtOwnTwt
+		}
+	}
+
+	text := fmt.Sprintf(
+		"(#%s) ♻️ @<%s %s>: %s",
+		original.Hash(), original.Twter.Nick, original.Twter.URL,
+		original.TextWithoutReplyTargets(),
+	)
+
+	return AppendTwt(conf, db, user, text)
+}
+
+// FormatRetwt formats the text of a retwt (see Retwt) as a Markdown quote
+// of the original twt attributed to its author, text that is not a retwt is
+// returned as is.
+func FormatRetwt(text string) string {
+	match := retwtRe.FindStringSubmatch(text)
+	if match == nil {
+		return text
+	}
+
+	return fmt.Sprintf("%s ♻️ %s\n\n> %s", match[1], match[2], match[3])
+}
+
+func FeedExists(conf *Config, username string) bool {
+	if _, err := conf.FeedStore().Stat(NormalizeUsername(username)); err != nil {
+		if os.IsNotExist(err) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// EnsureUserFeed creates the user's feed with its `# nick` and `# url`
+// metadata header unless the feed already exists, in which case it is left
+// untouched, so it is safe to call repeatedly (e.g: on registration).
+func EnsureUserFeed(conf *Config, db Store, user *User) error {
+	if db.HasFeed(user.Username) {
+		return ErrFeedAlreadyExists
+	}
+
+	url := user.URL
+	if url == "" {
+		url = URLForUser(conf, user.Username)
+	}
+
+	header := fmt.Sprintf(
+		"# nick = %s%s# url = %s%s",
+		user.Username, conf.EOL(), url, conf.EOL(),
+	)
+
+	// The feed is created exclusively so a feed created (e.g: appended to)
+	// concurrently is never clobbered
+	if err := conf.FeedStore().Create(user.Username, []byte(header)); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// FeedETag returns a strong ETag for the named feed derived from its size
+// and modification time, which changes whenever the feed is written to.
+// The feed's Last-Modified is its modification time (see TwtxtHandler).
+func FeedETag(conf *Config, name string) (string, error) {
+	fileInfo, err := conf.FeedStore().Stat(name)
+	if err != nil {
+		return "", err
+	}
+	return feedETag(fileInfo), nil
+}
+
+// FeedChangedSince cheaply reports whether the named feed has changed since
+// a client last saw it, where since is either the hash of the last twt seen
+// or an ETag previously returned by FeedETag. Only the feed's metadata and
+// its last line are read. ErrFeedNotFound is returned if the feed no longer
+// exists so callers can handle deleted feeds.
+func FeedChangedSince(conf *Config, name string, since string) (bool, error) {
+	fileInfo, err := conf.FeedStore().Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, ErrFeedNotFound
+		}
+		return false, err
+	}
+
+	if since == feedETag(fileInfo) {
+		return false, nil
+	}
+
+	twts, err := GetLastNTwts(conf, name, 1)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, ErrFeedNotFound
+		}
+		return false, err
+	}
+
+	if len(twts) == 0 {
+		return since != "", nil
+	}
+
+	return twts[0].Hash() != since, nil
+}
+
+func feedETag(fileInfo os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, fileInfo.Size(), fileInfo.ModTime().UnixNano())
+}
+
+func GetLastTwt(conf *Config, user *User) (twt types.Twt, offset int, err error) {
+	f, err := conf.FeedStore().Open(user.Username)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	data, n, err := ReadLastLine(f, stat.Size())
+	if err != nil {
+		return
+	}
+	offset = int(n)
+
+	// Tolerate feeds written with CRLF line endings
+	twt, err = parseLine(conf, strings.TrimSuffix(string(data), "\r"), user.Twter())
+
+	return
+}
+
+// hasTrailingNewline returns true if the named feed is empty (or does not
+// exist) or its last byte is a newline, false otherwise.
+func hasTrailingNewline(store FeedStore, name string) (bool, error) {
+	f, err := store.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if stat.Size() == 0 {
+		return true, nil
+	}
+
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, stat.Size()-1); err != nil {
+		return false, err
+	}
+
+	return b[0] == '\n', nil
+}
+
+func GetAllFeeds(conf *Config) ([]string, error) {
+	return conf.FeedStore().List()
+}
+
+// ListFeeds returns a summary of every local feed sorted by sortBy, capped
+// at maxListedFeeds. Counts are line counts (see GetFeedCount) and the last
+// activity is the time of the feed's last twt, or when it was last modified
+// if that cannot be parsed, so no feed is ever fully parsed.
+func ListFeeds(conf *Config, sortBy SortKey) ([]FeedSummary, error) {
+	store := conf.FeedStore()
+
+	names, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]FeedSummary, 0, len(names))
+	for _, name := range names {
+		count, err := GetFeedCount(conf, name)
+		if err != nil {
+			conf.feedLog(name).WithError(err).Warn("error counting feed")
+			continue
+		}
+
+		summary := FeedSummary{Name: name, Count: count}
+
+		if twt, _, err := GetLastTwt(conf, &User{Username: name}); err == nil && !twt.IsZero() {
+			summary.LastActive = twt.Created
+		} else if stat, err := store.Stat(name); err == nil {
+			summary.LastActive = stat.ModTime()
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		switch sortBy {
+		case SortByCount:
+			if summaries[i].Count != summaries[j].Count {
+				return summaries[i].Count > summaries[j].Count
+			}
+		case SortByActivity:
+			if !summaries[i].LastActive.Equal(summaries[j].LastActive) {
+				return summaries[i].LastActive.After(summaries[j].LastActive)
+			}
+		}
+		return summaries[i].Name < summaries[j].Name
+	})
+
+	if len(summaries) > maxListedFeeds {
+		summaries = summaries[:maxListedFeeds]
+	}
+
+	return summaries, nil
+}
+
+// readFeed reads the entire contents of the named feed
+func readFeed(store FeedStore, name string) ([]byte, error) {
+	f, err := store.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+func GetFeedCount(conf *Config, name string) (int, error) {
+	f, err := conf.FeedStore().Open(name)
+	if err != nil {
+		conf.feedLog(name).WithError(err).Error("error opening feed")
+		return 0, err
+	}
+	defer f.Close()
+
+	return LineCount(f)
+}
+
+// GetAllTwts returns all twts in the named feed regardless of their age
+// (see GetFeedTwts to split out twts older than the configured FeedTTL).
+func GetAllTwts(conf *Config, name string) (types.Twts, error) {
+	twts, _, err := getFeedTwts(conf, name, 0)
+	return twts, err
+}
+
+// GetFeedTwts returns the fresh twts in the named feed along with any old
+// twts created more than the configured FeedTTL ago. If FeedTTL is zero
+// all twts are considered fresh.
+func GetFeedTwts(conf *Config, name string) (types.Twts, types.Twts, error) {
+	return getFeedTwts(conf, name, conf.FeedTTL)
+}
+
+func getFeedTwts(conf *Config, name string, ttl time.Duration) (types.Twts, types.Twts, error) {
+	defer func(start time.Time) {
+		getFeedMetrics().Observe(MetricFeedReadDuration, time.Since(start).Seconds())
+	}(time.Now())
+
+	twter := types.Twter{
+		Nick: name,
+		URL:  URLForUser(conf, name),
+	}
+	f, err := conf.FeedStore().Open(name)
+	if err != nil {
+		conf.feedLog(name).WithError(err).Warn("error opening feed")
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	twts, old, err := ParseFileContext(context.Background(), conf, s, twter, ttl, 0)
+	if err != nil {
+		conf.feedLog(name).WithError(err).Error("error processing feed")
+		return nil, nil, err
+	}
+
+	return twts, old, nil
+}
+
+// GetTwtsAfterHash returns all twts in the named feed that appear after the
+// twt with the given hash (i.e: newer twts). The feed is read backwards from
+// the end and reading stops as soon as the hash is found.
+// ErrTwtNotFound is returned if the hash could not be found in the feed so
+// clients can fallback to a full resync.
+func GetTwtsAfterHash(conf *Config, name, afterHash string) (types.Twts, error) {
+	twter := types.Twter{
+		Nick: name,
+		URL:  URLForUser(conf, name),
+	}
+	f, err := conf.FeedStore().Open(name)
+	if err != nil {
+		conf.feedLog(name).WithError(err).Warn("error opening feed")
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		conf.feedLog(name).WithError(err).Error("error reading feed")
+		return nil, err
+	}
+
+	var (
+		twts  types.Twts
+		found bool
+	)
+
+	err = ReadLinesReverse(f, stat.Size(), func(line string) bool {
+		twt, err := parseLine(conf, line, twter)
+		if err != nil || twt.IsZero() {
+			return true
+		}
+		if twt.Hash() == afterHash {
+			found = true
+			return false
+		}
+		twts = append(twts, twt)
+		return true
+	})
+	if err != nil {
+		conf.feedLog(name).WithError(err).Error("error processing feed")
+		return nil, err
+	}
+
+	if !found {
+		return nil, ErrTwtNotFound
+	}
+
+	sort.Sort(twts)
+
+	return twts, nil
+}
+
+// GetLastNTwts returns (at most) the last n twts appended to the named feed,
+// most recent first, reading the feed backwards from the end.
+func GetLastNTwts(conf *Config, name string, n int) (types.Twts, error) {
+	twter := types.Twter{
+		Nick: name,
+		URL:  URLForUser(conf, name),
+	}
+	f, err := conf.FeedStore().Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		conf.feedLog(name).WithError(err).Error("error reading feed")
+		return nil, err
+	}
+
+	var twts types.Twts
+
+	err = ReadLinesReverse(f, stat.Size(), func(line string) bool {
+		if len(twts) >= n {
+			return false
+		}
+		twt, err := parseLine(conf, line, twter)
+		if err != nil || twt.IsZero() {
+			return true
+		}
+		twts = append(twts, twt)
+		return true
+	})
+	if err != nil {
+		conf.feedLog(name).WithError(err).Error("error processing feed")
+		return nil, err
+	}
+
+	sort.Sort(twts)
 
-// ExpandMentions turns "@nick" into "@<nick URL>" if we're following the user or feed
-// or if they exist on the local pod. Also turns @user@domain into
-// @<user URL> as a convenient way to mention users across pods.
-func ExpandMentions(conf *Config, db Store, user *User, text string) string {
-	re := regexp.MustCompile(`@([a-zA-Z0-9][a-zA-Z0-9_-]+)(?:@)?((?:[_a-z0-9](?:[_a-z0-9-]{0,61}[a-z0-9]\.)|(?:[0-9]+/[0-9]{2})\.)+(?:[a-z](?:[a-z0-9-]{0,61}[a-z0-9])?)?)?`)
-	return re.ReplaceAllStringFunc(text, func(match string) string {
-		parts := re.FindStringSubmatch(match)
-		mentionedNick := parts[1]
-		mentionedDomain := parts[2]
+	return twts, nil
+}
 
-		if mentionedNick != "" && mentionedDomain != "" {
-			// TODO: Validate the remote end for a valid Twtxt pod?
-			// XXX: Should we always assume https:// ?
-			return fmt.Sprintf(
-				"@<%s https://%s/user/%s/twtxt.txt>",
-				mentionedNick, mentionedDomain, mentionedNick,
-			)
-		}
+// GetFirstNTwts returns (at most) the first n twts of the named feed in the
+// order they appear, oldest first for feeds appended to in order, reading the
+// feed from the start and stopping once n twts have been read. Comments and
+// other lines that are not twts are skipped and not counted.
+func GetFirstNTwts(conf *Config, name string, n int) (types.Twts, error) {
+	twter := types.Twter{
+		Nick: name,
+		URL:  URLForUser(conf, name),
+	}
+	f, err := conf.FeedStore().Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-		for followedNick, followedURL := range user.Following {
-			if mentionedNick == followedNick {
-				return fmt.Sprintf("@<%s %s>", followedNick, followedURL)
-			}
-		}
+	var twts types.Twts
 
-		username := NormalizeUsername(mentionedNick)
-		if db.HasUser(username) || db.HasFeed(username) {
-			return fmt.Sprintf("@<%s %s>", username, URLForUser(conf, username))
+	scanner := bufio.NewScanner(f)
+	for len(twts) < n && scanner.Scan() {
+		twt, err := parseLine(conf, strings.TrimSuffix(scanner.Text(), "\r"), twter)
+		if err != nil || twt.IsZero() {
+			continue
 		}
+		twts = append(twts, twt)
+	}
+	if err := scanner.Err(); err != nil {
+		conf.feedLog(name).WithError(err).Error("error processing feed")
+		return nil, err
+	}
 
-		// Not expanding if we're not following, not a local user/feed
-		return match
-	})
+	return twts, nil
 }
 
-// Turns #tag into "@<tag URL>"
-func ExpandTag(conf *Config, db Store, user *User, text string) string {
-	re := regexp.MustCompile(`#([-\w]+)`)
-	return re.ReplaceAllStringFunc(text, func(match string) string {
-		parts := re.FindStringSubmatch(match)
-		tag := parts[1]
+// twtHeap is a min-heap of twts ordered by their Created timestamp used to
+// keep the newest N twts seen so far.
+type twtHeap types.Twts
 
-		return fmt.Sprintf("#<%s %s>", tag, URLForTag(conf.BaseURL, tag))
-	})
+func (h twtHeap) Len() int           { return len(h) }
+func (h twtHeap) Less(i, j int) bool { return h[i].Created.Before(h[j].Created) }
+func (h twtHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *twtHeap) Push(x interface{}) { *h = append(*h, x.(types.Twt)) }
+func (h *twtHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
 }
 
-func DeleteLastTwt(conf *Config, user *User) error {
-	p := filepath.Join(conf.Data, feedsDir)
-	if err := os.MkdirAll(p, 0755); err != nil {
-		log.WithError(err).Error("error creating feeds directory")
-		return err
+// IsSystemFeed returns true if the named local feed is a system/bot feed such
+// as the pod's special feeds (news, support, ...), its bots or any feed
+// configured as a system feed.
+func IsSystemFeed(conf *Config, name string) bool {
+	name = NormalizeFeedName(name)
+	for _, feeds := range [][]string{specialUsernames, twtxtBots, conf.SystemFeeds} {
+		for _, feed := range feeds {
+			if NormalizeFeedName(feed) == name {
+				return true
+			}
+		}
 	}
+	return false
+}
 
-	fn := filepath.Join(p, user.Username)
+// GetRecentTwts returns the n most recent twts across all local feeds sorted
+// newest first, optionally excluding system/bot feeds (see IsSystemFeed).
+// Feeds are read backwards from their tail and, as twts are appended to
+// feeds in chronological order, reading a feed stops once n twts have been
+// read from it or its twts are older than the oldest twt kept.
+func GetRecentTwts(conf *Config, n int, excludeSystem bool) (types.Twts, error) {
+	if n <= 0 {
+		return nil, nil
+	}
 
-	_, n, err := GetLastTwt(conf, user)
+	feeds, err := GetAllFeeds(conf)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	store := conf.FeedStore()
+	h := &twtHeap{}
+
+	for _, feed := range feeds {
+		if excludeSystem && IsSystemFeed(conf, feed) {
+			continue
+		}
+
+		f, err := store.Open(feed)
+		if err != nil {
+			conf.feedLog(feed).WithError(err).Warn("error opening feed")
+			continue
+		}
+
+		stat, err := f.Stat()
+		if err != nil || stat.IsDir() {
+			f.Close()
+			continue
+		}
+
+		twter := types.Twter{
+			Nick: feed,
+			URL:  URLForUser(conf, feed),
+		}
+
+		count := 0
+		err = ReadLinesReverse(f, stat.Size(), func(line string) bool {
+			twt, err := parseLine(conf, line, twter)
+			if err != nil || twt.IsZero() {
+				return true
+			}
+
+			if h.Len() < n {
+				heap.Push(h, twt)
+			} else if twt.Created.After((*h)[0].Created) {
+				heap.Pop(h)
+				heap.Push(h, twt)
+			} else {
+				return false
+			}
+
+			count++
+			return count < n
+		})
+		f.Close()
+		if err != nil {
+			conf.feedLog(feed).WithError(err).Error("error processing feed")
+		}
 	}
 
-	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	twts := types.Twts(*h)
+	sort.Sort(twts)
+
+	return twts, nil
+}
+
+// RehashFeeds rewrites the subject references of all local twts replying to
+// other local twts after a change of the pod's BaseURL from oldBaseURL to
+// newBaseURL. Since a twt's hash depends on its feed's URL every local twt's
+// hash changes with the BaseURL, and since it also depends on a twt's text
+// rewriting a reply's subject changes its hash too. Twts are therefore
+// processed oldest first across all feeds so the mapping of old to new hashes
+// is complete by the time any replies to a twt are rewritten.
+func RehashFeeds(conf *Config, oldBaseURL, newBaseURL string) error {
+	type entry struct {
+		feed  string
+		line  string
+		twt   types.Twt
+		text  string
+		field string
+	}
+
+	names, err := GetAllFeeds(conf)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	return f.Truncate(int64(n))
-}
+	store := conf.FeedStore()
 
-func AppendSpecial(conf *Config, db Store, specialUsername, text string, args ...interface{}) (types.Twt, error) {
-	user := &User{Username: specialUsername}
-	user.Following = make(map[string]string)
-	return AppendTwt(conf, db, user, text, args)
+	var entries []*entry
+
+	for _, name := range names {
+		data, err := readFeed(store, name)
+		if err != nil {
+			conf.feedLog(name).WithError(err).Error("error reading feed")
+			return err
+		}
+
+		twter := types.Twter{
+			Nick: name,
+			URL:  URLForUser(&Config{BaseURL: oldBaseURL}, name),
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSuffix(line, "\r")
+			twt, err := parseLine(conf, line, twter)
+			if err != nil || twt.IsZero() {
+				continue
+			}
+			entries = append(entries, &entry{
+				feed:  name,
+				line:  line,
+				twt:   twt,
+				text:  twt.Text,
+				field: strings.TrimSuffix(line, twt.Text),
+			})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].twt.Created.Before(entries[j].twt.Created)
+	})
+
+	re := regexp.MustCompile(`\(#<?([a-z0-9]+)(?: [^>]*>)?\)`)
+
+	hashes := make(map[string]string)
+
+	// The lines to rewrite of each feed (old line to new line)
+	changes := make(map[string]map[string]string)
+	for _, e := range entries {
+		// Only the hash is replaced keeping the form of the subject
+		if loc := re.FindStringSubmatchIndex(e.text); loc != nil {
+			if newHash, ok := hashes[e.text[loc[2]:loc[3]]]; ok {
+				e.text = e.text[:loc[2]] + newHash + e.text[loc[3]:]
+			}
+		}
+
+		newTwt := types.Twt{
+			Twter: types.Twter{
+				Nick: e.feed,
+				URL:  URLForUser(&Config{BaseURL: newBaseURL}, e.feed),
+			},
+			Created: e.twt.Created,
+			Text:    e.text,
+		}
+		hashes[e.twt.Hash()] = newTwt.Hash()
+
+		if e.text != e.twt.Text {
+			if changes[e.feed] == nil {
+				changes[e.feed] = make(map[string]string)
+			}
+			changes[e.feed][e.line] = e.field + e.text
+		}
+	}
+
+	// Feeds are rewritten under their lock applying the changes to the feed
+	// as it is now so twts appended in the meantime are kept
+	for name, lines := range changes {
+		if err := store.Update(name, func(data []byte) ([]byte, error) {
+			feed := strings.Split(string(data), "\n")
+			for i, line := range feed {
+				eol := ""
+				if strings.HasSuffix(line, "\r") {
+					eol = "\r"
+				}
+				if newLine, ok := lines[strings.TrimSuffix(line, "\r")]; ok {
+					feed[i] = newLine + eol
+				}
+			}
+			return []byte(strings.Join(feed, "\n")), nil
+		}); err != nil {
+			conf.feedLog(name).WithError(err).Error("error writing feed")
+			return err
+		}
+		conf.feedLog(name).Info("rehashed feed")
+	}
+
+	return nil
 }
 
-func AppendTwt(conf *Config, db Store, user *User, text string, args ...interface{}) (types.Twt, error) {
-	text = strings.TrimSpace(text)
-	if text == "" {
-		return types.Twt{}, fmt.Errorf("cowardly refusing to twt empty text, or only spaces")
+// MergeFeeds merges the twts of the local feed src into the local feed dst
+// (e.g: when consolidating two accounts). The twts of both feeds are written
+// to dst in timestamp order (oldest first) with exact duplicates (same
+// timestamp and text) removed. Comments and metadata of dst are preserved at
+// the top of the feed and those of src are dropped. dst is rewritten
+// atomically and src is removed afterwards if deleteSrc is true.
+//
+// Twts moved from src get new hashes as they now belong to dst; with
+// EditRedirects enabled the old hashes are resolved to the new ones.
+func MergeFeeds(conf *Config, dst, src string, deleteSrc bool) error {
+	type entry struct {
+		line string
+		twt  types.Twt
 	}
 
-	p := filepath.Join(conf.Data, feedsDir)
-	if err := os.MkdirAll(p, 0755); err != nil {
-		log.WithError(err).Error("error creating feeds directory")
-		return types.Twt{}, err
+	if dst == src {
+		return fmt.Errorf("error: cannot merge feed %s into itself", dst)
 	}
 
-	fn := filepath.Join(p, user.Username)
+	store := conf.FeedStore()
+
+	var (
+		header  []string
+		entries []entry
+		seen    = make(map[string]bool)
+		moved   = make(map[string]string)
+	)
 
-	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	srcData, err := readFeed(store, src)
 	if err != nil {
-		return types.Twt{}, err
+		conf.feedLog(src).WithError(err).Error("error reading feed")
+		return err
 	}
-	defer f.Close()
 
-	// Support replacing/editing an existing Twt whilst preserving Created Timestamp
-	now := time.Now()
-	if len(args) == 1 {
-		if t, ok := args[0].(time.Time); ok {
-			now = t
+	if err := store.Update(dst, func(dstData []byte) ([]byte, error) {
+		for _, name := range []string{dst, src} {
+			data := dstData
+			if name == src {
+				data = srcData
+			}
+
+			twter := types.Twter{Nick: name, URL: URLForUser(conf, name)}
+			for _, line := range strings.Split(string(data), "\n") {
+				line = strings.TrimSuffix(line, "\r")
+				if strings.TrimSpace(line) == "" {
+					continue
+				}
+
+				twt, err := parseLine(conf, line, twter)
+				if err != nil || twt.IsZero() {
+					if name == dst {
+						header = append(header, line)
+					}
+					continue
+				}
+
+				if name == src {
+					moved[twt.Hash()] = types.Twt{
+						Twter:   types.Twter{Nick: dst, URL: URLForUser(conf, dst)},
+						Created: twt.Created,
+						Text:    twt.Text,
+					}.Hash()
+				}
+
+				key := fmt.Sprintf("%s\t%s", twt.Created.UTC().Format(time.RFC3339Nano), twt.Text)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				entries = append(entries, entry{line: line, twt: twt})
+			}
 		}
-	}
 
-	line := fmt.Sprintf(
-		"%s\t%s\n",
-		now.Format(time.RFC3339),
-		ExpandTag(conf, db, user, ExpandMentions(conf, db, user, text)),
-	)
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].twt.Created.Before(entries[j].twt.Created)
+		})
 
-	if _, err = f.WriteString(line); err != nil {
-		return types.Twt{}, err
+		var buf strings.Builder
+		for _, line := range header {
+			buf.WriteString(line + conf.EOL())
+		}
+		for _, e := range entries {
+			buf.WriteString(e.line + conf.EOL())
+		}
+
+		return []byte(buf.String()), nil
+	}); err != nil {
+		conf.feedLog(dst).WithError(err).WithField("src", src).Error("error merging feed")
+		return err
 	}
 
-	twt, err := ParseLine(strings.TrimSpace(line), user.Twter())
-	if err != nil {
-		return types.Twt{}, err
+	if conf.EditRedirects && len(moved) > 0 {
+		if err := recordEdits(conf, moved); err != nil {
+			conf.feedLog(src).WithError(err).WithField("dst", dst).Warn("error recording hash redirects for merged feed")
+		}
 	}
 
-	return twt, nil
+	if deleteSrc {
+		if err := store.Remove(src); err != nil {
+			conf.feedLog(src).WithError(err).WithField("dst", dst).Error("error removing merged feed")
+			return err
+		}
+	}
+
+	return nil
 }
 
-func FeedExists(conf *Config, username string) bool {
-	fn := filepath.Join(conf.Data, feedsDir, NormalizeUsername(username))
-	if _, err := os.Stat(fn); err != nil {
-		if os.IsNotExist(err) {
-			return false
+// feedTwtsByHash reads all twts of a feed keyed by their hash
+func feedTwtsByHash(r io.Reader) (map[string]types.Twt, error) {
+	twts := make(map[string]types.Twt)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		twt, err := ParseLine(strings.TrimSuffix(scanner.Text(), "\r"), types.Twter{})
+		if err != nil || twt.IsZero() {
+			continue
 		}
+		twts[twt.Hash()] = twt
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
 
-	return true
+	return twts, nil
 }
 
-func GetLastTwt(conf *Config, user *User) (twt types.Twt, offset int, err error) {
-	p := filepath.Join(conf.Data, feedsDir)
-	if err = os.MkdirAll(p, 0755); err != nil {
-		log.WithError(err).Error("error creating feeds directory")
-		return
+// DiffFeeds compares two versions of a feed by twt hash (regardless of the
+// order of twts) returning the twts added to and removed from the old
+// version, e.g: to detect edits of twts in followed feeds which show as the
+// removal of the original and the addition of the edited twt. Both lists are
+// sorted most recent first.
+func DiffFeeds(old, new io.Reader) (added, removed types.Twts, err error) {
+	oldTwts, err := feedTwtsByHash(old)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	fn := filepath.Join(p, user.Username)
-
-	var data []byte
-	data, offset, err = read_file_last_line.ReadLastLine(fn)
+	newTwts, err := feedTwtsByHash(new)
 	if err != nil {
-		return
+		return nil, nil, err
+	}
+
+	for hash, twt := range newTwts {
+		if _, ok := oldTwts[hash]; !ok {
+			added = append(added, twt)
+		}
+	}
+	for hash, twt := range oldTwts {
+		if _, ok := newTwts[hash]; !ok {
+			removed = append(removed, twt)
+		}
 	}
 
-	twt, err = ParseLine(string(data), user.Twter())
+	sort.Sort(added)
+	sort.Sort(removed)
 
-	return
+	return added, removed, nil
 }
 
-func GetAllFeeds(conf *Config) ([]string, error) {
-	p := filepath.Join(conf.Data, feedsDir)
-	if err := os.MkdirAll(p, 0755); err != nil {
-		log.WithError(err).Error("error creating feeds directory")
-		return nil, err
+// ResolveHashes looks up the local twts with the given hashes scanning each
+// local feed only once regardless of the number of hashes (a twt hash does
+// not identify its feed so every feed is scanned). Hashes of edited twts are
+// resolved to the edited twt (see ResolveHash) but keyed by the requested
+// hash. Hashes that are not found are missing from the returned map.
+func ResolveHashes(conf *Config, hashes []string) (map[string]types.Twt, error) {
+	resolve := editsResolver(conf)
+	wanted := make(map[string][]string, len(hashes))
+	for _, hash := range hashes {
+		resolved := resolve(hash)
+		wanted[resolved] = append(wanted[resolved], hash)
 	}
 
-	files, err := ioutil.ReadDir(p)
-	if err != nil {
-		log.WithError(err).Error("error reading feeds directory")
-		return nil, err
+	twts := make(map[string]types.Twt, len(hashes))
+	if len(wanted) == 0 {
+		return twts, nil
 	}
 
-	fns := []string{}
-	for _, fileInfo := range files {
-		fns = append(fns, filepath.Base(fileInfo.Name()))
+	if err := scanFeeds(conf, func(twt types.Twt) {
+		for _, hash := range wanted[twt.Hash()] {
+			twts[hash] = twt
+		}
+	}); err != nil {
+		return nil, err
 	}
-	return fns, nil
+
+	return twts, nil
 }
 
-func GetFeedCount(conf *Config, name string) (int, error) {
-	p := filepath.Join(conf.Data, feedsDir)
-	if err := os.MkdirAll(p, 0755); err != nil {
-		log.WithError(err).Error("error creating feeds directory")
-		return 0, err
-	}
+type trimOptions struct {
+	archive      Archiver
+	archiveFeeds bool
+	dryRun       bool
+}
 
-	fn := filepath.Join(p, name)
+// TrimOption configures how TrimFeed trims a feed
+type TrimOption func(*trimOptions)
 
-	f, err := os.Open(fn)
-	if err != nil {
-		log.WithError(err).Error("error opening feed file")
-		return 0, err
+// TrimToArchive archives the twts removed by TrimFeed instead of discarding
+// them.
+func TrimToArchive(archive Archiver) TrimOption {
+	return func(opts *trimOptions) {
+		opts.archive = archive
 	}
-	defer f.Close()
-
-	return LineCount(f)
 }
 
-func GetAllTwts(conf *Config, name string) (types.Twts, error) {
-	p := filepath.Join(conf.Data, feedsDir)
-	if err := os.MkdirAll(p, 0755); err != nil {
-		log.WithError(err).Error("error creating feeds directory")
-		return nil, err
+// TrimToArchiveFeeds moves the twts removed by TrimFeed into the feed's
+// monthly archive feeds (see CompactArchives) linked from the feed by its
+// `# prev` pointer instead of discarding them.
+func TrimToArchiveFeeds() TrimOption {
+	return func(opts *trimOptions) {
+		opts.archiveFeeds = true
 	}
+}
 
-	var twts types.Twts
+// TrimDryRun makes TrimFeed only count the twts it would remove leaving the
+// feed untouched.
+func TrimDryRun() TrimOption {
+	return func(opts *trimOptions) {
+		opts.dryRun = true
+	}
+}
 
-	twter := types.Twter{
-		Nick: name,
-		URL:  URLForUser(conf, name),
+// TrimFeed trims the named local feed to its most recent keep twts, removing
+// (or archiving, see TrimToArchive) all older twts and rewriting the feed
+// atomically. Comments and metadata are kept. Unlike archival by FeedTTL the
+// feed is trimmed by count, e.g: for bot feeds that only need their recent
+// status. The number of twts removed is returned.
+func TrimFeed(conf *Config, name string, keep int, options ...TrimOption) (int, error) {
+	opts := &trimOptions{}
+	for _, option := range options {
+		option(opts)
 	}
-	fn := filepath.Join(p, name)
-	f, err := os.Open(fn)
-	if err != nil {
-		log.WithError(err).Warnf("error opening feed: %s", fn)
-		return nil, err
+
+	if keep < 0 {
+		return 0, fmt.Errorf("error: invalid number of twts to keep %d", keep)
 	}
-	s := bufio.NewScanner(f)
-	t, _, err := ParseFile(s, twter, 0, 0)
-	if err != nil {
-		log.WithError(err).Errorf("error processing feed %s", fn)
-		return nil, err
+
+	twter := types.Twter{Nick: name, URL: URLForUser(conf, name)}
+
+	var removed map[int]bool
+	if err := conf.FeedStore().Update(name, func(data []byte) ([]byte, error) {
+		var (
+			lines []string
+			twts  = make(map[int]types.Twt)
+		)
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSuffix(line, "\r")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+
+			if twt, err := parseLine(conf, line, twter); err == nil && !twt.IsZero() {
+				twts[len(lines)] = twt
+			}
+			lines = append(lines, line)
+		}
+
+		if len(twts) <= keep {
+			return nil, nil
+		}
+
+		// Find the oldest twts beyond the most recent keep twts
+		indexes := make([]int, 0, len(twts))
+		for i := range twts {
+			indexes = append(indexes, i)
+		}
+		sort.SliceStable(indexes, func(i, j int) bool {
+			if twts[indexes[i]].Created.Equal(twts[indexes[j]].Created) {
+				return indexes[i] > indexes[j]
+			}
+			return twts[indexes[i]].Created.After(twts[indexes[j]].Created)
+		})
+		removed = make(map[int]bool)
+		for _, i := range indexes[keep:] {
+			removed[i] = true
+		}
+
+		if opts.dryRun {
+			return nil, nil
+		}
+
+		var buf strings.Builder
+		for i, line := range lines {
+			if !removed[i] {
+				buf.WriteString(line + conf.EOL())
+			}
+		}
+
+		// Archive before rewriting the feed so no twt is lost if archiving fails
+		if opts.archive != nil {
+			for i := range removed {
+				if err := opts.archive.Archive(twts[i]); err != nil && err != ErrTwtAlreadyArchived {
+					conf.feedLog(name).WithError(err).WithField("twt", twts[i].Hash()).Error("error archiving twt from feed")
+					return nil, err
+				}
+			}
+		}
+
+		if opts.archiveFeeds {
+			var archived []string
+			for i := range removed {
+				archived = append(archived, lines[i])
+			}
+			prev, err := archiveFeedLines(conf, name, archived)
+			if err != nil {
+				return nil, err
+			}
+			if data := setFeedPrev([]byte(buf.String()), prev, conf.EOL()); data != nil {
+				return data, nil
+			}
+		}
+
+		return []byte(buf.String()), nil
+	}); err != nil {
+		conf.feedLog(name).WithError(err).Error("error trimming feed")
+		return 0, err
 	}
-	twts = append(twts, t...)
-	f.Close()
 
-	return twts, nil
+	return len(removed), nil
 }
 
+// ParseLine parses a single feed line as a twt by twter. Lines that are
+// empty or comments parse as the zero twt. A twt's timestamp and text may be
+// separated by a run of spaces rather than a tab for compatibility with feeds
+// that violate the spec (see parseLine).
 func ParseLine(line string, twter types.Twter) (twt types.Twt, err error) {
+	return parseLine(nil, line, twter)
+}
+
+// parseLine is ParseLine for lines of feeds read by the pod: if
+// conf.StrictSeparator is enabled the timestamp and text of twts must be
+// separated by a tab (possibly padded by spaces) as per the spec, otherwise a
+// run of spaces is also accepted and each such twt parsed is counted by the
+// MetricSpaceSeparators feed metric. conf may be nil.
+func parseLine(conf *Config, line string, twter types.Twter) (twt types.Twt, err error) {
 	if line == "" {
 		return
 	}
@@ -259,8 +1567,7 @@ func ParseLine(line string, twter types.Twter) (twt types.Twt, err error) {
 		return
 	}
 
-	re := regexp.MustCompile(`^(.+?)(\s+)(.+)$`) // .+? is ungreedy
-	parts := re.FindStringSubmatch(line)
+	parts := twtLineRe.FindStringSubmatch(line)
 	// "Submatch 0 is the match of the entire expression, submatch 1 the
 	// match of the first parenthesized subexpression, and so on."
 	if len(parts) != 4 {
@@ -268,36 +1575,82 @@ func ParseLine(line string, twter types.Twter) (twt types.Twt, err error) {
 		return
 	}
 
-	created, err := ParseTime(parts[1])
+	spaceSeparated := !strings.Contains(parts[2], "\t")
+	if spaceSeparated && conf != nil && conf.StrictSeparator {
+		err = ErrInvalidTwtLine
+		return
+	}
+
+	// Tolerate whitespace padding around the timestamp field (e.g: a space
+	// before the tab separator) leaving the text field intact
+	created, err := ParseTime(strings.TrimSpace(parts[1]))
 	if err != nil {
 		err = ErrInvalidTwtLine
 		return
 	}
 
+	if spaceSeparated {
+		getFeedMetrics().Inc(MetricSpaceSeparators)
+	}
+
 	text := parts[3]
 
-	twt = types.Twt{Twter: twter, Created: created, Text: text}
+	twt = types.Twt{
+		Twter:   twter,
+		Created: created,
+		Text:    text,
+		Yarn:    types.ParseYarn(text),
+		Lang:    parseLang(text),
+	}
+
+	if conf != nil {
+		twt.EditedAt = EditedAt(conf, twt)
+	}
+
+	if conf != nil && conf.EnablePolls {
+		twt.Poll = types.ParsePoll(text)
+	}
 
 	return
 }
 
 func ParseFile(scanner *bufio.Scanner, twter types.Twter, ttl time.Duration, N int) (types.Twts, types.Twts, error) {
+	return ParseFileContext(context.Background(), nil, scanner, twter, ttl, N)
+}
+
+// ParseFileContext is like ParseFile but stops parsing as soon as the context
+// is done returning the twts parsed so far along with the context's error,
+// e.g: to bound the time spent parsing a huge feed. Parse errors are logged
+// to conf's logger (see Config.Logger), conf may be nil.
+func ParseFileContext(ctx context.Context, conf *Config, scanner *bufio.Scanner, twter types.Twter, ttl time.Duration, N int) (types.Twts, types.Twts, error) {
 	var (
-		twts types.Twts
-		old  types.Twts
+		twts   types.Twts
+		old    types.Twts
+		ctxErr error
 	)
 
 	oldTime := time.Now().Add(-ttl)
 
+	feedLog := conf.feedLog(twter.URL)
+
 	nLines, nErrors := 0, 0
+	offset := int64(0)
 
 	for scanner.Scan() {
+		if ctxErr = ctx.Err(); ctxErr != nil {
+			break
+		}
+
 		line := scanner.Text()
 		nLines++
+		lineOffset := offset
+		offset += int64(len(line)) + 1
 
-		twt, err := ParseLine(line, twter)
+		twt, err := parseLine(conf, line, twter)
 		if err != nil {
 			nErrors++
+			getFeedMetrics().Inc(MetricParseErrors)
+			feedLog.WithError(err).WithFields(log.Fields{"line": nLines, "offset": lineOffset}).Debug("error parsing feed line")
 			continue
 		}
 		if twt.IsZero() {
@@ -310,12 +1663,15 @@ func ParseFile(scanner *bufio.Scanner, twter types.Twter, ttl time.Duration, N i
 			twts = append(twts, twt)
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, nil, err
+	if ctxErr == nil {
+		if err := scanner.Err(); err != nil {
+			feedLog.WithError(err).WithField("offset", offset).Error("error scanning feed")
+			return nil, nil, err
+		}
 	}
 
-	if (nLines+nErrors > 0) && nLines == nErrors {
-		log.Warnf("erroneous feed dtected (nLines + nErrors > 0 && nLines == nErrors): %d/%d", nLines, nErrors)
+	if ctxErr == nil && (nLines+nErrors > 0) && nLines == nErrors {
+		feedLog.WithFields(log.Fields{"lines": nLines, "errors": nErrors}).Warn("erroneous feed detected (every line is invalid)")
 		return nil, nil, ErrInvalidFeed
 	}
 
@@ -332,12 +1688,24 @@ func ParseFile(scanner *bufio.Scanner, twter types.Twter, ttl time.Duration, N i
 		old = append(old, twts[N:]...)
 	}
 
-	return twts, old, nil
+	return twts, old, ctxErr
 }
 
 func ParseTime(timestr string) (tm time.Time, err error) {
+	tm, _, err = parseTime(timestr)
+	return
+}
+
+// parseTime is like ParseTime but also returns whether or not the timestamp
+// has an explicit timezone (e.g: for LintFeed to warn about it)
+func parseTime(timestr string) (tm time.Time, hasZone bool, err error) {
 	// Twtxt clients generally uses basically time.RFC3339Nano, but sometimes
-	// there's a colon in the timezone, or no timezone at all.
+	// there's a colon in the timezone, or no timezone at all. Times without a
+	// timezone are explicitly parsed as UTC so they order correctly against
+	// times from other feeds. Some feeds use a comma as the decimal separator
+	// of fractional seconds (e.g: 15:04:05,999) which is normalized to a dot.
+	timestr = commaFractionRe.ReplaceAllString(timestr, "$1.$2")
+
 	for _, layout := range []string{
 		"2006-01-02T15:04:05.999999999Z07:00",
 		"2006-01-02T15:04:05.999999999Z0700",
@@ -346,10 +1714,11 @@ func ParseTime(timestr string) (tm time.Time, err error) {
 		"2006-01-02T15:04.999999999Z0700",
 		"2006-01-02T15:04.999999999",
 	} {
-		tm, err = time.Parse(layout, strings.ToUpper(timestr))
+		tm, err = time.ParseInLocation(layout, strings.ToUpper(timestr), time.UTC)
 		if err != nil {
 			continue
 		}
+		hasZone = strings.HasSuffix(layout, "Z07:00") || strings.HasSuffix(layout, "Z0700")
 		return
 	}
 	return
@@ -0,0 +1,1429 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+// newTestConfig returns a Config using a temporary data directory with an
+// empty feeds directory along with a function to clean it up again.
+func newTestConfig(t *testing.T) (*Config, func()) {
+	data, err := ioutil.TempDir("", "twtxt")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(data, feedsDir), 0755))
+
+	conf := NewConfig()
+	conf.Data = data
+	require.NoError(t, WithBaseURL(DefaultBaseURL)(conf))
+
+	return conf, func() { os.RemoveAll(data) }
+}
+
+// newTestFeed copies the named fixture from testdata into the config's
+// feeds directory as the feed name.
+func newTestFeed(t *testing.T, conf *Config, fixture, name string) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(conf.Data, feedsDir, name), data, 0644))
+}
+
+func TestAppendTwtNoTrailingNewline(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	newTestFeed(t, conf, "noeol.txt", "test")
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+
+	lastTwt, _, err := GetLastTwt(conf, user)
+	require.NoError(t, err)
+	assert.Equal("This feed has no trailing newline", lastTwt.Text)
+
+	twt, err := AppendTwt(conf, nil, user, "Hello again!")
+	require.NoError(t, err)
+
+	twts, err := GetAllTwts(conf, "test")
+	require.NoError(t, err)
+	assert.Len(twts, 3)
+
+	lastTwt, _, err = GetLastTwt(conf, user)
+	require.NoError(t, err)
+	assert.Equal(twt.Text, lastTwt.Text)
+	assert.Equal("This feed has no trailing newline", twts[1].Text)
+}
+
+func TestEnsureUserFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	user := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	require.NoError(t, EnsureUserFeed(conf, db, user))
+
+	data, err := readFeed(conf.FeedStore(), "alice")
+	require.NoError(t, err)
+	assert.Equal(fmt.Sprintf("# nick = alice\n# url = %s\n", user.URL), string(data))
+
+	meta, err := ParseFeedMetadata(strings.NewReader(string(data)))
+	require.NoError(t, err)
+	assert.Equal("alice", meta.Nick)
+	assert.Equal(user.URL, meta.URL)
+
+	// Existing feeds are never clobbered
+	_, err = AppendTwt(conf, db, user, "Hello World!")
+	require.NoError(t, err)
+	require.NoError(t, EnsureUserFeed(conf, db, user))
+	twts, err := GetAllTwts(conf, "alice")
+	require.NoError(t, err)
+	assert.Len(twts, 1)
+
+	require.NoError(t, db.SetFeed("news", &Feed{Name: "news"}))
+	assert.Equal(ErrFeedAlreadyExists, EnsureUserFeed(conf, db, &User{Username: "news"}))
+}
+
+func TestAppendTwtSanitized(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+
+	for _, text := range []string{"Hello\tWorld!", "Hello\nWorld!", "Hello\r\nWorld!", "Hello\x00World!"} {
+		_, err := AppendTwt(conf, nil, user, text)
+		require.NoError(t, err)
+	}
+
+	twts, err := GetAllTwts(conf, "test")
+	require.NoError(t, err)
+	require.Len(t, twts, 4)
+
+	var texts []string
+	for _, twt := range twts {
+		texts = append(texts, twt.Text)
+	}
+	assert.ElementsMatch([]string{"Hello World!", "Hello\u2028World!", "Hello\u2028World!", "HelloWorld!"}, texts)
+}
+
+func TestAppendTwtLineEnding(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	assert.Equal(ErrInvalidLineEnding, WithLineEnding("cr")(conf))
+	require.NoError(t, WithLineEnding("CRLF")(conf))
+
+	newTestFeed(t, conf, "noeol.txt", "test")
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+
+	_, err := AppendTwt(conf, nil, user, "Hello")
+	require.NoError(t, err)
+	_, err = AppendTwts(conf, nil, user, []string{"World", "Again"})
+	require.NoError(t, err)
+
+	data, err := readFeed(conf.FeedStore(), "test")
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+	for _, line := range lines[len(lines)-5 : len(lines)-1] {
+		assert.True(strings.HasSuffix(line, "\r\n"), line)
+	}
+
+	twts, err := GetAllTwts(conf, "test")
+	require.NoError(t, err)
+	assert.Len(twts, 5)
+}
+
+func TestAppendTwtSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithMaxTwtLength(20)(conf))
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test"), Signature: "#bot"}
+
+	twt, err := AppendTwt(conf, nil, user, "Hello World!")
+	require.NoError(t, err)
+	assert.Equal(fmt.Sprintf("Hello World! #<bot %s>", URLForTag(conf.BaseURL, "bot")), twt.Text)
+	assert.Equal([]string{"bot"}, twt.Tags())
+
+	// "Hello World, again!" fits but not with the signature
+	_, err = AppendTwt(conf, nil, user, "Hello World, again!")
+	assert.Equal(ErrTwtTooLong, err)
+
+	// A signature alone is not a twt
+	_, err = AppendTwt(conf, nil, user, "  ")
+	assert.Equal(ErrEmptyTwt, err)
+
+	user.Signature = ""
+	twt, err = AppendTwt(conf, nil, user, "Hello World, again!")
+	require.NoError(t, err)
+	assert.Equal("Hello World, again!", twt.Text)
+}
+
+func TestGetTwtsAfterHash(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	twter := types.Twter{Nick: "test", URL: URLForUser(conf, "test")}
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Enough twts (with CRLF line endings) for the feed to span several of
+	// the chunks it is read backwards in
+	var (
+		sb    strings.Builder
+		twts  types.Twts
+		lines []string
+	)
+	for i := 0; i < 2000; i++ {
+		line := fmt.Sprintf("%s\tTwt %d %s", start.Add(time.Duration(i)*time.Minute).Format(time.RFC3339), i, strings.Repeat("x", i%50))
+		twt, err := ParseLine(line, twter)
+		require.NoError(t, err)
+		twts = append(twts, twt)
+		lines = append(lines, line)
+	}
+	sb.WriteString("# nick = test\r\n")
+	sb.WriteString(strings.Join(lines, "\r\n"))
+	require.NoError(t, conf.FeedStore().Write("test", []byte(sb.String())))
+
+	after, err := GetTwtsAfterHash(conf, "test", twts[1500].Hash())
+	require.NoError(t, err)
+	require.Len(t, after, 499)
+	assert.Equal(twts[1999].Hash(), after[0].Hash())
+	assert.Equal(twts[1501].Hash(), after[len(after)-1].Hash())
+	assert.Equal(twts[1999].Text, after[0].Text)
+
+	// The last twt (with no trailing newline) has nothing after it
+	after, err = GetTwtsAfterHash(conf, "test", twts[1999].Hash())
+	require.NoError(t, err)
+	assert.Empty(after)
+
+	after, err = GetTwtsAfterHash(conf, "test", twts[0].Hash())
+	require.NoError(t, err)
+	assert.Len(after, 1999)
+
+	_, err = GetTwtsAfterHash(conf, "test", "abcdefg")
+	assert.Equal(ErrTwtNotFound, err)
+}
+
+func TestAppendTwts(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	newTestFeed(t, conf, "noeol.txt", "test")
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+
+	// All-or-nothing
+	_, err := AppendTwts(conf, nil, user, []string{"Hello", " ", "World"})
+	assert.Error(err)
+	_, err = AppendTwts(conf, nil, user, []string{"Hello", "Hello"})
+	assert.True(errors.Is(err, ErrDuplicateTwt))
+
+	twts, err := GetAllTwts(conf, "test")
+	require.NoError(t, err)
+	assert.Len(twts, 2)
+
+	appended, err := AppendTwts(conf, nil, user, []string{"Hello", "World", "Again"})
+	require.NoError(t, err)
+	require.Len(t, appended, 3)
+	assert.Equal("Hello", appended[0].Text)
+	assert.Equal("Again", appended[2].Text)
+
+	twts, err = GetAllTwts(conf, "test")
+	require.NoError(t, err)
+	assert.Len(twts, 5)
+
+	lastTwt, _, err := GetLastTwt(conf, user)
+	require.NoError(t, err)
+	assert.Equal("Again", lastTwt.Text)
+}
+
+func TestListFeeds(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	now := time.Now()
+	for name, created := range map[string][]time.Time{
+		"alice": {now.Add(-3 * time.Hour)},
+		"bob":   {now.Add(-4 * time.Hour), now.Add(-2 * time.Hour), now.Add(-time.Hour)},
+		"carol": {now.Add(-5 * time.Hour), now.Add(-4 * time.Hour)},
+	} {
+		for _, ts := range created {
+			_, err := AppendTwt(conf, nil, &User{Username: name}, "Hello World!", ts)
+			require.NoError(t, err)
+		}
+	}
+
+	names := func(summaries []FeedSummary) (names []string) {
+		for _, summary := range summaries {
+			names = append(names, summary.Name)
+		}
+		return
+	}
+
+	byName, err := ListFeeds(conf, SortByName)
+	require.NoError(t, err)
+	assert.Equal([]string{"alice", "bob", "carol"}, names(byName))
+	assert.Equal(3, byName[1].Count)
+
+	byCount, err := ListFeeds(conf, SortByCount)
+	require.NoError(t, err)
+	assert.Equal([]string{"bob", "carol", "alice"}, names(byCount))
+
+	byActivity, err := ListFeeds(conf, SortByActivity)
+	require.NoError(t, err)
+	assert.Equal([]string{"bob", "alice", "carol"}, names(byActivity))
+}
+
+func TestParseLineReplyTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	twter := types.Twter{Nick: "test", URL: "https://twtxt.net/user/test/twtxt.txt"}
+	twt, err := ParseLine(
+		"2020-07-18T12:39:06Z\t@<a https://a.com/twtxt.txt> @<b https://b.com/twtxt.txt> @<c https://c.com/twtxt.txt> hello world",
+		twter,
+	)
+	require.NoError(t, err)
+
+	targets := twt.ReplyTargets()
+	assert.Len(targets, 3)
+	assert.Equal([]string{"a", "b", "c"}, []string{targets[0].Nick, targets[1].Nick, targets[2].Nick})
+	assert.Equal("hello world", twt.TextWithoutReplyTargets())
+}
+
+func TestRehashFeeds(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	alice := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	bob := &User{Username: "bob", URL: URLForUser(conf, "bob")}
+
+	now := time.Now().Add(-time.Hour)
+	root, err := AppendTwt(conf, nil, alice, "Hello World!", now)
+	require.NoError(t, err)
+	reply, err := AppendTwt(conf, nil, bob, fmt.Sprintf("(#%s) Hi!", root.Hash()), now.Add(time.Minute))
+	require.NoError(t, err)
+	_, err = AppendTwt(conf, nil, alice, fmt.Sprintf("(#%s) Hi again!", reply.Hash()), now.Add(2*time.Minute))
+	require.NoError(t, err)
+	_, err = AppendTwt(conf, nil, bob, fmt.Sprintf("(#<%s https://example.com/search?tag=%s>) Linked", root.Hash(), root.Hash()), now.Add(3*time.Minute))
+	require.NoError(t, err)
+
+	newBaseURL := "https://twtxt.example.com"
+	require.NoError(t, RehashFeeds(conf, conf.BaseURL, newBaseURL))
+	require.NoError(t, WithBaseURL(newBaseURL)(conf))
+
+	aliceTwts, err := GetAllTwts(conf, "alice")
+	require.NoError(t, err)
+	bobTwts, err := GetAllTwts(conf, "bob")
+	require.NoError(t, err)
+	require.Len(t, bobTwts, 2)
+
+	newRoot, newReply, newReplyReply := aliceTwts[1], bobTwts[1], aliceTwts[0]
+	assert.Equal(fmt.Sprintf("(#%s)", newRoot.Hash()), newReply.Subject())
+	assert.Equal(fmt.Sprintf("(#%s)", newReply.Hash()), newReplyReply.Subject())
+	assert.NotEqual(root.Hash(), newRoot.Hash())
+
+	// The form of subjects is kept, only the hash is replaced
+	assert.Equal(fmt.Sprintf("(#<%s https://example.com/search?tag=%s>) Linked", newRoot.Hash(), root.Hash()), bobTwts[0].Text)
+}
+
+func TestEditTwt(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+
+	now := time.Now()
+	foo, err := AppendTwt(conf, nil, user, "foo", now)
+	require.NoError(t, err)
+	bar, err := AppendTwt(conf, nil, user, "bar", now)
+	require.NoError(t, err)
+
+	t.Run("PreservesTimestamp", func(t *testing.T) {
+		twt, err := EditTwt(conf, nil, user, bar.Hash(), "baz")
+		require.NoError(t, err)
+		assert.Equal("baz", twt.Text)
+		assert.True(bar.Created.Equal(twt.Created))
+
+		lastTwt, _, err := GetLastTwt(conf, user)
+		require.NoError(t, err)
+		assert.Equal(twt.Hash(), lastTwt.Hash())
+		bar = twt
+	})
+
+	t.Run("DuplicateTimestampAndText", func(t *testing.T) {
+		// Both twts were posted in the same second, so editing one to have
+		// the same text as the other would make their hashes ambiguous.
+		_, err := EditTwt(conf, nil, user, bar.Hash(), "foo")
+		assert.Equal(ErrDuplicateTwt, err)
+
+		twts, err := GetAllTwts(conf, "test")
+		require.NoError(t, err)
+		assert.Len(twts, 2)
+		assert.ElementsMatch([]string{foo.Hash(), bar.Hash()}, []string{twts[0].Hash(), twts[1].Hash()})
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := EditTwt(conf, nil, user, "invalid", "foo")
+		assert.Equal(ErrTwtNotFound, err)
+	})
+}
+
+func TestGetFirstNTwts(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	require.NoError(t, conf.FeedStore().Write("test", []byte(
+		"# nick = test\n"+
+			"2020-01-01T00:00:00Z\tFirst\n"+
+			"# a comment between twts\n"+
+			"not a twt\n"+
+			"2020-01-02T00:00:00Z\tSecond\r\n"+
+			"2020-01-03T00:00:00Z\tThird\n",
+	)))
+
+	twts, err := GetFirstNTwts(conf, "test", 2)
+	require.NoError(t, err)
+	require.Len(t, twts, 2)
+	assert.Equal("First", twts[0].Text)
+	assert.Equal("Second", twts[1].Text)
+
+	twts, err = GetFirstNTwts(conf, "test", 10)
+	require.NoError(t, err)
+	assert.Len(twts, 3)
+
+	twts, err = GetFirstNTwts(conf, "test", 0)
+	require.NoError(t, err)
+	assert.Empty(twts)
+
+	_, err = GetFirstNTwts(conf, "unknown", 1)
+	assert.Error(err)
+}
+
+func TestEditTwtMarkEdits(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithMarkEdits(true)(conf))
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+
+	original, err := AppendTwt(conf, nil, user, "Hello Wrold!", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.True(original.EditedAt.IsZero())
+
+	edited, err := EditTwt(conf, nil, user, original.Hash(), "Hello World!")
+	require.NoError(t, err)
+	assert.False(edited.EditedAt.IsZero())
+	assert.True(strings.HasPrefix(edited.Text, "Hello World! (edited "))
+
+	// The edited-at marker round-trips through the feed
+	twts, err := GetLastNTwts(conf, user.Username, 1)
+	require.NoError(t, err)
+	require.Len(t, twts, 1)
+	assert.Equal(edited.Hash(), twts[0].Hash())
+	assert.True(edited.EditedAt.Equal(twts[0].EditedAt))
+
+	// Editing an edited twt (e.g: its text prefilled with the marker) replaces
+	// the marker rather than adding another
+	again, err := EditTwt(conf, nil, user, edited.Hash(), strings.Replace(edited.Text, "World!", "World!!", 1))
+	require.NoError(t, err)
+	assert.Equal("Hello World!!", types.StripEdited(again.Text))
+	assert.Equal(1, strings.Count(again.Text, "(edited "))
+
+	html := string(FormatTwtFactory(conf)(TwtDisplayText(again)))
+	assert.Contains(html, "<em>(edited)</em>")
+	assert.NotContains(html, again.EditedAt.Format(time.RFC3339))
+
+	// Markers not written by EditTwt are not honoured
+	forged, err := AppendTwt(conf, nil, user, "Never edited"+types.EditedMarker(time.Now()))
+	require.NoError(t, err)
+	assert.True(forged.EditedAt.IsZero())
+	assert.Equal(forged.Text, TwtDisplayText(forged))
+
+	twts, err = GetAllTwts(conf, user.Username)
+	require.NoError(t, err)
+	for _, twt := range twts {
+		assert.Equal(twt.Hash() == again.Hash(), !twt.EditedAt.IsZero(), twt.Text)
+	}
+
+	// The marker counts towards the twt's length
+	require.NoError(t, WithMaxTwtLength(utf8.RuneCountInString(again.Text)-1)(conf))
+	_, err = EditTwt(conf, nil, user, again.Hash(), strings.Repeat("x", utf8.RuneCountInString(again.Text)-len(types.EditedMarker(time.Now()))))
+	assert.Equal(ErrTwtTooLong, err)
+}
+
+func TestEditTwtEmptyText(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+
+	now := time.Now()
+	foo, err := AppendTwt(conf, nil, user, "foo", now.Add(-time.Minute))
+	require.NoError(t, err)
+	bar, err := AppendTwt(conf, nil, user, "bar", now)
+	require.NoError(t, err)
+
+	// Empty edits are rejected by default
+	_, err = EditTwt(conf, nil, user, foo.Hash(), "  ")
+	assert.Error(err)
+	assert.NotEqual(ErrEditDeleted, err)
+
+	twts, err := GetAllTwts(conf, "test")
+	require.NoError(t, err)
+	assert.Len(twts, 2)
+
+	// ... or delete the twt when enabled
+	require.NoError(t, WithEmptyEditDeletes(true)(conf))
+	_, err = EditTwt(conf, nil, user, foo.Hash(), "")
+	assert.Equal(ErrEditDeleted, err)
+
+	twts, err = GetAllTwts(conf, "test")
+	require.NoError(t, err)
+	require.Len(t, twts, 1)
+	assert.Equal(bar.Hash(), twts[0].Hash())
+
+	_, err = EditTwt(conf, nil, user, foo.Hash(), "")
+	assert.Equal(ErrTwtNotFound, err)
+}
+
+func TestEditTwtUnsortedFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "unsorted", URL: URLForUser(conf, "unsorted")}
+
+	store := conf.FeedStore()
+	require.NoError(t, store.Write("unsorted", []byte(
+		"2020-01-02T00:00:00Z\tSecond\n"+
+			"2020-01-01T00:00:00Z\tFirst\n"+
+			"2020-01-03T00:00:00Z\tThird\n",
+	)))
+
+	twts, err := GetAllTwts(conf, "unsorted")
+	require.NoError(t, err)
+	require.Len(t, twts, 3)
+
+	// The edited twt keeps its position and timestamp
+	_, err = EditTwt(conf, nil, user, twts[2].Hash(), "First (edited)")
+	require.NoError(t, err)
+
+	data, err := readFeed(store, "unsorted")
+	require.NoError(t, err)
+	assert.Equal(
+		"2020-01-02T00:00:00Z\tSecond\n"+
+			"2020-01-01T00:00:00Z\tFirst (edited)\n"+
+			"2020-01-03T00:00:00Z\tThird\n",
+		string(data),
+	)
+}
+
+func TestGetRecentTwts(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	alice := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	bob := &User{Username: "bob", URL: URLForUser(conf, "bob")}
+
+	now := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		_, err := AppendTwt(conf, nil, alice, fmt.Sprintf("alice %d", i), now.Add(time.Duration(2*i)*time.Minute))
+		require.NoError(t, err)
+		_, err = AppendTwt(conf, nil, bob, fmt.Sprintf("bob %d", i), now.Add(time.Duration(2*i+1)*time.Minute))
+		require.NoError(t, err)
+	}
+
+	twts, err := GetRecentTwts(conf, 3, false)
+	require.NoError(t, err)
+	require.Len(t, twts, 3)
+	assert.Equal("bob 4", twts[0].Text)
+	assert.Equal("alice 4", twts[1].Text)
+	assert.Equal("bob 3", twts[2].Text)
+
+	twts, err = GetRecentTwts(conf, 100, false)
+	require.NoError(t, err)
+	assert.Len(twts, 10)
+
+	_, err = AppendSpecial(conf, nil, newsSpecialUser, "Pod news!")
+	require.NoError(t, err)
+
+	twts, err = GetRecentTwts(conf, 1, false)
+	require.NoError(t, err)
+	assert.Equal("Pod news!", twts[0].Text)
+
+	twts, err = GetRecentTwts(conf, 1, true)
+	require.NoError(t, err)
+	assert.Equal("bob 4", twts[0].Text)
+}
+
+func TestParseTimeWithoutTimezone(t *testing.T) {
+	assert := assert.New(t)
+
+	zoneless, err := ParseTime("2020-07-18T12:00:00")
+	require.NoError(t, err)
+	utc, err := ParseTime("2020-07-18T12:00:00Z")
+	require.NoError(t, err)
+
+	assert.True(zoneless.Equal(utc))
+	assert.Equal(time.UTC, zoneless.Location())
+
+	fractional, err := ParseTime("2020-07-18T12:00:00.5")
+	require.NoError(t, err)
+	assert.True(fractional.After(utc))
+
+	before, err := ParseTime("2020-07-18T11:59:59Z")
+	require.NoError(t, err)
+	after, err := ParseTime("2020-07-18T12:00:01Z")
+	require.NoError(t, err)
+
+	twts := types.Twts{
+		{Text: "after", Created: after},
+		{Text: "zoneless", Created: zoneless},
+		{Text: "before", Created: before},
+	}
+	sort.Sort(twts)
+	assert.Equal([]string{"after", "zoneless", "before"}, []string{twts[0].Text, twts[1].Text, twts[2].Text})
+}
+
+func TestParseTimeCommaFraction(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := os.Open(filepath.Join("testdata", "commafrac.txt"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	twts, _, err := ParseFile(bufio.NewScanner(f), types.Twter{Nick: "test"}, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, twts, 4)
+
+	expected := map[string]time.Time{
+		"Half a second":              time.Date(2020, 7, 18, 12, 39, 6, 500000000, time.UTC),
+		"Nanoseconds with an offset": time.Date(2020, 7, 18, 10, 39, 7, 123456789, time.UTC),
+		"No seconds":                 time.Date(2020, 7, 18, 12, 40, 0, 250000000, time.UTC),
+		"No timezone":                time.Date(2020, 7, 18, 12, 41, 0, 750000000, time.UTC),
+	}
+	for _, twt := range twts {
+		assert.True(expected[twt.Text].Equal(twt.Created), twt.Text)
+	}
+
+	// Commas are only accepted as the fractional seconds separator
+	_, err = ParseTime("2020-07-18,12:39:06Z")
+	assert.Error(err)
+}
+
+func TestParseLinePaddedSeparator(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := os.Open(filepath.Join("testdata", "padded.txt"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	twts, _, err := ParseFile(bufio.NewScanner(f), types.Twter{Nick: "test"}, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, twts, 4)
+
+	expected := []struct {
+		text    string
+		created time.Time
+	}{
+		{"Leading tab", time.Date(2020, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{"Padded on both sides  with  internal  spacing", time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"Space after the tab", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"Space before the tab", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for i, twt := range twts {
+		assert.Equal(expected[i].text, twt.Text)
+		assert.True(expected[i].created.Equal(twt.Created), twt.Text)
+	}
+}
+
+type countingFeedMetrics map[string]int
+
+func (m countingFeedMetrics) Inc(name string)                    { m[name]++ }
+func (m countingFeedMetrics) Observe(name string, value float64) {}
+
+func TestParseLineSeparators(t *testing.T) {
+	metrics := countingFeedMetrics{}
+	SetFeedMetrics(metrics)
+	defer SetFeedMetrics(nil)
+
+	conf := NewConfig()
+
+	parse := func(fixture string) types.Twts {
+		f, err := os.Open(filepath.Join("testdata", fixture))
+		require.NoError(t, err)
+		defer f.Close()
+
+		twts, _, err := ParseFileContext(context.Background(), conf, bufio.NewScanner(f), types.Twter{Nick: "test"}, 0, 0)
+		require.NoError(t, err)
+		return twts
+	}
+
+	t.Run("Lenient", func(t *testing.T) {
+		assert := assert.New(t)
+
+		spaces, tabs := parse("spaces.txt"), parse("tabs.txt")
+		require.Len(t, spaces, 3)
+		require.Len(t, tabs, 3)
+		for i := range spaces {
+			assert.Equal(tabs[i].Text, spaces[i].Text)
+			assert.True(tabs[i].Created.Equal(spaces[i].Created))
+		}
+		assert.Equal("Second twt with a\ttab", spaces[1].Text)
+		assert.Equal(3, metrics[MetricSpaceSeparators])
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		assert := assert.New(t)
+
+		require.NoError(t, WithStrictSeparator(true)(conf))
+		delete(metrics, MetricSpaceSeparators)
+
+		assert.Len(parse("tabs.txt"), 3)
+		assert.Len(parse("padded.txt"), 4)
+		assert.Len(parse("spaces.txt"), 0)
+		assert.Equal(3, metrics[MetricParseErrors])
+		assert.Zero(metrics[MetricSpaceSeparators])
+	})
+}
+
+func TestParseLinePolls(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := NewConfig()
+	line := "2020-01-01T00:00:00Z\tpoll: Tabs or spaces? | tabs | spaces"
+
+	// Polls are off by default
+	twt, err := parseLine(conf, line, types.Twter{Nick: "test"})
+	require.NoError(t, err)
+	assert.Nil(twt.Poll)
+
+	data, err := json.Marshal(twt)
+	require.NoError(t, err)
+	assert.NotContains(string(data), `"poll"`)
+
+	require.NoError(t, WithEnablePolls(true)(conf))
+	twt, err = parseLine(conf, line, types.Twter{Nick: "test"})
+	require.NoError(t, err)
+	require.NotNil(t, twt.Poll)
+	assert.Equal("Tabs or spaces?", twt.Poll.Question)
+	assert.Equal([]string{"tabs", "spaces"}, twt.Poll.Options)
+
+	data, err = json.Marshal(twt)
+	require.NoError(t, err)
+	assert.Contains(string(data), `"poll"`)
+}
+
+func TestExpandMentionsIgnoresURLs(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{
+		Username: "test",
+		Following: map[string]string{
+			"alice": "gemini://example.com/alice/twtxt.txt",
+		},
+	}
+
+	text, _ := ExpandMentions(conf, nil, user, "@alice see gemini://example.com/@alice/ and https://medium.com/@alice")
+	assert.Equal(
+		"@<alice gemini://example.com/alice/twtxt.txt> see gemini://example.com/@alice/ and https://medium.com/@alice",
+		text,
+	)
+	text, _ = ExpandMentions(conf, nil, user, "@<alice gemini://example.com/alice/twtxt.txt> hi")
+	assert.Equal(
+		"@<alice gemini://example.com/alice/twtxt.txt> hi",
+		text,
+	)
+}
+
+func TestExpandMentionsConfusables(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{
+		Username: "test",
+		Following: map[string]string{
+			"alice":      "https://example.com/alice/twtxt.txt",
+			"\u0430lice": "https://evil.com/alice/twtxt.txt",
+		},
+	}
+
+	// @\u0430lice uses a Cyrillic a and must not resolve to anyone
+	text, _ := ExpandMentions(conf, nil, user, "@alice and @\u0430lice")
+	assert.Equal("@<alice https://example.com/alice/twtxt.txt> and @\u0430lice", text)
+
+	assert.True(IsConfusableUsername("\u0430lice"))
+	assert.False(IsConfusableUsername("alice"))
+	assert.False(IsConfusableUsername("j\u00fcrgen"))
+	assert.Equal(ErrConfusableUsername, ValidateUsername("\u0430lice"))
+
+	// Compatibility forms are normalized (fullwidth to ASCII)
+	assert.Equal("alice", NormalizeUsername("\uff41\uff4c\uff49\uff43\uff45"))
+}
+
+func TestExpandCodeBlocks(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{
+		Username: "test",
+		Following: map[string]string{
+			"import": "https://example.com/import/twtxt.txt",
+		},
+	}
+
+	text := "Hi @import #c\u2028```\u2028#define MAX 10\u2028@import url(a.css);\u2028```\u2028Use `#include` too"
+
+	text, _ = ExpandMentions(conf, nil, user, text)
+	text = ExpandTag(conf, nil, user, text)
+	assert.Equal(
+		"Hi @<import https://example.com/import/twtxt.txt> #<c "+URLForTag(conf.BaseURL, "c")+">"+
+			"\u2028```\u2028#define MAX 10\u2028@import url(a.css);\u2028```\u2028Use `#include` too",
+		text,
+	)
+
+	html := string(FormatTwtFactory(conf)(text))
+	assert.Contains(html, "<pre><code>#define MAX 10\n@import url(a.css);\n</code></pre>")
+	assert.Contains(html, "<code>#include</code>")
+}
+
+func TestExpandMentionsPrecedence(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.SetUser("alice", &User{Username: "alice"}))
+
+	user := &User{
+		Username: "test",
+		Following: map[string]string{
+			"alice": "https://example.com/alice/twtxt.txt",
+		},
+	}
+
+	text, _ := ExpandMentions(conf, db, user, "@alice hi")
+	assert.Equal("@<alice https://example.com/alice/twtxt.txt> hi", text)
+
+	require.NoError(t, WithMentionPrecedence("local")(conf))
+	text, _ = ExpandMentions(conf, db, user, "@alice hi")
+	assert.Equal(fmt.Sprintf("@<alice %s> hi", URLForUser(conf, "alice")), text)
+
+	assert.Equal(ErrInvalidMentionPrecedence, WithMentionPrecedence("remote")(conf))
+}
+
+func TestPreviewExpansion(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{
+		Username:  "test",
+		Signature: "#sig",
+		Following: map[string]string{
+			"bob": "https://example.com/bob/twtxt.txt",
+		},
+	}
+
+	text, mentions, tags, err := PreviewExpansion(conf, nil, user, "@bob hello #test")
+	require.NoError(t, err)
+	assert.Equal([]types.Twter{{Nick: "bob", URL: "https://example.com/bob/twtxt.txt"}}, mentions)
+	assert.Equal([]string{"test", "sig"}, tags)
+
+	// Nothing is written
+	_, err = conf.FeedStore().Stat("test")
+	assert.True(os.IsNotExist(err))
+
+	twt, err := AppendTwt(conf, nil, user, "@bob hello #test")
+	require.NoError(t, err)
+	assert.Equal(twt.Text, text)
+
+	_, _, _, err = PreviewExpansion(conf, nil, &User{Username: "test"}, " ")
+	assert.Error(err)
+}
+
+func TestExpandMentionsLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithMaxMentions(10)(conf))
+
+	user := &User{Username: "test"}
+
+	var mentions []string
+	for i := 0; i < 500; i++ {
+		mentions = append(mentions, fmt.Sprintf("@user%d@example.com", i))
+	}
+
+	text, skipped := ExpandMentions(conf, nil, user, strings.Join(mentions, " "))
+	assert.Equal(490, skipped)
+
+	fields := strings.Fields(text)
+	for i := 0; i < 500; i++ {
+		if i < 10 {
+			assert.Equal(fmt.Sprintf("@<user%d", i), fields[i*2])
+		} else {
+			assert.Equal(mentions[i], fields[i+10])
+		}
+	}
+
+	_, err := AppendTwt(conf, nil, user, strings.Join(mentions, " "))
+	assert.Equal(ErrTooManyMentions, err)
+
+	_, err = AppendTwt(conf, nil, user, strings.Join(mentions[:10], " "))
+	assert.NoError(err)
+}
+
+func TestGetFeedTwts(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+
+	_, err := AppendTwt(conf, nil, user, "old", time.Now().Add(-100*DayAgo))
+	require.NoError(t, err)
+	_, err = AppendTwt(conf, nil, user, "fresh")
+	require.NoError(t, err)
+
+	twts, old, err := GetFeedTwts(conf, "test")
+	require.NoError(t, err)
+	assert.Len(twts, 2)
+	assert.Empty(old)
+
+	require.NoError(t, WithFeedTTL(90*DayAgo)(conf))
+
+	twts, old, err = GetFeedTwts(conf, "test")
+	require.NoError(t, err)
+	require.Len(t, twts, 1)
+	require.Len(t, old, 1)
+	assert.Equal("fresh", twts[0].Text)
+	assert.Equal("old", old[0].Text)
+
+	twts, err = GetAllTwts(conf, "test")
+	require.NoError(t, err)
+	assert.Len(twts, 2)
+}
+
+func TestEditTwtRedirects(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithEditRedirects(true)(conf))
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+
+	original, err := AppendTwt(conf, nil, user, "Hello Wrold!")
+	require.NoError(t, err)
+	assert.Equal(original.Hash(), ResolveHash(conf, original.Hash()))
+
+	edited, err := EditTwt(conf, nil, user, original.Hash(), "Hello World!")
+	require.NoError(t, err)
+	assert.Equal(edited.Hash(), ResolveHash(conf, original.Hash()))
+
+	again, err := EditTwt(conf, nil, user, edited.Hash(), "Hello World!!")
+	require.NoError(t, err)
+	assert.Equal(again.Hash(), ResolveHash(conf, original.Hash()))
+	assert.Equal(again.Hash(), ResolveHash(conf, edited.Hash()))
+
+	// Editing back to the original text must not create a cycle
+	reverted, err := EditTwt(conf, nil, user, again.Hash(), "Hello Wrold!")
+	require.NoError(t, err)
+	assert.Equal(original.Hash(), reverted.Hash())
+	assert.Equal(original.Hash(), ResolveHash(conf, edited.Hash()))
+	assert.Equal(original.Hash(), ResolveHash(conf, original.Hash()))
+}
+
+func TestRetwt(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test"), Feeds: []string{"news"}}
+
+	original, err := ParseLine(
+		"2020-07-18T12:39:06Z\t@<test http://0.0.0.0:8000/user/test/twtxt.txt> Hello World!",
+		types.Twter{Nick: "alice", URL: "https://example.com/twtxt.txt"},
+	)
+	require.NoError(t, err)
+
+	retwt, err := Retwt(conf, nil, user, original)
+	require.NoError(t, err)
+	assert.Equal(fmt.Sprintf("(#%s)", original.Hash()), retwt.Subject())
+	assert.Equal([]types.Twter{original.Twter}, retwt.Mentions())
+	assert.True(strings.HasSuffix(
+		FormatRetwt(retwt.Text),
+		") ♻️ @<alice https://example.com/twtxt.txt>\n\n> Hello World!",
+	))
+	assert.Equal("Hello World!", FormatRetwt("Hello World!"))
+
+	_, err = Retwt(conf, nil, user, retwt)
+	assert.Equal(ErrRetwtOwnTwt, err)
+
+	news, err := AppendSpecial(conf, nil, "news", "Pod news!")
+	require.NoError(t, err)
+	news.Twter.URL = URLForUser(conf, "news")
+	_, err = Retwt(conf, nil, user, news)
+	assert.Equal(ErrRetwtOwnTwt, err)
+}
+
+func TestRenameFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithEditRedirects(true)(conf))
+	require.NoError(t, WithRenameRedirects(true)(conf))
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	user := NewUser()
+	user.Username = "alice"
+	user.URL = URLForUser(conf, "alice")
+	user.Following["alice"] = user.URL
+	require.NoError(t, db.SetUser("alice", user))
+
+	bob := NewUser()
+	bob.Username = "bob"
+	bob.URL = URLForUser(conf, "bob")
+	bob.Following["alice"] = user.URL
+	require.NoError(t, db.SetUser("bob", bob))
+	require.NoError(t, db.SetUser("carol", &User{Username: "carol"}))
+
+	twt, err := AppendTwt(conf, db, user, "Hello World!")
+	require.NoError(t, err)
+
+	assert.Equal(ErrFeedAlreadyExists, RenameFeed(conf, db, "alice", "carol"))
+	assert.Equal(ErrFeedNotFound, RenameFeed(conf, db, "nobody", "somebody"))
+	require.NoError(t, RenameFeed(conf, db, "alice", "alicia"))
+
+	assert.False(db.HasUser("alice"))
+	assert.False(FeedExists(conf, "alice"))
+
+	renamed, err := db.GetUser("alicia")
+	require.NoError(t, err)
+	assert.Equal(URLForUser(conf, "alicia"), renamed.URL)
+	assert.Equal(URLForUser(conf, "alicia"), renamed.Following["alicia"])
+
+	bob, err = db.GetUser("bob")
+	require.NoError(t, err)
+	assert.True(bob.Follows(URLForUser(conf, "alicia")))
+	assert.False(bob.Follows(URLForUser(conf, "alice")))
+
+	twts, err := GetAllTwts(conf, "alicia")
+	require.NoError(t, err)
+	require.Len(t, twts, 1)
+	assert.Equal(twt.Text, twts[0].Text)
+	assert.Equal(twts[0].Hash(), ResolveHash(conf, twt.Hash()))
+
+	assert.Equal("alicia", ResolveFeedName(conf, "alice"))
+
+	// Renaming back must not create a cycle
+	require.NoError(t, RenameFeed(conf, db, "alicia", "alice"))
+	assert.Equal("alice", ResolveFeedName(conf, "alicia"))
+	assert.Equal("alice", ResolveFeedName(conf, "alice"))
+}
+
+func TestRenameFeedRedirectFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithRenameRedirects(true)(conf))
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	user := NewUser()
+	user.Username = "alice"
+	user.URL = URLForUser(conf, "alice")
+	require.NoError(t, db.SetUser("alice", user))
+
+	_, err = AppendTwt(conf, db, user, "Hello World!")
+	require.NoError(t, err)
+
+	// Recording the rename fails as the renames file cannot be read
+	require.NoError(t, os.Mkdir(filepath.Join(conf.Data, renamesFile), 0755))
+
+	assert.Error(RenameFeed(conf, db, "alice", "alicia"))
+
+	assert.True(db.HasUser("alice"))
+	assert.False(db.HasUser("alicia"))
+	assert.True(FeedExists(conf, "alice"))
+	assert.False(FeedExists(conf, "alicia"))
+
+	twts, err := GetAllTwts(conf, "alice")
+	require.NoError(t, err)
+	assert.Len(twts, 1)
+}
+
+func TestFeedETag(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	_, err := FeedETag(conf, "test")
+	assert.True(os.IsNotExist(err))
+
+	user := &User{Username: "test"}
+
+	_, err = AppendTwt(conf, nil, user, "Hello World!")
+	require.NoError(t, err)
+
+	etag, err := FeedETag(conf, "test")
+	require.NoError(t, err)
+	assert.True(strings.HasPrefix(etag, `"`) && strings.HasSuffix(etag, `"`))
+
+	again, err := FeedETag(conf, "test")
+	require.NoError(t, err)
+	assert.Equal(etag, again)
+
+	_, err = AppendTwt(conf, nil, user, "Hello again!")
+	require.NoError(t, err)
+
+	changed, err := FeedETag(conf, "test")
+	require.NoError(t, err)
+	assert.NotEqual(etag, changed)
+}
+
+func TestFeedChangedSince(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	_, err := FeedChangedSince(conf, "test", "")
+	assert.Equal(ErrFeedNotFound, err)
+
+	user := &User{Username: "test", URL: URLForUser(conf, "test")}
+
+	twt, err := AppendTwt(conf, nil, user, "Hello World!")
+	require.NoError(t, err)
+
+	changed, err := FeedChangedSince(conf, "test", twt.Hash())
+	require.NoError(t, err)
+	assert.False(changed)
+
+	etag, err := FeedETag(conf, "test")
+	require.NoError(t, err)
+	changed, err = FeedChangedSince(conf, "test", etag)
+	require.NoError(t, err)
+	assert.False(changed)
+
+	_, err = AppendTwt(conf, nil, user, "Hello again!")
+	require.NoError(t, err)
+
+	changed, err = FeedChangedSince(conf, "test", twt.Hash())
+	require.NoError(t, err)
+	assert.True(changed)
+
+	require.NoError(t, conf.FeedStore().Remove("test"))
+	_, err = FeedChangedSince(conf, "test", twt.Hash())
+	assert.Equal(ErrFeedNotFound, err)
+}
+
+func TestFeedLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	logger, hook := logtest.NewNullLogger()
+	require.NoError(t, WithLogger(logger)(conf))
+
+	user := &User{Username: "missing"}
+	_, err := EditTwt(conf, nil, user, "abcdefg", "Hello")
+	require.Error(t, err)
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	assert.Equal("missing", entry.Data["feed"])
+	assert.Equal(err, entry.Data["error"])
+	assert.Equal("error editing twt in feed", entry.Message)
+}
+
+// cancelingReader cancels a context once reads go past the first read
+type cancelingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+	reads  int
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	r.reads++
+	if r.reads > 1 {
+		r.cancel()
+	}
+	return r.r.Read(p)
+}
+
+func TestParseFileContext(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf strings.Builder
+	for i := 0; i < 1000; i++ {
+		buf.WriteString(fmt.Sprintf("2020-07-18T12:39:06Z\tHello World! %d\n", i))
+	}
+
+	twter := types.Twter{Nick: "test", URL: "https://example.com/twtxt.txt"}
+
+	twts, _, err := ParseFile(bufio.NewScanner(strings.NewReader(buf.String())), twter, 0, 0)
+	require.NoError(t, err)
+	assert.Len(twts, 1000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &cancelingReader{r: strings.NewReader(buf.String()), cancel: cancel}
+	twts, _, err = ParseFileContext(ctx, nil, bufio.NewScanner(r), twter, 0, 0)
+	assert.Equal(context.Canceled, err)
+	assert.NotEmpty(twts)
+	assert.True(len(twts) < 1000)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	twts, _, err = ParseFileContext(ctx, nil, bufio.NewScanner(strings.NewReader(buf.String())), twter, 0, 0)
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Empty(twts)
+}
+
+func TestParseFileYarn(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := os.Open(filepath.Join("testdata", "yarn.txt"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	twter := types.Twter{Nick: "alice", URL: "https://example.social/user/alice/twtxt.txt"}
+	twts, _, err := ParseFile(bufio.NewScanner(f), twter, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, twts, 5)
+
+	bob := types.Twter{Nick: "bob", URL: "https://bob.example.org/twtxt.txt"}
+	carol := types.Twter{Nick: "carol", URL: "https://carol.example.net/twtxt.txt"}
+
+	// Newest first
+	assert.Nil(twts[0].Yarn)
+	assert.True(strings.HasSuffix(twts[0].Text, "(via:#abcdefg)"))
+
+	assert.Equal("vs7lrzq", twts[1].Yarn.Fork)
+	assert.Equal("(#kgrd5bq)", twts[1].Subject())
+
+	assert.Equal([]types.Twter{carol, bob}, twts[2].Mentions())
+	assert.Equal("", twts[2].Yarn.Fork)
+
+	assert.Equal([]types.Twter{bob}, twts[3].Mentions())
+	assert.Equal("(#kgrd5bq)", twts[3].Subject())
+
+	assert.Nil(twts[4].Yarn)
+}
+
+func TestMergeFeeds(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+	require.NoError(t, WithEditRedirects(true)(conf))
+
+	store := conf.FeedStore()
+	require.NoError(t, store.Write("alice", []byte(
+		"# nick = alice\n"+
+			"2020-01-01T00:00:00Z\tFirst\n"+
+			"2020-01-03T00:00:00Z\tThird\n",
+	)))
+	require.NoError(t, store.Write("alice2", []byte(
+		"# nick = alice2\n"+
+			"2020-01-02T00:00:00Z\tSecond\r\n"+
+			"2020-01-03T00:00:00Z\tThird\n"+
+			"2020-01-04T00:00:00Z\tFourth\n",
+	)))
+
+	src, err := GetAllTwts(conf, "alice2")
+	require.NoError(t, err)
+
+	assert.Error(MergeFeeds(conf, "alice", "alice", false))
+	require.NoError(t, MergeFeeds(conf, "alice", "alice2", true))
+
+	data, err := readFeed(store, "alice")
+	require.NoError(t, err)
+	assert.Equal(
+		"# nick = alice\n"+
+			"2020-01-01T00:00:00Z\tFirst\n"+
+			"2020-01-02T00:00:00Z\tSecond\n"+
+			"2020-01-03T00:00:00Z\tThird\n"+
+			"2020-01-04T00:00:00Z\tFourth\n",
+		string(data),
+	)
+
+	assert.False(FeedExists(conf, "alice2"))
+
+	twts, err := GetAllTwts(conf, "alice")
+	require.NoError(t, err)
+	assert.Equal(twts[0].Hash(), ResolveHash(conf, src[0].Hash()))
+}
+
+func TestDiffFeeds(t *testing.T) {
+	assert := assert.New(t)
+
+	texts := func(twts types.Twts) (texts []string) {
+		for _, twt := range twts {
+			texts = append(texts, twt.Text)
+		}
+		return
+	}
+
+	old := "# nick = alice\n" +
+		"2020-01-01T00:00:00Z\tFirst\n" +
+		"2020-01-02T00:00:00Z\tSecond\n" +
+		"2020-01-03T00:00:00Z\tThird\n"
+
+	// Reordered, "First" removed, "Second" edited and "Fourth" added
+	new := "# nick = alice\n" +
+		"2020-01-04T00:00:00Z\tFourth\n" +
+		"2020-01-03T00:00:00Z\tThird\n" +
+		"2020-01-02T00:00:00Z\tSecond (edited)\n"
+
+	added, removed, err := DiffFeeds(strings.NewReader(old), strings.NewReader(new))
+	require.NoError(t, err)
+	assert.Equal([]string{"Fourth", "Second (edited)"}, texts(added))
+	assert.Equal([]string{"Second", "First"}, texts(removed))
+
+	added, removed, err = DiffFeeds(strings.NewReader(old), strings.NewReader(old))
+	require.NoError(t, err)
+	assert.Empty(added)
+	assert.Empty(removed)
+}
+
+func TestResolveHashes(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	alice, err := AppendTwt(conf, nil, &User{Username: "alice", URL: URLForUser(conf, "alice")}, "Hello from alice")
+	require.NoError(t, err)
+	bob, err := AppendTwt(conf, nil, &User{Username: "bob", URL: URLForUser(conf, "bob")}, "Hello from bob")
+	require.NoError(t, err)
+
+	twts, err := ResolveHashes(conf, []string{alice.Hash(), bob.Hash(), "missing"})
+	require.NoError(t, err)
+	assert.Len(twts, 2)
+	assert.Equal("Hello from alice", twts[alice.Hash()].Text)
+	assert.Equal("Hello from bob", twts[bob.Hash()].Text)
+	_, ok := twts["missing"]
+	assert.False(ok)
+}
+
+func TestTrimFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	store := conf.FeedStore()
+	require.NoError(t, store.Write("bot", []byte(
+		"# nick = bot\n"+
+			"2020-01-01T00:00:00Z\tFirst\n"+
+			"2020-01-03T00:00:00Z\tThird\n"+
+			"2020-01-02T00:00:00Z\tSecond\n"+
+			"2020-01-04T00:00:00Z\tFourth\n",
+	)))
+
+	removed, err := TrimFeed(conf, "bot", 2, TrimDryRun())
+	require.NoError(t, err)
+	assert.Equal(2, removed)
+
+	twts, err := GetAllTwts(conf, "bot")
+	require.NoError(t, err)
+	assert.Len(twts, 4)
+
+	archive, err := NewDiskArchiver(filepath.Join(conf.Data, archiveDir))
+	require.NoError(t, err)
+
+	removed, err = TrimFeed(conf, "bot", 2, TrimToArchive(archive))
+	require.NoError(t, err)
+	assert.Equal(2, removed)
+
+	data, err := readFeed(store, "bot")
+	require.NoError(t, err)
+	assert.Equal(
+		"# nick = bot\n"+
+			"2020-01-03T00:00:00Z\tThird\n"+
+			"2020-01-04T00:00:00Z\tFourth\n",
+		string(data),
+	)
+
+	for _, twt := range twts {
+		if twt.Text == "First" || twt.Text == "Second" {
+			assert.True(archive.Has(twt.Hash()))
+		}
+	}
+
+	removed, err = TrimFeed(conf, "bot", 2)
+	require.NoError(t, err)
+	assert.Equal(0, removed)
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prologic/twtxt/types"
+)
+
+const (
+	// feedMetadataCacheTTL is how long the metadata of remote feeds fetched
+	// by the feed cache is kept for ResolveTwter, feeds still followed are
+	// refetched (and their metadata cached again) well within it
+	feedMetadataCacheTTL = 24 * time.Hour
+
+	// resolvedTwterCacheTTL is how long remote twters resolved by
+	// ResolveTwter are cached for
+	resolvedTwterCacheTTL = time.Hour
+)
+
+var (
+	feedMetadata   = NewTTLCache(feedMetadataCacheTTL)
+	resolvedTwters = NewTTLCache(resolvedTwterCacheTTL)
+)
+
+// ResolvedTwter is the complete identity of the author of twts, local or
+// remote, as needed to render them.
+type ResolvedTwter struct {
+	Nick        string
+	URL         string
+	Avatar      string
+	Description string
+	Local       bool
+}
+
+// cacheFeedMetadata records the metadata last fetched for the remote feed
+// at url for ResolveTwter
+func cacheFeedMetadata(url string, meta FeedMetadata) {
+	key := NormalizeURL(url)
+
+	feedMetadata.SetValue(key, meta)
+	resolvedTwters.Del(key)
+}
+
+func getFeedMetadata(url string) (FeedMetadata, bool) {
+	v, ok := feedMetadata.GetValue(NormalizeURL(url))
+	if !ok {
+		return FeedMetadata{}, false
+	}
+	meta, ok := v.(FeedMetadata)
+	return meta, ok
+}
+
+// ResolveTwter resolves the complete identity of the twter t so templates
+// can render local and remote authors alike. Local users and feeds are
+// looked up (fresh) in the Store. Remote feeds are resolved from the
+// metadata last fetched by the feed cache, falling back to t itself, and
+// are cached for a while.
+func ResolveTwter(conf *Config, db Store, t types.Twter) (ResolvedTwter, error) {
+	isLocalURL := IsLocalURLFactory(conf)
+	isExternalFeed := IsExternalFeedFactory(conf)
+
+	if isLocalURL(t.URL) && !isExternalFeed(t.URL) {
+		return resolveLocalTwter(conf, db, t)
+	}
+
+	key := NormalizeURL(t.URL)
+
+	if v, ok := resolvedTwters.GetValue(key); ok {
+		if cached, ok := v.(ResolvedTwter); ok {
+			return cached, nil
+		}
+	}
+
+	resolved := ResolvedTwter{
+		Nick:   t.Nick,
+		URL:    t.URL,
+		Avatar: t.Avatar,
+	}
+
+	if meta, ok := getFeedMetadata(t.URL); ok {
+		if resolved.Nick == "" {
+			resolved.Nick = meta.Nick
+		}
+		if resolved.Avatar == "" && meta.Avatar != "" {
+			resolved.Avatar = GetDeclaredExternalAvatar(conf, t.URL, meta.Avatar)
+		}
+		resolved.Description = meta.Description
+	}
+	if resolved.Description == "" {
+		resolved.Description = t.Tagline
+	}
+
+	resolvedTwters.SetValue(key, resolved)
+
+	return resolved, nil
+}
+
+// ResolveTwterFactory returns a func resolving twters like ResolveTwter for
+// templates to render authors with, twters that cannot be resolved are
+// rendered as best as possible from what is known about them
+func ResolveTwterFactory(conf *Config, db Store) func(t types.Twter) ResolvedTwter {
+	return func(t types.Twter) ResolvedTwter {
+		resolved, err := ResolveTwter(conf, db, t)
+		if err != nil {
+			log.WithError(err).Debugf("error resolving twter %s", t.URL)
+		}
+		return resolved
+	}
+}
+
+// resolveLocalTwter resolves the twter t of a local user or feed
+func resolveLocalTwter(conf *Config, db Store, t types.Twter) (ResolvedTwter, error) {
+	name := NormalizeUsername(filepath.Base(UserURL(t.URL)))
+
+	resolved := ResolvedTwter{
+		Nick:   name,
+		URL:    URLForUser(conf, name),
+		Avatar: URLForAvatar(conf, name),
+		Local:  true,
+	}
+
+	user, err := db.GetUser(name)
+	if err == nil {
+		resolved.Nick = user.Username
+		resolved.Description = user.Tagline
+		return resolved, nil
+	}
+	if err != ErrUserNotFound {
+		return resolved, err
+	}
+
+	feed, err := db.GetFeed(name)
+	if err != nil {
+		return resolved, err
+	}
+	resolved.Nick = feed.Name
+	resolved.Description = feed.Description
+
+	return resolved, nil
+}
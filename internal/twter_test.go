@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestResolveTwter(t *testing.T) {
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	db, err := NewStore(fmt.Sprintf("bitcask://%s", filepath.Join(conf.Data, "twtxt.db")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	user := &User{Username: "alice", URL: URLForUser(conf, "alice"), Tagline: "Hello"}
+	require.NoError(t, db.SetUser("alice", user))
+	require.NoError(t, db.SetFeed("news", &Feed{Name: "news", URL: URLForUser(conf, "news"), Description: "Pod news"}))
+
+	t.Run("LocalUser", func(t *testing.T) {
+		assert := assert.New(t)
+
+		resolved, err := ResolveTwter(conf, db, types.Twter{Nick: "stale", URL: user.URL})
+		require.NoError(t, err)
+		assert.Equal(ResolvedTwter{
+			Nick:        "alice",
+			URL:         user.URL,
+			Avatar:      URLForAvatar(conf, "alice"),
+			Description: "Hello",
+			Local:       true,
+		}, resolved)
+
+		// Local users are always looked up fresh
+		user.Tagline = "Updated"
+		require.NoError(t, db.SetUser("alice", user))
+		resolved, err = ResolveTwter(conf, db, types.Twter{Nick: "alice", URL: user.URL})
+		require.NoError(t, err)
+		assert.Equal("Updated", resolved.Description)
+	})
+
+	t.Run("LocalFeed", func(t *testing.T) {
+		assert := assert.New(t)
+
+		resolved, err := ResolveTwter(conf, db, types.Twter{Nick: "news", URL: URLForUser(conf, "news")})
+		require.NoError(t, err)
+		assert.Equal("news", resolved.Nick)
+		assert.Equal("Pod news", resolved.Description)
+		assert.True(resolved.Local)
+	})
+
+	t.Run("LocalNotFound", func(t *testing.T) {
+		_, err := ResolveTwter(conf, db, types.Twter{Nick: "bob", URL: URLForUser(conf, "bob")})
+		assert.Equal(t, ErrFeedNotFound, err)
+
+		// Templates still render what is known about the twter
+		resolved := ResolveTwterFactory(conf, db)(types.Twter{Nick: "bob", URL: URLForUser(conf, "bob")})
+		assert.Equal(t, "bob", resolved.Nick)
+		assert.Equal(t, URLForAvatar(conf, "bob"), resolved.Avatar)
+		assert.True(t, resolved.Local)
+	})
+
+	t.Run("Remote", func(t *testing.T) {
+		assert := assert.New(t)
+
+		twter := types.Twter{
+			Nick:   "carol",
+			URL:    "https://example.com/carol/twtxt.txt",
+			Avatar: "https://example.com/carol/avatar.png",
+		}
+		cacheFeedMetadata(twter.URL, FeedMetadata{Nick: "carol", Description: "Carol's feed"})
+
+		resolved, err := ResolveTwter(conf, db, twter)
+		require.NoError(t, err)
+		assert.Equal(ResolvedTwter{
+			Nick:        "carol",
+			URL:         twter.URL,
+			Avatar:      twter.Avatar,
+			Description: "Carol's feed",
+		}, resolved)
+
+		// Remote lookups are cached until the feed's metadata is next fetched
+		feedMetadata.SetValue(NormalizeURL(twter.URL), FeedMetadata{Description: "Changed"})
+		resolved, err = ResolveTwter(conf, db, twter)
+		require.NoError(t, err)
+		assert.Equal("Carol's feed", resolved.Description)
+
+		cacheFeedMetadata(twter.URL, FeedMetadata{Description: "Changed"})
+		resolved, err = ResolveTwter(conf, db, twter)
+		require.NoError(t, err)
+		assert.Equal("Changed", resolved.Description)
+	})
+}
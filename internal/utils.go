@@ -114,6 +114,7 @@ var (
 	ErrInvalidUserAgent = errors.New("error: invalid twtxt user agent")
 	ErrReservedUsername = errors.New("error: username is reserved")
 	ErrInvalidImage     = errors.New("error: invalid image")
+	ErrImageTooLarge    = errors.New("error: image too large")
 	ErrInvalidAudio     = errors.New("error: invalid audio")
 	ErrInvalidVideo     = errors.New("error: invalid video")
 	ErrInvalidVideoSize = errors.New("error: invalid video size")
@@ -253,6 +254,29 @@ func GetExternalAvatar(conf *Config, nick, uri string) string {
 	return ""
 }
 
+// GetDeclaredExternalAvatar downloads the avatar an external feed declares
+// in its `# avatar = ` metadata (if not already downloaded) and returns the
+// URL to the pod's copy of it. DownloadImage ensures the avatar is an image.
+func GetDeclaredExternalAvatar(conf *Config, uri, avatar string) string {
+	slug := Slugify(uri)
+
+	fn := filepath.Join(conf.Data, externalDir, fmt.Sprintf("%s.webp", slug))
+	if FileExists(fn) {
+		return URLForExternalAvatar(conf, uri)
+	}
+
+	opts := &ImageOptions{Resize: true, Width: AvatarResolution, Height: AvatarResolution}
+	if _, err := DownloadImage(conf, avatar, externalDir, slug, opts); err != nil {
+		log.WithError(err).
+			WithField("uri", uri).
+			WithField("avatar", avatar).
+			Error("error downloading declared external avatar")
+		return ""
+	}
+
+	return URLForExternalAvatar(conf, uri)
+}
+
 func Request(conf *Config, method, url string, headers http.Header) (*http.Response, error) {
 	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
@@ -320,6 +344,121 @@ func LineCount(r io.Reader) (int, error) {
 	}
 }
 
+// ReadLastLine returns the last line of r (of the given size) ignoring any
+// trailing newline along with the offset the last line starts at.
+func ReadLastLine(r io.ReaderAt, size int64) ([]byte, int64, error) {
+	if size == 0 {
+		return nil, 0, nil
+	}
+
+	b := make([]byte, 1)
+	if _, err := r.ReadAt(b, size-1); err != nil {
+		return nil, 0, err
+	}
+	if b[0] == '\n' {
+		size--
+	}
+
+	var line []byte
+
+	buf := make([]byte, 32*1024)
+	for pos := size; pos > 0; {
+		n := int64(len(buf))
+		if n > pos {
+			n = pos
+		}
+		pos -= n
+
+		chunk := buf[:n]
+		if _, err := r.ReadAt(chunk, pos); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			line = append(append([]byte{}, chunk[i+1:]...), line...)
+			return line, pos + int64(i) + 1, nil
+		}
+		line = append(append([]byte{}, chunk...), line...)
+	}
+
+	return line, 0, nil
+}
+
+// ReadLinesReverse reads lines from r (of the given size) starting from the
+// end and working backwards calling f for each line (without the trailing
+// newline). Reading stops when f returns false or the start is reached.
+func ReadLinesReverse(r io.ReaderAt, size int64, f func(line string) bool) error {
+	buf := make([]byte, 32*1024)
+	offset := size
+
+	var partial []byte
+
+	for offset > 0 {
+		n := int64(len(buf))
+		if n > offset {
+			n = offset
+		}
+		offset -= n
+
+		if _, err := r.ReadAt(buf[:n], offset); err != nil && err != io.EOF {
+			return err
+		}
+
+		chunk := append(append([]byte{}, buf[:n]...), partial...)
+
+		for {
+			i := bytes.LastIndexByte(chunk, '\n')
+			if i == -1 {
+				break
+			}
+			line := chunk[i+1:]
+			chunk = chunk[:i]
+			// Ignore the trailing newline at the very end of the file
+			if offset+int64(i)+1 == size && len(line) == 0 {
+				continue
+			}
+			if !f(strings.TrimSuffix(string(line), "\r")) {
+				return nil
+			}
+		}
+
+		partial = chunk
+	}
+
+	if len(partial) > 0 {
+		f(strings.TrimSuffix(string(partial), "\r"))
+	}
+
+	return nil
+}
+
+// WriteFileAtomic writes data to a temporary file alongside fn and then
+// renames it over fn so readers never see a partially written file.
+func WriteFileAtomic(fn string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(fn), fmt.Sprintf(".%s-*", filepath.Base(fn)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), fn)
+}
+
 func FileExists(name string) bool {
 	if _, err := os.Stat(name); err != nil {
 		if os.IsNotExist(err) {
@@ -579,6 +718,10 @@ func DownloadImage(conf *Config, url string, resource, name string, opts *ImageO
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return "", ErrBadRequest
+	}
+
 	tf, err := ioutil.TempFile("", "rss2twtxt-*")
 	if err != nil {
 		log.WithError(err).Error("error creating temporary file")
@@ -586,10 +729,20 @@ func DownloadImage(conf *Config, url string, resource, name string, opts *ImageO
 	}
 	defer tf.Close()
 
-	if _, err := io.Copy(tf, res.Body); err != nil {
+	// Images downloaded are bound by the same limit as images uploaded
+	var body io.Reader = res.Body
+	if conf.MaxUploadSize > 0 {
+		body = io.LimitReader(res.Body, conf.MaxUploadSize+1)
+	}
+
+	n, err := io.Copy(tf, body)
+	if err != nil {
 		log.WithError(err).Error("error writng temporary file")
 		return "", err
 	}
+	if conf.MaxUploadSize > 0 && n > conf.MaxUploadSize {
+		return "", ErrImageTooLarge
+	}
 
 	if _, err := tf.Seek(0, io.SeekStart); err != nil {
 		log.WithError(err).Error("error seeking temporary file")
@@ -1082,9 +1235,9 @@ func NormalizeFeedName(name string) string {
 }
 
 func ValidateFeed(conf *Config, nick, url string) error {
-	res, err := Request(conf, http.MethodGet, url, nil)
+	res, err := FetchFeed(conf, url, nil)
 	if err != nil {
-		log.WithError(err).Errorf("error fetching feed %s", url)
+		conf.feedLog(url).WithError(err).Error("error fetching feed")
 		return err
 	}
 	defer res.Body.Close()
@@ -1096,7 +1249,7 @@ func ValidateFeed(conf *Config, nick, url string) error {
 	limitedReader := &io.LimitedReader{R: res.Body, N: conf.MaxFetchLimit}
 	scanner := bufio.NewScanner(limitedReader)
 	twter := types.Twter{Nick: nick, URL: url}
-	_, _, err = ParseFile(scanner, twter, conf.MaxCacheTTL, conf.MaxCacheItems)
+	_, _, err = ParseFileContext(context.Background(), conf, scanner, twter, conf.MaxCacheTTL, conf.MaxCacheItems)
 	if err != nil {
 		return err
 	}
@@ -1156,7 +1309,7 @@ func ParseURI(uri string) (*URI, error) {
 }
 
 func NormalizeUsername(username string) string {
-	return strings.TrimSpace(strings.ToLower(username))
+	return strings.TrimSpace(strings.ToLower(normalizeUnicode(username)))
 }
 
 func NormalizeURL(url string) string {
@@ -1174,6 +1327,9 @@ func NormalizeURL(url string) string {
 	if u.Scheme == "https" && strings.HasSuffix(u.Host, ":443") {
 		u.Host = strings.TrimSuffix(u.Host, ":443")
 	}
+	if u.Scheme == "gemini" && strings.HasSuffix(u.Host, ":1965") {
+		u.Host = strings.TrimSuffix(u.Host, ":1965")
+	}
 	u.User = nil
 	u.Path = strings.TrimSuffix(u.Path, "/")
 	norm, err := urlx.Normalize(u)
@@ -1326,7 +1482,14 @@ func URLForConvFactory(conf *Config, cache *Cache) func(twt types.Twt) string {
 		}
 
 		if _, ok := cache.Lookup(hash); !ok {
-			return ""
+			if !conf.EditRedirects {
+				return ""
+			}
+			// The twt may have been edited since
+			hash = ResolveHash(conf, hash)
+			if _, ok := cache.Lookup(hash); !ok {
+				return ""
+			}
 		}
 
 		return fmt.Sprintf(
@@ -1338,10 +1501,23 @@ func URLForConvFactory(conf *Config, cache *Cache) func(twt types.Twt) string {
 }
 
 func URLForTag(baseURL, tag string) string {
+	return URLForNamespacedTag(baseURL, "", tag)
+}
+
+// URLForNamespacedTag returns the url of the page of tag scoped to the given
+// namespace, an empty namespace being the global tag (see URLForTag).
+func URLForNamespacedTag(baseURL, namespace, tag string) string {
+	if namespace == "" {
+		return fmt.Sprintf(
+			"%s/search?tag=%s",
+			strings.TrimSuffix(baseURL, "/"),
+			tag,
+		)
+	}
 	return fmt.Sprintf(
-		"%s/search?tag=%s",
+		"%s/search?tag=%s&ns=%s",
 		strings.TrimSuffix(baseURL, "/"),
-		tag,
+		tag, namespace,
 	)
 }
 
@@ -1378,6 +1554,10 @@ func SafeParseInt(s string, d int) int {
 func ValidateUsername(username string) error {
 	username = NormalizeUsername(username)
 
+	if IsConfusableUsername(username) {
+		return ErrConfusableUsername
+	}
+
 	if !validUsername.MatchString(username) {
 		return ErrInvalidUsername
 	}
@@ -1439,6 +1619,47 @@ func FilterTwts(user *User, twts types.Twts) (filtered types.Twts) {
 	return user.Filter(twts)
 }
 
+// ReplyFilter filters twts by whether or not they are replies
+type ReplyFilter int
+
+const (
+	// AllTwts keeps all twts
+	AllTwts ReplyFilter = iota
+	// NoReplies keeps only top-level twts
+	NoReplies
+	// OnlyReplies keeps only replies
+	OnlyReplies
+)
+
+// ParseReplyFilter parses a ReplyFilter from a query parameter, "no" for
+// NoReplies and "only" for OnlyReplies, defaulting to AllTwts.
+func ParseReplyFilter(s string) ReplyFilter {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "no":
+		return NoReplies
+	case "only":
+		return OnlyReplies
+	default:
+		return AllTwts
+	}
+}
+
+// FilterReplies filters twts by whether or not they are replies (see
+// types.Twt.IsReply).
+func FilterReplies(twts types.Twts, filter ReplyFilter) types.Twts {
+	if filter == AllTwts {
+		return twts
+	}
+
+	var filtered types.Twts
+	for _, twt := range twts {
+		if twt.IsReply() == (filter == OnlyReplies) {
+			filtered = append(filtered, twt)
+		}
+	}
+	return filtered
+}
+
 // CleanTwt cleans a twt's text, replacing new lines with spaces and
 // stripping surrounding spaces.
 func CleanTwt(text string) string {
@@ -1448,6 +1669,19 @@ func CleanTwt(text string) string {
 	return text
 }
 
+// SanitizeForFeed escapes the characters in a twt's text that would corrupt
+// the tab separated, line oriented feed format: new lines (\n, \r\n or a
+// lone \r) are replaced with the Unicode Line Separator (U+2028) as per the
+// spec, tabs with a space and NULs are stripped.
+func SanitizeForFeed(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.ReplaceAll(text, "\n", "\u2028")
+	text = strings.ReplaceAll(text, "\t", " ")
+	text = strings.ReplaceAll(text, "\x00", "")
+	return text
+}
+
 // RenderAudio ...
 func RenderAudio(conf *Config, uri string) string {
 	isLocalURL := IsLocalURLFactory(conf)
@@ -1566,6 +1800,67 @@ func FormatForDateTime(t time.Time) string {
 	return format
 }
 
+// maxDateFormatLength is the maximum length of a user's date format
+const maxDateFormatLength = 64
+
+// dateFormatLocaleRe matches a language tag with a region (e.g: de-AT)
+var dateFormatLocaleRe = regexp.MustCompile(`^([a-zA-Z]{2,3})[-_][a-zA-Z0-9]{2,8}$`)
+
+// dateFormatReference is a time whose every layout element (see time.Format)
+// formats differently from the element itself
+var dateFormatReference = time.Date(2009, time.November, 10, 9, 7, 8, 123456789, time.UTC)
+
+// dateFormatLocales are the layouts of dates in a few common locales (by
+// lowercase language tag or primary language subtag)
+var dateFormatLocales = map[string]string{
+	"en":    "Jan 2, 2006 3:04PM",
+	"en-us": "Jan 2, 2006 3:04PM",
+	"en-gb": "2 Jan 2006 15:04",
+	"de":    "02.01.2006 15:04",
+	"fr":    "02/01/2006 15:04",
+	"ja":    "2006/01/02 15:04",
+	"iso":   "2006-01-02 15:04",
+}
+
+// FormatForDisplay returns the layout to display t in given a user's date
+// format preference, either a locale (e.g: en-GB or de) or a Go time layout
+// (e.g: "02 Jan 06 15:04"). Invalid (or empty) preferences fall back to the
+// default relative layout of FormatForDateTime. Only the display is affected,
+// twts are always stored as written.
+func FormatForDisplay(format string, t time.Time) string {
+	format = strings.TrimSpace(format)
+	if format == "" || len(format) > maxDateFormatLength {
+		return FormatForDateTime(t)
+	}
+
+	locale := strings.ToLower(format)
+	if layout, ok := dateFormatLocales[locale]; ok {
+		return layout
+	}
+	if match := dateFormatLocaleRe.FindStringSubmatch(locale); match != nil {
+		if layout, ok := dateFormatLocales[match[1]]; ok {
+			return layout
+		}
+	}
+
+	// A layout with no elements formats as itself
+	if dateFormatReference.Format(format) == format {
+		return FormatForDateTime(t)
+	}
+
+	return format
+}
+
+// TwtDisplayText returns the text of a twt to format for display (see
+// FormatTwtFactory) with the edited marker of an edited twt (see EditedAt)
+// shown as "(edited)"
+func TwtDisplayText(twt types.Twt) string {
+	if twt.EditedAt.IsZero() {
+		return twt.Text
+	}
+	return types.StripEdited(twt.Text) + " *(edited)*"
+}
+
 // FormatTwtFactory formats a twt into a valid HTML snippet
 func FormatTwtFactory(conf *Config) func(text string) template.HTML {
 	return func(text string) template.HTML {
@@ -1625,6 +1920,29 @@ func FormatTwtFactory(conf *Config) func(text string) template.HTML {
 			return ast.GoToNext, false
 		}
 
+		// Display yarn extensions (mention links, fork markers) cleanly
+		text = types.StripYarn(text)
+
+		// The language is shown as the twt's lang attribute not its text
+		text = types.StripLang(text)
+
+		if conf.StripReplyTargets {
+			text = types.Twt{Text: text}.TextWithoutReplyTargets()
+		}
+
+		if conf.EnablePolls {
+			if poll := types.ParsePoll(text); poll != nil {
+				text = strings.TrimSuffix(text, types.Twt{Text: text}.TextWithoutReplyTargets()) + FormatPoll(poll)
+			}
+		}
+
+		// Render retwts as a quote of the original twt
+		text = FormatRetwt(text)
+
+		if conf.CleanLinks {
+			text = cleanLinks(text, conf.TrackingParams)
+		}
+
 		// Replace  `LS: Line Separator, U+2028` with `\n` so the Markdown
 		// renderer can interpreter newlines as `<br />` and `<p>`.
 		text = strings.ReplaceAll(text, "\u2028", "\n")
@@ -1657,6 +1975,19 @@ func FormatTwtFactory(conf *Config) func(text string) template.HTML {
 	}
 }
 
+// FormatPoll formats an inline poll as Markdown with the question followed
+// by a numbered list of its options.
+func FormatPoll(poll *types.Poll) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("📊 **%s**\n\n", poll.Question))
+	for i, option := range poll.Options {
+		sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, option))
+	}
+
+	return sb.String()
+}
+
 // FormatMentionsAndTags turns `@<nick URL>` into `<a href="URL">@nick</a>`
 // and `#<tag URL>` into `<a href="URL">#tag</a>` and a `!<hash URL>`
 // into a `<a href="URL">!hash</a>`.
@@ -1703,6 +2034,134 @@ func FormatMentionsAndTags(conf *Config, text string, format TwtTextFormat) stri
 	})
 }
 
+var (
+	// codeRe matches Markdown fenced code blocks (```...```) and inline code
+	// spans (`...`) in a twt's text, whose lines may be separated by either
+	// new lines or Line Separators (U+2028)
+	codeRe = regexp.MustCompile("(?s)```.*?```|`[^`\\n\\x{2028}]+`")
+	// tokenRe matches `@<nick URL>`, `#<tag URL>` and `!<hash URL>` tokens
+	tokenRe = regexp.MustCompile(`[@#!]<[^>]*>`)
+	// mediaRe matches Markdown images `![alt](URL)` and HTML media elements
+	mediaRe = regexp.MustCompile(`(?is)!\[[^\]]*\]\([^)]*\)|<(?:img|audio|video|source)\b[^>]*>`)
+	// urlRe matches plain http(s) URLs
+	urlRe = regexp.MustCompile(`https?://[^\s<>()\[\]"'` + "`" + `]+`)
+)
+
+// SkipCode applies fn to the parts of text outside of any code blocks or
+// code spans (see codeRe) leaving code untouched, e.g: so that `#define` or
+// `@import` in a code snippet are not expanded as a tag or mention.
+func SkipCode(text string, fn func(string) string) string {
+	var sb strings.Builder
+
+	last := 0
+	for _, loc := range codeRe.FindAllStringIndex(text, -1) {
+		sb.WriteString(fn(text[last:loc[0]]))
+		sb.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	sb.WriteString(fn(text[last:]))
+
+	return sb.String()
+}
+
+// ExtractURLs returns the plain http(s) URLs in a twt's text suitable for
+// link previews, de-duplicated in the order they appear. URLs that are part
+// of mention, tag or subject tokens (`@<nick URL>`, `#<tag URL>`, ...) and
+// media (images, audio and video) are excluded.
+func ExtractURLs(twt types.Twt) []string {
+	text := tokenRe.ReplaceAllString(twt.Text, " ")
+	text = mediaRe.ReplaceAllString(text, " ")
+
+	var urls []string
+
+	seen := make(map[string]bool)
+	for _, u := range urlRe.FindAllString(text, -1) {
+		u = strings.TrimRight(u, ".,;:!?")
+		if !seen[u] {
+			urls = append(urls, u)
+			seen[u] = true
+		}
+	}
+
+	return urls
+}
+
+// isTrackingParam returns true if the query parameter key matches an entry
+// of the blocklist, where a trailing * matches any suffix
+func isTrackingParam(key string, blocklist []string) bool {
+	key = strings.ToLower(key)
+	for _, param := range blocklist {
+		param = strings.ToLower(param)
+		if strings.HasSuffix(param, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(param, "*")) {
+				return true
+			}
+		} else if key == param {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanTrackingParams strips query parameters matching the blocklist from u
+// leaving the rest of u untouched (including the order of other parameters)
+func cleanTrackingParams(u string, blocklist []string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.RawQuery == "" {
+		return u
+	}
+
+	var (
+		kept    []string
+		cleaned bool
+	)
+	for _, pair := range strings.Split(parsed.RawQuery, "&") {
+		key := strings.SplitN(pair, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if isTrackingParam(key, blocklist) {
+			cleaned = true
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	if !cleaned {
+		return u
+	}
+
+	parsed.RawQuery = strings.Join(kept, "&")
+	return parsed.String()
+}
+
+// CleanTrackingParams strips tracking parameters (see DefaultTrackingParams)
+// such as utm_source from the query of url u.
+func CleanTrackingParams(u string) string {
+	return cleanTrackingParams(u, DefaultTrackingParams)
+}
+
+// cleanLinks strips tracking parameters matching the blocklist from links in
+// text leaving mentions, tags and other tokens untouched
+func cleanLinks(text string, blocklist []string) string {
+	clean := func(s string) string {
+		return urlRe.ReplaceAllStringFunc(s, func(u string) string {
+			return cleanTrackingParams(u, blocklist)
+		})
+	}
+
+	var sb strings.Builder
+
+	last := 0
+	for _, loc := range tokenRe.FindAllStringIndex(text, -1) {
+		sb.WriteString(clean(text[last:loc[0]]))
+		sb.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	sb.WriteString(clean(text[last:]))
+
+	return sb.String()
+}
+
 // FormatMentionsAndTagsForSubject turns `@<nick URL>` into `@nick`
 func FormatMentionsAndTagsForSubject(text string) string {
 	re := regexp.MustCompile(`(@|#)<([^ ]+) *([^>]+)>`)
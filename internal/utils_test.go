@@ -1,10 +1,22 @@
 package internal
 
 import (
+	"bytes"
 	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
 )
 
 func TestFormatMentionsAndTags(t *testing.T) {
@@ -52,3 +64,324 @@ func TestFormatMentionsAndTags(t *testing.T) {
 		assert.Equal(t, testCase.expected, actual)
 	}
 }
+
+func TestExtractURLs(t *testing.T) {
+	testCases := []struct {
+		text     string
+		expected []string
+	}{
+		{
+			text:     "Hello World!",
+			expected: nil,
+		},
+		{
+			text:     "Check out https://example.com/foo, and https://example.com/bar.",
+			expected: []string{"https://example.com/foo", "https://example.com/bar"},
+		},
+		{
+			text:     "@<test http://0.0.0.0:8000/user/test/twtxt.txt> see http://example.com and #<tag http://0.0.0.0:8000/search?tag=tag>",
+			expected: []string{"http://example.com"},
+		},
+		{
+			text:     "(#<abcdefg https://twtxt.net/search?tag=abcdefg>) ![](https://example.com/image.png) [link](https://example.com/page)",
+			expected: []string{"https://example.com/page"},
+		},
+		{
+			text:     `<img src="https://example.com/a.png"> https://example.com https://example.com`,
+			expected: []string{"https://example.com"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		actual := ExtractURLs(types.Twt{Text: testCase.text})
+		assert.Equal(t, testCase.expected, actual)
+	}
+}
+
+func TestReadLastLine(t *testing.T) {
+	testCases := []struct {
+		data   string
+		line   string
+		offset int64
+	}{
+		{data: "", line: "", offset: 0},
+		{data: "foo", line: "foo", offset: 0},
+		{data: "foo\n", line: "foo", offset: 0},
+		{data: "foo\nbar\n", line: "bar", offset: 4},
+		{data: "foo\nbar", line: "bar", offset: 4},
+		{data: "foo\n\n", line: "", offset: 4},
+	}
+
+	for _, testCase := range testCases {
+		r := strings.NewReader(testCase.data)
+		line, offset, err := ReadLastLine(r, r.Size())
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.line, string(line))
+		assert.Equal(t, testCase.offset, offset)
+	}
+}
+
+func TestReadLinesReverse(t *testing.T) {
+	readAll := func(data string) (lines []string) {
+		r := strings.NewReader(data)
+		require.NoError(t, ReadLinesReverse(r, r.Size(), func(line string) bool {
+			lines = append(lines, line)
+			return true
+		}))
+		return
+	}
+
+	// expected returns the lines of data (without line endings) last first
+	expected := func(data string) (lines []string) {
+		if data == "" {
+			return nil
+		}
+		forward := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+		for i := len(forward) - 1; i >= 0; i-- {
+			lines = append(lines, strings.TrimSuffix(forward[i], "\r"))
+		}
+		return
+	}
+
+	const chunk = 32 * 1024
+
+	testCases := map[string]string{
+		"Empty":                 "",
+		"NoTrailingNewline":     "foo\nbar",
+		"TrailingNewline":       "foo\nbar\n",
+		"EmptyLines":            "foo\n\nbar\n",
+		"CRLF":                  "foo\r\nbar\r\n",
+		"CRLFNoTrailingNewline": "foo\r\nbar",
+		"NewlineAtBoundary":     "foo\n" + strings.Repeat("x", chunk-1) + "\n",
+		"LineAcrossBoundary":    "foo\n" + strings.Repeat("x", chunk) + "\n",
+		"CRLFAcrossBoundary":    "foo\r\n" + strings.Repeat("x", chunk-1) + "\r\n",
+		"LineLongerThanChunk":   "foo\n" + strings.Repeat("x", 3*chunk) + "\nbar",
+	}
+
+	for name, data := range testCases {
+		assert.Equal(t, expected(data), readAll(data), name)
+	}
+
+	// Many lines spanning several chunks
+	var sb strings.Builder
+	for i := 0; i < 10000; i++ {
+		sb.WriteString(fmt.Sprintf("%d %s\r\n", i, strings.Repeat("x", i%17)))
+	}
+	data := strings.TrimSuffix(sb.String(), "\r\n")
+	assert.Equal(t, expected(data), readAll(data))
+
+	// Reading stops as soon as f returns false
+	var lines []string
+	r := strings.NewReader("foo\nbar\nbaz\n")
+	require.NoError(t, ReadLinesReverse(r, r.Size(), func(line string) bool {
+		lines = append(lines, line)
+		return len(lines) < 2
+	}))
+	assert.Equal(t, []string{"baz", "bar"}, lines)
+}
+
+func TestGetDeclaredExternalAvatar(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 32, 32))))
+	avatar := buf.Bytes()
+
+	var downloads int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downloads, 1)
+		switch r.URL.Path {
+		case "/avatar.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(avatar)
+		case "/avatar.txt":
+			fmt.Fprintln(w, "Not an image")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	uri := "https://example.com/alice/twtxt.txt"
+	assert.Equal(URLForExternalAvatar(conf, uri), GetDeclaredExternalAvatar(conf, uri, ts.URL+"/avatar.png"))
+	assert.True(FileExists(filepath.Join(conf.Data, externalDir, fmt.Sprintf("%s.webp", Slugify(uri)))))
+
+	// Avatars are only downloaded once
+	assert.Equal(URLForExternalAvatar(conf, uri), GetDeclaredExternalAvatar(conf, uri, ts.URL+"/avatar.png"))
+	assert.Equal(int32(1), atomic.LoadInt32(&downloads))
+
+	// Missing, invalid or too large avatars are rejected
+	for _, testCase := range []struct {
+		uri, avatar string
+		maxSize     int64
+	}{
+		{uri: "https://example.com/bob/twtxt.txt", avatar: ts.URL + "/missing.png"},
+		{uri: "https://example.com/carol/twtxt.txt", avatar: ts.URL + "/avatar.txt"},
+		{uri: "https://example.com/dave/twtxt.txt", avatar: ts.URL + "/avatar.png", maxSize: int64(len(avatar) - 1)},
+	} {
+		conf.MaxUploadSize = testCase.maxSize
+		assert.Equal("", GetDeclaredExternalAvatar(conf, testCase.uri, testCase.avatar), testCase.avatar)
+		assert.False(FileExists(filepath.Join(conf.Data, externalDir, fmt.Sprintf("%s.webp", Slugify(testCase.uri)))))
+	}
+}
+
+func TestFilterReplies(t *testing.T) {
+	twts := types.Twts{
+		{Text: "Hello World!"},
+		{Text: "(#abcdefg) Hello back!"},
+		{Text: "@<bob https://example.com/twtxt.txt> (#<abcdefg https://twtxt.net/search?tag=abcdefg>) Hi"},
+		{Text: "Another root"},
+	}
+
+	texts := func(twts types.Twts) (texts []string) {
+		for _, twt := range twts {
+			texts = append(texts, twt.Text)
+		}
+		return
+	}
+
+	assert.Equal(t, texts(twts), texts(FilterReplies(twts, ParseReplyFilter(""))))
+	assert.Equal(t, []string{twts[0].Text, twts[3].Text}, texts(FilterReplies(twts, ParseReplyFilter("no"))))
+	assert.Equal(t, []string{twts[1].Text, twts[2].Text}, texts(FilterReplies(twts, ParseReplyFilter("only"))))
+}
+
+func TestFilterByLang(t *testing.T) {
+	twt, err := ParseLine("2020-01-01T00:00:00Z\tHello World! lang:en-GB", types.Twter{})
+	assert.NoError(t, err)
+	assert.Equal(t, "en-gb", twt.Lang)
+
+	// Without an explicit token only the LanguageDetector (if any) is used
+	SetLanguageDetector(func(text string) string { return "de" })
+	defer SetLanguageDetector(nil)
+	other, err := ParseLine("2020-01-01T00:00:00Z\tHallo Welt!", types.Twter{})
+	assert.NoError(t, err)
+	assert.Equal(t, "de", other.Lang)
+
+	filtered := FilterByLang(types.Twts{twt, other, {Text: "unknown"}}, "en")
+	assert.Equal(t, types.Twts{twt}, filtered)
+}
+
+func TestFeedLanguage(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	SetLanguageDetector(func(text string) string {
+		if strings.Contains(text, "Hallo") {
+			return "de"
+		}
+		return ""
+	})
+	defer SetLanguageDetector(nil)
+
+	require.NoError(t, conf.FeedStore().Write("test", []byte(
+		"2020-01-01T00:00:00Z\tHallo Welt!\n"+
+			"2020-01-02T00:00:00Z\tHello lang:en-GB\n"+
+			"2020-01-03T00:00:00Z\tHello lang:en\n"+
+			"2020-01-04T00:00:00Z\tHello lang:EN-us\n"+
+			"2020-01-05T00:00:00Z\t???\n",
+	)))
+
+	lang, confidence, err := FeedLanguage(conf, "test")
+	require.NoError(t, err)
+	assert.Equal("en", lang)
+	assert.InDelta(0.6, confidence, 0.001)
+
+	require.NoError(t, conf.FeedStore().Write("unknown", []byte("2020-01-01T00:00:00Z\t???\n")))
+	lang, confidence, err = FeedLanguage(conf, "unknown")
+	require.NoError(t, err)
+	assert.Equal("", lang)
+	assert.Zero(confidence)
+
+	_, _, err = FeedLanguage(conf, "missing")
+	assert.Error(err)
+}
+
+func TestSanitizeForFeed(t *testing.T) {
+	testCases := []struct {
+		text     string
+		expected string
+	}{
+		{text: "Hello World!", expected: "Hello World!"},
+		{text: "Hello\tWorld!", expected: "Hello World!"},
+		{text: "Hello\nWorld!", expected: "Hello\u2028World!"},
+		{text: "Hello\r\nWorld!", expected: "Hello\u2028World!"},
+		{text: "Hello\rWorld!", expected: "Hello\u2028World!"},
+		{text: "Hello\x00 World!", expected: "Hello World!"},
+		{text: "Hello\u2028World!", expected: "Hello\u2028World!"},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, SanitizeForFeed(testCase.text))
+	}
+}
+
+func TestCleanTrackingParams(t *testing.T) {
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{url: "https://example.com/page", expected: "https://example.com/page"},
+		{url: "https://example.com/page?b=2&a=1", expected: "https://example.com/page?b=2&a=1"},
+		{url: "https://example.com/page?utm_source=twtxt&utm_medium=social", expected: "https://example.com/page"},
+		{url: "https://example.com/page?id=42&fbclid=abc#top", expected: "https://example.com/page?id=42#top"},
+		{url: "https://example.com/page?UTM_Campaign=x&q=a+b", expected: "https://example.com/page?q=a+b"},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, CleanTrackingParams(testCase.url))
+	}
+}
+
+func TestFormatTwtCleanLinks(t *testing.T) {
+	conf := NewConfig()
+	conf.BaseURL = "http://0.0.0.0:8000"
+
+	text := "See https://example.com/page?id=42&utm_source=twtxt"
+
+	// Links are only cleaned for display when enabled
+	html := string(FormatTwtFactory(conf)(text))
+	assert.Contains(t, html, "utm_source=twtxt")
+
+	conf.CleanLinks = true
+	html = string(FormatTwtFactory(conf)(text))
+	assert.NotContains(t, html, "utm_source")
+	assert.Contains(t, html, "https://example.com/page?id=42")
+}
+
+func TestFormatForDisplay(t *testing.T) {
+	assert := assert.New(t)
+
+	created := time.Date(2021, time.March, 4, 17, 5, 0, 0, time.UTC)
+	fallback := FormatForDateTime(created)
+
+	testCases := []struct {
+		format   string
+		expected string
+	}{
+		{"", created.Format(fallback)},
+		{"en-US", "Mar 4, 2021 5:05PM"},
+		{"en-GB", "4 Mar 2021 17:05"},
+		{"de", "04.03.2021 17:05"},
+		{"de-AT", "04.03.2021 17:05"},
+		{"FR_ca", "04/03/2021 17:05"},
+		{"ja-JP", "2021/03/04 17:05"},
+		{"02 Jan 06 15:04", "04 Mar 21 17:05"},
+		{"2006-01-02T15:04:05Z07:00", "2021-03-04T17:05:00Z"},
+		{"xx-YY", created.Format(fallback)},
+		{"not a format", created.Format(fallback)},
+		{strings.Repeat("2006", 20), created.Format(fallback)},
+	}
+
+	for _, testCase := range testCases {
+		actual := created.Format(FormatForDisplay(testCase.format, created))
+		assert.Equal(testCase.expected, actual, testCase.format)
+	}
+
+	user := &User{Username: "alice", DisplayDatesInFormat: "en-GB"}
+	assert.Equal("en-GB", user.Profile("http://0.0.0.0:8000", nil).DateFormat)
+}
@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/prologic/twtxt/types"
+)
+
+var (
+	// watchFeedInterval is how often WatchFeed polls a feed for changes
+	watchFeedInterval = time.Second
+)
+
+// WatchFeed watches the named local feed for changes and emits twts added to
+// the feed as they arrive (e.g: to push live updates to a timeline) until the
+// context is done, at which point the channel is closed. The feed is polled
+// through the FeedStore so any store is supported. Whenever the feed changes
+// it is resynced in full so rewrites (edits, deletes) are handled gracefully:
+// only twts not previously seen (including edited twts) are emitted.
+func WatchFeed(conf *Config, name string, ctx context.Context) (<-chan types.Twt, error) {
+	store := conf.FeedStore()
+
+	stat, err := store.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	seen, err := feedHashes(conf, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan types.Twt)
+
+	go func() {
+		defer close(ch)
+
+		size, modTime := stat.Size(), stat.ModTime()
+
+		ticker := time.NewTicker(watchFeedInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			stat, err := store.Stat(name)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					conf.feedLog(name).WithError(err).Warn("error watching feed")
+				}
+				continue
+			}
+			if stat.Size() == size && stat.ModTime().Equal(modTime) {
+				continue
+			}
+			size, modTime = stat.Size(), stat.ModTime()
+
+			twts, err := GetAllTwts(conf, name)
+			if err != nil {
+				conf.feedLog(name).WithError(err).Warn("error resyncing watched feed")
+				continue
+			}
+
+			current := make(map[string]bool, len(twts))
+			// GetAllTwts returns twts most recent first, emit oldest first
+			for i := len(twts) - 1; i >= 0; i-- {
+				twt := twts[i]
+				current[twt.Hash()] = true
+				if seen[twt.Hash()] {
+					continue
+				}
+
+				select {
+				case ch <- twt:
+				case <-ctx.Done():
+					return
+				}
+			}
+			seen = current
+		}
+	}()
+
+	return ch, nil
+}
+
+// feedHashes returns the set of hashes of all twts in the named feed
+func feedHashes(conf *Config, name string) (map[string]bool, error) {
+	twts, err := GetAllTwts(conf, name)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]bool, len(twts))
+	for _, twt := range twts {
+		hashes[twt.Hash()] = true
+	}
+	return hashes, nil
+}
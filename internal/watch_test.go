@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prologic/twtxt/types"
+)
+
+func TestWatchFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(interval time.Duration) { watchFeedInterval = interval }(watchFeedInterval)
+	watchFeedInterval = 10 * time.Millisecond
+
+	conf, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	user := &User{Username: "alice", URL: URLForUser(conf, "alice")}
+	_, err := AppendTwt(conf, nil, user, "Hello World!")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := WatchFeed(conf, "alice", ctx)
+	require.NoError(t, err)
+
+	next := func() types.Twt {
+		select {
+		case twt := <-ch:
+			return twt
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for twt")
+		}
+		return types.Twt{}
+	}
+
+	// Appends are emitted, existing twts are not
+	twt, err := AppendTwt(conf, nil, user, "Live update!")
+	require.NoError(t, err)
+	assert.Equal(twt.Hash(), next().Hash())
+
+	// Rewrites are resynced emitting only new twts
+	data, err := readFeed(conf.FeedStore(), "alice")
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+	require.NoError(t, conf.FeedStore().Write("alice", []byte(
+		lines[0]+"2020-01-02T00:00:00Z\tRewritten\n",
+	)))
+	assert.Equal("Rewritten", next().Text)
+
+	_, err = WatchFeed(conf, "unknown", ctx)
+	assert.Error(err)
+
+	cancel()
+	for range ch {
+	}
+}
@@ -2,6 +2,7 @@
 package webmention
 
 import (
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
@@ -13,6 +14,10 @@ import (
 	"golang.org/x/net/html/atom"
 )
 
+// maxDiscoverySize is the most of a HTML document read looking for its
+// webmention endpoint
+const maxDiscoverySize = 1 << 20
+
 type WebMention struct {
 	inbox       chan *mention
 	outbox      chan *mention
@@ -47,24 +52,40 @@ type mention struct {
 }
 
 func (wm *WebMention) GetTargetEndpoint(target *url.URL) (*url.URL, error) {
-	res, err := http.Get(target.String())
+	endpoint, err := DiscoverEndpoint(http.DefaultClient, target)
 	if err != nil {
 		log.WithError(err).Error("error getting target endpoint")
 		return nil, err
 	}
+	return endpoint, nil
+}
+
+// DiscoverEndpoint discovers the webmention endpoint of target using client
+// from a `Link` header with rel="webmention" or, for HTML documents, a
+// <link> or <a> element with rel="webmention". The endpoint is resolved
+// relative to target and nil is returned if target advertises none.
+func DiscoverEndpoint(client *http.Client, target *url.URL) (*url.URL, error) {
+	res, err := client.Get(target.String())
+	if err != nil {
+		return nil, err
+	}
 	defer res.Body.Close()
 
 	links := GetHeaderLinks(res.Header["Link"])
 	for _, link := range links {
 		for _, rel := range link.Params["rel"] {
 			if rel == "webmention" || rel == "http://webmention.org" {
-				return link.URL, nil
+				return target.ResolveReference(link.URL), nil
 			}
 		}
 	}
 
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		return nil, nil
+	}
+
 	parser := microformats.New()
-	mf2data := parser.Parse(res.Body, target)
+	mf2data := parser.Parse(io.LimitReader(res.Body, maxDiscoverySize), target)
 
 	for _, link := range mf2data.Rels["webmention"] {
 		wmurl, err := url.Parse(link)
@@ -72,7 +93,7 @@ func (wm *WebMention) GetTargetEndpoint(target *url.URL) (*url.URL, error) {
 			log.WithError(err).Warn("error parsing webmention link")
 			continue
 		}
-		return wmurl, nil
+		return target.ResolveReference(wmurl), nil
 	}
 
 	return nil, nil
@@ -71,7 +71,12 @@ func NewPostRequest(r io.Reader) (req PostRequest, err error) {
 
 // PagedRequest ...
 type PagedRequest struct {
-	Page int `json:"page"`
+	Page   int    `json:"page"`
+	Before string `json:"before,omitempty"`
+
+	// ExcludeOwn excludes the user's own twts from the timeline (implies
+	// cursor based paging)
+	ExcludeOwn bool `json:"exclude_own,omitempty"`
 }
 
 // NewPagedRequest ...
@@ -86,9 +91,10 @@ func NewPagedRequest(r io.Reader) (req PagedRequest, err error) {
 
 // PagerResponse ...
 type PagerResponse struct {
-	Current   int `json:"current_page"`
-	MaxPages  int `json:"max_pages"`
-	TotalTwts int `json:"total_twts"`
+	Current    int    `json:"current_page"`
+	MaxPages   int    `json:"max_pages"`
+	TotalTwts  int    `json:"total_twts"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // PagedResponse ...
@@ -0,0 +1,41 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var (
+	editedRe = regexp.MustCompile(`\s*\(edited (\d{4}-\d{2}-\d{2}T[0-9:.]+(?:Z|[+-]\d{2}:\d{2}))\)$`)
+)
+
+// EditedMarker returns the marker appended to the text of a twt edited at
+// editedAt, a trailing `(edited <timestamp>)` that reads naturally in clients
+// which know nothing about it.
+func EditedMarker(editedAt time.Time) string {
+	return fmt.Sprintf(" (edited %s)", editedAt.UTC().Format(time.RFC3339))
+}
+
+// ParseEditedAt returns the time a twt was edited given by a trailing edited
+// marker in its text (see EditedMarker), or the zero time if it has none.
+func ParseEditedAt(text string) time.Time {
+	match := editedRe.FindStringSubmatch(text)
+	if match == nil {
+		return time.Time{}
+	}
+	editedAt, err := time.Parse(time.RFC3339, match[1])
+	if err != nil {
+		return time.Time{}
+	}
+	return editedAt
+}
+
+// StripEdited returns a twt's text with any trailing edited marker removed
+// (see EditedMarker).
+func StripEdited(text string) string {
+	if ParseEditedAt(text).IsZero() {
+		return text
+	}
+	return editedRe.ReplaceAllString(text, "")
+}
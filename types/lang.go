@@ -0,0 +1,38 @@
+package types
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	langTokenRe = regexp.MustCompile(`(?:^|\s)lang:([a-zA-Z]{2,3}(?:-[a-zA-Z0-9]{2,8})?)(?:\s|$)`)
+)
+
+// ParseLang returns the language of a twt given explicitly by a `lang:xx`
+// token in its text (an ISO 639 language code optionally followed by a
+// region, e.g: lang:en or lang:pt-BR) lowercased, or the empty string if the
+// text has none.
+func ParseLang(text string) string {
+	match := langTokenRe.FindStringSubmatch(text)
+	if match == nil {
+		return ""
+	}
+	return strings.ToLower(match[1])
+}
+
+// StripLang returns a twt's text for display with any `lang:xx` token
+// removed (see ParseLang).
+func StripLang(text string) string {
+	return strings.TrimSpace(langTokenRe.ReplaceAllString(text, " "))
+}
+
+// MatchLang returns true if the language lang matches the language filter,
+// i.e: they are the same language ignoring any region so that "en" matches
+// "en-gb".
+func MatchLang(lang, filter string) bool {
+	primary := func(s string) string {
+		return strings.SplitN(strings.ToLower(s), "-", 2)[0]
+	}
+	return lang != "" && primary(lang) == primary(filter)
+}
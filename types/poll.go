@@ -0,0 +1,57 @@
+package types
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxPollOptions is the maximum number of options a poll may have
+	MaxPollOptions = 10
+)
+
+var (
+	pollRe = regexp.MustCompile(`^poll: ([^|]+\?) *((?:\| *[^|]+)+)$`)
+)
+
+// Poll is a lightweight inline poll of the form:
+//
+//	poll: Question? | opt1 | opt2
+//
+// as the (trimmed) text of a twt excluding any leading reply targets. The
+// question must end with a `?` and there must be between 2 and
+// MaxPollOptions non-empty and distinct options so that twts merely
+// mentioning the word "poll:" are not treated as polls.
+type Poll struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+}
+
+// ParsePoll parses an inline poll from a twt's text returning nil if the
+// text is not a poll.
+func ParsePoll(text string) *Poll {
+	text = strings.TrimSpace(Twt{Text: text}.TextWithoutReplyTargets())
+
+	match := pollRe.FindStringSubmatch(text)
+	if match == nil {
+		return nil
+	}
+
+	poll := &Poll{Question: strings.TrimSpace(match[1])}
+
+	seen := make(map[string]bool)
+	for _, option := range strings.Split(strings.TrimPrefix(match[2], "|"), "|") {
+		option = strings.TrimSpace(option)
+		if option == "" || seen[option] {
+			return nil
+		}
+		seen[option] = true
+		poll.Options = append(poll.Options, option)
+	}
+
+	if len(poll.Options) < 2 || len(poll.Options) > MaxPollOptions {
+		return nil
+	}
+
+	return poll
+}
@@ -10,6 +10,10 @@ type Profile struct {
 	TwtURL   string
 	BlogsURL string
 
+	// DateFormat is the user's preferred format to display their twts' dates
+	// in (see FormatForDisplay)
+	DateFormat string
+
 	// `true` if the User viewing the Profile has muted this user/feed
 	Muted bool
 
@@ -0,0 +1,116 @@
+package types
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// shingleSize is the number of characters in each shingle (n-gram) of
+	// the normalized text of twts compared by DedupeSimilar
+	shingleSize = 3
+)
+
+var (
+	similarStripRe = regexp.MustCompile(`@<[^>]*>|#<[^>]*>|[@#][-\w]+`)
+)
+
+// normalizeSimilar normalizes the text of a twt for near-duplicate detection,
+// mentions and tags are stripped, the text lowercased and whitespace
+// collapsed
+func normalizeSimilar(text string) string {
+	text = similarStripRe.ReplaceAllString(text, " ")
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// shingles returns the set of (character) n-grams of text, a text shorter
+// than n is its own single shingle
+func shingles(text string) map[string]struct{} {
+	runes := []rune(text)
+	if len(runes) <= shingleSize {
+		return map[string]struct{}{text: {}}
+	}
+
+	set := make(map[string]struct{}, len(runes)-shingleSize+1)
+	for i := 0; i+shingleSize <= len(runes); i++ {
+		set[string(runes[i:i+shingleSize])] = struct{}{}
+	}
+	return set
+}
+
+// similarity is the Jaccard index of two sets of shingles, the size of their
+// intersection over the size of their union
+func similarity(a, b map[string]struct{}) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+
+	common := 0
+	for shingle := range a {
+		if _, ok := b[shingle]; ok {
+			common++
+		}
+	}
+
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// DedupeSimilar returns twts with near-duplicates removed keeping the first
+// of each (e.g: the most recent in a sorted timeline), such as posts mirrored
+// by bots that tweak them slightly. Twts are compared by their normalized
+// text (lowercased, whitespace collapsed and mentions and tags stripped) and
+// two twts are similar if the Jaccard index of the sets of character trigrams
+// of their normalized text is at least threshold, between 0 and 1 where 1
+// only removes twts whose normalized text is identical. Twts with no text
+// left after normalizing are always kept and a threshold <= 0 removes
+// nothing.
+func (twts Twts) DedupeSimilar(threshold float64) Twts {
+	deduped := make(Twts, 0, len(twts))
+	if threshold <= 0 {
+		return append(deduped, twts...)
+	}
+
+	var (
+		seen = make(map[string]bool)
+		kept []map[string]struct{}
+	)
+
+	for _, twt := range twts {
+		text := normalizeSimilar(twt.Text)
+		if text == "" {
+			deduped = append(deduped, twt)
+			continue
+		}
+		if seen[text] {
+			continue
+		}
+
+		set := shingles(text)
+
+		duplicate := false
+		for _, other := range kept {
+			// The Jaccard index is at most the ratio of the sets' sizes
+			small, large := len(set), len(other)
+			if small > large {
+				small, large = large, small
+			}
+			if float64(small) < threshold*float64(large) {
+				continue
+			}
+
+			if similarity(set, other) >= threshold {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+
+		seen[text] = true
+		kept = append(kept, set)
+		deduped = append(deduped, twt)
+	}
+
+	return deduped
+}
@@ -21,6 +21,9 @@ var (
 
 	uriTagsRe     = regexp.MustCompile(`#<(.*?) .*?>`)
 	uriMentionsRe = regexp.MustCompile(`@<(.*?) (.*?)>`)
+
+	replyTargetsRe = regexp.MustCompile(`^(?:@<[^ >]+ [^>]+>[, ]*)+`)
+	replySubjectRe = regexp.MustCompile(`^(?:@<[^>]*>[, ]*)*\(#(?:[a-z0-9]+|<[^ >]+ [^>]+>)\)`)
 )
 
 // Twter ...
@@ -55,16 +58,29 @@ type Twt struct {
 	Text         string
 	MarkdownText string
 	Created      time.Time
+	Poll         *Poll
+	Yarn         *Yarn
+	Lang         string
+	EditedAt     time.Time
 
 	hash string
 }
 
 func (twt Twt) MarshalJSON() ([]byte, error) {
+	var editedAt *time.Time
+	if !twt.EditedAt.IsZero() {
+		editedAt = &twt.EditedAt
+	}
+
 	return json.Marshal(struct {
-		Twter        Twter     `json:"twter"`
-		Text         string    `json:"text"`
-		Created      time.Time `json:"created"`
-		MarkdownText string    `json:"markdownText"`
+		Twter        Twter      `json:"twter"`
+		Text         string     `json:"text"`
+		Created      time.Time  `json:"created"`
+		MarkdownText string     `json:"markdownText"`
+		Poll         *Poll      `json:"poll,omitempty"`
+		Yarn         *Yarn      `json:"yarn,omitempty"`
+		Lang         string     `json:"lang,omitempty"`
+		EditedAt     *time.Time `json:"editedAt,omitempty"`
 
 		// Dynamic Fields
 		Hash    string   `json:"hash"`
@@ -75,6 +91,10 @@ func (twt Twt) MarshalJSON() ([]byte, error) {
 		Text:         twt.Text,
 		Created:      twt.Created,
 		MarkdownText: twt.MarkdownText,
+		Poll:         twt.Poll,
+		Yarn:         twt.Yarn,
+		Lang:         twt.Lang,
+		EditedAt:     editedAt,
 
 		// Dynamic Fields
 		Hash:    twt.Hash(),
@@ -97,9 +117,42 @@ func (twt Twt) Mentions() []Twter {
 		}
 	}
 
+	if twt.Yarn != nil {
+		for _, mention := range twt.Yarn.Mentions {
+			if !seen[mention] {
+				mentions = append(mentions, mention)
+				seen[mention] = true
+			}
+		}
+	}
+
 	return mentions
 }
 
+// ReplyTargets returns the Twters mentioned in the leading contiguous run of
+// mentions at the start of the twt's text (i.e: who the twt is replying to).
+func (twt Twt) ReplyTargets() []Twter {
+	var targets []Twter
+
+	seen := make(map[Twter]bool)
+	run := replyTargetsRe.FindString(twt.Text)
+	for _, match := range uriMentionsRe.FindAllStringSubmatch(run, -1) {
+		target := Twter{Nick: match[1], URL: match[2]}
+		if !seen[target] {
+			targets = append(targets, target)
+			seen[target] = true
+		}
+	}
+
+	return targets
+}
+
+// TextWithoutReplyTargets returns the twt's text with the leading contiguous
+// run of mentions (see ReplyTargets) stripped.
+func (twt Twt) TextWithoutReplyTargets() string {
+	return strings.TrimPrefix(twt.Text, replyTargetsRe.FindString(twt.Text))
+}
+
 // Tags ...
 func (twt Twt) Tags() []string {
 	var tags []string
@@ -137,6 +190,12 @@ func (twt Twt) Subject() string {
 	return fmt.Sprintf("(#%s)", twt.Hash())
 }
 
+// IsReply returns true if the twt is a reply in a conversation, i.e: its
+// text has an explicit (#hash) subject (possibly after leading mentions).
+func (twt Twt) IsReply() bool {
+	return replySubjectRe.MatchString(twt.Text)
+}
+
 // Hash ...
 func (twt Twt) Hash() string {
 	if twt.hash != "" {
@@ -154,6 +213,16 @@ func (twt Twt) Hash() string {
 	return twt.hash
 }
 
+// AttributedTo returns the twt attributed to author with the given text
+// (e.g: a twt republished by an aggregator feed on behalf of its author)
+// keeping the hash of the twt as published in its feed.
+func (twt Twt) AttributedTo(author Twter, text string) Twt {
+	twt.hash = twt.Hash()
+	twt.Twter = author
+	twt.Text = text
+	return twt
+}
+
 func (twt Twt) IsZero() bool {
 	return twt.Twter.IsZero() && twt.Created.IsZero() && twt.Text == ""
 }
@@ -184,3 +253,23 @@ func (twts Twts) Tags() map[string]int {
 	}
 	return tags
 }
+
+// GroupByTwter groups twts by their Twter preserving the order of twts
+// within each group. Twters are identified by both their Nick and URL so
+// twters with the same nick on different feeds remain distinct groups.
+func (twts Twts) GroupByTwter() map[Twter]Twts {
+	type key struct{ nick, url string }
+
+	twters := make(map[key]Twter)
+	groups := make(map[Twter]Twts)
+	for _, twt := range twts {
+		k := key{twt.Twter.Nick, twt.Twter.URL}
+		twter, ok := twters[k]
+		if !ok {
+			twter = twt.Twter
+			twters[k] = twter
+		}
+		groups[twter] = append(groups[twter], twt)
+	}
+	return groups
+}
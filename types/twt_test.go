@@ -84,3 +84,180 @@ func TestSubject(t *testing.T) {
 		})
 	}
 }
+
+func TestGroupByTwter(t *testing.T) {
+	assert := assert.New(t)
+
+	alice := Twter{Nick: "alice", URL: "https://a.example/twtxt.txt"}
+	otherAlice := Twter{Nick: "alice", URL: "https://b.example/twtxt.txt"}
+	bob := Twter{Nick: "bob", URL: "https://a.example/bob/twtxt.txt"}
+
+	now := time.Now()
+	twts := Twts{
+		{Twter: alice, Text: "one", Created: now},
+		{Twter: bob, Text: "two", Created: now},
+		{Twter: otherAlice, Text: "three", Created: now},
+		{Twter: alice, Text: "four", Created: now},
+	}
+
+	groups := twts.GroupByTwter()
+	assert.Len(groups, 3)
+	assert.Equal(Twts{twts[0], twts[3]}, groups[alice])
+	assert.Equal(Twts{twts[1]}, groups[bob])
+	assert.Equal(Twts{twts[2]}, groups[otherAlice])
+	assert.Empty(Twts{}.GroupByTwter())
+}
+
+func TestReplyTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	twt := Twt{
+		Text:    "@<a https://a.com/twtxt.txt> @<b https://b.com/twtxt.txt>, @<c https://c.com/twtxt.txt> hello @<d https://d.com/twtxt.txt>",
+		Created: time.Now(),
+	}
+
+	assert.Equal([]Twter{
+		{Nick: "a", URL: "https://a.com/twtxt.txt"},
+		{Nick: "b", URL: "https://b.com/twtxt.txt"},
+		{Nick: "c", URL: "https://c.com/twtxt.txt"},
+	}, twt.ReplyTargets())
+	assert.Equal("hello @<d https://d.com/twtxt.txt>", twt.TextWithoutReplyTargets())
+
+	twt = Twt{Text: "hello @<a https://a.com/twtxt.txt>", Created: time.Now()}
+	assert.Empty(twt.ReplyTargets())
+	assert.Equal(twt.Text, twt.TextWithoutReplyTargets())
+}
+
+func TestParsePoll(t *testing.T) {
+	assert := assert.New(t)
+
+	poll := ParsePoll("poll: Tabs or spaces? | tabs | spaces")
+	if assert.NotNil(poll) {
+		assert.Equal("Tabs or spaces?", poll.Question)
+		assert.Equal([]string{"tabs", "spaces"}, poll.Options)
+	}
+
+	poll = ParsePoll("@<a https://a.com/twtxt.txt> poll: Lunch? | pizza | sushi | tacos")
+	if assert.NotNil(poll) {
+		assert.Equal([]string{"pizza", "sushi", "tacos"}, poll.Options)
+	}
+
+	assert.Nil(ParsePoll("I ran a poll: it went well | mostly"))
+	assert.Nil(ParsePoll("poll: No question mark | a | b"))
+	assert.Nil(ParsePoll("poll: Only one option? | a"))
+	assert.Nil(ParsePoll("poll: Empty option? | a | | b"))
+	assert.Nil(ParsePoll("poll: Duplicates? | a | a"))
+	assert.Nil(ParsePoll("Hello World!"))
+}
+
+func TestParseYarn(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(ParseYarn("Hello World!"))
+	assert.Nil(ParseYarn("@<prologic https://twtxt.net/user/prologic/twtxt.txt> Hello (via:#abcdefg)"))
+
+	yarn := ParseYarn("[@bob](https://bob.example.org/twtxt.txt#bob) [@bob](https://bob.example.org/twtxt.txt) Hi (fork:#vs7lrzq)")
+	assert.Equal(
+		&Yarn{
+			Mentions: []Twter{{Nick: "bob", URL: "https://bob.example.org/twtxt.txt"}},
+			Fork:     "vs7lrzq",
+		},
+		yarn,
+	)
+
+	assert.Equal(
+		"@<bob https://bob.example.org/twtxt.txt> Hi",
+		StripYarn("[@bob](https://bob.example.org/twtxt.txt#bob) Hi (fork:#vs7lrzq)"),
+	)
+	assert.Equal(
+		"Hi (via:#abcdefg) [@bob]() [docs](https://example.com/#bob)",
+		StripYarn("Hi (via:#abcdefg) [@bob]() [docs](https://example.com/#bob)"),
+	)
+}
+
+func TestIsReply(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(Twt{Text: "Hello World!"}.IsReply())
+	assert.False(Twt{Text: "(not a subject) Hello World!"}.IsReply())
+	assert.False(Twt{Text: "@<bob https://example.com/twtxt.txt> Hello (#abcdefg)"}.IsReply())
+	assert.True(Twt{Text: "(#abcdefg) Hello World!"}.IsReply())
+	assert.True(Twt{Text: "(#<abcdefg https://twtxt.net/search?tag=abcdefg>) Hello World!"}.IsReply())
+	assert.True(Twt{Text: "@<bob https://example.com/twtxt.txt> (#abcdefg) Hello"}.IsReply())
+}
+
+func TestParseLang(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", ParseLang("Hello World!"))
+	assert.Equal("", ParseLang("Hello slang:en World!"))
+	assert.Equal("de", ParseLang("lang:de Hallo Welt!"))
+	assert.Equal("pt-br", ParseLang("Olá mundo! lang:pt-BR"))
+
+	assert.Equal("Hello World!", StripLang("Hello lang:en World!"))
+	assert.Equal("Hallo Welt!", StripLang("lang:de Hallo Welt!"))
+
+	assert.True(MatchLang("en-gb", "en"))
+	assert.True(MatchLang("en", "EN"))
+	assert.False(MatchLang("de", "en"))
+	assert.False(MatchLang("", "en"))
+}
+
+func TestParseEditedAt(t *testing.T) {
+	assert := assert.New(t)
+
+	editedAt := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	text := "Hello World!" + EditedMarker(editedAt)
+
+	assert.Equal("Hello World! (edited 2021-01-02T03:04:05Z)", text)
+	assert.True(editedAt.Equal(ParseEditedAt(text)))
+	assert.Equal("Hello World!", StripEdited(text))
+
+	assert.True(ParseEditedAt("Hello World!").IsZero())
+	assert.True(ParseEditedAt("Hello World! (edited)").IsZero())
+	assert.True(ParseEditedAt("(edited 2021-01-02T03:04:05Z) Hello World!").IsZero())
+	assert.Equal("Hello World! (edited)", StripEdited("Hello World! (edited)"))
+}
+
+func TestDedupeSimilar(t *testing.T) {
+	assert := assert.New(t)
+
+	alice := Twter{Nick: "alice", URL: "https://example.com/alice/twtxt.txt"}
+	mirror := Twter{Nick: "mirror", URL: "https://example.com/mirror/twtxt.txt"}
+	now := time.Now()
+
+	twts := Twts{
+		{Twter: alice, Created: now, Text: "The quick brown fox jumps over the lazy dog #animals"},
+		{Twter: mirror, Created: now.Add(-time.Minute), Text: "@<alice https://example.com/alice/twtxt.txt> the  QUICK brown fox jumps over the lazy dog"},
+		{Twter: mirror, Created: now.Add(-2 * time.Minute), Text: "The quick brown fox jumps over the lazy dog!!"},
+		{Twter: alice, Created: now.Add(-3 * time.Minute), Text: "Something else entirely"},
+		{Twter: alice, Created: now.Add(-4 * time.Minute), Text: "#animals"},
+		{Twter: mirror, Created: now.Add(-5 * time.Minute), Text: "#animals"},
+	}
+
+	deduped := twts.DedupeSimilar(0.8)
+	assert.Equal(Twts{twts[0], twts[3], twts[4], twts[5]}, deduped)
+
+	// Only identical normalized text
+	deduped = twts.DedupeSimilar(1)
+	assert.Equal(Twts{twts[0], twts[2], twts[3], twts[4], twts[5]}, deduped)
+
+	assert.Equal(twts, twts.DedupeSimilar(0))
+	assert.Empty(Twts(nil).DedupeSimilar(0.8))
+}
+
+func BenchmarkDedupeSimilar(b *testing.B) {
+	twter := Twter{Nick: "bot", URL: "https://example.com/bot/twtxt.txt"}
+	now := time.Now()
+
+	var twts Twts
+	for i := 0; i < 1000; i++ {
+		text := fmt.Sprintf("Headline number %d: something happened somewhere today #news", i%250)
+		twts = append(twts, Twt{Twter: twter, Created: now.Add(-time.Duration(i) * time.Minute), Text: text})
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		twts.DedupeSimilar(0.9)
+	}
+}
@@ -0,0 +1,65 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	yarnMentionRe = regexp.MustCompile(`\[@([a-zA-Z0-9][a-zA-Z0-9_-]*)\]\(([a-zA-Z][a-zA-Z0-9+.-]*://[^\s)#]+)(?:#[^\s)]*)?\)`)
+	yarnForkRe    = regexp.MustCompile(`\s*\(fork:#([a-z0-9]+)\)$`)
+)
+
+// Yarn holds the yarn.social style extensions found in a twt's text. The
+// supported extensions are:
+//
+//	[@nick](url) or [@nick](url#nick)
+//
+// mentions written as Markdown links (as rendered into feeds by some yarn
+// pods and clients) which are read as the mention @<nick url>, and:
+//
+//	(fork:#hash)
+//
+// a trailing fork marker with the hash of the twt the conversation was forked
+// from. Anything else (including unknown trailing tokens) is left in the text
+// as is. The twt's text is never rewritten so its hash is unaffected.
+type Yarn struct {
+	Mentions []Twter `json:"mentions,omitempty"`
+	Fork     string  `json:"fork,omitempty"`
+}
+
+// ParseYarn extracts the yarn extensions from a twt's text returning nil if
+// the text has none.
+func ParseYarn(text string) *Yarn {
+	yarn := &Yarn{}
+
+	seen := make(map[Twter]bool)
+	for _, match := range yarnMentionRe.FindAllStringSubmatch(text, -1) {
+		mention := Twter{Nick: match[1], URL: match[2]}
+		if !seen[mention] {
+			yarn.Mentions = append(yarn.Mentions, mention)
+			seen[mention] = true
+		}
+	}
+
+	if match := yarnForkRe.FindStringSubmatch(text); match != nil {
+		yarn.Fork = match[1]
+	}
+
+	if yarn.Mentions == nil && yarn.Fork == "" {
+		return nil
+	}
+
+	return yarn
+}
+
+// StripYarn returns a twt's text for display with mentions written as
+// Markdown links turned into @<nick url> mentions and any fork marker
+// removed (see Yarn).
+func StripYarn(text string) string {
+	text = yarnMentionRe.ReplaceAllStringFunc(text, func(match string) string {
+		parts := yarnMentionRe.FindStringSubmatch(match)
+		return fmt.Sprintf("@<%s %s>", parts[1], parts[2])
+	})
+	return yarnForkRe.ReplaceAllString(text, "")
+}